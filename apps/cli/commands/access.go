@@ -0,0 +1,485 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	accessProjectID string
+	accessPassword  string
+	accessRotate    bool
+	accessRole      string
+	accessExpires   int
+)
+
+var accessCmd = &cobra.Command{
+	Use:   "access",
+	Short: "Manage who has access to a project",
+}
+
+var accessListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the users with access to a project",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := accessProjectArg()
+		if err != nil {
+			return err
+		}
+
+		entries, err := fetchProjectAccess(activeProfile.URL, activeProfile.Token, projectID)
+		if err != nil {
+			return err
+		}
+
+		groups, err := fetchProjectGroupAccess(activeProfile.URL, activeProfile.Token, projectID)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 && len(groups) == 0 {
+			pterm.Info.Println("No collaborators have access to this project.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"User ID", "Username", "Email", "Role", "Keypair", "Granted", "Expires"}}
+		for _, a := range entries {
+			keypair := "yes"
+			if a.PublicKey == "" {
+				keypair = "no"
+			}
+			granted := "-"
+			if a.GrantedAt != nil {
+				granted = a.GrantedAt.Local().Format("2006-01-02 15:04")
+			}
+			expires := "never"
+			if a.ExpiresAt != nil {
+				expires = a.ExpiresAt.Local().Format("2006-01-02 15:04")
+				if !a.ExpiresAt.After(time.Now()) {
+					expires += " (expired)"
+				}
+			}
+			tableData = append(tableData, []string{a.UserID.String(), a.Username, a.Email, a.Role, keypair, granted, expires})
+		}
+
+		if err := pterm.DefaultTable.WithHasHeader().WithData(tableData).Render(); err != nil {
+			return err
+		}
+
+		if len(groups) > 0 {
+			groupData := pterm.TableData{{"Group ID", "Group", "Role", "Granted"}}
+			for _, g := range groups {
+				granted := "-"
+				if g.GrantedAt != nil {
+					granted = g.GrantedAt.Local().Format("2006-01-02 15:04")
+				}
+				groupData = append(groupData, []string{g.GroupID.String(), g.GroupName, g.Role, granted})
+			}
+			pterm.Println()
+			return pterm.DefaultTable.WithHasHeader().WithData(groupData).Render()
+		}
+		return nil
+	},
+}
+
+var accessGrantCmd = &cobra.Command{
+	Use:   "grant [USER]",
+	Short: "Grant an existing user access to a project by sealing its key to their public key",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := accessProjectArg()
+		if err != nil {
+			return err
+		}
+		user, err := accessUserArg(args)
+		if err != nil {
+			return err
+		}
+
+		password := accessPassword
+		if password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your password to unlock the project key")
+			if err != nil {
+				return err
+			}
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Fetching the user's public key...")
+		grantee, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Unwrapping project key...")
+		project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, projectID, password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		wrapped, err := vault.WrapDataKeyForUser(grantee.PublicKey, project.ID, dataKey)
+		if err != nil {
+			spinner.Fail("Failed to seal project key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Granting access...")
+		body := map[string]interface{}{
+			"user_id":          grantee.UserID,
+			"wrapped_data_key": wrapped,
+			"role":             accessRole,
+		}
+		if accessExpires > 0 {
+			body["expires_at"] = time.Now().Add(time.Duration(accessExpires) * time.Hour)
+		}
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/projects/"+projectID+"/access", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to grant access: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		if accessExpires > 0 {
+			spinner.Success(fmt.Sprintf("%s is now %s of project '%s' for %d hours.", grantee.Username, accessRole, project.Name, accessExpires))
+			return nil
+		}
+		spinner.Success(fmt.Sprintf("%s is now %s of project '%s'.", grantee.Username, accessRole, project.Name))
+		return nil
+	},
+}
+
+var accessRevokeCmd = &cobra.Command{
+	Use:   "revoke [USER]",
+	Short: "Revoke a user's access to a project, optionally rotating its data key",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := accessProjectArg()
+		if err != nil {
+			return err
+		}
+		user, err := accessUserArg(args)
+		if err != nil {
+			return err
+		}
+
+		// Users without a keypair have no public key lookup, so resolve them from the grant list
+		entries, err := fetchProjectAccess(activeProfile.URL, activeProfile.Token, projectID)
+		if err != nil {
+			return err
+		}
+		var grant *models.ProjectAccess
+		for i := range entries {
+			if entries[i].UserID.String() == user || entries[i].Username == user {
+				grant = &entries[i]
+			}
+		}
+		if grant == nil {
+			return fmt.Errorf("user '%s' has no access to this project", user)
+		}
+
+		if !accessRotate {
+			pterm.Warning.Println("The user may still hold a copy of the project key. Use --rotate to replace it.")
+		}
+
+		password := accessPassword
+		if accessRotate && password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Admin Password to unwrap Master Key")
+			if err != nil {
+				return err
+			}
+		}
+
+		url := activeProfile.URL + "/api/v1/projects/" + projectID + "/access/" + grant.UserID.String()
+		if accessRotate {
+			url += "?rotate=true"
+		}
+		req, _ := http.NewRequest("DELETE", url, nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("failed to revoke access: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("Access revoked for %s.\n", grant.Username)
+
+		if accessRotate {
+			return rotateProjectKey(projectID, password, false, false)
+		}
+		return nil
+	},
+}
+
+var accessSetRoleCmd = &cobra.Command{
+	Use:   "set-role [USER] [ROLE]",
+	Short: "Set a user's global role (ADMIN, COLLABORATOR or AUDITOR)",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		user, err := accessUserArg(args)
+		if err != nil {
+			return err
+		}
+
+		var role string
+		if len(args) > 1 {
+			role = strings.ToUpper(args[1])
+		} else {
+			role, err = pterm.DefaultInteractiveSelect.WithOptions([]string{"COLLABORATOR", "AUDITOR", "ADMIN"}).Show("Select the global role")
+			if err != nil {
+				return err
+			}
+		}
+
+		// The role endpoint takes a user ID; resolve usernames through the public key lookup
+		userID := user
+		if _, err := uuid.Parse(user); err != nil {
+			found, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+			if err != nil {
+				return err
+			}
+			userID = found.UserID.String()
+		}
+
+		payload, _ := json.Marshal(map[string]string{"role": role})
+		req, _ := http.NewRequest("PUT", activeProfile.URL+"/api/v1/users/"+userID+"/role", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("failed to set role: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("%s is now %s. The new role applies from their next login.\n", user, role)
+		return nil
+	},
+}
+
+var accessGrantGroupCmd = &cobra.Command{
+	Use:   "grant-group [GROUP]",
+	Short: "Grant a group access to a project by sealing its key to the group's public key",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := accessProjectArg()
+		if err != nil {
+			return err
+		}
+		group, err := groupArg(args)
+		if err != nil {
+			return err
+		}
+
+		password := accessPassword
+		if password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your password to unlock the project key")
+			if err != nil {
+				return err
+			}
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Unwrapping project key...")
+		project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, projectID, password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		wrapped, err := vault.WrapDataKeyForUser(group.PublicKey, project.ID, dataKey)
+		if err != nil {
+			spinner.Fail("Failed to seal project key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Granting access...")
+		payload, _ := json.Marshal(map[string]interface{}{
+			"group_id":         group.ID,
+			"wrapped_data_key": wrapped,
+			"role":             accessRole,
+		})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/projects/"+projectID+"/groups", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to grant access: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		spinner.Success(fmt.Sprintf("Group '%s' is now %s of project '%s'.", group.Name, accessRole, project.Name))
+		return nil
+	},
+}
+
+var accessRevokeGroupCmd = &cobra.Command{
+	Use:   "revoke-group [GROUP]",
+	Short: "Revoke a group's access to a project, optionally rotating its data key",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := accessProjectArg()
+		if err != nil {
+			return err
+		}
+		group, err := groupArg(args)
+		if err != nil {
+			return err
+		}
+
+		if !accessRotate {
+			pterm.Warning.Println("Members of the group may still hold a copy of the project key. Use --rotate to replace it.")
+		}
+
+		password := accessPassword
+		if accessRotate && password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Admin Password to unwrap Master Key")
+			if err != nil {
+				return err
+			}
+		}
+
+		url := activeProfile.URL + "/api/v1/projects/" + projectID + "/groups/" + group.ID.String()
+		if accessRotate {
+			url += "?rotate=true"
+		}
+		req, _ := http.NewRequest("DELETE", url, nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("failed to revoke access: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("Access revoked for group '%s'.\n", group.Name)
+
+		if accessRotate {
+			return rotateProjectKey(projectID, password, false, false)
+		}
+		return nil
+	},
+}
+
+func accessProjectArg() (string, error) {
+	projectID := accessProjectID
+	if projectID == "" {
+		var err error
+		projectID, err = pterm.DefaultInteractiveTextInput.Show("Enter Project ID")
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := uuid.Parse(projectID); err != nil {
+		return "", fmt.Errorf("invalid project ID: %w", err)
+	}
+	return projectID, nil
+}
+
+func accessUserArg(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	user, err := pterm.DefaultInteractiveTextInput.Show("Enter username or user ID")
+	if err != nil {
+		return "", err
+	}
+	if user == "" {
+		return "", fmt.Errorf("a user is required")
+	}
+	return user, nil
+}
+
+func init() {
+	accessCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return accessInteractive()
+	}
+	for _, c := range []*cobra.Command{accessListCmd, accessGrantCmd, accessRevokeCmd, accessGrantGroupCmd, accessRevokeGroupCmd} {
+		c.Flags().StringVarP(&accessProjectID, "project", "i", "", "Project ID")
+	}
+	for _, c := range []*cobra.Command{accessGrantCmd, accessGrantGroupCmd} {
+		c.Flags().StringVarP(&accessPassword, "password", "p", "", "Your password, to unlock the project key")
+		c.Flags().StringVarP(&accessRole, "role", "r", "editor", "Project role: viewer, editor or project-admin")
+	}
+	accessGrantCmd.Flags().IntVar(&accessExpires, "expires", 0, "Hours until the grant expires (0 = never)")
+	for _, c := range []*cobra.Command{accessRevokeCmd, accessRevokeGroupCmd} {
+		c.Flags().BoolVar(&accessRotate, "rotate", false, "Rotate the project data key after revoking")
+		c.Flags().StringVarP(&accessPassword, "password", "p", "", "Admin password to unwrap the Master Key (with --rotate)")
+	}
+	accessCmd.AddCommand(accessListCmd)
+	accessCmd.AddCommand(accessGrantCmd)
+	accessCmd.AddCommand(accessRevokeCmd)
+	accessCmd.AddCommand(accessSetRoleCmd)
+	accessCmd.AddCommand(accessGrantGroupCmd)
+	accessCmd.AddCommand(accessRevokeGroupCmd)
+	rootCmd.AddCommand(accessCmd)
+}
@@ -0,0 +1,546 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	breakGlassProjectID     string
+	breakGlassPassword      string
+	breakGlassJustification string
+	breakGlassHours         int
+	breakGlassNote          string
+	breakGlassAll           bool
+)
+
+var breakGlassCmd = &cobra.Command{
+	Use:   "break-glass",
+	Short: "Emergency access to projects through a pre-wrapped escrow",
+}
+
+var breakGlassSetupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Generate the escrow keypair, with you as its first responder",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		keys, err := fetchMyKeyPair(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			return err
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Generating escrow keypair...")
+		publicKey, privateKey, err := vault.NewGroupKeyPair()
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		wrapped, err := vault.WrapGroupKeyForMember(keys.PublicKey, publicKey, privateKey)
+		if err != nil {
+			spinner.Fail("Failed to seal escrow key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Creating escrow...")
+		if err := breakGlassCall("POST", "/break-glass/escrow", map[string]string{
+			"public_key":         publicKey,
+			"wrapped_escrow_key": wrapped,
+		}, http.StatusCreated, nil); err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.Success("Break-glass escrow created. Add responders and arm projects next.")
+		return nil
+	},
+}
+
+var breakGlassRespondersCmd = &cobra.Command{
+	Use:   "responders",
+	Short: "List the users who can break the glass",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		var responders []models.BreakGlassResponder
+		if err := breakGlassCall("GET", "/break-glass/responders", nil, http.StatusOK, &responders); err != nil {
+			return err
+		}
+
+		if len(responders) == 0 {
+			pterm.Info.Println("No break-glass responders.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"User ID", "Username", "Email", "Added"}}
+		for _, r := range responders {
+			added := "-"
+			if r.AddedAt != nil {
+				added = r.AddedAt.Local().Format("2006-01-02 15:04")
+			}
+			tableData = append(tableData, []string{r.UserID.String(), r.Username, r.Email, added})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var breakGlassAddCmd = &cobra.Command{
+	Use:   "add [USER]",
+	Short: "Make a user a responder by sealing the escrow key to their public key",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		user, err := accessUserArg(args)
+		if err != nil {
+			return err
+		}
+		password, err := breakGlassPasswordArg("Enter your password to unlock the escrow key")
+		if err != nil {
+			return err
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Unlocking escrow key...")
+		escrow, err := fetchBreakGlassEscrow(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		if escrow == nil {
+			spinner.Fail("No break-glass escrow. Run 'bastion break-glass setup' first")
+			return fmt.Errorf("no break-glass escrow")
+		}
+		var mine models.BreakGlassResponder
+		if err := breakGlassCall("GET", "/break-glass/key", nil, http.StatusOK, &mine); err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		keys, err := fetchMyKeyPair(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		privateKey, err := vault.UnlockPrivateKey(keys, password)
+		if err != nil {
+			spinner.Fail("Failed to unlock private key. Invalid password?")
+			return err
+		}
+		escrowKey, err := vault.UnwrapGroupKey(privateKey, escrow.PublicKey, mine.WrappedEscrowKey)
+		if err != nil {
+			spinner.Fail("Failed to open escrow key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Fetching the user's public key...")
+		responder, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		wrapped, err := vault.WrapGroupKeyForMember(responder.PublicKey, escrow.PublicKey, escrowKey)
+		if err != nil {
+			spinner.Fail("Failed to seal escrow key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Adding responder...")
+		if err := breakGlassCall("POST", "/break-glass/responders", map[string]interface{}{
+			"user_id":            responder.UserID,
+			"wrapped_escrow_key": wrapped,
+		}, http.StatusNoContent, nil); err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.Success(fmt.Sprintf("%s can now break the glass on armed projects.", responder.Username))
+		return nil
+	},
+}
+
+var breakGlassRemoveCmd = &cobra.Command{
+	Use:   "remove [USER]",
+	Short: "Remove a responder",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		user, err := accessUserArg(args)
+		if err != nil {
+			return err
+		}
+		userID := user
+		if _, err := uuid.Parse(user); err != nil {
+			found, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+			if err != nil {
+				return err
+			}
+			userID = found.UserID.String()
+		}
+
+		if err := breakGlassCall("DELETE", "/break-glass/responders/"+userID, nil, http.StatusNoContent, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Printf("%s is no longer a break-glass responder.\n", user)
+		pterm.Warning.Println("They may have kept a copy of the escrow key. Consider rotating the armed projects.")
+		return nil
+	},
+}
+
+var breakGlassArmCmd = &cobra.Command{
+	Use:   "arm",
+	Short: "Seal a project's data key to the escrow so responders can reach it",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := breakGlassProjectArg()
+		if err != nil {
+			return err
+		}
+		password, err := breakGlassPasswordArg("Enter admin password to unwrap the Master Key")
+		if err != nil {
+			return err
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Unwrapping project key...")
+		escrow, err := fetchBreakGlassEscrow(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		if escrow == nil {
+			spinner.Fail("No break-glass escrow. Run 'bastion break-glass setup' first")
+			return fmt.Errorf("no break-glass escrow")
+		}
+		project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, projectID, password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		wrapped, err := vault.WrapDataKeyForUser(escrow.PublicKey, project.ID, dataKey)
+		if err != nil {
+			spinner.Fail("Failed to seal project key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Arming project...")
+		if err := breakGlassCall("PUT", "/projects/"+projectID+"/break-glass", map[string]string{
+			"wrapped_data_key": wrapped,
+		}, http.StatusNoContent, nil); err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.Success(fmt.Sprintf("Project '%s' is armed for break-glass access.", project.Name))
+		return nil
+	},
+}
+
+var breakGlassDisarmCmd = &cobra.Command{
+	Use:   "disarm",
+	Short: "Delete a project's escrowed data key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := breakGlassProjectArg()
+		if err != nil {
+			return err
+		}
+		if err := breakGlassCall("DELETE", "/projects/"+projectID+"/break-glass", nil, http.StatusNoContent, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Println("Project disarmed.")
+		return nil
+	},
+}
+
+var breakGlassOpenCmd = &cobra.Command{
+	Use:   "open",
+	Short: "Break the glass: get time-limited read access to a project and print its secrets",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := breakGlassProjectArg()
+		if err != nil {
+			return err
+		}
+		justification := breakGlassJustification
+		if justification == "" {
+			justification, err = pterm.DefaultInteractiveTextInput.Show("Justification (this raises an alert and is reviewed by an admin)")
+			if err != nil {
+				return err
+			}
+		}
+
+		pterm.Warning.Println("Breaking the glass alerts the security team and is recorded for admin review.")
+		confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show("Do you want to continue?")
+		if !confirm {
+			pterm.Info.Println("Operation cancelled.")
+			return nil
+		}
+
+		password, err := breakGlassPasswordArg("Enter your password to unlock the escrow key")
+		if err != nil {
+			return err
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Breaking the glass...")
+		var session models.BreakGlassSession
+		if err := breakGlassCall("POST", "/break-glass", map[string]interface{}{
+			"project_id":     projectID,
+			"justification":  justification,
+			"duration_hours": breakGlassHours,
+		}, http.StatusCreated, &session); err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		// During the session the project key is served like a group grant, sealed to the escrow
+		spinner.UpdateText("Unwrapping project key...")
+		project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, projectID, password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		secrets, err := fetchSecrets(activeProfile.URL, activeProfile.Token, projectID)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		allowLegacy, err := legacySecretsAllowed(activeProfile.URL)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		tableData := pterm.TableData{{"Key", "Version", "Value"}}
+		for _, s := range secrets {
+			plaintext, _, err := vault.DecryptSecret(dataKey, s, allowLegacy)
+			if err != nil {
+				spinner.Fail(fmt.Sprintf("Failed to decrypt '%s': %s", s.Key, err))
+				return err
+			}
+			tableData = append(tableData, []string{s.Key, fmt.Sprintf("%d", s.Version), string(plaintext)})
+		}
+
+		spinner.Success(fmt.Sprintf("Read access to '%s' until %s (session %s).", project.Name, session.ExpiresAt.Local().Format("2006-01-02 15:04"), session.ID))
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var breakGlassSessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List break-glass sessions awaiting acknowledgment",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		sessions, err := fetchBreakGlassSessions(!breakGlassAll)
+		if err != nil {
+			return err
+		}
+
+		if len(sessions) == 0 {
+			pterm.Info.Println("No break-glass sessions to review.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "User", "Project", "Justification", "Opened", "Expires", "Acknowledged"}}
+		for _, s := range sessions {
+			acknowledged := "no"
+			if s.AcknowledgedAt != nil {
+				acknowledged = s.AcknowledgedAt.Local().Format("2006-01-02 15:04")
+			}
+			tableData = append(tableData, []string{
+				s.ID.String(),
+				s.Username,
+				s.ProjectName,
+				s.Justification,
+				s.CreatedAt.Local().Format("2006-01-02 15:04"),
+				s.ExpiresAt.Local().Format("2006-01-02 15:04"),
+				acknowledged,
+			})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var breakGlassAckCmd = &cobra.Command{
+	Use:   "ack [ID]",
+	Short: "Acknowledge a break-glass session after reviewing it",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		id := ""
+		if len(args) > 0 {
+			id = args[0]
+		} else {
+			sessions, err := fetchBreakGlassSessions(true)
+			if err != nil {
+				return err
+			}
+			if len(sessions) == 0 {
+				return fmt.Errorf("no break-glass sessions awaiting acknowledgment")
+			}
+			options := make([]string, len(sessions))
+			for i, s := range sessions {
+				options[i] = fmt.Sprintf("%s - %s on %s: %s", s.ID, s.Username, s.ProjectName, s.Justification)
+			}
+			selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Select a session")
+			if err != nil {
+				return err
+			}
+			id = strings.Split(selected, " ")[0]
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("invalid session ID: %w", err)
+		}
+
+		if err := breakGlassCall("POST", "/break-glass/sessions/"+id+"/acknowledge", map[string]string{
+			"note": breakGlassNote,
+		}, http.StatusOK, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Printf("Break-glass session %s acknowledged.\n", id)
+		return nil
+	},
+}
+
+// fetchBreakGlassEscrow returns the break-glass escrow, or nil if it has not been set up.
+func fetchBreakGlassEscrow(url, token string) (*models.BreakGlassEscrow, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/break-glass/escrow", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch break-glass escrow: %s", resp.Status)
+	}
+
+	var escrow models.BreakGlassEscrow
+	if err := json.NewDecoder(resp.Body).Decode(&escrow); err != nil {
+		return nil, fmt.Errorf("failed to decode break-glass escrow: %w", err)
+	}
+	return &escrow, nil
+}
+
+func fetchBreakGlassSessions(unacknowledgedOnly bool) ([]models.BreakGlassSession, error) {
+	var sessions []models.BreakGlassSession
+	err := breakGlassCall("GET", fmt.Sprintf("/break-glass/sessions?unacknowledged=%t", unacknowledgedOnly), nil, http.StatusOK, &sessions)
+	return sessions, err
+}
+
+// breakGlassCall sends body as JSON to an API path of the active profile and decodes the response into
+// out, if given, when the server answers with the wanted status.
+func breakGlassCall(method, path string, body interface{}, want int, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(payload)
+	}
+	req, _ := http.NewRequest(method, activeProfile.URL+"/api/v1"+path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func breakGlassProjectArg() (string, error) {
+	projectID := breakGlassProjectID
+	if projectID == "" {
+		var err error
+		projectID, err = pterm.DefaultInteractiveTextInput.Show("Enter Project ID")
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := uuid.Parse(projectID); err != nil {
+		return "", fmt.Errorf("invalid project ID: %w", err)
+	}
+	return projectID, nil
+}
+
+func breakGlassPasswordArg(prompt string) (string, error) {
+	if breakGlassPassword != "" {
+		return breakGlassPassword, nil
+	}
+	return pterm.DefaultInteractiveTextInput.WithMask("*").Show(prompt)
+}
+
+func init() {
+	breakGlassCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return breakGlassInteractive()
+	}
+	for _, c := range []*cobra.Command{breakGlassArmCmd, breakGlassDisarmCmd, breakGlassOpenCmd} {
+		c.Flags().StringVarP(&breakGlassProjectID, "project", "i", "", "Project ID")
+	}
+	breakGlassAddCmd.Flags().StringVarP(&breakGlassPassword, "password", "p", "", "Your password, to unlock the escrow key")
+	breakGlassOpenCmd.Flags().StringVarP(&breakGlassPassword, "password", "p", "", "Your password, to unlock the escrow key")
+	breakGlassArmCmd.Flags().StringVarP(&breakGlassPassword, "password", "p", "", "Admin password to unwrap the Master Key")
+	breakGlassOpenCmd.Flags().StringVarP(&breakGlassJustification, "justification", "j", "", "Why you need emergency access")
+	breakGlassOpenCmd.Flags().IntVar(&breakGlassHours, "hours", 1, "Hours the access lasts (max 4)")
+	breakGlassSessionsCmd.Flags().BoolVar(&breakGlassAll, "all", false, "Include acknowledged sessions")
+	breakGlassAckCmd.Flags().StringVar(&breakGlassNote, "note", "", "Review note recorded with the acknowledgment")
+	breakGlassCmd.AddCommand(breakGlassSetupCmd)
+	breakGlassCmd.AddCommand(breakGlassRespondersCmd)
+	breakGlassCmd.AddCommand(breakGlassAddCmd)
+	breakGlassCmd.AddCommand(breakGlassRemoveCmd)
+	breakGlassCmd.AddCommand(breakGlassArmCmd)
+	breakGlassCmd.AddCommand(breakGlassDisarmCmd)
+	breakGlassCmd.AddCommand(breakGlassOpenCmd)
+	breakGlassCmd.AddCommand(breakGlassSessionsCmd)
+	breakGlassCmd.AddCommand(breakGlassAckCmd)
+	rootCmd.AddCommand(breakGlassCmd)
+}
@@ -0,0 +1,475 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	changeProjectID   string
+	changePassword    string
+	changeDescription string
+	changeStatus      string
+	changeNote        string
+	changeReveal      bool
+)
+
+var changeCmd = &cobra.Command{
+	Use:   "change",
+	Short: "Review changes to protected projects",
+}
+
+var changeProtectCmd = &cobra.Command{
+	Use:   "protect",
+	Short: "Require a second user's approval for every write to a project",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setProjectProtection(true)
+	},
+}
+
+var changeUnprotectCmd = &cobra.Command{
+	Use:   "unprotect",
+	Short: "Let writes to a project take effect immediately again",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setProjectProtection(false)
+	},
+}
+
+var changeProposeCmd = &cobra.Command{
+	Use:   "propose [KEY=VALUE]...",
+	Short: "Encrypt new secret values and stage them for approval",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := changeProjectArg()
+		if err != nil {
+			return err
+		}
+
+		values := make(map[string]string)
+		var keys []string
+		for _, arg := range args {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("invalid argument %q, expected KEY=VALUE", arg)
+			}
+			if _, dup := values[key]; !dup {
+				keys = append(keys, key)
+			}
+			values[key] = value
+		}
+		if len(keys) == 0 {
+			key, err := pterm.DefaultInteractiveTextInput.Show("Enter secret key")
+			if err != nil {
+				return err
+			}
+			value, err := pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter secret value")
+			if err != nil {
+				return err
+			}
+			if key == "" {
+				return fmt.Errorf("a key is required")
+			}
+			keys = append(keys, key)
+			values[key] = value
+		}
+
+		password, err := changePasswordArg()
+		if err != nil {
+			return err
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Unwrapping project key...")
+		project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, projectID, password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		current, err := fetchSecrets(activeProfile.URL, activeProfile.Token, projectID)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		latest := make(map[string]int, len(current))
+		for _, s := range current {
+			latest[s.Key] = s.Version
+		}
+
+		spinner.UpdateText("Encrypting secrets...")
+		staged := make([]models.ChangeSetSecret, 0, len(keys))
+		for _, key := range keys {
+			version := latest[key] + 1
+			ciphertext, err := vault.EncryptSecret(dataKey, project.ID, key, version, []byte(values[key]))
+			if err != nil {
+				spinner.Fail("Failed to encrypt secret: " + err.Error())
+				return err
+			}
+			staged = append(staged, models.ChangeSetSecret{Key: key, Value: ciphertext, Version: version})
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"project_id":  projectID,
+			"description": changeDescription,
+			"secrets":     staged,
+		})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/change-sets", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusAccepted {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to propose change: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		var cs models.ChangeSet
+		json.NewDecoder(resp.Body).Decode(&cs)
+
+		spinner.Success(fmt.Sprintf("Staged %d secrets in change set %s. Another user must approve it.", len(staged), cs.ID))
+		return nil
+	},
+}
+
+var changeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the change sets of a project",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID, err := changeProjectArg()
+		if err != nil {
+			return err
+		}
+
+		changeSets, err := fetchChangeSets(activeProfile.URL, activeProfile.Token, projectID, changeStatus)
+		if err != nil {
+			return err
+		}
+
+		if len(changeSets) == 0 {
+			pterm.Info.Println("No change sets found.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "Description", "Status", "Author", "Decided By", "Note", "Created"}}
+		for _, cs := range changeSets {
+			decidedBy := "-"
+			if cs.DecidedBy != nil {
+				decidedBy = cs.DecidedBy.String()
+			}
+			tableData = append(tableData, []string{
+				cs.ID.String(),
+				cs.Description,
+				cs.Status,
+				cs.CreatedBy.String(),
+				decidedBy,
+				cs.DecisionNote,
+				cs.CreatedAt.Local().Format("2006-01-02 15:04"),
+			})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var changeShowCmd = &cobra.Command{
+	Use:   "show [ID]",
+	Short: "Decrypt a change set and compare it with the current secrets",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		cs, err := changeSetArg(args)
+		if err != nil {
+			return err
+		}
+		password, err := changePasswordArg()
+		if err != nil {
+			return err
+		}
+
+		_, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, cs.ProjectID.String(), password)
+		if err != nil {
+			return err
+		}
+		current, err := fetchSecrets(activeProfile.URL, activeProfile.Token, cs.ProjectID.String())
+		if err != nil {
+			return err
+		}
+		allowLegacy, err := legacySecretsAllowed(activeProfile.URL)
+		if err != nil {
+			return err
+		}
+		currentByKey := make(map[string]models.Secret, len(current))
+		for _, s := range current {
+			currentByKey[s.Key] = s
+		}
+
+		pterm.Info.Printf("Change set %s (%s) by %s: %s\n", cs.ID, cs.Status, cs.CreatedBy, cs.Description)
+
+		header := []string{"Key", "Version", "Change"}
+		if changeReveal {
+			header = append(header, "Current", "Proposed")
+		}
+		tableData := pterm.TableData{header}
+		for _, s := range cs.Secrets {
+			proposed, _, err := vault.DecryptSecret(dataKey, models.Secret{ProjectID: cs.ProjectID, Key: s.Key, Value: s.Value, Version: s.Version}, allowLegacy)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt staged '%s': %w", s.Key, err)
+			}
+
+			change, old := "added", ""
+			if existing, ok := currentByKey[s.Key]; ok {
+				plaintext, _, err := vault.DecryptSecret(dataKey, existing, allowLegacy)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt current '%s': %w", s.Key, err)
+				}
+				old = string(plaintext)
+				change = "modified"
+				if old == string(proposed) {
+					change = "unchanged"
+				}
+			}
+
+			row := []string{s.Key, fmt.Sprintf("%d", s.Version), change}
+			if changeReveal {
+				row = append(row, old, string(proposed))
+			}
+			tableData = append(tableData, row)
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var changeApproveCmd = &cobra.Command{
+	Use:   "approve [ID]",
+	Short: "Approve a change set and write its secrets",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return decideChangeSet(args, "approve")
+	},
+}
+
+var changeRejectCmd = &cobra.Command{
+	Use:   "reject [ID]",
+	Short: "Reject a change set, or withdraw your own",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return decideChangeSet(args, "reject")
+	},
+}
+
+func setProjectProtection(protected bool) error {
+	if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+		return fmt.Errorf("no active profile. Please login first")
+	}
+
+	projectID, err := changeProjectArg()
+	if err != nil {
+		return err
+	}
+
+	payload, _ := json.Marshal(map[string]bool{"protected": protected})
+	req, _ := http.NewRequest("PUT", activeProfile.URL+"/api/v1/projects/"+projectID+"/protection", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update project: %s", strings.TrimSpace(string(msg)))
+	}
+
+	if protected {
+		pterm.Success.Println("Project is now protected. Writes are staged until another user approves them.")
+	} else {
+		pterm.Success.Println("Project is no longer protected.")
+	}
+	return nil
+}
+
+// decideChangeSet approves or rejects a pending change set.
+func decideChangeSet(args []string, decision string) error {
+	if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+		return fmt.Errorf("no active profile. Please login first")
+	}
+
+	cs, err := changeSetArg(args)
+	if err != nil {
+		return err
+	}
+	if cs.Status != models.ChangeSetPending {
+		return fmt.Errorf("change set %s is already %s", cs.ID, cs.Status)
+	}
+
+	payload, _ := json.Marshal(map[string]string{"note": changeNote})
+	req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/change-sets/"+cs.ID.String()+"/"+decision, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to %s change set: %s", decision, strings.TrimSpace(string(msg)))
+	}
+
+	if decision == "approve" {
+		pterm.Success.Printf("Change set %s approved: %d secrets written.\n", cs.ID, len(cs.Secrets))
+	} else {
+		pterm.Success.Printf("Change set %s rejected.\n", cs.ID)
+	}
+	return nil
+}
+
+func fetchChangeSets(url, token, projectID, status string) ([]models.ChangeSet, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/change-sets?project_id="+projectID+"&status="+status, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch change sets: %s", resp.Status)
+	}
+
+	var changeSets []models.ChangeSet
+	json.NewDecoder(resp.Body).Decode(&changeSets)
+	return changeSets, nil
+}
+
+// changeSetArg fetches the change set named by args, or lets the user pick a pending one of a project.
+func changeSetArg(args []string) (*models.ChangeSet, error) {
+	id := ""
+	if len(args) > 0 {
+		id = args[0]
+	} else {
+		projectID, err := changeProjectArg()
+		if err != nil {
+			return nil, err
+		}
+		pending, err := fetchChangeSets(activeProfile.URL, activeProfile.Token, projectID, models.ChangeSetPending)
+		if err != nil {
+			return nil, err
+		}
+		if len(pending) == 0 {
+			return nil, fmt.Errorf("no pending change sets")
+		}
+		options := make([]string, len(pending))
+		for i, cs := range pending {
+			options[i] = fmt.Sprintf("%s - %s", cs.ID, cs.Description)
+		}
+		selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Select a change set")
+		if err != nil {
+			return nil, err
+		}
+		id = strings.Split(selected, " ")[0]
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("invalid change set ID: %w", err)
+	}
+
+	req, _ := http.NewRequest("GET", activeProfile.URL+"/api/v1/change-sets/"+id, nil)
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch change set: %s", resp.Status)
+	}
+
+	var cs models.ChangeSet
+	if err := json.NewDecoder(resp.Body).Decode(&cs); err != nil {
+		return nil, fmt.Errorf("failed to decode change set: %w", err)
+	}
+	return &cs, nil
+}
+
+func changeProjectArg() (string, error) {
+	projectID := changeProjectID
+	if projectID == "" {
+		var err error
+		projectID, err = pterm.DefaultInteractiveTextInput.Show("Enter Project ID")
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := uuid.Parse(projectID); err != nil {
+		return "", fmt.Errorf("invalid project ID: %w", err)
+	}
+	return projectID, nil
+}
+
+func changePasswordArg() (string, error) {
+	if changePassword != "" {
+		return changePassword, nil
+	}
+	return pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your password to unlock the project key")
+}
+
+func init() {
+	changeCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return changeInteractive()
+	}
+	for _, c := range []*cobra.Command{changeProtectCmd, changeUnprotectCmd, changeProposeCmd, changeListCmd, changeShowCmd, changeApproveCmd, changeRejectCmd} {
+		c.Flags().StringVarP(&changeProjectID, "project", "i", "", "Project ID")
+	}
+	for _, c := range []*cobra.Command{changeProposeCmd, changeShowCmd} {
+		c.Flags().StringVarP(&changePassword, "password", "p", "", "Your password, to unlock the project key")
+	}
+	changeProposeCmd.Flags().StringVarP(&changeDescription, "description", "d", "", "What the change is for")
+	changeListCmd.Flags().StringVar(&changeStatus, "status", "", "Only show change sets in this status: pending, approved or rejected")
+	changeShowCmd.Flags().BoolVar(&changeReveal, "reveal", false, "Print the current and proposed plaintext values")
+	for _, c := range []*cobra.Command{changeApproveCmd, changeRejectCmd} {
+		c.Flags().StringVar(&changeNote, "note", "", "Note recorded with the decision")
+	}
+	changeCmd.AddCommand(changeProtectCmd)
+	changeCmd.AddCommand(changeUnprotectCmd)
+	changeCmd.AddCommand(changeProposeCmd)
+	changeCmd.AddCommand(changeListCmd)
+	changeCmd.AddCommand(changeShowCmd)
+	changeCmd.AddCommand(changeApproveCmd)
+	changeCmd.AddCommand(changeRejectCmd)
+	rootCmd.AddCommand(changeCmd)
+}
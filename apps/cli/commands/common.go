@@ -1,16 +1,22 @@
 package commands
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
 	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
 	"github.com/dcdavidev/bastion/packages/version"
+	"github.com/google/uuid"
 	"github.com/pterm/pterm"
 )
 
@@ -64,6 +70,16 @@ func fetchVaultConfig(url, token string) (*vaultConfigResponse, error) {
 	return &config, nil
 }
 
+// legacySecretsAllowed reports whether the server's vault still accepts secrets in the legacy unbound
+// format. Once `db upgrade-secrets` has run it does not, and they are refused as tampered.
+func legacySecretsAllowed(url string) (bool, error) {
+	status, err := checkServerStatus(url)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch server status: %w", err)
+	}
+	return status.LegacySecrets, nil
+}
+
 func fetchProject(url, token, id string) (*models.Project, error) {
 	req, _ := http.NewRequest("GET", url+"/api/v1/projects/"+id, nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -83,6 +99,388 @@ func fetchProject(url, token, id string) (*models.Project, error) {
 	return &project, nil
 }
 
+func fetchClients(url, token string) ([]models.Client, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/clients", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch clients: %s", resp.Status)
+	}
+
+	var clients []models.Client
+	json.NewDecoder(resp.Body).Decode(&clients)
+	return clients, nil
+}
+
+// findClient resolves a client by ID or name.
+func findClient(url, token, client string) (*models.Client, error) {
+	clients, err := fetchClients(url, token)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		if clients[i].ID.String() == client || clients[i].Name == client {
+			return &clients[i], nil
+		}
+	}
+	return nil, fmt.Errorf("client '%s' not found", client)
+}
+
+// fetchAllProjects lists the projects of every client visible to the token.
+func fetchAllProjects(url, token string) ([]models.Project, error) {
+	clients, err := fetchClients(url, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []models.Project
+	for _, c := range clients {
+		req, _ := http.NewRequest("GET", url+"/api/v1/projects?client_id="+c.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch projects for client '%s': %s", c.Name, resp.Status)
+		}
+
+		var clientProjects []models.Project
+		json.NewDecoder(resp.Body).Decode(&clientProjects)
+		resp.Body.Close()
+		projects = append(projects, clientProjects...)
+	}
+	return projects, nil
+}
+
+func fetchSecrets(url, token, projectID string) ([]models.Secret, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/secrets?project_id="+projectID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch secrets: %s", resp.Status)
+	}
+
+	var secrets []models.Secret
+	json.NewDecoder(resp.Body).Decode(&secrets)
+	return secrets, nil
+}
+
+// fetchAllSecretVersions returns every version of every secret in a project.
+func fetchAllSecretVersions(url, token, projectID string) ([]models.Secret, error) {
+	latest, err := fetchSecrets(url, token, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []models.Secret
+	for _, s := range latest {
+		req, _ := http.NewRequest("GET", url+"/api/v1/secrets/history?project_id="+projectID+"&key="+neturl.QueryEscape(s.Key), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch history of '%s': %s", s.Key, resp.Status)
+		}
+
+		var history []models.Secret
+		json.NewDecoder(resp.Body).Decode(&history)
+		resp.Body.Close()
+		versions = append(versions, history...)
+	}
+	return versions, nil
+}
+
+// unlockProjectKey returns a project and its data key. Admins unwrap it with the Master Key, so password is
+// the admin password; other users open their own grant, or their group's, with their private key, unlocked
+// by their login password. Service accounts open their grant with BASTION_SERVICE_KEY and need no password.
+func unlockProjectKey(url, token, projectID, password string) (*models.Project, []byte, error) {
+	project, err := fetchProject(url, token, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := fetchProjectKey(url, token, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if raw, err := hex.DecodeString(key.WrappedDataKey); err == nil && crypto.IsSealed(raw) {
+		var privateKey []byte
+		if serviceKey := os.Getenv("BASTION_SERVICE_KEY"); serviceKey != "" {
+			// Service accounts carry their private key in the clear, e.g. as a CI secret
+			privateKey, err = parseServiceKey(serviceKey)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			keys, err := fetchMyKeyPair(url, token)
+			if err != nil {
+				return nil, nil, err
+			}
+			privateKey, err = vault.UnlockPrivateKey(keys, password)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to unlock private key. Invalid password?")
+			}
+		}
+		if key.WrappedGroupKey != "" {
+			privateKey, err = vault.UnwrapGroupKey(privateKey, key.GroupPublicKey, key.WrappedGroupKey)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to open group key: %w", err)
+			}
+		}
+		dataKey, err := vault.UnwrapDataKeyForUser(privateKey, project.ID, key.WrappedDataKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open project grant: %w", err)
+		}
+		return project, dataKey, nil
+	}
+
+	vc, err := fetchVaultConfig(url, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	masterKey, err := vault.UnwrapMasterKey(&db.VaultConfig{WrappedMasterKey: vc.WrappedMasterKey, MasterKeySalt: vc.MasterKeySalt}, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap Master Key. Invalid password?")
+	}
+	dataKey, err := vault.UnwrapHexKey(masterKey, key.WrappedDataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap project data key: %w", err)
+	}
+	return project, dataKey, nil
+}
+
+// fetchProjectKey returns the project data key as wrapped for the authenticated user: under the Master Key
+// for admins, sealed to their public key for direct grants, or sealed to a group they belong to, in which
+// case the group fields are set as well.
+func fetchProjectKey(url, token, projectID string) (*models.GroupProjectKey, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/projects/"+projectID+"/key", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch project key: %s", resp.Status)
+	}
+
+	var result models.GroupProjectKey
+	json.NewDecoder(resp.Body).Decode(&result)
+	return &result, nil
+}
+
+func fetchInvitations(url, token string) ([]models.Invitation, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/invitations", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch invitations: %s", resp.Status)
+	}
+
+	var invitations []models.Invitation
+	json.NewDecoder(resp.Body).Decode(&invitations)
+	return invitations, nil
+}
+
+type userPublicKey struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	PublicKey string    `json:"public_key"`
+}
+
+// fetchPublicKey returns the public key of a user, looked up by ID or username.
+func fetchPublicKey(url, token, user string) (*userPublicKey, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/users/"+neturl.PathEscape(user)+"/public-key", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("user '%s' not found or has no keypair yet", user)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch public key: %s", resp.Status)
+	}
+
+	var result userPublicKey
+	json.NewDecoder(resp.Body).Decode(&result)
+	return &result, nil
+}
+
+// fetchProjectAccess returns the users granted access to a project, with their public keys.
+func fetchProjectAccess(url, token, projectID string) ([]models.ProjectAccess, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/projects/"+projectID+"/access", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch project access: %s", resp.Status)
+	}
+
+	var entries []models.ProjectAccess
+	json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, nil
+}
+
+// fetchProjectGroupAccess returns the groups granted access to a project, with their public keys.
+func fetchProjectGroupAccess(url, token, projectID string) ([]models.GroupProjectAccess, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/projects/"+projectID+"/groups", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch group access: %s", resp.Status)
+	}
+
+	var entries []models.GroupProjectAccess
+	json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, nil
+}
+
+// fetchProjectServiceAccountAccess returns the service accounts holding a project's data key, with their
+// public keys.
+func fetchProjectServiceAccountAccess(url, token, projectID string) ([]models.ServiceAccountProjectAccess, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/projects/"+projectID+"/service-accounts", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch service account access: %s", resp.Status)
+	}
+
+	var entries []models.ServiceAccountProjectAccess
+	json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, nil
+}
+
+func fetchGroups(url, token string) ([]models.Group, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/groups", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch groups: %s", resp.Status)
+	}
+
+	var groups []models.Group
+	json.NewDecoder(resp.Body).Decode(&groups)
+	return groups, nil
+}
+
+// findGroup resolves a group by ID or name.
+func findGroup(url, token, group string) (*models.Group, error) {
+	groups, err := fetchGroups(url, token)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if groups[i].ID.String() == group || groups[i].Name == group {
+			return &groups[i], nil
+		}
+	}
+	return nil, fmt.Errorf("group '%s' not found", group)
+}
+
+// fetchMyGroupKey returns the authenticated user's membership of a group, with the group key sealed to them.
+func fetchMyGroupKey(url, token string, groupID uuid.UUID) (*models.GroupMember, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/groups/"+groupID.String()+"/key", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("you are not a member of this group")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch group key: %s", resp.Status)
+	}
+
+	var member models.GroupMember
+	json.NewDecoder(resp.Body).Decode(&member)
+	return &member, nil
+}
+
+// errNoKeyPair is returned by fetchMyKeyPair when the authenticated user has not generated a keypair yet.
+var errNoKeyPair = fmt.Errorf("no keypair found, run 'bastion create keypair' first")
+
+// fetchMyKeyPair returns the authenticated user's keypair, with the private key still encrypted.
+func fetchMyKeyPair(url, token string) (*models.UserKeyPair, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/auth/keys", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoKeyPair
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch keypair: %s", resp.Status)
+	}
+
+	var keys models.UserKeyPair
+	json.NewDecoder(resp.Body).Decode(&keys)
+	return &keys, nil
+}
+
 // CheckForUpdates checks GitHub for the latest release and displays a warning if a new version is available.
 // It caches the last check time to avoid frequent API calls.
 func CheckForUpdates() {
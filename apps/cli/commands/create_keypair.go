@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var keypairPassword string
+
+var createKeyPairCmd = &cobra.Command{
+	Use:   "keypair",
+	Short: "Generate your personal keypair so project access can be granted to you",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pterm.DefaultHeader.WithFullWidth().Println("KEYPAIR GENERATION")
+
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Checking for an existing keypair...")
+		existing, err := fetchMyKeyPair(activeProfile.URL, activeProfile.Token)
+		if err == nil {
+			spinner.Success("You already have a keypair.")
+			pterm.DefaultBox.WithTitle("Public Key").Println(existing.PublicKey)
+			return nil
+		}
+		if !errors.Is(err, errNoKeyPair) {
+			spinner.Fail(err.Error())
+			return err
+		}
+		spinner.Success("No keypair found, a new one will be generated.")
+
+		password := keypairPassword
+		if password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your login password (a vault password of your choice if you log in with single sign-on) to protect the private key")
+			if err != nil {
+				return err
+			}
+			confirm, _ := pterm.DefaultInteractiveTextInput.WithMask("*").Show("Confirm password")
+			if password != confirm {
+				pterm.Error.Println("Passwords do not match!")
+				return fmt.Errorf("passwords do not match")
+			}
+		}
+
+		spinner, _ = pterm.DefaultSpinner.Start("Generating and encrypting keypair...")
+		keys, _, err := vault.NewUserKeyPair(password)
+		if err != nil {
+			spinner.Fail("Failed to generate keypair: " + err.Error())
+			return err
+		}
+
+		payload, _ := json.Marshal(keys)
+		req, _ := http.NewRequest("PUT", activeProfile.URL+"/api/v1/auth/keys", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			body, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to store keypair: %s", string(body)))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		spinner.Success("Keypair generated! Your private key never leaves this machine unencrypted.")
+		pterm.DefaultBox.WithTitle("Public Key").Println(keys.PublicKey)
+		return nil
+	},
+}
+
+func init() {
+	createKeyPairCmd.Flags().StringVarP(&keypairPassword, "password", "p", "", "Login password, or vault password for single sign-on accounts, used to encrypt the private key")
+	createCmd.AddCommand(createKeyPairCmd)
+}
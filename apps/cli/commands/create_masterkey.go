@@ -2,11 +2,11 @@ package commands
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 
 	"github.com/dcdavidev/bastion/packages/crypto"
 	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/vault"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
@@ -27,9 +27,10 @@ var createMasterKeyCmd = &cobra.Command{
 		spinner.Success("Connected to database!")
 
 		// 1. Check if vault is already initialized
-		vault, err := database.GetVaultConfig(context.Background())
-		if err == nil && vault.WrappedMasterKey != "" {
+		vaultConfig, err := database.GetVaultConfig(context.Background())
+		if err == nil && vaultConfig.WrappedMasterKey != "" {
 			pterm.Warning.Println("Vault is already initialized with a Master Key.")
+			pterm.Info.Println("To replace it without losing data, use 'bastion rotate masterkey' instead.")
 			confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show("Do you want to OVERWRITE the existing Master Key? (THIS WILL RENDER ALL EXISTING SECRETS UNREADABLE!)")
 			if !confirm {
 				pterm.Info.Println("Operation cancelled.")
@@ -55,19 +56,17 @@ var createMasterKeyCmd = &cobra.Command{
 		spinner, _ = pterm.DefaultSpinner.Start("Generating and wrapping new Master Key...")
 
 		masterKey, _ := crypto.GenerateRandomKey()
-		salt, _ := crypto.GenerateSalt()
-		kek := crypto.DeriveKey([]byte(password), salt)
-		wrappedMK, err := crypto.WrapKey(kek, masterKey)
+		wrappedMK, salt, err := vault.WrapMasterKey(masterKey, password)
 		if err != nil {
 			spinner.Fail("Failed to wrap key: " + err.Error())
 			return err
 		}
 
 		// 4. Save
-		if vault != nil && vault.WrappedMasterKey != "" {
-			err = database.UpdateVaultConfig(context.Background(), hex.EncodeToString(wrappedMK), hex.EncodeToString(salt))
+		if vaultConfig != nil && vaultConfig.WrappedMasterKey != "" {
+			err = database.UpdateVaultConfig(context.Background(), wrappedMK, salt)
 		} else {
-			err = database.InitializeVault(context.Background(), hex.EncodeToString(wrappedMK), hex.EncodeToString(salt))
+			err = database.InitializeVault(context.Background(), wrappedMK, salt)
 		}
 
 		if err != nil {
@@ -13,6 +13,7 @@ import (
 	"github.com/dcdavidev/bastion/packages/crypto"
 	"github.com/dcdavidev/bastion/packages/db"
 	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
 	"github.com/google/uuid"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
@@ -61,8 +62,7 @@ var createProjectCmd = &cobra.Command{
 		// even if the URL is localhost. Otherwise we fallback to local DB access.
 		isRemote := activeProfile != nil && activeProfile.Token != "" && activeProfile.URL != ""
 
-		var vaultSalt []byte
-		var wrappedMK []byte
+		var vaultConfig *db.VaultConfig
 
 		if isRemote {
 			// Remote Mode
@@ -81,8 +81,7 @@ var createProjectCmd = &cobra.Command{
 				MasterKeySalt    string `json:"master_key_salt"`
 			}
 			json.NewDecoder(resp.Body).Decode(&vc)
-			vaultSalt, _ = hex.DecodeString(vc.MasterKeySalt)
-			wrappedMK, _ = hex.DecodeString(vc.WrappedMasterKey)
+			vaultConfig = &db.VaultConfig{WrappedMasterKey: vc.WrappedMasterKey, MasterKeySalt: vc.MasterKeySalt}
 		} else {
 			// Local Mode
 			spinner.UpdateText("Connecting to local database...")
@@ -92,19 +91,16 @@ var createProjectCmd = &cobra.Command{
 				return err
 			}
 			defer database.Close()
-			vc, err := database.GetVaultConfig(context.Background())
+			vaultConfig, err = database.GetVaultConfig(context.Background())
 			if err != nil {
 				spinner.Fail("Vault not initialized")
 				return err
 			}
-			vaultSalt, _ = hex.DecodeString(vc.MasterKeySalt)
-			wrappedMK, _ = hex.DecodeString(vc.WrappedMasterKey)
 		}
 
 		// Derive KEK and unwrap Master Key
 		spinner.UpdateText("Deriving keys and unwrapping Master Key...")
-		kek := crypto.DeriveKey([]byte(password), vaultSalt)
-		masterKey, err := crypto.UnwrapKey(kek, wrappedMK)
+		masterKey, err := vault.UnwrapMasterKey(vaultConfig, password)
 		if err != nil {
 			spinner.Fail("Failed to unwrap Master Key. Invalid password?")
 			return err
@@ -1,9 +1,14 @@
 package commands
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
@@ -64,10 +69,184 @@ var dbMigrateCmd = &cobra.Command{
 	},
 }
 
+var (
+	verifyPassword string
+	verifyJSON     bool
+)
+
+var dbVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify that every project key and secret version is still decryptable",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !verifyJSON {
+			pterm.DefaultHeader.WithFullWidth().Println("VAULT INTEGRITY CHECK")
+		}
+
+		password := verifyPassword
+		if password == "" {
+			var err error
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Admin Password to unwrap Master Key")
+			if err != nil {
+				return err
+			}
+		}
+
+		database, err := db.NewConnection()
+		if err != nil {
+			return fmt.Errorf("connection failed: %w", err)
+		}
+		defer database.Close()
+
+		var spinner *pterm.SpinnerPrinter
+		if !verifyJSON {
+			spinner, _ = pterm.DefaultSpinner.Start("Decrypting project keys and secrets...")
+		}
+
+		report, err := vault.Verify(context.Background(), database, password)
+		if err != nil {
+			if spinner != nil {
+				spinner.Fail("Verification aborted: " + err.Error())
+			}
+			return err
+		}
+
+		if verifyJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			summary := fmt.Sprintf("Checked %d projects, %d secret versions and %d access keys.",
+				report.ProjectsChecked, report.SecretsChecked, report.AccessKeysChecked)
+			if report.OK {
+				spinner.Success(summary)
+			} else {
+				spinner.Fail(summary)
+				tableData := pterm.TableData{{"Kind", "ID", "Project", "Key", "Version", "Error"}}
+				for _, issue := range report.Issues {
+					version := ""
+					if issue.Version > 0 {
+						version = fmt.Sprintf("%d", issue.Version)
+					}
+					tableData = append(tableData, []string{issue.Kind, issue.ID, issue.ProjectID, issue.Key, version, issue.Error})
+				}
+				pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+			}
+		}
+
+		if !report.OK {
+			return fmt.Errorf("vault verification found %d broken items", len(report.Issues))
+		}
+		return nil
+	},
+}
+
+var upgradePassword string
+
+var dbUpgradeSecretsCmd = &cobra.Command{
+	Use:   "upgrade-secrets",
+	Short: "Re-encrypt legacy secret versions into AAD-bound envelopes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pterm.DefaultHeader.WithFullWidth().Println("SECRET FORMAT UPGRADE")
+
+		password := upgradePassword
+		if password == "" {
+			var err error
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Admin Password to unwrap Master Key")
+			if err != nil {
+				return err
+			}
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Connecting to database...")
+		database, err := db.NewConnection()
+		if err != nil {
+			spinner.Fail("Connection failed: " + err.Error())
+			return err
+		}
+		defer database.Close()
+
+		ctx := context.Background()
+		vaultConfig, err := database.GetVaultConfig(ctx)
+		if err != nil {
+			spinner.Fail("Vault not initialized")
+			return err
+		}
+
+		masterKey, err := vault.UnwrapMasterKey(vaultConfig, password)
+		if err != nil {
+			spinner.Fail("Failed to unwrap Master Key. Invalid password?")
+			return err
+		}
+
+		projects, err := database.GetAllProjects(ctx)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		upgraded := 0
+		for _, project := range projects {
+			spinner.UpdateText(fmt.Sprintf("Upgrading project '%s'...", project.Name))
+
+			dataKey, err := vault.UnwrapHexKey(masterKey, project.WrappedDataKey)
+			if err != nil {
+				spinner.Fail(fmt.Sprintf("Failed to unwrap data key of project '%s'", project.Name))
+				return err
+			}
+
+			secrets, err := database.GetAllSecretVersions(ctx, project.ID)
+			if err != nil {
+				spinner.Fail(err.Error())
+				return err
+			}
+
+			values, err := vault.UpgradeSecrets(dataKey, secrets)
+			if err != nil {
+				spinner.Fail(fmt.Sprintf("Project '%s': %v", project.Name, err))
+				pterm.Info.Println("Run 'bastion db verify' to list broken secrets.")
+				return err
+			}
+			if len(values) == 0 {
+				continue
+			}
+
+			if err := database.UpdateSecretValues(ctx, project.ID, values); err != nil {
+				spinner.Fail(fmt.Sprintf("Failed to save project '%s': %v", project.Name, err))
+				return err
+			}
+
+			database.LogEvent(ctx, "UPGRADE_SECRETS", "PROJECT", project.ID, map[string]interface{}{
+				"secrets": len(values),
+				"source":  "cli",
+			})
+			upgraded += len(values)
+		}
+
+		// Every secret is now bound to its project, key and version, so clients stop accepting the legacy format
+		if err := database.DisallowLegacySecrets(ctx); err != nil {
+			spinner.Fail("Failed to retire the legacy secret format: " + err.Error())
+			return err
+		}
+		database.LogEvent(ctx, "DISALLOW_LEGACY_SECRETS", "VAULT", uuid.Nil, map[string]interface{}{
+			"source": "cli",
+		})
+
+		spinner.Success(fmt.Sprintf("Upgraded %d legacy secret versions across %d projects. Legacy secrets are no longer accepted.", upgraded, len(projects)))
+		return nil
+	},
+}
+
 func init() {
 	dbCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		return dbInteractive()
 	}
+	dbVerifyCmd.Flags().StringVarP(&verifyPassword, "password", "p", "", "Admin password to unwrap the Master Key")
+	dbVerifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "Print a machine-readable JSON report")
 	dbCmd.AddCommand(dbMigrateCmd)
+	dbUpgradeSecretsCmd.Flags().StringVarP(&upgradePassword, "password", "p", "", "Admin password to unwrap the Master Key")
+	dbCmd.AddCommand(dbVerifyCmd)
+	dbCmd.AddCommand(dbUpgradeSecretsCmd)
 	rootCmd.AddCommand(dbCmd)
 }
@@ -0,0 +1,379 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	groupName     string
+	groupPassword string
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage groups of collaborators sharing project access",
+}
+
+var groupCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a group with a new group keypair, sealed to your public key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		name := groupName
+		if name == "" {
+			var err error
+			name, err = pterm.DefaultInteractiveTextInput.Show("Enter group name")
+			if err != nil {
+				return err
+			}
+		}
+		if name == "" {
+			return fmt.Errorf("a group name is required")
+		}
+
+		keys, err := fetchMyKeyPair(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			return err
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Generating group keypair...")
+		publicKey, privateKey, err := vault.NewGroupKeyPair()
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		wrapped, err := vault.WrapGroupKeyForMember(keys.PublicKey, publicKey, privateKey)
+		if err != nil {
+			spinner.Fail("Failed to seal group key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Creating group...")
+		payload, _ := json.Marshal(map[string]string{
+			"name":              name,
+			"public_key":        publicKey,
+			"wrapped_group_key": wrapped,
+		})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/groups", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to create group: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		var group models.Group
+		json.NewDecoder(resp.Body).Decode(&group)
+
+		spinner.Success(fmt.Sprintf("Group '%s' created (ID: %s).", group.Name, group.ID))
+		return nil
+	},
+}
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List groups",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		groups, err := fetchGroups(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			pterm.Info.Println("No groups found.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "Name", "Members", "Created"}}
+		for _, g := range groups {
+			tableData = append(tableData, []string{g.ID.String(), g.Name, strconv.Itoa(g.MemberCount), g.CreatedAt.Local().Format("2006-01-02 15:04")})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var groupMembersCmd = &cobra.Command{
+	Use:   "members [GROUP]",
+	Short: "List the members of a group",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		group, err := groupArg(args)
+		if err != nil {
+			return err
+		}
+
+		req, _ := http.NewRequest("GET", activeProfile.URL+"/api/v1/groups/"+group.ID.String()+"/members", nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to fetch members: %s", resp.Status)
+		}
+
+		var members []models.GroupMember
+		json.NewDecoder(resp.Body).Decode(&members)
+
+		tableData := pterm.TableData{{"User ID", "Username", "Email", "Added"}}
+		for _, m := range members {
+			added := "-"
+			if m.AddedAt != nil {
+				added = m.AddedAt.Local().Format("2006-01-02 15:04")
+			}
+			tableData = append(tableData, []string{m.UserID.String(), m.Username, m.Email, added})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var groupAddCmd = &cobra.Command{
+	Use:   "add [GROUP] [USER]",
+	Short: "Add a user to a group by sealing the group key to their public key",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		group, err := groupArg(args)
+		if err != nil {
+			return err
+		}
+		user, err := accessUserArg(args[min(1, len(args)):])
+		if err != nil {
+			return err
+		}
+
+		password := groupPassword
+		if password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your password to unlock the group key")
+			if err != nil {
+				return err
+			}
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Unlocking group key...")
+		groupPriv, err := unlockGroupKey(group, password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Fetching the user's public key...")
+		member, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		wrapped, err := vault.WrapGroupKeyForMember(member.PublicKey, group.PublicKey, groupPriv)
+		if err != nil {
+			spinner.Fail("Failed to seal group key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Adding member...")
+		payload, _ := json.Marshal(map[string]interface{}{
+			"user_id":           member.UserID,
+			"wrapped_group_key": wrapped,
+		})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/groups/"+group.ID.String()+"/members", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to add member: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		spinner.Success(fmt.Sprintf("%s added to group '%s'.", member.Username, group.Name))
+		return nil
+	},
+}
+
+var groupRemoveCmd = &cobra.Command{
+	Use:   "remove [GROUP] [USER]",
+	Short: "Remove a user from a group",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		group, err := groupArg(args)
+		if err != nil {
+			return err
+		}
+		user, err := accessUserArg(args[min(1, len(args)):])
+		if err != nil {
+			return err
+		}
+
+		// Members may not have a public key lookup, so resolve them from the member list
+		req, _ := http.NewRequest("GET", activeProfile.URL+"/api/v1/groups/"+group.ID.String()+"/members", nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		var members []models.GroupMember
+		json.NewDecoder(resp.Body).Decode(&members)
+		resp.Body.Close()
+
+		var member *models.GroupMember
+		for i := range members {
+			if members[i].UserID.String() == user || members[i].Username == user {
+				member = &members[i]
+			}
+		}
+		if member == nil {
+			return fmt.Errorf("user '%s' is not a member of group '%s'", user, group.Name)
+		}
+
+		req, _ = http.NewRequest("DELETE", activeProfile.URL+"/api/v1/groups/"+group.ID.String()+"/members/"+member.UserID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("failed to remove member: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("%s removed from group '%s'.\n", member.Username, group.Name)
+		pterm.Warning.Println("They may still hold a copy of the group's project keys. Rotate the group's projects to replace them.")
+		return nil
+	},
+}
+
+var groupDeleteCmd = &cobra.Command{
+	Use:   "delete [GROUP]",
+	Short: "Delete a group and its project grants",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		group, err := groupArg(args)
+		if err != nil {
+			return err
+		}
+
+		confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show(fmt.Sprintf("Delete group '%s' and all its project grants?", group.Name))
+		if !confirm {
+			pterm.Info.Println("Operation cancelled.")
+			return nil
+		}
+
+		req, _ := http.NewRequest("DELETE", activeProfile.URL+"/api/v1/groups/"+group.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("failed to delete group: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("Group '%s' deleted.\n", group.Name)
+		return nil
+	},
+}
+
+func groupArg(args []string) (*models.Group, error) {
+	var group string
+	if len(args) > 0 {
+		group = args[0]
+	} else {
+		var err error
+		group, err = pterm.DefaultInteractiveTextInput.Show("Enter group name or ID")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return findGroup(activeProfile.URL, activeProfile.Token, group)
+}
+
+// unlockGroupKey opens the group private key sealed to the authenticated member.
+func unlockGroupKey(group *models.Group, password string) ([]byte, error) {
+	membership, err := fetchMyGroupKey(activeProfile.URL, activeProfile.Token, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := fetchMyKeyPair(activeProfile.URL, activeProfile.Token)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := vault.UnlockPrivateKey(keys, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock private key. Invalid password?")
+	}
+	return vault.UnwrapGroupKey(privateKey, group.PublicKey, membership.WrappedGroupKey)
+}
+
+func init() {
+	groupCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return groupInteractive()
+	}
+	groupCreateCmd.Flags().StringVarP(&groupName, "name", "n", "", "Group name")
+	groupAddCmd.Flags().StringVarP(&groupPassword, "password", "p", "", "Your password, to unlock the group key")
+	groupCmd.AddCommand(groupCreateCmd)
+	groupCmd.AddCommand(groupListCmd)
+	groupCmd.AddCommand(groupMembersCmd)
+	groupCmd.AddCommand(groupAddCmd)
+	groupCmd.AddCommand(groupRemoveCmd)
+	groupCmd.AddCommand(groupDeleteCmd)
+	rootCmd.AddCommand(groupCmd)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/dcdavidev/bastion/packages/crypto"
 	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/vault"
 	"github.com/joho/godotenv"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
@@ -91,24 +92,37 @@ var initCmd = &cobra.Command{
 
 			spinner, _ = pterm.DefaultSpinner.Start("Creating admin and initializing vault...")
 
-			salt, _ := crypto.GenerateSalt()
-			hash := crypto.DeriveKey([]byte(password), salt)
-
-			saltHex := hex.EncodeToString(salt)
-			hashHex := hex.EncodeToString(hash)
+			hashHex, saltEncoded, err := crypto.HashPassword(password)
+			if err != nil {
+				spinner.Fail("Failed to hash password: " + err.Error())
+				return err
+			}
 
-			user, err := database.CreateUser(context.Background(), username, email, hashHex, saltHex, "ADMIN")
+			user, err := database.CreateUser(context.Background(), username, email, hashHex, saltEncoded, "ADMIN")
 			if err != nil {
 				spinner.Fail("Failed to create admin: " + err.Error())
 				return err
 			}
 
-			// Initialize Vault
+			// Generate the admin's keypair so project data keys can be sealed to it
+			keys, _, err := vault.NewUserKeyPair(password)
+			if err == nil {
+				err = database.SetUserKeyPair(context.Background(), user.ID, keys)
+			}
+			if err != nil {
+				spinner.Fail("Failed to create keypair: " + err.Error())
+				return err
+			}
+
+			// Initialize Vault (the KEK uses its own salt, so it never equals the stored password hash)
 			masterKey, _ := crypto.GenerateRandomKey()
-			kek := crypto.DeriveKey([]byte(password), salt)
-			wrappedMK, _ := crypto.WrapKey(kek, masterKey)
+			wrappedMK, vaultSalt, err := vault.WrapMasterKey(masterKey, password)
+			if err != nil {
+				spinner.Fail("Failed to wrap Master Key: " + err.Error())
+				return err
+			}
 
-			err = database.InitializeVault(context.Background(), hex.EncodeToString(wrappedMK), hex.EncodeToString(salt))
+			err = database.InitializeVault(context.Background(), wrappedMK, vaultSalt)
 			if err != nil {
 				spinner.Fail("Failed to initialize vault: " + err.Error())
 				return err
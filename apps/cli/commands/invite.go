@@ -0,0 +1,377 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inviteEmail     string
+	inviteProjectID string
+	inviteClient    string
+	inviteExpires   int
+	inviteRole      string
+	invitePassword  string
+
+	acceptToken    string
+	acceptEmail    string
+	acceptUsername string
+	acceptPassword string
+)
+
+var inviteCmd = &cobra.Command{
+	Use:   "invite",
+	Short: "Invite collaborators and manage pending invitations",
+}
+
+var inviteCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Issue a single-use invite token bound to an email address",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		if inviteEmail == "" {
+			var err error
+			inviteEmail, err = pterm.DefaultInteractiveTextInput.Show("Enter the invitee's email")
+			if err != nil {
+				return err
+			}
+		}
+
+		body := map[string]interface{}{
+			"email":            inviteEmail,
+			"expires_in_hours": inviteExpires,
+		}
+		if inviteProjectID != "" {
+			if _, err := uuid.Parse(inviteProjectID); err != nil {
+				return fmt.Errorf("invalid project ID: %w", err)
+			}
+			body["project_id"] = inviteProjectID
+			body["project_role"] = inviteRole
+		}
+		if inviteClient != "" {
+			client, err := findClient(activeProfile.URL, activeProfile.Token, inviteClient)
+			if err != nil {
+				return err
+			}
+			body["client_id"] = client.ID
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Creating invitation...")
+
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/invitations", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to create invitation: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		var result struct {
+			Invitation models.Invitation `json:"invitation"`
+			Token      string            `json:"token"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+
+		spinner.Success(fmt.Sprintf("Invitation for %s created (expires %s).", result.Invitation.Email, result.Invitation.ExpiresAt.Local().Format(time.RFC1123)))
+		pterm.DefaultBox.WithTitle("Invite Token (shown only once)").Println(result.Token)
+		pterm.Info.Printf("Send the token to the invitee over a trusted channel. They redeem it with:\n  bastion accept-invite --url %s --email %s --token <TOKEN>\n", activeProfile.URL, result.Invitation.Email)
+		if result.Invitation.ProjectID != nil {
+			pterm.Info.Printf("Once accepted, complete the project grant with: bastion invite grant %s\n", result.Invitation.ID)
+		}
+		return nil
+	},
+}
+
+var inviteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List invitations and their status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		invitations, err := fetchInvitations(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			return err
+		}
+
+		if len(invitations) == 0 {
+			pterm.Info.Println("No invitations found.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "Email", "Project", "Status", "Expires"}}
+		for _, inv := range invitations {
+			project := "-"
+			if inv.ProjectID != nil {
+				project = inv.ProjectID.String()
+			}
+			tableData = append(tableData, []string{inv.ID.String(), inv.Email, project, inv.Status, inv.ExpiresAt.Local().Format("2006-01-02 15:04")})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var inviteRevokeCmd = &cobra.Command{
+	Use:   "revoke [INVITATION_ID]",
+	Short: "Revoke an invitation that has not been redeemed yet",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		id, err := invitationIDArg(args)
+		if err != nil {
+			return err
+		}
+
+		req, _ := http.NewRequest("DELETE", activeProfile.URL+"/api/v1/invitations/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("failed to revoke invitation: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Println("Invitation revoked.")
+		return nil
+	},
+}
+
+var inviteGrantCmd = &cobra.Command{
+	Use:   "grant [INVITATION_ID]",
+	Short: "Complete an accepted invitation by sealing the project key to the invitee's public key",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		id, err := invitationIDArg(args)
+		if err != nil {
+			return err
+		}
+
+		invitations, err := fetchInvitations(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			return err
+		}
+		var invitation *models.Invitation
+		for i := range invitations {
+			if invitations[i].ID.String() == id {
+				invitation = &invitations[i]
+			}
+		}
+		switch {
+		case invitation == nil:
+			return fmt.Errorf("invitation %s not found", id)
+		case invitation.ProjectID == nil:
+			return fmt.Errorf("invitation %s has no project to grant", id)
+		case invitation.Status != models.InvitationAccepted:
+			return fmt.Errorf("invitation %s is %s, it must be accepted before the grant can be completed", id, invitation.Status)
+		}
+
+		password := invitePassword
+		if password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Admin Password to unwrap the project key")
+			if err != nil {
+				return err
+			}
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Fetching the invitee's public key...")
+		invitee, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, invitation.AcceptedUserID.String())
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Unwrapping project key...")
+		project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, invitation.ProjectID.String(), password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		wrapped, err := vault.WrapDataKeyForUser(invitee.PublicKey, project.ID, dataKey)
+		if err != nil {
+			spinner.Fail("Failed to seal project key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Completing grant...")
+		payload, _ := json.Marshal(map[string]string{"wrapped_data_key": wrapped})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/invitations/"+id+"/grant", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to complete grant: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		spinner.Success(fmt.Sprintf("%s now has access to project '%s'.", invitation.Email, project.Name))
+		return nil
+	},
+}
+
+var acceptInviteCmd = &cobra.Command{
+	Use:   "accept-invite",
+	Short: "Redeem an invite token: choose your password and generate your keypair locally",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pterm.DefaultHeader.WithFullWidth().Println("ACCEPT INVITATION")
+
+		serverURL, _ := cmd.Flags().GetString("url")
+		if serverURL == "" {
+			var err error
+			serverURL, err = pterm.DefaultInteractiveTextInput.Show("Enter Bastion Server URL")
+			if err != nil {
+				return err
+			}
+		}
+		if !strings.HasPrefix(serverURL, "http") {
+			serverURL = "http://" + serverURL
+		}
+
+		var err error
+		if acceptToken == "" {
+			if acceptToken, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Invite Token"); err != nil {
+				return err
+			}
+		}
+		if acceptEmail == "" {
+			if acceptEmail, err = pterm.DefaultInteractiveTextInput.Show("Enter the email you were invited with"); err != nil {
+				return err
+			}
+		}
+		if acceptUsername == "" {
+			if acceptUsername, err = pterm.DefaultInteractiveTextInput.Show("Choose a username"); err != nil {
+				return err
+			}
+		}
+		if acceptPassword == "" {
+			if acceptPassword, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Choose a password"); err != nil {
+				return err
+			}
+			confirm, _ := pterm.DefaultInteractiveTextInput.WithMask("*").Show("Confirm password")
+			if acceptPassword != confirm {
+				pterm.Error.Println("Passwords do not match!")
+				return fmt.Errorf("passwords do not match")
+			}
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Generating your keypair...")
+		keys, _, err := vault.NewUserKeyPair(acceptPassword)
+		if err != nil {
+			spinner.Fail("Failed to generate keypair: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Registering...")
+		payload, _ := json.Marshal(map[string]interface{}{
+			"token":    acceptToken,
+			"email":    acceptEmail,
+			"username": acceptUsername,
+			"password": acceptPassword,
+			"key_pair": keys,
+		})
+
+		resp, err := http.Post(serverURL+"/api/v1/invitations/accept", "application/json", bytes.NewBuffer(payload))
+		if err != nil {
+			spinner.Fail("Failed to connect to server")
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			msg, _ := io.ReadAll(resp.Body)
+			spinner.Fail(fmt.Sprintf("Failed to accept invitation: %s", strings.TrimSpace(string(msg))))
+			return fmt.Errorf("api error: %s", resp.Status)
+		}
+
+		spinner.Success(fmt.Sprintf("Welcome, %s! Your account has been created.", acceptUsername))
+		pterm.Info.Println("Project access is granted once an admin completes the invitation.")
+		pterm.Info.Printf("Log in with: bastion login --url %s --email %s\n", serverURL, acceptEmail)
+		return nil
+	},
+}
+
+func invitationIDArg(args []string) (string, error) {
+	id := ""
+	if len(args) > 0 {
+		id = args[0]
+	} else {
+		var err error
+		id, err = pterm.DefaultInteractiveTextInput.Show("Enter Invitation ID")
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return "", fmt.Errorf("invalid invitation ID: %w", err)
+	}
+	return id, nil
+}
+
+func init() {
+	inviteCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return inviteInteractive()
+	}
+	inviteCreateCmd.Flags().StringVarP(&inviteEmail, "email", "e", "", "Email address of the invitee")
+	inviteCreateCmd.Flags().StringVarP(&inviteProjectID, "project", "i", "", "Project to grant once the invitation is accepted")
+	inviteCreateCmd.Flags().StringVarP(&inviteRole, "role", "r", "editor", "Project role once granted: viewer, editor or project-admin")
+	inviteCreateCmd.Flags().StringVarP(&inviteClient, "client", "c", "", "Client (name or ID) to restrict the invitee to, as a portal account")
+	inviteCreateCmd.Flags().IntVar(&inviteExpires, "expires", 72, "Hours until the invitation expires")
+	inviteGrantCmd.Flags().StringVarP(&invitePassword, "password", "p", "", "Admin password to unwrap the project key")
+	inviteCmd.AddCommand(inviteCreateCmd)
+	inviteCmd.AddCommand(inviteListCmd)
+	inviteCmd.AddCommand(inviteRevokeCmd)
+	inviteCmd.AddCommand(inviteGrantCmd)
+	rootCmd.AddCommand(inviteCmd)
+
+	acceptInviteCmd.Flags().StringVarP(&acceptToken, "token", "t", "", "Invite token")
+	acceptInviteCmd.Flags().StringVarP(&acceptEmail, "email", "e", "", "Email address the invitation was sent to")
+	acceptInviteCmd.Flags().StringVarP(&acceptUsername, "username", "n", "", "Username to register")
+	acceptInviteCmd.Flags().StringVarP(&acceptPassword, "password", "p", "", "Password to register (avoids interactive prompt)")
+	rootCmd.AddCommand(acceptInviteCmd)
+}
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var lockoutCmd = &cobra.Command{
+	Use:   "lockout",
+	Short: "Manage accounts locked after failed logins",
+}
+
+var lockoutListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List locked accounts (admin)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		lockouts, err := fetchLoginLockouts()
+		if err != nil {
+			return err
+		}
+		if len(lockouts) == 0 {
+			pterm.Info.Println("No account is locked.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"User", "ID", "Locked until"}}
+		for _, l := range lockouts {
+			id := "(environment admin)"
+			if l.UserID != nil {
+				id = l.UserID.String()
+			}
+			tableData = append(tableData, []string{l.Username, id, l.LockedUntil.Local().Format("2006-01-02 15:04")})
+		}
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var lockoutUnlockCmd = &cobra.Command{
+	Use:   "unlock [USER]",
+	Short: "Unlock an account and forget its failed logins (admin). Use 'admin' for the environment admin",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		user := ""
+		if len(args) > 0 {
+			user = args[0]
+		} else {
+			lockouts, err := fetchLoginLockouts()
+			if err != nil {
+				return err
+			}
+			if len(lockouts) == 0 {
+				pterm.Info.Println("No account is locked.")
+				return nil
+			}
+			var options []string
+			for _, l := range lockouts {
+				options = append(options, fmt.Sprintf("%s - locked until %s", l.Username, l.LockedUntil.Local().Format("2006-01-02 15:04")))
+			}
+			selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Select an account")
+			if err != nil {
+				return err
+			}
+			user = strings.Split(selected, " ")[0]
+		}
+
+		userID := user
+		if user == "admin" {
+			userID = uuid.Nil.String()
+		} else if _, err := uuid.Parse(user); err != nil {
+			found, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+			if err != nil {
+				return err
+			}
+			userID = found.UserID.String()
+		}
+
+		if err := apiRequest("DELETE", "/api/v1/users/"+userID+"/lockout", nil, http.StatusNoContent, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Printf("Account %s unlocked.\n", user)
+		return nil
+	},
+}
+
+func fetchLoginLockouts() ([]models.LoginLockout, error) {
+	var lockouts []models.LoginLockout
+	if err := apiRequest("GET", "/api/v1/auth/lockouts", nil, http.StatusOK, &lockouts); err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// retryAfterMessage explains a 429 from a login endpoint.
+func retryAfterMessage(resp *http.Response) string {
+	if wait, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s"); err == nil && wait > 0 {
+		return fmt.Sprintf("too many failed logins, try again in %s", wait)
+	}
+	return "too many failed logins, try again later"
+}
+
+func init() {
+	lockoutCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return lockoutInteractive()
+	}
+	lockoutCmd.AddCommand(lockoutListCmd)
+	lockoutCmd.AddCommand(lockoutUnlockCmd)
+	rootCmd.AddCommand(lockoutCmd)
+}
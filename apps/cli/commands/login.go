@@ -3,13 +3,15 @@ package commands
 import (
 	"bytes"
 	"context"
-	"crypto/subtle"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
+	"runtime"
 	"strings"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/dcdavidev/bastion/packages/crypto"
 	"github.com/dcdavidev/bastion/packages/db"
 	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
@@ -32,16 +35,24 @@ type StatusResponse struct {
 		HasPending     bool `json:"has_pending"`
 		IsDirty        bool `json:"is_dirty"`
 	} `json:"migrations"`
-	HasAdmin bool   `json:"has_admin"`
-	Version  string `json:"version"`
+	HasAdmin      bool   `json:"has_admin"`
+	SSOEnabled    bool   `json:"sso_enabled"`
+	LegacySecrets bool   `json:"legacy_secrets"`
+	Version       string `json:"version"`
 }
 
 type loginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 var loginEmail string
 var loginPassword string
+var loginSSO bool
+var loginOTP string
+
+// ssoLoginTimeout is how long the CLI waits for the browser to come back from the identity provider.
+const ssoLoginTimeout = 5 * time.Minute
 
 var loginCmd = &cobra.Command{
 	Use:   "login",
@@ -58,6 +69,9 @@ var loginCmd = &cobra.Command{
 		}
 
 		if serverURL == "" {
+			if loginSSO {
+				return fmt.Errorf("single sign-on needs a server URL")
+			}
 			pterm.Info.Println("No URL provided. Attempting local authentication...")
 			return handleLocalLogin()
 		}
@@ -88,6 +102,27 @@ var loginCmd = &cobra.Command{
 			pterm.Info.Println("We strongly recommend generating a new secure secret. Run 'bastion create secretkey'.")
 		}
 
+		if !loginSSO && status.SSOEnabled && loginEmail == "" && loginPassword == "" {
+			method, err := pterm.DefaultInteractiveSelect.
+				WithOptions([]string{"Single sign-on", "Email and password"}).
+				Show("How do you want to log in?")
+			if err != nil {
+				return err
+			}
+			loginSSO = method == "Single sign-on"
+		}
+
+		if loginSSO {
+			if !status.SSOEnabled {
+				return fmt.Errorf("single sign-on is not configured on this server")
+			}
+			loginResp, err := handleSSOLogin(serverURL)
+			if err != nil {
+				return err
+			}
+			return saveLoginToConfig(serverURL, loginResp.Token, loginResp.RefreshToken)
+		}
+
 		if loginEmail == "" {
 			var err error
 			loginEmail, err = pterm.DefaultInteractiveTextInput.Show("Enter Email (leave empty for Admin fallback)")
@@ -118,22 +153,214 @@ var loginCmd = &cobra.Command{
 		}
 		defer resp.Body.Close()
 
+		body, _ := io.ReadAll(resp.Body)
+
+		// A correct password may still need a second factor
+		var challenge mfaChallenge
+		if resp.StatusCode == http.StatusUnauthorized && json.Unmarshal(body, &challenge) == nil && challenge.MFAToken != "" {
+			spinner.Success("Password accepted")
+			var loginResp *loginResponse
+			if challenge.Error == "mfa_enrollment_required" {
+				loginResp, err = handleMFAEnrollment(serverURL, challenge.MFAToken)
+			} else {
+				loginResp, err = handleMFAVerification(serverURL, challenge.MFAToken)
+			}
+			if err != nil {
+				return err
+			}
+			return saveLoginToConfig(serverURL, loginResp.Token, loginResp.RefreshToken)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			spinner.Fail("Authentication refused")
+			return fmt.Errorf("%s", retryAfterMessage(resp))
+		}
 		if resp.StatusCode != http.StatusOK {
 			spinner.Fail("Authentication failed")
 			return fmt.Errorf("authentication failed: %s", resp.Status)
 		}
 
 		var loginResp loginResponse
-		if err := json.NewDecoder(resp.Body).Decode(&loginResp); err != nil {
+		if err := json.Unmarshal(body, &loginResp); err != nil {
 			spinner.Fail("Failed to decode response")
 			return fmt.Errorf("failed to decode response: %w", err)
 		}
 
 		spinner.Success("Successfully authenticated!")
-		return saveLoginToConfig(serverURL, loginResp.Token)
+		return saveLoginToConfig(serverURL, loginResp.Token, loginResp.RefreshToken)
 	},
 }
 
+// handleMFAVerification completes a password login with a code from the user's authenticator app, or a
+// recovery code.
+func handleMFAVerification(serverURL, mfaToken string) (*loginResponse, error) {
+	code := loginOTP
+	if code == "" {
+		var err error
+		code, err = pterm.DefaultInteractiveTextInput.Show("Enter the code from your authenticator app, or a recovery code")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	payload := secondFactorPayload(code)
+	payload["mfa_token"] = mfaToken
+
+	var loginResp loginResponse
+	if err := postMFALogin(serverURL+"/api/v1/auth/mfa/verify", payload, &loginResp); err != nil {
+		return nil, err
+	}
+	pterm.Success.Println("Successfully authenticated!")
+	return &loginResp, nil
+}
+
+// handleMFAEnrollment sets up an authenticator app during a login, for users whose role requires a second
+// factor they don't have yet.
+func handleMFAEnrollment(serverURL, mfaToken string) (*loginResponse, error) {
+	pterm.Warning.Println("Your role requires two-factor authentication. Set up an authenticator app to continue.")
+
+	var enrollment totpEnrollment
+	if err := postMFALogin(serverURL+"/api/v1/auth/mfa/enroll", map[string]string{"mfa_token": mfaToken}, &enrollment); err != nil {
+		return nil, err
+	}
+	showTOTPEnrollment(&enrollment)
+
+	code, err := pterm.DefaultInteractiveTextInput.Show("Enter the code shown by your authenticator app")
+	if err != nil {
+		return nil, err
+	}
+
+	var confirmed mfaEnrollResponse
+	if err := postMFALogin(serverURL+"/api/v1/auth/mfa/enroll/confirm", map[string]string{"mfa_token": mfaToken, "code": code}, &confirmed); err != nil {
+		return nil, err
+	}
+
+	pterm.Success.Println("Two-factor authentication enabled.")
+	showRecoveryCodes(confirmed.RecoveryCodes)
+	return &confirmed.loginResponse, nil
+}
+
+func postMFALogin(endpoint string, body map[string]string, out interface{}) error {
+	payload, _ := json.Marshal(body)
+	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%s", retryAfterMessage(resp))
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("authentication failed: %s", strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// handleSSOLogin logs in through the server's identity provider. The browser comes back to a listener on a
+// loopback address with a one-time login code, which only this process can redeem: the server checks it
+// against the PKCE challenge sent when the login started.
+func handleSSOLogin(serverURL string) (*loginResponse, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the login callback: %w", err)
+	}
+
+	verifier, err := crypto.GenerateToken("")
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	results := make(chan url.Values, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if query.Get("error") != "" {
+			fmt.Fprintf(w, "Bastion login failed: %s\n", query.Get("error_description"))
+		} else {
+			fmt.Fprintln(w, "You are logged in to Bastion. You can close this window.")
+		}
+		select {
+		case results <- query:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	loginURL := serverURL + "/api/v1/auth/oidc/login?" + url.Values{
+		"return_to":             {fmt.Sprintf("http://%s/callback", listener.Addr())},
+		"code_challenge":        {auth.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+
+	pterm.Info.Println("Opening your browser to log in. If it does not open, visit:")
+	pterm.Println(loginURL)
+	openBrowser(loginURL)
+
+	spinner, _ := pterm.DefaultSpinner.Start("Waiting for the identity provider...")
+	var query url.Values
+	select {
+	case query = <-results:
+	case <-time.After(ssoLoginTimeout):
+		spinner.Fail("Timed out waiting for the login")
+		return nil, fmt.Errorf("single sign-on timed out")
+	}
+	if e := query.Get("error"); e != "" {
+		spinner.Fail("Single sign-on failed")
+		return nil, fmt.Errorf("single sign-on failed: %s %s", e, query.Get("error_description"))
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"code":          query.Get("code"),
+		"code_verifier": verifier,
+	})
+	resp, err := http.Post(serverURL+"/api/v1/auth/oidc/token", "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		spinner.Fail("Failed to connect to server")
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		spinner.Fail("Authentication failed")
+		return nil, fmt.Errorf("authentication failed: %s", strings.TrimSpace(string(body)))
+	}
+
+	var loginResp loginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&loginResp); err != nil {
+		spinner.Fail("Failed to decode response")
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	spinner.Success("Successfully authenticated!")
+	return &loginResp, nil
+}
+
+// openBrowser tries to open target in the default browser. Failing is fine: the URL is printed too.
+func openBrowser(target string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	_ = cmd.Start()
+}
+
 func handleLocalLogin() error {
 	_ = godotenv.Load()
 
@@ -161,35 +388,46 @@ func handleLocalLogin() error {
 
 	spinner, _ := pterm.DefaultSpinner.Start("Authenticating locally...")
 
+	// The database is needed for user accounts and to record the session in any case
+	database, err := db.NewConnection()
+	if err != nil {
+		spinner.Fail("Failed to connect to local database: " + err.Error())
+		return err
+	}
+	defer database.Close()
+
 	var role string
-	var userID string
+	var userID uuid.UUID
 	var username string
+	var clientID *uuid.UUID
 
 	if loginEmail != "" {
-		database, err := db.NewConnection()
-		if err != nil {
-			spinner.Fail("Failed to connect to local database: " + err.Error())
-			return err
-		}
-		defer database.Close()
-
 		user, storedHashHex, saltHex, err := database.GetUserByEmail(context.Background(), loginEmail)
 		if err != nil {
 			spinner.Fail("User not found in local database")
 			return err
 		}
 
-		salt, _ := hex.DecodeString(saltHex)
-		storedHash, _ := hex.DecodeString(storedHashHex)
-		computedHash := crypto.DeriveKey([]byte(password), salt)
-
-		if subtle.ConstantTimeCompare(computedHash, storedHash) != 1 {
+		ok, needsRehash := crypto.VerifyPassword(password, storedHashHex, saltHex)
+		if !ok {
 			spinner.Fail("Invalid local password")
 			return fmt.Errorf("unauthorized")
 		}
 
+		// Upgrade hashes made with outdated Argon2id parameters
+		if needsRehash {
+			if hash, salt, err := crypto.HashPassword(password); err == nil {
+				_ = database.UpdateUserPassword(context.Background(), user.ID, hash, salt)
+			}
+		}
+
+		if err := verifyLocalSecondFactor(spinner, database, user); err != nil {
+			return err
+		}
+
 		role = user.Role
-		userID = user.ID.String()
+		userID = user.ID
+		clientID = user.ClientID
 		username = user.Username
 	} else {
 		// Admin Fallback
@@ -197,14 +435,28 @@ func handleLocalLogin() error {
 			spinner.Fail("Invalid admin credentials")
 			return fmt.Errorf("unauthorized")
 		}
-		role = "ADMIN"
-		userID = "00000000-0000-0000-0000-000000000000"
+		role = auth.RoleAdmin
+		userID = uuid.Nil // Reserved Admin ID
 		username = "admin"
 	}
 
-	// Generate JWT locally
-	uid, _ := models.ParseUUID(userID)
-	token, err := auth.GenerateToken(uid, username, role == "ADMIN")
+	if err := auth.LoadSigningKeys(context.Background(), database); err != nil {
+		spinner.Fail("Failed to load signing keys: " + err.Error())
+		return err
+	}
+
+	// Open a session and generate its tokens locally
+	refreshToken, err := crypto.GenerateToken("bst_rt_")
+	if err != nil {
+		spinner.Fail("Failed to generate refresh token")
+		return err
+	}
+	session, err := database.CreateSession(context.Background(), userID, crypto.HashToken(refreshToken), "bastion-cli (local)", "local", time.Now().Add(auth.SessionTTL))
+	if err != nil {
+		spinner.Fail("Failed to create session: " + err.Error())
+		return err
+	}
+	token, err := auth.GenerateToken(userID, username, role, clientID, session.ID)
 	if err != nil {
 		spinner.Fail("Failed to generate local token")
 		return err
@@ -218,10 +470,63 @@ func handleLocalLogin() error {
 	}
 	localURL := fmt.Sprintf("http://localhost:%s", port)
 
-	return saveLoginToConfig(localURL, token)
+	return saveLoginToConfig(localURL, token, refreshToken)
+}
+
+// verifyLocalSecondFactor asks for the user's second factor, if they have one. Enrolling needs the server,
+// which shows the new secret and recovery codes.
+func verifyLocalSecondFactor(spinner *pterm.SpinnerPrinter, database *db.DB, user *models.User) error {
+	ctx := context.Background()
+	totp, err := database.GetUserTOTP(ctx, user.ID)
+	if err != nil {
+		spinner.Fail("Failed to read two-factor settings: " + err.Error())
+		return err
+	}
+
+	if totp.EnabledAt == nil {
+		required, err := database.RoleRequiresMFA(ctx, user.Role)
+		if err != nil {
+			spinner.Fail("Failed to read two-factor policy: " + err.Error())
+			return err
+		}
+		if required {
+			spinner.Fail("Your role requires two-factor authentication")
+			return fmt.Errorf("log in through the server once to set up an authenticator app")
+		}
+		return nil
+	}
+
+	code := loginOTP
+	if code == "" {
+		spinner.Stop()
+		code, err = pterm.DefaultInteractiveTextInput.Show("Enter the code from your authenticator app, or a recovery code")
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isTOTPCode(code) {
+		if _, err := database.UseRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(code)); err != nil {
+			spinner.Fail("Invalid recovery code")
+			return fmt.Errorf("unauthorized")
+		}
+		return nil
+	}
+
+	secret, err := auth.OpenTOTPSecret(user.ID, totp.Secret)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	step, ok := auth.VerifyTOTP(secret, code, time.Now(), totp.LastStep)
+	if !ok || database.UseTOTPStep(ctx, user.ID, step) != nil {
+		spinner.Fail("Invalid code")
+		return fmt.Errorf("unauthorized")
+	}
+	return nil
 }
 
-func saveLoginToConfig(serverURL, token string) error {
+func saveLoginToConfig(serverURL, token, refreshToken string) error {
 	parsedURL, err := url.Parse(serverURL)
 	profileName := "local"
 	if err == nil && parsedURL.Host != "" && !strings.Contains(parsedURL.Host, "localhost") {
@@ -234,10 +539,11 @@ func saveLoginToConfig(serverURL, token string) error {
 	}
 
 	cfg.Profiles[profileName] = config.Profile{
-		Name:     profileName,
-		URL:      serverURL,
-		Token:    token,
-		IsActive: true,
+		Name:         profileName,
+		URL:          serverURL,
+		Token:        token,
+		RefreshToken: refreshToken,
+		IsActive:     true,
 	}
 	cfg.ActiveProfile = profileName
 
@@ -268,5 +574,7 @@ func checkServerStatus(serverURL string) (*StatusResponse, error) {
 func init() {
 	loginCmd.Flags().StringVarP(&loginEmail, "email", "e", "", "Email for login")
 	loginCmd.Flags().StringVarP(&loginPassword, "password", "p", "", "Password for login")
+	loginCmd.Flags().StringVar(&loginOTP, "otp", "", "Code from your authenticator app, or a recovery code, if two-factor authentication is enabled")
+	loginCmd.Flags().BoolVar(&loginSSO, "sso", false, "Log in through the server's single sign-on provider")
 	rootCmd.AddCommand(loginCmd)
 }
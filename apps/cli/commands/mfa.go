@@ -0,0 +1,320 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// mfaChallenge is the server's answer to a correct password that needs a second factor.
+type mfaChallenge struct {
+	Error    string `json:"error"`
+	MFAToken string `json:"mfa_token"`
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type mfaEnrollResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	loginResponse
+}
+
+var (
+	mfaCode        string
+	mfaRequire     []string
+	mfaClearPolicy bool
+)
+
+var mfaCmd = &cobra.Command{
+	Use:   "mfa",
+	Short: "Manage two-factor authentication",
+}
+
+var mfaStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show your two-factor authentication status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		var status models.MFAStatus
+		if err := apiRequest("GET", "/api/v1/auth/mfa", nil, http.StatusOK, &status); err != nil {
+			return err
+		}
+
+		if status.TOTPEnabled {
+			pterm.Success.Printf("Authenticator app enabled since %s\n", status.EnabledAt.Local().Format("2006-01-02 15:04"))
+			pterm.Info.Printf("Recovery codes left: %d\n", status.RecoveryCodesLeft)
+			if status.RecoveryCodesLeft < 3 {
+				pterm.Warning.Println("You are running out of recovery codes. Run 'bastion mfa recovery-codes' for new ones.")
+			}
+		} else {
+			pterm.Info.Println("Two-factor authentication is not enabled. Run 'bastion mfa enable' to set it up.")
+		}
+		if status.Required {
+			pterm.Info.Println("Your role requires two-factor authentication.")
+		}
+		return nil
+	},
+}
+
+var mfaEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Set up an authenticator app as a second factor",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		var enrollment totpEnrollment
+		if err := apiRequest("POST", "/api/v1/auth/mfa/totp", nil, http.StatusCreated, &enrollment); err != nil {
+			return err
+		}
+		showTOTPEnrollment(&enrollment)
+
+		code, err := promptMFACode("Enter the code shown by your authenticator app", false)
+		if err != nil {
+			return err
+		}
+
+		var confirmed mfaEnrollResponse
+		if err := apiRequest("POST", "/api/v1/auth/mfa/totp/confirm", map[string]string{"code": code}, http.StatusOK, &confirmed); err != nil {
+			return err
+		}
+
+		pterm.Success.Println("Two-factor authentication enabled.")
+		showRecoveryCodes(confirmed.RecoveryCodes)
+		return nil
+	},
+}
+
+var mfaDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Turn off two-factor authentication",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		code, err := promptMFACode("Enter a code from your authenticator app, or a recovery code", true)
+		if err != nil {
+			return err
+		}
+		if err := apiRequest("DELETE", "/api/v1/auth/mfa/totp", secondFactorPayload(code), http.StatusNoContent, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Println("Two-factor authentication disabled.")
+		return nil
+	},
+}
+
+var mfaRecoveryCodesCmd = &cobra.Command{
+	Use:   "recovery-codes",
+	Short: "Replace your recovery codes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		code, err := promptMFACode("Enter a code from your authenticator app, or a recovery code", true)
+		if err != nil {
+			return err
+		}
+
+		var result mfaEnrollResponse
+		if err := apiRequest("POST", "/api/v1/auth/mfa/recovery-codes", secondFactorPayload(code), http.StatusOK, &result); err != nil {
+			return err
+		}
+
+		pterm.Success.Println("New recovery codes generated. The old ones no longer work.")
+		showRecoveryCodes(result.RecoveryCodes)
+		return nil
+	},
+}
+
+var mfaResetCmd = &cobra.Command{
+	Use:   "reset [USER]",
+	Short: "Remove a user's second factor, e.g. after they lost their device (admin)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		user, err := accessUserArg(args)
+		if err != nil {
+			return err
+		}
+		userID := user
+		if _, err := uuid.Parse(user); err != nil {
+			found, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+			if err != nil {
+				return err
+			}
+			userID = found.UserID.String()
+		}
+
+		if err := apiRequest("DELETE", "/api/v1/users/"+userID+"/mfa", nil, http.StatusNoContent, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Printf("Two-factor authentication of %s reset.\n", user)
+		return nil
+	},
+}
+
+var mfaPolicyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Show or set the roles that must use two-factor authentication (admin)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		var policy struct {
+			RequiredRoles []string `json:"required_roles"`
+		}
+		if len(mfaRequire) > 0 || mfaClearPolicy {
+			roles := []string{}
+			for _, role := range mfaRequire {
+				roles = append(roles, strings.ToUpper(strings.TrimSpace(role)))
+			}
+			if err := apiRequest("PUT", "/api/v1/auth/mfa/policy", map[string][]string{"required_roles": roles}, http.StatusOK, &policy); err != nil {
+				return err
+			}
+			pterm.Success.Println("Two-factor policy updated.")
+		} else if err := apiRequest("GET", "/api/v1/auth/mfa/policy", nil, http.StatusOK, &policy); err != nil {
+			return err
+		}
+
+		if len(policy.RequiredRoles) == 0 {
+			pterm.Info.Println("No role requires two-factor authentication.")
+		} else {
+			pterm.Info.Printf("Two-factor authentication is required for: %s\n", strings.Join(policy.RequiredRoles, ", "))
+		}
+		return nil
+	},
+}
+
+// apiRequest sends an authenticated request to the server and decodes the response into out, if given.
+func apiRequest(method, path string, body interface{}, wantStatus int, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(payload)
+	}
+	req, _ := http.NewRequest(method, activeProfile.URL+path, reader)
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// promptMFACode returns the --code flag, or asks for a code.
+func promptMFACode(prompt string, allowRecovery bool) (string, error) {
+	if mfaCode != "" {
+		return mfaCode, nil
+	}
+	code, err := pterm.DefaultInteractiveTextInput.Show(prompt)
+	if err != nil {
+		return "", err
+	}
+	if !allowRecovery && !isTOTPCode(code) {
+		return "", fmt.Errorf("expected a %d-digit code", auth.TOTPDigits)
+	}
+	return code, nil
+}
+
+// secondFactorPayload sends code as a TOTP code if it looks like one, and as a recovery code otherwise.
+func secondFactorPayload(code string) map[string]string {
+	if isTOTPCode(code) {
+		return map[string]string{"code": code}
+	}
+	return map[string]string{"recovery_code": code}
+}
+
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != auth.TOTPDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// showTOTPEnrollment prints the new secret as a QR code, when qrencode is installed, and as text.
+func showTOTPEnrollment(enrollment *totpEnrollment) {
+	pterm.DefaultSection.Println("Set up your authenticator app")
+	if path, err := exec.LookPath("qrencode"); err == nil {
+		qr := exec.Command(path, "-t", "ansiutf8", enrollment.URI)
+		qr.Stdout = os.Stdout
+		if qr.Run() == nil {
+			pterm.Info.Println("Scan the QR code above, or enter the secret below by hand.")
+		}
+	} else {
+		pterm.Info.Println("Add this URI to your authenticator app, or enter the secret by hand:")
+		pterm.Println(enrollment.URI)
+	}
+	pterm.Info.Printf("Secret: %s\n", enrollment.Secret)
+}
+
+func showRecoveryCodes(codes []string) {
+	pterm.DefaultSection.Println("Recovery codes")
+	pterm.Warning.Println("Store these somewhere safe. Each works once, in place of a code, if you lose your device. They are not shown again.")
+	for _, code := range codes {
+		pterm.Println("  " + code)
+	}
+}
+
+func init() {
+	mfaCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return mfaInteractive()
+	}
+	mfaCmd.PersistentFlags().StringVar(&mfaCode, "code", "", "Code from your authenticator app, or a recovery code")
+	mfaPolicyCmd.Flags().StringSliceVar(&mfaRequire, "require", nil, "Roles that must use two-factor authentication, e.g. ADMIN,AUDITOR")
+	mfaPolicyCmd.Flags().BoolVar(&mfaClearPolicy, "clear", false, "Require two-factor authentication for no role")
+	mfaCmd.AddCommand(mfaStatusCmd)
+	mfaCmd.AddCommand(mfaEnableCmd)
+	mfaCmd.AddCommand(mfaDisableCmd)
+	mfaCmd.AddCommand(mfaRecoveryCodesCmd)
+	mfaCmd.AddCommand(mfaResetCmd)
+	mfaCmd.AddCommand(mfaPolicyCmd)
+	rootCmd.AddCommand(mfaCmd)
+}
@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var passkeyCmd = &cobra.Command{
+	Use:   "passkey",
+	Short: "Manage your passkeys. Register new ones from the web interface",
+}
+
+var passkeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your passkeys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		passkeys, err := fetchPasskeys()
+		if err != nil {
+			return err
+		}
+		if len(passkeys) == 0 {
+			pterm.Info.Println("You have no passkeys. Register one from the web interface.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "Name", "Added", "Last used", ""}}
+		for _, p := range passkeys {
+			lastUsed := "never"
+			if p.LastUsedAt != nil {
+				lastUsed = p.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			warning := ""
+			if p.CloneWarning {
+				warning = pterm.Red("may be cloned")
+			}
+			tableData = append(tableData, []string{p.PublicID.String(), p.Name, p.CreatedAt.Local().Format("2006-01-02 15:04"), lastUsed, warning})
+		}
+		if err := pterm.DefaultTable.WithHasHeader().WithData(tableData).Render(); err != nil {
+			return err
+		}
+
+		for _, p := range passkeys {
+			if p.CloneWarning {
+				pterm.Warning.Println("A passkey marked 'may be cloned' was used with a signature counter that did not increase. Unless you know why, remove it and check the audit log.")
+				break
+			}
+		}
+		return nil
+	},
+}
+
+var passkeyRenameCmd = &cobra.Command{
+	Use:   "rename [ID] [NAME]",
+	Short: "Rename one of your passkeys",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		id := ""
+		if len(args) > 0 {
+			id = args[0]
+		} else {
+			var err error
+			if id, err = selectPasskey("Select a passkey to rename"); err != nil || id == "" {
+				return err
+			}
+		}
+
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		} else {
+			var err error
+			if name, err = pterm.DefaultInteractiveTextInput.Show("New name"); err != nil {
+				return err
+			}
+		}
+
+		body := map[string]string{"name": name}
+		if err := apiRequest("PUT", "/api/v1/auth/passkeys/"+id+"/name", body, http.StatusNoContent, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Printf("Passkey renamed to %s.\n", strings.TrimSpace(name))
+		return nil
+	},
+}
+
+var passkeyRemoveCmd = &cobra.Command{
+	Use:   "remove [ID]",
+	Short: "Remove one of your passkeys. Your only way to log in cannot be removed",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		id := ""
+		if len(args) > 0 {
+			id = args[0]
+		} else {
+			var err error
+			if id, err = selectPasskey("Select a passkey to remove"); err != nil || id == "" {
+				return err
+			}
+			confirm, _ := pterm.DefaultInteractiveConfirm.Show("It will no longer log you in. Continue?")
+			if !confirm {
+				return nil
+			}
+		}
+
+		if err := apiRequest("DELETE", "/api/v1/auth/passkeys/"+id, nil, http.StatusNoContent, nil); err != nil {
+			return err
+		}
+
+		pterm.Success.Println("Passkey removed.")
+		return nil
+	},
+}
+
+func fetchPasskeys() ([]models.WebAuthnCredential, error) {
+	var passkeys []models.WebAuthnCredential
+	if err := apiRequest("GET", "/api/v1/auth/passkeys", nil, http.StatusOK, &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+// selectPasskey asks which passkey to act on and returns its ID, or "" if there are none.
+func selectPasskey(prompt string) (string, error) {
+	passkeys, err := fetchPasskeys()
+	if err != nil {
+		return "", err
+	}
+	if len(passkeys) == 0 {
+		pterm.Info.Println("You have no passkeys.")
+		return "", nil
+	}
+	var options []string
+	for _, p := range passkeys {
+		options = append(options, fmt.Sprintf("%s - %s, added %s", p.PublicID, p.Name, p.CreatedAt.Local().Format("2006-01-02")))
+	}
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show(prompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.Split(selected, " ")[0], nil
+}
+
+func init() {
+	passkeyCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return passkeyInteractive()
+	}
+	passkeyCmd.AddCommand(passkeyListCmd)
+	passkeyCmd.AddCommand(passkeyRenameCmd)
+	passkeyCmd.AddCommand(passkeyRemoveCmd)
+	rootCmd.AddCommand(passkeyCmd)
+}
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var portalClient string
+
+var portalCmd = &cobra.Command{
+	Use:   "portal",
+	Short: "Manage client portal accounts, restricted to a single client's projects",
+}
+
+var portalListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the portal accounts of a client",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		client, err := portalClientArg()
+		if err != nil {
+			return err
+		}
+
+		req, _ := http.NewRequest("GET", activeProfile.URL+"/api/v1/clients/"+client.ID.String()+"/users", nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to fetch portal accounts: %s", resp.Status)
+		}
+
+		var users []models.User
+		json.NewDecoder(resp.Body).Decode(&users)
+
+		if len(users) == 0 {
+			pterm.Info.Printf("Client '%s' has no portal accounts.\n", client.Name)
+			return nil
+		}
+
+		tableData := pterm.TableData{{"User ID", "Username", "Email", "Created"}}
+		for _, u := range users {
+			tableData = append(tableData, []string{u.ID.String(), u.Username, u.Email, u.CreatedAt.Local().Format("2006-01-02 15:04")})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var portalAddCmd = &cobra.Command{
+	Use:   "add [USER]",
+	Short: "Restrict an existing collaborator to a client's projects",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setPortalClient(args, "PUT")
+	},
+}
+
+var portalRemoveCmd = &cobra.Command{
+	Use:   "remove [USER]",
+	Short: "Lift a portal account's restriction, making it a regular collaborator",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setPortalClient(args, "DELETE")
+	},
+}
+
+// setPortalClient adds a user to (PUT) or removes them from (DELETE) a client's portal accounts.
+func setPortalClient(args []string, method string) error {
+	if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+		return fmt.Errorf("no active profile. Please login first")
+	}
+
+	client, err := portalClientArg()
+	if err != nil {
+		return err
+	}
+	user, err := accessUserArg(args)
+	if err != nil {
+		return err
+	}
+
+	// The endpoint takes a user ID; resolve usernames through the public key lookup
+	userID := user
+	if _, err := uuid.Parse(user); err != nil {
+		found, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+		if err != nil {
+			return err
+		}
+		userID = found.UserID.String()
+	}
+
+	if method == "PUT" {
+		pterm.Warning.Println("The user's grants on other clients' projects will be revoked.")
+		confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show("Do you want to continue?")
+		if !confirm {
+			pterm.Info.Println("Operation cancelled.")
+			return nil
+		}
+	}
+
+	req, _ := http.NewRequest(method, activeProfile.URL+"/api/v1/clients/"+client.ID.String()+"/users/"+userID, nil)
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update portal account: %s", strings.TrimSpace(string(msg)))
+	}
+
+	if method == "PUT" {
+		pterm.Success.Printf("%s is now a portal account of '%s'. The restriction applies to their token from their next login.\n", user, client.Name)
+	} else {
+		pterm.Success.Printf("%s is no longer restricted to '%s'.\n", user, client.Name)
+	}
+	return nil
+}
+
+func portalClientArg() (*models.Client, error) {
+	client := portalClient
+	if client == "" {
+		var err error
+		client, err = pterm.DefaultInteractiveTextInput.Show("Enter client name or ID")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return findClient(activeProfile.URL, activeProfile.Token, client)
+}
+
+func init() {
+	portalCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return portalInteractive()
+	}
+	for _, c := range []*cobra.Command{portalListCmd, portalAddCmd, portalRemoveCmd} {
+		c.Flags().StringVarP(&portalClient, "client", "c", "", "Client name or ID")
+	}
+	portalCmd.AddCommand(portalListCmd)
+	portalCmd.AddCommand(portalAddCmd)
+	portalCmd.AddCommand(portalRemoveCmd)
+	rootCmd.AddCommand(portalCmd)
+}
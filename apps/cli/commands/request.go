@@ -0,0 +1,309 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	requestProjectID string
+	requestRole      string
+	requestReason    string
+	requestHours     int
+	requestStatus    string
+	requestNote      string
+	requestPassword  string
+)
+
+var requestCmd = &cobra.Command{
+	Use:   "request",
+	Short: "Request, approve and deny just-in-time project access",
+}
+
+var requestCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Request short-lived access to a project",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		projectID := requestProjectID
+		if projectID == "" {
+			var err error
+			projectID, err = pterm.DefaultInteractiveTextInput.Show("Enter Project ID")
+			if err != nil {
+				return err
+			}
+		}
+
+		reason := requestReason
+		if reason == "" {
+			var err error
+			reason, err = pterm.DefaultInteractiveTextInput.Show("Why do you need access?")
+			if err != nil {
+				return err
+			}
+		}
+		if reason == "" {
+			return fmt.Errorf("a reason is required")
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"project_id":     projectID,
+			"role":           requestRole,
+			"reason":         reason,
+			"duration_hours": requestHours,
+		})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/access-requests", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("failed to request access: %s", strings.TrimSpace(string(msg)))
+		}
+
+		var ar models.AccessRequest
+		json.NewDecoder(resp.Body).Decode(&ar)
+
+		pterm.Success.Printf("Requested %s access to '%s' for %d hours (request %s). An admin has been notified.\n", ar.Role, ar.ProjectName, ar.DurationHours, ar.ID)
+		return nil
+	},
+}
+
+var requestListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List access requests (admins see everyone's, others their own)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		requests, err := fetchAccessRequests(activeProfile.URL, activeProfile.Token, requestStatus)
+		if err != nil {
+			return err
+		}
+
+		if len(requests) == 0 {
+			pterm.Info.Println("No access requests found.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "User", "Project", "Role", "Hours", "Reason", "Status", "Expires", "Requested"}}
+		for _, ar := range requests {
+			expires := "-"
+			if ar.GrantExpiresAt != nil {
+				expires = ar.GrantExpiresAt.Local().Format("2006-01-02 15:04")
+			}
+			tableData = append(tableData, []string{
+				ar.ID.String(),
+				ar.Username,
+				ar.ProjectName,
+				ar.Role,
+				fmt.Sprintf("%d", ar.DurationHours),
+				ar.Reason,
+				ar.Status,
+				expires,
+				ar.CreatedAt.Local().Format("2006-01-02 15:04"),
+			})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var requestApproveCmd = &cobra.Command{
+	Use:   "approve [ID]",
+	Short: "Approve an access request by sealing the project key to the requester's public key",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		ar, err := pendingAccessRequestArg(args)
+		if err != nil {
+			return err
+		}
+
+		pterm.Info.Printf("%s asks for %s access to '%s' for %d hours: %s\n", ar.Username, ar.Role, ar.ProjectName, ar.DurationHours, ar.Reason)
+
+		password := requestPassword
+		if password == "" {
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your password to unlock the project key")
+			if err != nil {
+				return err
+			}
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Fetching the requester's public key...")
+		requester, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, ar.UserID.String())
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Unwrapping project key...")
+		project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, ar.ProjectID.String(), password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		wrapped, err := vault.WrapDataKeyForUser(requester.PublicKey, project.ID, dataKey)
+		if err != nil {
+			spinner.Fail("Failed to seal project key: " + err.Error())
+			return err
+		}
+
+		spinner.UpdateText("Approving request...")
+		payload, _ := json.Marshal(map[string]string{
+			"wrapped_data_key": wrapped,
+			"note":             requestNote,
+		})
+		approved, err := decideAccessRequest(ar.ID.String(), "approve", payload)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.Success(fmt.Sprintf("%s is %s of project '%s' until %s.", approved.Username, approved.Role, approved.ProjectName, approved.GrantExpiresAt.Local().Format("2006-01-02 15:04")))
+		return nil
+	},
+}
+
+var requestDenyCmd = &cobra.Command{
+	Use:   "deny [ID]",
+	Short: "Deny an access request",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		ar, err := pendingAccessRequestArg(args)
+		if err != nil {
+			return err
+		}
+
+		payload, _ := json.Marshal(map[string]string{"note": requestNote})
+		if _, err := decideAccessRequest(ar.ID.String(), "deny", payload); err != nil {
+			return err
+		}
+
+		pterm.Success.Printf("Denied %s's request for access to '%s'.\n", ar.Username, ar.ProjectName)
+		return nil
+	},
+}
+
+func fetchAccessRequests(url, token, status string) ([]models.AccessRequest, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/access-requests?status="+status, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch access requests: %s", resp.Status)
+	}
+
+	var requests []models.AccessRequest
+	json.NewDecoder(resp.Body).Decode(&requests)
+	return requests, nil
+}
+
+// pendingAccessRequestArg returns the pending request named by args, or lets the user pick one.
+func pendingAccessRequestArg(args []string) (*models.AccessRequest, error) {
+	requests, err := fetchAccessRequests(activeProfile.URL, activeProfile.Token, models.AccessRequestPending)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("no pending access requests")
+	}
+
+	if len(args) > 0 {
+		for i := range requests {
+			if requests[i].ID.String() == args[0] {
+				return &requests[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no pending access request with ID %s", args[0])
+	}
+
+	options := make([]string, len(requests))
+	for i, ar := range requests {
+		options[i] = fmt.Sprintf("%s - %s on %s (%s, %dh): %s", ar.ID, ar.Username, ar.ProjectName, ar.Role, ar.DurationHours, ar.Reason)
+	}
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Select a request")
+	if err != nil {
+		return nil, err
+	}
+	for i := range options {
+		if options[i] == selected {
+			return &requests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no request selected")
+}
+
+// decideAccessRequest posts an approve or deny decision and returns the updated request.
+func decideAccessRequest(id, decision string, payload []byte) (*models.AccessRequest, error) {
+	req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/access-requests/"+id+"/"+decision, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to %s request: %s", decision, strings.TrimSpace(string(msg)))
+	}
+
+	var ar models.AccessRequest
+	json.NewDecoder(resp.Body).Decode(&ar)
+	return &ar, nil
+}
+
+func init() {
+	requestCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return requestInteractive()
+	}
+	requestCreateCmd.Flags().StringVarP(&requestProjectID, "project", "i", "", "Project ID")
+	requestCreateCmd.Flags().StringVarP(&requestRole, "role", "r", "editor", "Project role: viewer, editor or project-admin")
+	requestCreateCmd.Flags().StringVar(&requestReason, "reason", "", "Why you need access")
+	requestCreateCmd.Flags().IntVar(&requestHours, "hours", 1, "Hours the grant lasts once approved (max 24)")
+	requestListCmd.Flags().StringVar(&requestStatus, "status", "", "Only show requests in this status: pending, approved or denied")
+	requestApproveCmd.Flags().StringVarP(&requestPassword, "password", "p", "", "Your password, to unlock the project key")
+	for _, c := range []*cobra.Command{requestApproveCmd, requestDenyCmd} {
+		c.Flags().StringVar(&requestNote, "note", "", "Note recorded with the decision")
+	}
+	requestCmd.AddCommand(requestCreateCmd)
+	requestCmd.AddCommand(requestListCmd)
+	requestCmd.AddCommand(requestApproveCmd)
+	requestCmd.AddCommand(requestDenyCmd)
+	rootCmd.AddCommand(requestCmd)
+}
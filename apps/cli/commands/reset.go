@@ -2,11 +2,12 @@ package commands
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 
 	"github.com/dcdavidev/bastion/packages/crypto"
 	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
@@ -40,7 +41,7 @@ var resetCredentialsCmd = &cobra.Command{
 		}
 		defer database.Close()
 
-		user, hashHex, saltHexDB, err := database.GetUserByUsername(context.Background(), resetUser)
+		user, hashHex, saltDB, err := database.GetUserByUsername(context.Background(), resetUser)
 		if err != nil {
 			spinner.Fail("User not found: " + err.Error())
 			return err
@@ -56,52 +57,54 @@ var resetCredentialsCmd = &cobra.Command{
 			return fmt.Errorf("passwords do not match")
 		}
 
+		// The private key is encrypted under the password, so it must follow the password change
+		var rewrappedKeys *models.UserKeyPair
+		keys, err := database.GetUserKeyPair(context.Background(), user.ID)
+		if err == nil {
+			if oldPassword != "" {
+				rewrappedKeys, err = vault.RewrapPrivateKey(keys, oldPassword, newPassword)
+			}
+			if rewrappedKeys == nil {
+				pterm.Warning.Println("The user's private key cannot be re-encrypted without the correct old password.")
+				pterm.Warning.Println("Their keypair and project grants will be removed; they must run 'bastion create keypair' and be granted access again.")
+				confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show("Do you want to continue?")
+				if !confirm {
+					pterm.Info.Println("Operation cancelled.")
+					return nil
+				}
+			}
+		} else {
+			keys = nil
+		}
+
 		// Re-wrapping logic for ADMIN
 		reWrapped := false
 		if user.Role == "ADMIN" && oldPassword != "" {
 			spinner, _ = pterm.DefaultSpinner.Start("Authenticating and re-wrapping Master Key...")
 
 			// Verify old password for LOGIN first
-			saltDB, _ := hex.DecodeString(saltHexDB)
-			loginKek := crypto.DeriveKey([]byte(oldPassword), saltDB)
-			loginHash := hex.EncodeToString(loginKek)
-
-			if loginHash != hashHex {
+			if ok, _ := crypto.VerifyPassword(oldPassword, hashHex, saltDB); !ok {
 				spinner.Fail("Old password verification failed. Cannot re-wrap Master Key.")
 			} else {
-				// Re-wrap Master Key using the VAULT salt
-				vault, err := database.GetVaultConfig(context.Background())
+				// Re-wrap Master Key using a fresh VAULT salt and the current Argon2id parameters
+				vaultConfig, err := database.GetVaultConfig(context.Background())
 				if err != nil {
 					spinner.Fail("Could not fetch vault configuration: " + err.Error())
 				} else {
-					vaultSalt, err := hex.DecodeString(vault.MasterKeySalt)
-					if err != nil {
-						spinner.Fail("Invalid salt format in vault: " + err.Error())
-					} else {
-						// Derive the KEK used for the vault
-						oldVaultKek := crypto.DeriveKey([]byte(oldPassword), vaultSalt)
-
-						wrappedMK, err := hex.DecodeString(vault.WrappedMasterKey)
-						if err != nil {
-							spinner.Fail("Invalid wrapped key format in vault: " + err.Error())
+					masterKey, err := vault.UnwrapMasterKey(vaultConfig, oldPassword)
+					if err == nil {
+						newWrappedMK, newVaultSalt, err := vault.WrapMasterKey(masterKey, newPassword)
+						if err == nil {
+							err = database.UpdateVaultConfig(context.Background(), newWrappedMK, newVaultSalt)
+						}
+						if err == nil {
+							reWrapped = true
+							spinner.Success("Master Key re-wrapped and vault configuration updated!")
 						} else {
-							masterKey, err := crypto.UnwrapKey(oldVaultKek, wrappedMK)
-							if err == nil {
-								newVaultSalt, _ := crypto.GenerateSalt()
-								newVaultKek := crypto.DeriveKey([]byte(newPassword), newVaultSalt)
-								newWrappedMK, _ := crypto.WrapKey(newVaultKek, masterKey)
-
-								err = database.UpdateVaultConfig(context.Background(), hex.EncodeToString(newWrappedMK), hex.EncodeToString(newVaultSalt))
-								if err == nil {
-									reWrapped = true
-									spinner.Success("Master Key re-wrapped and vault configuration updated!")
-								} else {
-									spinner.Fail("Failed to update vault configuration: " + err.Error())
-								}
-							} else {
-								spinner.Fail("Failed to decrypt Master Key with old password. Are you sure it's the right one? Error: " + err.Error())
-							}
+							spinner.Fail("Failed to update vault configuration: " + err.Error())
 						}
+					} else {
+						spinner.Fail("Failed to decrypt Master Key with old password. Are you sure it's the right one? Error: " + err.Error())
 					}
 				}
 				if !reWrapped && spinner.IsActive {
@@ -112,24 +115,30 @@ var resetCredentialsCmd = &cobra.Command{
 
 		spinner, _ = pterm.DefaultSpinner.Start("Updating credentials...")
 
-		var finalSaltHex, finalHashHex string
-		if reWrapped {
-			// If we re-wrapped, the salt and hash were already used for vault, so we use them
-			// Actually, let's keep it simple: generate new salt/hash for user login too.
-			// Re-wrapping logic above already updated vault_config.
+		finalHashHex, finalSalt, err := crypto.HashPassword(newPassword)
+		if err != nil {
+			spinner.Fail("Failed to hash password: " + err.Error())
+			return err
 		}
 
-		salt, _ := crypto.GenerateSalt()
-		hash := crypto.DeriveKey([]byte(newPassword), salt)
-		finalSaltHex = hex.EncodeToString(salt)
-		finalHashHex = hex.EncodeToString(hash)
-
-		err = database.UpdateUserPassword(context.Background(), user.ID, finalHashHex, finalSaltHex)
+		err = database.UpdateUserPassword(context.Background(), user.ID, finalHashHex, finalSalt)
 		if err != nil {
 			spinner.Fail("Failed to update password: " + err.Error())
 			return err
 		}
 
+		if keys != nil {
+			if rewrappedKeys != nil {
+				err = database.SetUserKeyPair(context.Background(), user.ID, rewrappedKeys)
+			} else {
+				err = database.DeleteUserKeyPair(context.Background(), user.ID)
+			}
+			if err != nil {
+				spinner.Fail("Failed to update keypair: " + err.Error())
+				return err
+			}
+		}
+
 		if user.Role == "ADMIN" && !reWrapped {
 			pterm.Warning.Println("User is an ADMIN but Master Key was NOT re-wrapped.")
 			pterm.Warning.Println("The vault will be inaccessible unless the old password is used to recover it.")
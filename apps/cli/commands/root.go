@@ -35,6 +35,26 @@ var rootCmd = &cobra.Command{
 			activeProfile = cfg.GetActiveProfile()
 		}
 
+		// An API token from the environment, e.g. in CI, overrides the profile's session
+		if token := os.Getenv("BASTION_TOKEN"); token != "" {
+			profile := config.Profile{Name: "env"}
+			if activeProfile != nil {
+				profile = *activeProfile
+			}
+			if host := os.Getenv("BASTION_HOST"); host != "" {
+				profile.URL = host
+			}
+			profile.Token = token
+			profile.RefreshToken = ""
+			activeProfile = &profile
+		}
+
+		if activeProfile != nil && activeProfile.RefreshToken != "" && cmd != logoutCmd {
+			if err := refreshActiveSession(cfg); err != nil {
+				pterm.Warning.Printf("Could not refresh your session: %v. Please login again.\n", err)
+			}
+		}
+
 		if os.Getenv("BASTION_TEST") == "true" {
 			return
 		}
@@ -58,10 +78,24 @@ func runRootInteractive(cmd *cobra.Command, args []string) error {
 
 	options := []string{
 		"Login - Connect to a Bastion server",
+		"Logout - End the current session",
 		"Init - Initialize local Bastion (database & admin)",
 		"Create - Create resources",
 		"Reset - Reset resources (credentials, etc.)",
 		"Remove - Remove resources (client, project)",
+		"Invite - Invite collaborators",
+		"Access - Manage project access",
+		"Group - Manage groups of collaborators",
+		"Portal - Manage client portal accounts",
+		"Request - Request or approve just-in-time access",
+		"Change - Review changes to protected projects",
+		"Break-glass - Emergency access to projects",
+		"Session - Manage login sessions",
+		"MFA - Manage two-factor authentication",
+		"Passkey - Manage your passkeys",
+		"Lockout - Unlock accounts after failed logins",
+		"Service account - Manage service accounts and API tokens",
+		"Rotate - Rotate encryption keys",
 		"DB - Database management (migrations, etc.)",
 		"Exit",
 	}
@@ -74,6 +108,8 @@ func runRootInteractive(cmd *cobra.Command, args []string) error {
 	switch {
 	case strings.HasPrefix(selected, "Login"):
 		return loginCmd.RunE(loginCmd, []string{})
+	case strings.HasPrefix(selected, "Logout"):
+		return logoutCmd.RunE(logoutCmd, []string{})
 	case strings.HasPrefix(selected, "Init"):
 		return initCmd.RunE(initCmd, []string{})
 	case strings.HasPrefix(selected, "Create"):
@@ -82,6 +118,32 @@ func runRootInteractive(cmd *cobra.Command, args []string) error {
 		return resetInteractive()
 	case strings.HasPrefix(selected, "Remove"):
 		return removeInteractive()
+	case strings.HasPrefix(selected, "Invite"):
+		return inviteInteractive()
+	case strings.HasPrefix(selected, "Access"):
+		return accessInteractive()
+	case strings.HasPrefix(selected, "Group"):
+		return groupInteractive()
+	case strings.HasPrefix(selected, "Portal"):
+		return portalInteractive()
+	case strings.HasPrefix(selected, "Request"):
+		return requestInteractive()
+	case strings.HasPrefix(selected, "Change"):
+		return changeInteractive()
+	case strings.HasPrefix(selected, "Break-glass"):
+		return breakGlassInteractive()
+	case strings.HasPrefix(selected, "Session"):
+		return sessionInteractive()
+	case strings.HasPrefix(selected, "MFA"):
+		return mfaInteractive()
+	case strings.HasPrefix(selected, "Passkey"):
+		return passkeyInteractive()
+	case strings.HasPrefix(selected, "Lockout"):
+		return lockoutInteractive()
+	case strings.HasPrefix(selected, "Service account"):
+		return serviceAccountInteractive()
+	case strings.HasPrefix(selected, "Rotate"):
+		return rotateInteractive()
 	case strings.HasPrefix(selected, "DB"):
 		return dbInteractive()
 	case selected == "Exit":
@@ -102,6 +164,7 @@ func createInteractive() error {
 		"masterkey - Initialize the vault with a new Master Key",
 		"client - Create a new client",
 		"project - Create a new secured project",
+		"keypair - Generate your personal keypair",
 		"Back",
 	}
 
@@ -178,9 +241,99 @@ func removeInteractive() error {
 	return nil
 }
 
+func rotateInteractive() error {
+	options := []string{
+		"masterkey - Rotate the Master Key and re-wrap all project keys",
+		"projectkey - Rotate a project data key and re-encrypt its secrets",
+		"signingkey - Rotate the key signing access tokens",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Which key do you want to rotate?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range rotateCmd.Commands() {
+		if c.Use == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func inviteInteractive() error {
+	options := []string{
+		"create - Invite a collaborator by email",
+		"list - List invitations",
+		"revoke - Revoke a pending invitation",
+		"grant - Complete the project grant of an accepted invitation",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range inviteCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func accessInteractive() error {
+	options := []string{
+		"list - List the users with access to a project",
+		"grant - Grant an existing user access to a project",
+		"revoke - Revoke a user's access to a project",
+		"set-role - Set a user's global role",
+		"grant-group - Grant a group access to a project",
+		"revoke-group - Revoke a group's access to a project",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range accessCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
 func dbInteractive() error {
 	options := []string{
 		"migrate - Check and apply database migrations",
+		"verify - Check that all project keys and secrets are decryptable",
+		"upgrade-secrets - Re-encrypt legacy secrets into AAD-bound envelopes",
 		"Back",
 	}
 
@@ -228,3 +381,305 @@ func init() {
 	// Add command groups
 	rootCmd.AddCommand(createCmd)
 }
+
+func groupInteractive() error {
+	options := []string{
+		"list - List groups",
+		"create - Create a group",
+		"members - List the members of a group",
+		"add - Add a user to a group",
+		"remove - Remove a user from a group",
+		"delete - Delete a group",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range groupCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func portalInteractive() error {
+	options := []string{
+		"list - List the portal accounts of a client",
+		"add - Restrict a collaborator to a client",
+		"remove - Lift a portal account's restriction",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range portalCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func requestInteractive() error {
+	options := []string{
+		"create - Request short-lived access to a project",
+		"list - List access requests",
+		"approve - Approve a pending request",
+		"deny - Deny a pending request",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range requestCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func changeInteractive() error {
+	options := []string{
+		"list - List the change sets of a project",
+		"show - Review a change set",
+		"propose - Stage new secret values for approval",
+		"approve - Approve a change set",
+		"reject - Reject a change set",
+		"protect - Require approval for a project's writes",
+		"unprotect - Stop requiring approval",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range changeCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func breakGlassInteractive() error {
+	options := []string{
+		"open - Break the glass on a project",
+		"sessions - List sessions awaiting acknowledgment",
+		"ack - Acknowledge a session",
+		"responders - List responders",
+		"add - Add a responder",
+		"remove - Remove a responder",
+		"arm - Arm a project",
+		"disarm - Disarm a project",
+		"setup - Create the escrow keypair",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range breakGlassCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func sessionInteractive() error {
+	options := []string{
+		"list - List your active sessions",
+		"revoke - Sign out one of your sessions",
+		"revoke-user - Sign a user out everywhere",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range sessionCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func mfaInteractive() error {
+	options := []string{
+		"status - Show your two-factor status",
+		"enable - Set up an authenticator app",
+		"disable - Turn off two-factor authentication",
+		"recovery-codes - Replace your recovery codes",
+		"reset - Remove a user's second factor",
+		"policy - Show the roles that must use two-factor",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range mfaCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func passkeyInteractive() error {
+	options := []string{
+		"list - List your passkeys",
+		"rename - Rename a passkey",
+		"remove - Remove a passkey",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range passkeyCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func lockoutInteractive() error {
+	options := []string{
+		"list - List locked accounts",
+		"unlock - Unlock an account",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range lockoutCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
+
+func serviceAccountInteractive() error {
+	options := []string{
+		"list - List service accounts",
+		"create - Create a service account",
+		"issue - Issue an API token",
+		"tokens - List the tokens of a service account",
+		"revoke - Revoke an API token",
+		"keygen - Generate a new keypair",
+		"grant - Seal a project key to a service account",
+		"ungrant - Remove a project key from a service account",
+		"delete - Delete a service account",
+		"Back",
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("What do you want to do?")
+	if err != nil {
+		return err
+	}
+
+	if selected == "Back" {
+		return runRootInteractive(rootCmd, []string{})
+	}
+
+	cmdStr := strings.Split(selected, " ")[0]
+	for _, c := range serviceAccountCmd.Commands() {
+		if strings.Split(c.Use, " ")[0] == cmdStr {
+			return c.RunE(c, []string{})
+		}
+	}
+
+	pterm.Error.Println("Command not implemented interactively yet")
+	return nil
+}
@@ -0,0 +1,479 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rotatePassword        string
+	rotateProjectID       string
+	rotateDeleteHistory   bool
+	rotateRevokeNoKeyPair bool
+	rotateAlgorithm       string
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate encryption keys",
+}
+
+var rotateMasterKeyCmd = &cobra.Command{
+	Use:   "masterkey",
+	Short: "Generate a new Master Key and re-wrap every project data key with it",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pterm.DefaultHeader.WithFullWidth().Println("MASTER KEY ROTATION")
+
+		password := rotatePassword
+		if password == "" {
+			var err error
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Admin Password to unwrap the current Master Key")
+			if err != nil {
+				return err
+			}
+		}
+
+		isRemote := activeProfile != nil && activeProfile.Token != "" && activeProfile.URL != ""
+
+		spinner, _ := pterm.DefaultSpinner.Start("Fetching vault configuration and projects...")
+
+		var vaultConfig *db.VaultConfig
+		var projects []models.Project
+		var database *db.DB
+
+		if isRemote {
+			vc, err := fetchVaultConfig(activeProfile.URL, activeProfile.Token)
+			if err != nil {
+				spinner.Fail(err.Error())
+				return err
+			}
+			vaultConfig = &db.VaultConfig{WrappedMasterKey: vc.WrappedMasterKey, MasterKeySalt: vc.MasterKeySalt}
+
+			projects, err = fetchAllProjects(activeProfile.URL, activeProfile.Token)
+			if err != nil {
+				spinner.Fail(err.Error())
+				return err
+			}
+		} else {
+			var err error
+			database, err = db.NewConnection()
+			if err != nil {
+				spinner.Fail("Local database connection failed: " + err.Error())
+				return err
+			}
+			defer database.Close()
+
+			vaultConfig, err = database.GetVaultConfig(context.Background())
+			if err != nil {
+				spinner.Fail("Vault not initialized")
+				return err
+			}
+
+			projects, err = database.GetAllProjects(context.Background())
+			if err != nil {
+				spinner.Fail(err.Error())
+				return err
+			}
+		}
+
+		spinner.UpdateText("Unwrapping current Master Key...")
+		oldMasterKey, err := vault.UnwrapMasterKey(vaultConfig, password)
+		if err != nil {
+			spinner.Fail("Failed to unwrap Master Key. Invalid password?")
+			return err
+		}
+
+		spinner.UpdateText(fmt.Sprintf("Re-wrapping %d project data keys...", len(projects)))
+		newMasterKey, err := crypto.GenerateRandomKey()
+		if err != nil {
+			spinner.Fail("Failed to generate new Master Key")
+			return err
+		}
+
+		projectKeys, err := vault.RewrapProjectKeys(oldMasterKey, newMasterKey, projects)
+		if err != nil {
+			spinner.Fail(err.Error())
+			pterm.Info.Println("Run 'bastion db verify' to find broken projects. Nothing was changed.")
+			return err
+		}
+
+		wrappedMK, salt, err := vault.WrapMasterKey(newMasterKey, password)
+		if err != nil {
+			spinner.Fail("Failed to wrap new Master Key")
+			return err
+		}
+
+		// The server only accepts the rotation if these are still the current keys
+		oldProjectKeys := make(map[uuid.UUID]string, len(projects))
+		for _, p := range projects {
+			oldProjectKeys[p.ID] = p.WrappedDataKey
+		}
+		rotation := db.MasterKeyRotation{
+			OldWrappedMasterKey: vaultConfig.WrappedMasterKey,
+			OldMasterKeySalt:    vaultConfig.MasterKeySalt,
+			WrappedMasterKey:    wrappedMK,
+			MasterKeySalt:       salt,
+			OldProjectKeys:      oldProjectKeys,
+			ProjectKeys:         projectKeys,
+		}
+
+		spinner.UpdateText("Saving rotated keys...")
+		if isRemote {
+			payload, _ := json.Marshal(map[string]interface{}{
+				"old_wrapped_master_key": rotation.OldWrappedMasterKey,
+				"old_master_key_salt":    rotation.OldMasterKeySalt,
+				"wrapped_master_key":     rotation.WrappedMasterKey,
+				"master_key_salt":        rotation.MasterKeySalt,
+				"old_project_keys":       rotation.OldProjectKeys,
+				"project_keys":           rotation.ProjectKeys,
+			})
+
+			req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/vault/rotate", bytes.NewBuffer(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				spinner.Fail("Failed to connect to server")
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				spinner.Fail(fmt.Sprintf("Rotation rejected by server: %s", string(body)))
+				return fmt.Errorf("api error: %s", resp.Status)
+			}
+		} else {
+			if err := database.RotateMasterKey(context.Background(), rotation); err != nil {
+				spinner.Fail("Rotation failed: " + err.Error())
+				return err
+			}
+
+			database.LogEvent(context.Background(), "ROTATE_MASTER_KEY", "VAULT", uuid.Nil, map[string]interface{}{
+				"projects": len(projectKeys),
+				"source":   "cli",
+			})
+		}
+
+		spinner.Success(fmt.Sprintf("Master Key rotated and %d project keys re-wrapped!", len(projectKeys)))
+		return nil
+	},
+}
+
+var rotateProjectKeyCmd = &cobra.Command{
+	Use:   "projectkey",
+	Short: "Generate a new project data key and re-encrypt the project's secrets with it",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pterm.DefaultHeader.WithFullWidth().Println("PROJECT KEY ROTATION")
+
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		if rotateProjectID == "" {
+			var err error
+			rotateProjectID, err = pterm.DefaultInteractiveTextInput.Show("Enter Project ID")
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := uuid.Parse(rotateProjectID); err != nil {
+			return fmt.Errorf("invalid project ID: %w", err)
+		}
+
+		if rotateDeleteHistory {
+			pterm.Warning.Println("Only the latest version of each secret will be re-encrypted. Every older version will be PERMANENTLY DELETED and the secret history lost.")
+			confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show("Delete the secret history of this project?")
+			if !confirm {
+				pterm.Info.Println("Operation cancelled. Rotate without --delete-history to keep older versions.")
+				return nil
+			}
+		}
+		if rotateRevokeNoKeyPair {
+			pterm.Warning.Println("Collaborators without a keypair cannot receive the new key and will lose access. Re-grant access afterwards.")
+		}
+		confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show("Do you want to continue?")
+		if !confirm {
+			pterm.Info.Println("Operation cancelled.")
+			return nil
+		}
+
+		password := rotatePassword
+		if password == "" {
+			var err error
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter Admin Password to unwrap Master Key")
+			if err != nil {
+				return err
+			}
+		}
+
+		return rotateProjectKey(rotateProjectID, password, rotateDeleteHistory, rotateRevokeNoKeyPair)
+	},
+}
+
+// rotateProjectKey replaces a project's data key, re-encrypts its secrets and re-seals the new key to
+// every collaborator's, group's and service account's public key. All key material is handled client-side.
+// Collaborators without a keypair cannot receive the new key, so the rotation is refused while any hold a
+// grant, unless revokeNoKeyPair lets the server revoke them.
+func rotateProjectKey(projectID, password string, deleteHistory, revokeNoKeyPair bool) error {
+	spinner, _ := pterm.DefaultSpinner.Start("Fetching vault configuration and project...")
+
+	vc, err := fetchVaultConfig(activeProfile.URL, activeProfile.Token)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	project, err := fetchProject(activeProfile.URL, activeProfile.Token, projectID)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	access, err := fetchProjectAccess(activeProfile.URL, activeProfile.Token, projectID)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	var noKeyPair []string
+	for _, a := range access {
+		if a.PublicKey == "" {
+			noKeyPair = append(noKeyPair, a.Username)
+		}
+	}
+	if len(noKeyPair) > 0 && !revokeNoKeyPair {
+		spinner.Fail(fmt.Sprintf("Collaborators without a keypair would lose access: %s", strings.Join(noKeyPair, ", ")))
+		pterm.Info.Println("Ask them to log in once to create a keypair, or rotate with 'bastion rotate projectkey --revoke-without-keypair' to revoke their access.")
+		return fmt.Errorf("%d collaborators cannot receive the new key", len(noKeyPair))
+	}
+	groupAccess, err := fetchProjectGroupAccess(activeProfile.URL, activeProfile.Token, projectID)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	serviceAccess, err := fetchProjectServiceAccountAccess(activeProfile.URL, activeProfile.Token, projectID)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	escrow, err := fetchBreakGlassEscrow(activeProfile.URL, activeProfile.Token)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+
+	spinner.UpdateText("Unwrapping keys...")
+	masterKey, err := vault.UnwrapMasterKey(&db.VaultConfig{WrappedMasterKey: vc.WrappedMasterKey, MasterKeySalt: vc.MasterKeySalt}, password)
+	if err != nil {
+		spinner.Fail("Failed to unwrap Master Key. Invalid password?")
+		return err
+	}
+	oldDataKey, err := vault.UnwrapHexKey(masterKey, project.WrappedDataKey)
+	if err != nil {
+		spinner.Fail("Failed to unwrap project data key")
+		return err
+	}
+
+	spinner.UpdateText("Fetching secrets...")
+	var secrets []models.Secret
+	if deleteHistory {
+		secrets, err = fetchSecrets(activeProfile.URL, activeProfile.Token, projectID)
+	} else {
+		secrets, err = fetchAllSecretVersions(activeProfile.URL, activeProfile.Token, projectID)
+	}
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+	allowLegacy, err := legacySecretsAllowed(activeProfile.URL)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+
+	spinner.UpdateText(fmt.Sprintf("Re-encrypting %d secret versions...", len(secrets)))
+	newDataKey, err := crypto.GenerateRandomKey()
+	if err != nil {
+		spinner.Fail("Failed to generate new data key")
+		return err
+	}
+
+	values, err := vault.ReencryptSecrets(oldDataKey, newDataKey, secrets, allowLegacy)
+	if err != nil {
+		spinner.Fail(err.Error())
+		return err
+	}
+
+	wrappedDK, err := crypto.WrapKey(masterKey, newDataKey)
+	if err != nil {
+		spinner.Fail("Failed to wrap new data key")
+		return err
+	}
+
+	// Grants of users without a keypair are left out and revoked by the server
+	accessKeys := make(map[uuid.UUID]string)
+	for _, a := range access {
+		if a.PublicKey == "" {
+			continue
+		}
+		wrapped, err := vault.WrapDataKeyForUser(a.PublicKey, project.ID, newDataKey)
+		if err != nil {
+			spinner.Fail(fmt.Sprintf("Failed to seal new data key for %s: %s", a.Username, err))
+			return err
+		}
+		accessKeys[a.UserID] = wrapped
+	}
+
+	// Every group grant must be re-sealed, the server rejects the rotation otherwise
+	groupKeys := make(map[uuid.UUID]string)
+	for _, g := range groupAccess {
+		wrapped, err := vault.WrapDataKeyForUser(g.GroupPublicKey, project.ID, newDataKey)
+		if err != nil {
+			spinner.Fail(fmt.Sprintf("Failed to seal new data key for group %s: %s", g.GroupName, err))
+			return err
+		}
+		groupKeys[g.GroupID] = wrapped
+	}
+
+	// Service accounts too, or their pipelines would stop decrypting
+	serviceKeys := make(map[uuid.UUID]string)
+	for _, a := range serviceAccess {
+		wrapped, err := vault.WrapDataKeyForUser(a.PublicKey, project.ID, newDataKey)
+		if err != nil {
+			spinner.Fail(fmt.Sprintf("Failed to seal new data key for service account %s: %s", a.ServiceAccountName, err))
+			return err
+		}
+		serviceKeys[a.ServiceAccountID] = wrapped
+	}
+
+	// The server only keeps the escrowed copy if the project is armed for break-glass access
+	breakGlassKey := ""
+	if escrow != nil {
+		breakGlassKey, err = vault.WrapDataKeyForUser(escrow.PublicKey, project.ID, newDataKey)
+		if err != nil {
+			spinner.Fail("Failed to seal new data key for the break-glass escrow: " + err.Error())
+			return err
+		}
+	}
+
+	spinner.UpdateText("Committing rotation...")
+	payload, _ := json.Marshal(map[string]interface{}{
+		"old_wrapped_data_key": project.WrappedDataKey, // The server refuses the rotation if it was replaced since
+		"wrapped_data_key":     hex.EncodeToString(wrappedDK),
+		"secrets":              values,
+		"access_keys":          accessKeys,
+		"group_keys":           groupKeys,
+		"service_keys":         serviceKeys,
+		"break_glass_key":      breakGlassKey,
+		"delete_history":       deleteHistory,
+	})
+
+	req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/projects/"+projectID+"/rotate", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		spinner.Fail("Failed to connect to server")
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		spinner.Fail(fmt.Sprintf("Rotation rejected by server: %s", string(body)))
+		return fmt.Errorf("api error: %s", resp.Status)
+	}
+
+	var result struct {
+		RevokedUsers []uuid.UUID `json:"revoked_users"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	spinner.Success(fmt.Sprintf("Project '%s' rotated: %d secret versions re-encrypted, %d grants re-sealed.", project.Name, len(values), len(accessKeys)+len(groupKeys)+len(serviceKeys)))
+	if len(result.RevokedUsers) > 0 {
+		pterm.Warning.Printf("Access revoked for %d collaborators without a keypair. Grant it again to restore their access.\n", len(result.RevokedUsers))
+	}
+	return nil
+}
+
+var rotateSigningKeyCmd = &cobra.Command{
+	Use:   "signingkey",
+	Short: "Generate a new key to sign access tokens; the previous one keeps verifying them for an hour",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !auth.ValidSigningAlgorithm(rotateAlgorithm) {
+			return fmt.Errorf("algorithm must be EdDSA or ES256")
+		}
+
+		var key models.SigningKey
+		if activeProfile != nil && activeProfile.Token != "" && activeProfile.URL != "" {
+			payload, _ := json.Marshal(map[string]string{"algorithm": rotateAlgorithm})
+			req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/auth/signing-keys/rotate", bytes.NewBuffer(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to connect to server: %w", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusCreated {
+				msg, _ := io.ReadAll(resp.Body)
+				return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+			}
+			json.NewDecoder(resp.Body).Decode(&key)
+		} else {
+			// Without a server, rotate in the database directly; servers reload their keys within a minute
+			_ = godotenv.Load()
+			database, err := db.NewConnection()
+			if err != nil {
+				return fmt.Errorf("failed to connect to local database: %w", err)
+			}
+			defer database.Close()
+
+			newKey, err := auth.NewSigningKey(rotateAlgorithm)
+			if err != nil {
+				return err
+			}
+			if err := database.RotateSigningKey(context.Background(), newKey, auth.SigningKeyRetention); err != nil {
+				return err
+			}
+			key = *newKey
+		}
+
+		pterm.Success.Printf("New %s signing key %s is current. The previous key verifies tokens for another %s.\n", key.Algorithm, key.KID, auth.SigningKeyRetention)
+		return nil
+	},
+}
+
+func init() {
+	rotateCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return rotateInteractive()
+	}
+	rotateMasterKeyCmd.Flags().StringVarP(&rotatePassword, "password", "p", "", "Admin password to unwrap the Master Key")
+	rotateProjectKeyCmd.Flags().StringVarP(&rotateProjectID, "project", "i", "", "Project ID")
+	rotateProjectKeyCmd.Flags().StringVarP(&rotatePassword, "password", "p", "", "Admin password to unwrap the Master Key")
+	rotateProjectKeyCmd.Flags().BoolVar(&rotateDeleteHistory, "delete-history", false, "Permanently delete every older secret version and re-encrypt only the latest ones. The secret history is lost")
+	rotateProjectKeyCmd.Flags().BoolVar(&rotateRevokeNoKeyPair, "revoke-without-keypair", false, "Revoke the grants of collaborators without a keypair, who cannot receive the new key")
+	rotateCmd.AddCommand(rotateMasterKeyCmd)
+	rotateSigningKeyCmd.Flags().StringVar(&rotateAlgorithm, "algorithm", auth.AlgEdDSA, "Signing algorithm: EdDSA or ES256")
+	rotateCmd.AddCommand(rotateProjectKeyCmd)
+	rotateCmd.AddCommand(rotateSigningKeyCmd)
+	rootCmd.AddCommand(rotateCmd)
+}
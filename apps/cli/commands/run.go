@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runProjectID string
+	runPassword  string
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run -- COMMAND [ARGS...]",
+	Short: "Run a command with a project's secrets injected as environment variables",
+	Long: `Decrypts the latest version of every secret of a project and runs a command with them in its
+environment. Service accounts decrypt with BASTION_SERVICE_KEY and need no password.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		if runProjectID == "" {
+			return fmt.Errorf("--project is required")
+		}
+		if _, err := uuid.Parse(runProjectID); err != nil {
+			return fmt.Errorf("invalid project ID: %w", err)
+		}
+
+		password := runPassword
+		if password == "" && os.Getenv("BASTION_SERVICE_KEY") == "" {
+			var err error
+			password, err = pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your password to unlock the project key")
+			if err != nil {
+				return err
+			}
+		}
+
+		_, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, runProjectID, password)
+		if err != nil {
+			return err
+		}
+		secrets, err := fetchSecrets(activeProfile.URL, activeProfile.Token, runProjectID)
+		if err != nil {
+			return err
+		}
+		allowLegacy, err := legacySecretsAllowed(activeProfile.URL)
+		if err != nil {
+			return err
+		}
+
+		env := os.Environ()
+		for _, s := range secrets {
+			plaintext, _, err := vault.DecryptSecret(dataKey, s, allowLegacy)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt '%s': %w", s.Key, err)
+			}
+			env = append(env, s.Key+"="+string(plaintext))
+		}
+
+		child := exec.Command(args[0], args[1:]...)
+		child.Env = env
+		child.Stdin = os.Stdin
+		child.Stdout = os.Stdout
+		child.Stderr = os.Stderr
+
+		if err := child.Run(); err != nil {
+			// Pass the command's exit code through, e.g. to fail a CI step
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				os.Exit(exitErr.ExitCode())
+			}
+			return fmt.Errorf("failed to run %s: %w", args[0], err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	runCmd.Flags().StringVarP(&runProjectID, "project", "i", "", "Project ID")
+	runCmd.Flags().StringVarP(&runPassword, "password", "p", "", "Your password (avoids interactive prompt)")
+	rootCmd.AddCommand(runCmd)
+}
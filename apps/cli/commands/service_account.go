@@ -0,0 +1,695 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// serviceKeyPrefix marks the private key of a service account as handed to machines in BASTION_SERVICE_KEY.
+const serviceKeyPrefix = "bst_sk_"
+
+var (
+	serviceAccountDescription string
+	serviceAccountProjectID   string
+	serviceAccountPassword    string
+	tokenName                 string
+	tokenProjects             []string
+	tokenWrite                bool
+	tokenAllowIPs             []string
+	tokenExpiresInDays        int
+)
+
+var serviceAccountCmd = &cobra.Command{
+	Use:   "service-account",
+	Short: "Manage service accounts and their API tokens, e.g. for CI",
+}
+
+var serviceAccountCreateCmd = &cobra.Command{
+	Use:   "create [NAME]",
+	Short: "Create a service account",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		name := ""
+		if len(args) > 0 {
+			name = args[0]
+		} else {
+			var err error
+			name, err = pterm.DefaultInteractiveTextInput.Show("Service account name")
+			if err != nil {
+				return err
+			}
+		}
+
+		payload, _ := json.Marshal(map[string]string{"name": name, "description": serviceAccountDescription})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/service-accounts", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+		}
+
+		var account models.ServiceAccount
+		json.NewDecoder(resp.Body).Decode(&account)
+		pterm.Success.Printf("Service account '%s' created (%s).\n", account.Name, account.ID)
+
+		serviceKey, _, err := generateServiceKey(account.ID)
+		if err != nil {
+			return err
+		}
+		printServiceKey(serviceKey)
+		pterm.Info.Printf("Issue a token with 'bastion service-account issue %s'.\n", account.Name)
+		return nil
+	},
+}
+
+var serviceAccountListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List service accounts",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		accounts, err := fetchServiceAccounts(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			return err
+		}
+
+		if len(accounts) == 0 {
+			pterm.Info.Println("No service accounts.")
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "Name", "Description", "Active tokens", "Created"}}
+		for _, a := range accounts {
+			tableData = append(tableData, []string{
+				a.ID.String(),
+				a.Name,
+				a.Description,
+				fmt.Sprintf("%d", a.TokenCount),
+				a.CreatedAt.Local().Format("2006-01-02 15:04"),
+			})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var serviceAccountDeleteCmd = &cobra.Command{
+	Use:   "delete [ACCOUNT]",
+	Short: "Delete a service account and all its tokens",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		account, err := serviceAccountArg(args)
+		if err != nil {
+			return err
+		}
+
+		confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show(fmt.Sprintf("Delete '%s' and revoke all its tokens?", account.Name))
+		if !confirm {
+			pterm.Info.Println("Operation cancelled.")
+			return nil
+		}
+
+		req, _ := http.NewRequest("DELETE", activeProfile.URL+"/api/v1/service-accounts/"+account.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("Service account '%s' deleted.\n", account.Name)
+		return nil
+	},
+}
+
+var serviceAccountIssueCmd = &cobra.Command{
+	Use:   "issue [ACCOUNT]",
+	Short: "Issue an API token for a service account, scoped to some projects",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		account, err := serviceAccountArg(args)
+		if err != nil {
+			return err
+		}
+
+		name := tokenName
+		if name == "" {
+			name, err = pterm.DefaultInteractiveTextInput.Show("Token name (e.g. github-actions)")
+			if err != nil {
+				return err
+			}
+		}
+		projects := tokenProjects
+		if len(projects) == 0 {
+			input, err := pterm.DefaultInteractiveTextInput.Show("Project IDs the token may access (comma-separated)")
+			if err != nil {
+				return err
+			}
+			projects = strings.Split(input, ",")
+		}
+		projectIDs := make([]uuid.UUID, 0, len(projects))
+		for _, p := range projects {
+			id, err := uuid.Parse(strings.TrimSpace(p))
+			if err != nil {
+				return fmt.Errorf("invalid project ID '%s': %w", p, err)
+			}
+			projectIDs = append(projectIDs, id)
+		}
+		permission := models.APITokenRead
+		if tokenWrite {
+			permission = models.APITokenReadWrite
+		}
+
+		// Seal the project keys before issuing, so a wrong password leaves no token behind
+		sealed := make(map[uuid.UUID]string)
+		if account.PublicKey != "" {
+			password, err := serviceAccountPasswordArg()
+			if err != nil {
+				return err
+			}
+			for _, id := range projectIDs {
+				wrapped, err := sealProjectKeyForServiceAccount(account, id.String(), password)
+				if err != nil {
+					return err
+				}
+				sealed[id] = wrapped
+			}
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"name":            name,
+			"permission":      permission,
+			"project_ids":     projectIDs,
+			"allowed_ips":     tokenAllowIPs,
+			"expires_in_days": tokenExpiresInDays,
+		})
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/service-accounts/"+account.ID.String()+"/tokens", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+		}
+
+		var result struct {
+			APIToken models.APIToken `json:"api_token"`
+			Token    string          `json:"token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		pterm.Success.Printf("Token '%s' issued for '%s' with %s access to %d projects.\n", result.APIToken.Name, account.Name, result.APIToken.Permission, len(result.APIToken.ProjectIDs))
+		pterm.DefaultBox.WithTitle("API Token (shown only once)").Println(result.Token)
+		pterm.Info.Println("Set it as BASTION_TOKEN in your pipeline's secret store.")
+
+		if account.PublicKey == "" {
+			pterm.Warning.Printf("'%s' has no keypair and cannot decrypt secrets. Run 'bastion service-account keygen %s'.\n", account.Name, account.Name)
+			return nil
+		}
+		for projectID, wrapped := range sealed {
+			if err := postServiceAccountGrant(account.ID, projectID.String(), wrapped); err != nil {
+				return err
+			}
+		}
+		pterm.Success.Printf("Project keys sealed to '%s' for %d projects.\n", account.Name, len(sealed))
+		return nil
+	},
+}
+
+var serviceAccountTokensCmd = &cobra.Command{
+	Use:   "tokens [ACCOUNT]",
+	Short: "List the API tokens of a service account",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		account, err := serviceAccountArg(args)
+		if err != nil {
+			return err
+		}
+		tokens, err := fetchAPITokens(activeProfile.URL, activeProfile.Token, account.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(tokens) == 0 {
+			pterm.Info.Printf("'%s' has no tokens.\n", account.Name)
+			return nil
+		}
+
+		tableData := pterm.TableData{{"ID", "Name", "Permission", "Projects", "Allowed IPs", "Expires", "Last used", "Status"}}
+		for _, t := range tokens {
+			expires, lastUsed, status := "never", "never", "active"
+			if t.ExpiresAt != nil {
+				expires = t.ExpiresAt.Local().Format("2006-01-02 15:04")
+			}
+			if t.LastUsedAt != nil {
+				lastUsed = t.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			if t.RevokedAt != nil {
+				status = "revoked"
+			}
+			allowed := strings.Join(t.AllowedIPs, ", ")
+			if allowed == "" {
+				allowed = "any"
+			}
+			tableData = append(tableData, []string{
+				t.ID.String(),
+				t.Name,
+				t.Permission,
+				fmt.Sprintf("%d", len(t.ProjectIDs)),
+				allowed,
+				expires,
+				lastUsed,
+				status,
+			})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var serviceAccountRevokeCmd = &cobra.Command{
+	Use:   "revoke [ACCOUNT] [TOKEN_ID]",
+	Short: "Revoke an API token",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		account, err := serviceAccountArg(args)
+		if err != nil {
+			return err
+		}
+
+		tokenID := ""
+		if len(args) > 1 {
+			tokenID = args[1]
+		} else {
+			tokens, err := fetchAPITokens(activeProfile.URL, activeProfile.Token, account.ID)
+			if err != nil {
+				return err
+			}
+			var options []string
+			for _, t := range tokens {
+				if t.RevokedAt == nil {
+					options = append(options, fmt.Sprintf("%s - %s (%s)", t.ID, t.Name, t.Permission))
+				}
+			}
+			if len(options) == 0 {
+				return fmt.Errorf("'%s' has no active tokens", account.Name)
+			}
+			selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Select a token")
+			if err != nil {
+				return err
+			}
+			tokenID = strings.Split(selected, " ")[0]
+		}
+		if _, err := uuid.Parse(tokenID); err != nil {
+			return fmt.Errorf("invalid token ID: %w", err)
+		}
+
+		req, _ := http.NewRequest("DELETE", activeProfile.URL+"/api/v1/service-accounts/"+account.ID.String()+"/tokens/"+tokenID, nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("Token %s revoked.\n", tokenID)
+		return nil
+	},
+}
+
+var serviceAccountKeygenCmd = &cobra.Command{
+	Use:   "keygen [ACCOUNT]",
+	Short: "Generate a new keypair for a service account",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		account, err := serviceAccountArg(args)
+		if err != nil {
+			return err
+		}
+
+		if account.PublicKey != "" {
+			confirm, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show(fmt.Sprintf("Replace the keypair of '%s'? Project keys sealed to the old key are dropped and must be granted again.", account.Name))
+			if !confirm {
+				pterm.Info.Println("Operation cancelled.")
+				return nil
+			}
+		}
+
+		serviceKey, removed, err := generateServiceKey(account.ID)
+		if err != nil {
+			return err
+		}
+		printServiceKey(serviceKey)
+		if removed > 0 {
+			pterm.Warning.Printf("%d project keys sealed to the old key were dropped. Grant them again with 'bastion service-account grant %s'.\n", removed, account.Name)
+		}
+		return nil
+	},
+}
+
+var serviceAccountGrantCmd = &cobra.Command{
+	Use:   "grant [ACCOUNT]",
+	Short: "Seal a project's data key to a service account",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		account, err := serviceAccountArg(args)
+		if err != nil {
+			return err
+		}
+		if account.PublicKey == "" {
+			return fmt.Errorf("'%s' has no keypair. Run 'bastion service-account keygen %s' first", account.Name, account.Name)
+		}
+		projectID, err := serviceAccountProjectArg()
+		if err != nil {
+			return err
+		}
+		password, err := serviceAccountPasswordArg()
+		if err != nil {
+			return err
+		}
+
+		spinner, _ := pterm.DefaultSpinner.Start("Sealing the project key to the service account...")
+		wrapped, err := sealProjectKeyForServiceAccount(account, projectID, password)
+		if err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+		if err := postServiceAccountGrant(account.ID, projectID, wrapped); err != nil {
+			spinner.Fail(err.Error())
+			return err
+		}
+
+		spinner.Success(fmt.Sprintf("'%s' can now decrypt project %s. It still needs a token scoped to it.", account.Name, projectID))
+		return nil
+	},
+}
+
+var serviceAccountUngrantCmd = &cobra.Command{
+	Use:   "ungrant [ACCOUNT]",
+	Short: "Remove a project's data key from a service account",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		account, err := serviceAccountArg(args)
+		if err != nil {
+			return err
+		}
+		projectID, err := serviceAccountProjectArg()
+		if err != nil {
+			return err
+		}
+
+		req, _ := http.NewRequest("DELETE", activeProfile.URL+"/api/v1/projects/"+projectID+"/service-accounts/"+account.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("Project key removed from '%s'. Rotate the project key if the service key may have leaked.\n", account.Name)
+		return nil
+	},
+}
+
+// generateServiceKey creates a keypair for a service account, registers its public key and returns the
+// private key encoded for BASTION_SERVICE_KEY, with the number of project keys dropped with the old key.
+func generateServiceKey(accountID uuid.UUID) (string, int64, error) {
+	publicKey, privateKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to generate keypair: %w", err)
+	}
+
+	payload, _ := json.Marshal(map[string]string{"public_key": hex.EncodeToString(publicKey)})
+	req, _ := http.NewRequest("PUT", activeProfile.URL+"/api/v1/service-accounts/"+accountID.String()+"/key", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", 0, fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		RemovedGrants int64 `json:"removed_grants"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return serviceKeyPrefix + hex.EncodeToString(privateKey), result.RemovedGrants, nil
+}
+
+// parseServiceKey decodes the private key of a service account from BASTION_SERVICE_KEY.
+func parseServiceKey(serviceKey string) ([]byte, error) {
+	serviceKey = strings.TrimSpace(serviceKey)
+	if !strings.HasPrefix(serviceKey, serviceKeyPrefix) {
+		return nil, fmt.Errorf("BASTION_SERVICE_KEY is not a service key")
+	}
+	privateKey, err := hex.DecodeString(strings.TrimPrefix(serviceKey, serviceKeyPrefix))
+	if err != nil || len(privateKey) != crypto.X25519KeyLen {
+		return nil, fmt.Errorf("BASTION_SERVICE_KEY is malformed")
+	}
+	return privateKey, nil
+}
+
+func printServiceKey(serviceKey string) {
+	pterm.DefaultBox.WithTitle("Service Key (shown only once)").Println(serviceKey)
+	pterm.Info.Println("Set it as BASTION_SERVICE_KEY next to BASTION_TOKEN. The server only knows its public key.")
+}
+
+// sealProjectKeyForServiceAccount unlocks a project's data key and seals it to a service account.
+func sealProjectKeyForServiceAccount(account *models.ServiceAccount, projectID, password string) (string, error) {
+	project, dataKey, err := unlockProjectKey(activeProfile.URL, activeProfile.Token, projectID, password)
+	if err != nil {
+		return "", err
+	}
+	return vault.WrapDataKeyForUser(account.PublicKey, project.ID, dataKey)
+}
+
+func postServiceAccountGrant(accountID uuid.UUID, projectID, wrapped string) error {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"service_account_id": accountID,
+		"wrapped_data_key":   wrapped,
+	})
+	req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/projects/"+projectID+"/service-accounts", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func serviceAccountProjectArg() (string, error) {
+	projectID := serviceAccountProjectID
+	if projectID == "" {
+		var err error
+		projectID, err = pterm.DefaultInteractiveTextInput.Show("Enter Project ID")
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := uuid.Parse(projectID); err != nil {
+		return "", fmt.Errorf("invalid project ID: %w", err)
+	}
+	return projectID, nil
+}
+
+func serviceAccountPasswordArg() (string, error) {
+	if serviceAccountPassword != "" {
+		return serviceAccountPassword, nil
+	}
+	return pterm.DefaultInteractiveTextInput.WithMask("*").Show("Enter your password to unlock the project key")
+}
+
+// serviceAccountArg resolves the service account named or identified by the first argument, prompting
+// for one if missing.
+func serviceAccountArg(args []string) (*models.ServiceAccount, error) {
+	accounts, err := fetchServiceAccounts(activeProfile.URL, activeProfile.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	account := ""
+	if len(args) > 0 {
+		account = args[0]
+	} else {
+		if len(accounts) == 0 {
+			return nil, fmt.Errorf("no service accounts. Create one with 'bastion service-account create'")
+		}
+		options := make([]string, len(accounts))
+		for i, a := range accounts {
+			options[i] = a.Name
+		}
+		account, err = pterm.DefaultInteractiveSelect.WithOptions(options).Show("Select a service account")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range accounts {
+		if accounts[i].ID.String() == account || accounts[i].Name == account {
+			return &accounts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("service account '%s' not found", account)
+}
+
+func fetchServiceAccounts(url, token string) ([]models.ServiceAccount, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/service-accounts", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch service accounts: %s", resp.Status)
+	}
+
+	var accounts []models.ServiceAccount
+	if err := json.NewDecoder(resp.Body).Decode(&accounts); err != nil {
+		return nil, fmt.Errorf("failed to decode service accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+func fetchAPITokens(url, token string, accountID uuid.UUID) ([]models.APIToken, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/service-accounts/"+accountID.String()+"/tokens", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch api tokens: %s", resp.Status)
+	}
+
+	var tokens []models.APIToken
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode api tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func init() {
+	serviceAccountCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return serviceAccountInteractive()
+	}
+	serviceAccountCreateCmd.Flags().StringVarP(&serviceAccountDescription, "description", "d", "", "What the service account is for")
+	serviceAccountIssueCmd.Flags().StringVarP(&tokenName, "name", "n", "", "Token name")
+	serviceAccountIssueCmd.Flags().StringSliceVarP(&tokenProjects, "project", "i", nil, "Project ID the token may access (repeatable)")
+	serviceAccountIssueCmd.Flags().BoolVar(&tokenWrite, "write", false, "Allow writing secrets, not just reading them")
+	serviceAccountIssueCmd.Flags().StringSliceVar(&tokenAllowIPs, "allow-ip", nil, "IP or CIDR the token may be used from (repeatable)")
+	serviceAccountIssueCmd.Flags().IntVar(&tokenExpiresInDays, "expires-in-days", 0, "Days until the token expires (0 for never)")
+	serviceAccountIssueCmd.Flags().StringVarP(&serviceAccountPassword, "password", "p", "", "Your password, to seal the project keys (avoids interactive prompt)")
+	serviceAccountGrantCmd.Flags().StringVarP(&serviceAccountProjectID, "project", "i", "", "Project ID")
+	serviceAccountGrantCmd.Flags().StringVarP(&serviceAccountPassword, "password", "p", "", "Your password (avoids interactive prompt)")
+	serviceAccountUngrantCmd.Flags().StringVarP(&serviceAccountProjectID, "project", "i", "", "Project ID")
+	serviceAccountCmd.AddCommand(serviceAccountCreateCmd)
+	serviceAccountCmd.AddCommand(serviceAccountListCmd)
+	serviceAccountCmd.AddCommand(serviceAccountDeleteCmd)
+	serviceAccountCmd.AddCommand(serviceAccountIssueCmd)
+	serviceAccountCmd.AddCommand(serviceAccountTokensCmd)
+	serviceAccountCmd.AddCommand(serviceAccountRevokeCmd)
+	serviceAccountCmd.AddCommand(serviceAccountKeygenCmd)
+	serviceAccountCmd.AddCommand(serviceAccountGrantCmd)
+	serviceAccountCmd.AddCommand(serviceAccountUngrantCmd)
+	rootCmd.AddCommand(serviceAccountCmd)
+}
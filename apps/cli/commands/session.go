@@ -0,0 +1,261 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/config"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// refreshMargin renews access tokens a little before they expire, so a command doesn't fail midway.
+const refreshMargin = time.Minute
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "End the current session and forget its tokens",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" {
+			pterm.Info.Println("Not logged in.")
+			return nil
+		}
+
+		req, _ := http.NewRequest("POST", activeProfile.URL+"/api/v1/auth/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			pterm.Warning.Printf("Could not reach the server to revoke the session: %v\n", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusUnauthorized {
+				pterm.Warning.Printf("The server did not revoke the session: %s\n", resp.Status)
+			}
+		}
+
+		cfg, _ := config.LoadConfig()
+		profile := *activeProfile
+		profile.Token = ""
+		profile.RefreshToken = ""
+		cfg.Profiles[profile.Name] = profile
+		if err := cfg.Save(); err != nil {
+			return err
+		}
+
+		pterm.Success.Printf("Logged out of profile '%s'.\n", profile.Name)
+		return nil
+	},
+}
+
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manage login sessions",
+}
+
+var sessionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your active sessions",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		sessions, err := fetchSessions(activeProfile.URL, activeProfile.Token)
+		if err != nil {
+			return err
+		}
+
+		tableData := pterm.TableData{{"ID", "Client", "IP", "Signed in", "Last used", ""}}
+		for _, s := range sessions {
+			current := ""
+			if s.Current {
+				current = "current"
+			}
+			tableData = append(tableData, []string{
+				s.ID.String(),
+				s.UserAgent,
+				s.IP,
+				s.CreatedAt.Local().Format("2006-01-02 15:04"),
+				s.LastUsedAt.Local().Format("2006-01-02 15:04"),
+				current,
+			})
+		}
+
+		return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	},
+}
+
+var sessionRevokeCmd = &cobra.Command{
+	Use:   "revoke [ID]",
+	Short: "Sign out one of your sessions",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		id := ""
+		if len(args) > 0 {
+			id = args[0]
+		} else {
+			sessions, err := fetchSessions(activeProfile.URL, activeProfile.Token)
+			if err != nil {
+				return err
+			}
+			var options []string
+			for _, s := range sessions {
+				if !s.Current {
+					options = append(options, fmt.Sprintf("%s - %s from %s, last used %s", s.ID, s.UserAgent, s.IP, s.LastUsedAt.Local().Format("2006-01-02 15:04")))
+				}
+			}
+			if len(options) == 0 {
+				return fmt.Errorf("no other sessions. Use 'bastion logout' to end this one")
+			}
+			selected, err := pterm.DefaultInteractiveSelect.WithOptions(options).Show("Select a session")
+			if err != nil {
+				return err
+			}
+			id = strings.Split(selected, " ")[0]
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("invalid session ID: %w", err)
+		}
+
+		req, _ := http.NewRequest("DELETE", activeProfile.URL+"/api/v1/auth/sessions/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+		}
+
+		pterm.Success.Printf("Session %s revoked.\n", id)
+		return nil
+	},
+}
+
+var sessionRevokeUserCmd = &cobra.Command{
+	Use:   "revoke-user [USER]",
+	Short: "Sign a user out of all their sessions (admin)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if activeProfile == nil || activeProfile.Token == "" || activeProfile.URL == "" {
+			return fmt.Errorf("no active profile. Please login first")
+		}
+
+		user, err := accessUserArg(args)
+		if err != nil {
+			return err
+		}
+		userID := user
+		if _, err := uuid.Parse(user); err != nil {
+			found, err := fetchPublicKey(activeProfile.URL, activeProfile.Token, user)
+			if err != nil {
+				return err
+			}
+			userID = found.UserID.String()
+		}
+
+		req, _ := http.NewRequest("DELETE", activeProfile.URL+"/api/v1/users/"+userID+"/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+activeProfile.Token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to connect to server: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("api error: %s", strings.TrimSpace(string(msg)))
+		}
+
+		var result struct {
+			Revoked int64 `json:"revoked"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+
+		pterm.Success.Printf("Revoked %d sessions of %s.\n", result.Revoked, user)
+		return nil
+	},
+}
+
+// refreshActiveSession renews the active profile's access token with its refresh token when it is about
+// to expire, or was signed before signing keys had a kid, and saves the new token pair. Refresh tokens are
+// single-use, so the new one must be kept.
+func refreshActiveSession(cfg *config.Config) error {
+	claims := jwt.MapClaims{}
+	if token, _, err := jwt.NewParser().ParseUnverified(activeProfile.Token, claims); err == nil && token.Header["kid"] != nil {
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil && time.Until(exp.Time) > refreshMargin {
+			return nil
+		}
+	}
+
+	payload, _ := json.Marshal(map[string]string{"refresh_token": activeProfile.RefreshToken})
+	resp, err := http.Post(activeProfile.URL+"/api/v1/auth/refresh", "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("session expired or revoked")
+	}
+
+	var tokens loginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	activeProfile.Token = tokens.Token
+	activeProfile.RefreshToken = tokens.RefreshToken
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]config.Profile)
+	}
+	cfg.Profiles[activeProfile.Name] = *activeProfile
+	return cfg.Save()
+}
+
+func fetchSessions(url, token string) ([]models.Session, error) {
+	req, _ := http.NewRequest("GET", url+"/api/v1/auth/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch sessions: %s", resp.Status)
+	}
+
+	var sessions []models.Session
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+func init() {
+	sessionCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return sessionInteractive()
+	}
+	sessionCmd.AddCommand(sessionListCmd)
+	sessionCmd.AddCommand(sessionRevokeCmd)
+	sessionCmd.AddCommand(sessionRevokeUserCmd)
+	rootCmd.AddCommand(sessionCmd)
+	rootCmd.AddCommand(logoutCmd)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -41,56 +42,211 @@ func main() {
 		log.Fatalf("Could not run migrations: %v", err)
 	}
 
+	// Load the keys signing access tokens, generating the first one on a fresh install
+	if err := auth.LoadSigningKeys(context.Background(), database); err != nil {
+		log.Fatalf("Could not load signing keys: %v", err)
+	}
+	go auth.WatchSigningKeys(context.Background(), database, time.Minute)
+
 	// Initialize API Handler
 	h := api.NewHandler(database)
 
+	// Backoff and lockout after failed logins
+	h.LoginPolicy, err = auth.LoginPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid login policy: %v", err)
+	}
+	// Forwarded client addresses are only believed from these proxies
+	trustedProxies, err := auth.TrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid BASTION_TRUSTED_PROXIES: %v", err)
+	}
+	loginLimiter := auth.NewRateLimiter(h.LoginPolicy.IPRateLimit, trustedProxies)
+
+	// Passkey ceremonies live in the database unless a single server keeps them in memory
+	switch store := os.Getenv("BASTION_WEBAUTHN_STORE"); store {
+	case "", "postgres":
+	case "memory":
+		h.Ceremonies = api.NewMemoryCeremonyStore()
+	default:
+		log.Fatalf("Invalid BASTION_WEBAUTHN_STORE %q: use postgres or memory", store)
+	}
+
+	// Single sign-on through an OpenID Connect provider, if configured
+	oidcConfig, err := auth.OIDCConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid single sign-on configuration: %v", err)
+	}
+	if oidcConfig != nil {
+		h.OIDC = auth.NewOIDCProvider(*oidcConfig)
+		log.Printf("Single sign-on enabled with %s", oidcConfig.Issuer)
+	}
+
+	// Remove the wrapped keys of expired time-bound grants
+	go h.RunGrantReaper(context.Background(), time.Minute)
+	// Remove expired sessions and their refresh tokens
+	go h.RunSessionReaper(context.Background(), time.Hour)
+
 	r := chi.NewRouter()
 
 	// Standard middleware stack
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(auth.RealIP(trustedProxies))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
 
+	// Public keys verifying access tokens
+	r.Get("/.well-known/jwks.json", h.JWKSHandler)
+
 	// API Routes
 	r.Route("/api/v1", func(r chi.Router) {
 		// Public routes
 		r.Get("/status", h.StatusHandler)
 		r.Get("/version/check", h.VersionCheckHandler)
-		r.Post("/auth/login", h.LoginHandler)
-		r.Get("/auth/passkey/login/begin", h.PasskeyLoginBegin)
-		r.Post("/auth/passkey/login/finish", h.PasskeyLoginFinish)
+		r.Post("/auth/refresh", h.RefreshHandler)
+		r.Get("/auth/oidc/login", h.OIDCLogin)
+		r.Get("/auth/oidc/callback", h.OIDCCallback)
+		r.Post("/auth/oidc/token", h.OIDCToken)
+
+		// Logins and invitation acceptance, limited per IP address
+		r.Group(func(r chi.Router) {
+			r.Use(loginLimiter.Middleware)
+			r.Post("/invitations/accept", h.AcceptInvitation)
+			r.Post("/auth/login", h.LoginHandler)
+			r.Post("/auth/mfa/verify", h.VerifyMFA)
+			r.Post("/auth/mfa/enroll", h.BeginMFAEnrollment)
+			r.Post("/auth/mfa/enroll/confirm", h.ConfirmMFAEnrollment)
+			r.Get("/auth/passkey/login/begin", h.PasskeyLoginBegin)
+			r.Post("/auth/passkey/login/finish", h.PasskeyLoginFinish)
+		})
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
-			r.Use(auth.JWTMiddleware)
+			r.Use(auth.JWTMiddleware(database))
 
-			r.Get("/auth/me", h.GetMe)
-			r.Get("/auth/passkey/register/begin", h.PasskeyRegisterBegin)
-			r.Post("/auth/passkey/register/finish", h.PasskeyRegisterFinish)
+			// Routes for users only; API tokens are limited to the project-scoped routes below
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireUser)
 
-			r.Get("/vault/config", h.GetVaultConfigHandler)
-			r.Get("/audit", h.ListAuditLogs)
-			r.Get("/clients", h.ListClients)
+				r.Get("/auth/me", h.GetMe)
+				r.Post("/auth/logout", h.LogoutHandler)
+				r.Get("/auth/sessions", h.ListMySessions)
+				r.Delete("/auth/sessions/{id}", h.RevokeMySession)
+				r.Get("/auth/keys", h.GetMyKeyPair)
+				r.Put("/auth/keys", h.SetMyKeyPair)
+				r.Get("/users/{user}/public-key", h.GetUserPublicKey)
+				r.Get("/auth/passkey/register/begin", h.PasskeyRegisterBegin)
+				r.Post("/auth/passkey/register/finish", h.PasskeyRegisterFinish)
+				r.Get("/auth/passkeys", h.ListMyPasskeys)
+				r.Put("/auth/passkeys/{id}/name", h.RenameMyPasskey)
+				r.Delete("/auth/passkeys/{id}", h.DeleteMyPasskey)
+				r.Get("/auth/mfa", h.GetMyMFA)
+				r.Post("/auth/mfa/totp", h.EnrollMyTOTP)
+				r.Post("/auth/mfa/totp/confirm", h.ConfirmMyTOTP)
+				r.Delete("/auth/mfa/totp", h.DisableMyTOTP)
+				r.Post("/auth/mfa/recovery-codes", h.RegenerateMyRecoveryCodes)
 
-			// Admin-only routes
-			r.Group(func(r chi.Router) {
-				r.Use(auth.AdminMiddleware)
-				r.Post("/clients", h.CreateClient)
-				r.Delete("/clients/{id}", h.DeleteClient)
-				r.Post("/collaborators", h.CreateCollaborator)
+				r.Get("/vault/config", h.GetVaultConfigHandler)
+				r.Get("/clients", h.ListClients)
+				r.Get("/projects", h.ListProjectsByClient)
+				r.Get("/groups", h.ListGroups)
+				r.Get("/groups/{id}", h.GetGroup)
+				r.Get("/groups/{id}/key", h.GetMyGroupKey)
+				r.With(auth.RequirePermission(auth.PermReadAudit)).Get("/audit", h.ListAuditLogs)
+				r.Get("/break-glass/escrow", h.GetBreakGlassEscrow)
+				r.Get("/break-glass/key", h.GetMyBreakGlassKey)
+				r.Post("/break-glass", h.BreakGlass)
+				r.With(auth.RequirePermission(auth.PermReadAudit)).Get("/break-glass/sessions", h.ListBreakGlassSessions)
+				r.Get("/access-requests", h.ListAccessRequests)
+				r.Post("/access-requests", h.CreateAccessRequest)
+				r.With(auth.RequirePermission(auth.PermManageAccess)).Post("/access-requests/{id}/approve", h.ApproveAccessRequest)
+				r.With(auth.RequirePermission(auth.PermManageAccess)).Post("/access-requests/{id}/deny", h.DenyAccessRequest)
+
+				// Vault administration
+				r.Group(func(r chi.Router) {
+					r.Use(auth.RequirePermission(auth.PermManageVault))
+					r.Post("/clients", h.CreateClient)
+					r.Delete("/clients/{id}", h.DeleteClient)
+					r.Get("/clients/{id}/users", h.ListClientUsers)
+					r.Put("/clients/{id}/users/{user}", h.AddClientUser)
+					r.Delete("/clients/{id}/users/{user}", h.RemoveClientUser)
+					r.Post("/projects", h.CreateProject)
+					r.Delete("/projects/{id}", h.DeleteProject)
+					r.Put("/users/{user}/role", h.SetUserRole)
+					r.Delete("/users/{user}/sessions", h.RevokeUserSessions)
+					r.Delete("/users/{user}/mfa", h.ResetUserMFA)
+					r.Get("/auth/lockouts", h.ListLoginLockouts)
+					r.Delete("/users/{user}/lockout", h.UnlockUser)
+					r.Get("/auth/mfa/policy", h.GetMFAPolicy)
+					r.Put("/auth/mfa/policy", h.SetMFAPolicy)
+					r.Get("/invitations", h.ListInvitations)
+					r.Post("/invitations", h.CreateInvitation)
+					r.Delete("/invitations/{id}", h.RevokeInvitation)
+					r.Post("/invitations/{id}/grant", h.CompleteInvitationGrant)
+					r.Post("/vault/rotate", h.RotateMasterKey)
+					r.Get("/auth/signing-keys", h.ListSigningKeys)
+					r.Post("/auth/signing-keys/rotate", h.RotateSigningKey)
+					r.Post("/projects/{id}/rotate", h.RotateProjectKey)
+					r.Put("/projects/{id}/protection", h.SetProjectProtection)
+					r.Post("/break-glass/escrow", h.CreateBreakGlassEscrow)
+					r.Get("/break-glass/responders", h.ListBreakGlassResponders)
+					r.Post("/break-glass/responders", h.AddBreakGlassResponder)
+					r.Delete("/break-glass/responders/{user}", h.RemoveBreakGlassResponder)
+					r.Get("/break-glass/projects", h.ListArmedProjects)
+					r.Put("/projects/{id}/break-glass", h.ArmBreakGlass)
+					r.Delete("/projects/{id}/break-glass", h.DisarmBreakGlass)
+					r.Post("/break-glass/sessions/{id}/acknowledge", h.AcknowledgeBreakGlassSession)
+					r.Post("/groups", h.CreateGroup)
+					r.Delete("/groups/{id}", h.DeleteGroup)
+					r.Get("/groups/{id}/members", h.ListGroupMembers)
+					r.Post("/groups/{id}/members", h.AddGroupMember)
+					r.Delete("/groups/{id}/members/{user}", h.RemoveGroupMember)
+					r.Get("/service-accounts", h.ListServiceAccounts)
+					r.Post("/service-accounts", h.CreateServiceAccount)
+					r.Delete("/service-accounts/{id}", h.DeleteServiceAccount)
+					r.Put("/service-accounts/{id}/key", h.SetServiceAccountKey)
+					r.Get("/service-accounts/{id}/tokens", h.ListAPITokens)
+					r.Post("/service-accounts/{id}/tokens", h.CreateAPIToken)
+					r.Delete("/service-accounts/{id}/tokens/{token}", h.RevokeAPIToken)
+				})
 			})
 
-			r.Get("/projects", h.ListProjectsByClient)
-			r.Get("/projects/{id}", h.GetProject)
-			r.Get("/projects/{id}/key", h.GetProjectKey)
-			r.Post("/projects", h.CreateProject)
-			r.Delete("/projects/{id}", h.DeleteProject)
+			// Project-scoped routes, allowed by the global role, by the role of the user's grant or by the
+			// scope of an API token
+			readProject := auth.RequireProjectPermission(database, auth.PermReadSecrets, auth.ProjectFromURLParam("id"))
+			manageAccess := auth.RequireProjectPermission(database, auth.PermManageAccess, auth.ProjectFromURLParam("id"))
+
+			r.With(readProject).Get("/projects/{id}", h.GetProject)
+			r.With(readProject).Get("/projects/{id}/key", h.GetProjectKey)
+			r.With(manageAccess).Get("/projects/{id}/access", h.ListProjectAccess)
+			r.With(manageAccess).Post("/projects/{id}/access", h.GrantProjectAccess)
+			r.With(manageAccess).Delete("/projects/{id}/access/{user}", h.RevokeProjectAccess)
+			r.With(manageAccess).Get("/projects/{id}/groups", h.ListProjectGroupAccess)
+			r.With(manageAccess).Post("/projects/{id}/groups", h.GrantGroupProjectAccess)
+			r.With(manageAccess).Delete("/projects/{id}/groups/{group}", h.RevokeGroupProjectAccess)
+			r.With(manageAccess).Get("/projects/{id}/service-accounts", h.ListProjectServiceAccounts)
+			r.With(manageAccess).Post("/projects/{id}/service-accounts", h.GrantServiceAccountProjectAccess)
+			r.With(manageAccess).Delete("/projects/{id}/service-accounts/{account}", h.RevokeServiceAccountProjectAccess)
+
+			r.With(auth.RequireProjectPermission(database, auth.PermReadSecrets, auth.ProjectFromQuery("project_id"))).
+				Get("/secrets", h.ListSecretsByProject)
+			r.With(auth.RequireProjectPermission(database, auth.PermReadSecrets, auth.ProjectFromQuery("project_id"))).
+				Get("/secrets/history", h.GetSecretHistory)
+			r.With(auth.RequireProjectPermission(database, auth.PermWriteSecrets, auth.ProjectFromJSONBody("project_id"))).
+				Post("/secrets", h.CreateSecret)
 
-			r.Get("/secrets", h.ListSecretsByProject)
-			r.Post("/secrets", h.CreateSecret)
-			r.Get("/secrets/history", h.GetSecretHistory)
+			r.With(auth.RequireProjectPermission(database, auth.PermReadSecrets, auth.ProjectFromQuery("project_id"))).
+				Get("/change-sets", h.ListChangeSets)
+			r.With(auth.RequireProjectPermission(database, auth.PermWriteSecrets, auth.ProjectFromJSONBody("project_id"))).
+				Post("/change-sets", h.CreateChangeSet)
+			r.With(auth.RequireProjectPermission(database, auth.PermReadSecrets, h.ChangeSetProject)).
+				Get("/change-sets/{id}", h.GetChangeSet)
+			// Approving is the second person's job; tokens can only propose
+			r.With(auth.RequireUser, auth.RequireProjectPermission(database, auth.PermWriteSecrets, h.ChangeSetProject)).
+				Post("/change-sets/{id}/approve", h.ApproveChangeSet)
+			r.With(auth.RequireUser, auth.RequireProjectPermission(database, auth.PermWriteSecrets, h.ChangeSetProject)).
+				Post("/change-sets/{id}/reject", h.RejectChangeSet)
 		})
 	})
 
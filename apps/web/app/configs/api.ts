@@ -22,6 +22,54 @@ api.interceptors.request.use((config) => {
   return config;
 });
 
+// Access tokens are short-lived: on a 401, trade the refresh token for a new pair and retry once.
+// Refresh tokens are single-use, so concurrent 401s share one refresh.
+let refreshing: Promise<string | null> | null = null;
+
+const refreshSession = async (): Promise<string | null> => {
+  const refreshToken = localStorage.getItem('bastion_refresh_token');
+  if (!refreshToken) return null;
+
+  const response = await fetch('/api/v1/auth/refresh', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ refresh_token: refreshToken }),
+  });
+  if (!response.ok) {
+    localStorage.removeItem('bastion_token');
+    localStorage.removeItem('bastion_refresh_token');
+    Cookies.remove('bastion_session', { path: '/' });
+    return null;
+  }
+
+  const data = await response.json();
+  localStorage.setItem('bastion_token', data.token);
+  localStorage.setItem('bastion_refresh_token', data.refresh_token);
+  Cookies.set('bastion_session', data.token, { expires: 1, path: '/' });
+  return data.token;
+};
+
+api.interceptors.response.use(undefined, async (error) => {
+  const original = error.config;
+  if (
+    globalThis.window === undefined ||
+    error.response?.status !== 401 ||
+    original._retried
+  ) {
+    throw error;
+  }
+
+  refreshing ??= refreshSession().finally(() => {
+    refreshing = null;
+  });
+  const token = await refreshing;
+  if (!token) throw error;
+
+  original._retried = true;
+  original.headers.Authorization = `Bearer ${token}`;
+  return api(original);
+});
+
 export const getApi = (request?: Request) => {
   if (request) {
     const cookieHeader = request.headers.get('Cookie') || '';
@@ -3,7 +3,7 @@ import { createContext, use, useMemo, useState } from 'react';
 
 interface AuthContextType {
   token: string | null;
-  setToken: (token: string | null) => void;
+  setToken: (token: string | null, refreshToken?: string) => void;
   logout: () => void;
   isAdmin: boolean;
 }
@@ -22,24 +22,37 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     if (!token) return false;
     try {
       const payload = JSON.parse(atob(token.split('.')[1]));
-      return payload.admin === true;
+      return payload.role === 'ADMIN';
     } catch {
       return false;
     }
   }, [token]);
 
-  const handleSetToken = (newToken: string | null) => {
+  const handleSetToken = (newToken: string | null, refreshToken?: string) => {
     if (globalThis.window !== undefined) {
       if (newToken) {
         localStorage.setItem('bastion_token', newToken);
       } else {
         localStorage.removeItem('bastion_token');
+        localStorage.removeItem('bastion_refresh_token');
+      }
+      if (refreshToken) {
+        localStorage.setItem('bastion_refresh_token', refreshToken);
       }
     }
     setToken(newToken);
   };
 
-  const logout = () => handleSetToken(null);
+  const logout = () => {
+    // Revoke the session server-side; the local tokens are dropped either way
+    if (token) {
+      fetch('/api/v1/auth/logout', {
+        method: 'POST',
+        headers: { Authorization: `Bearer ${token}` },
+      }).catch(() => {});
+    }
+    handleSetToken(null);
+  };
 
   return (
     <AuthContext value={{ token, setToken: handleSetToken, logout, isAdmin }}>
@@ -39,9 +39,9 @@ export default function Login() {
   // Calculate form validity
   const isFormValid = password.trim().length > 0;
 
-  const handleLoginSuccess = (token: string) => {
-    // Set token in context (handles localStorage)
-    setToken(token);
+  const handleLoginSuccess = (token: string, refreshToken: string) => {
+    // Set tokens in context (handles localStorage)
+    setToken(token, refreshToken);
     // Also set cookie for server-side / secondary checks
     Cookies.set('bastion_session', token, { expires: 1, path: '/' });
 
@@ -76,7 +76,7 @@ export default function Login() {
       }
 
       const data = await response.json();
-      handleLoginSuccess(data.token);
+      handleLoginSuccess(data.token, data.refresh_token);
     } catch (error: unknown) {
       const message =
         error instanceof Error ? error.message : 'Something went wrong';
@@ -92,18 +92,16 @@ export default function Login() {
   }
 
   async function handlePasskeyLogin() {
-    if (!email.trim()) {
-      setError('Please enter your email to use Passkey');
-      return;
-    }
-
     setPasskeyLoading(true);
     setError('');
 
     try {
-      // 1. Get options from server
+      // 1. Get options from server. With an email, passkeys registered before
+      // usernameless login, which the authenticator may not hold, are offered too
       const beginResp = await fetch(
-        `/api/v1/auth/passkey/login/begin?email=${encodeURIComponent(email)}`
+        email.includes('@')
+          ? `/api/v1/auth/passkey/login/begin?email=${encodeURIComponent(email.trim())}`
+          : '/api/v1/auth/passkey/login/begin'
       );
       if (!beginResp.ok) throw new Error('Failed to start Passkey login');
 
@@ -114,7 +112,7 @@ export default function Login() {
 
       // 3. Verify assertion on server
       const finishResp = await fetch(
-        `/api/v1/auth/passkey/login/finish?email=${encodeURIComponent(email)}`,
+        `/api/v1/auth/passkey/login/finish?ceremony_id=${encodeURIComponent(options.ceremony_id)}`,
         {
           method: 'POST',
           headers: { 'Content-Type': 'application/json' },
@@ -125,7 +123,7 @@ export default function Login() {
       if (!finishResp.ok) throw new Error('Passkey verification failed');
 
       const data = await finishResp.json();
-      handleLoginSuccess(data.token);
+      handleLoginSuccess(data.token, data.refresh_token);
     } catch (error: unknown) {
       console.error(error);
       const message =
@@ -103,14 +103,17 @@ export default function VaultOverview() {
       });
 
       // 3. Verify attestation on server
-      const finishResp = await fetch('/api/v1/auth/passkey/register/finish', {
-        method: 'POST',
-        headers: {
-          'Content-Type': 'application/json',
-          Authorization: `Bearer ${token}`,
-        },
-        body: JSON.stringify(attestation),
-      });
+      const finishResp = await fetch(
+        `/api/v1/auth/passkey/register/finish?ceremony_id=${encodeURIComponent(options.ceremony_id)}`,
+        {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/json',
+            Authorization: `Bearer ${token}`,
+          },
+          body: JSON.stringify(attestation),
+        }
+      );
 
       if (!finishResp.ok) throw new Error('Passkey verification failed');
 
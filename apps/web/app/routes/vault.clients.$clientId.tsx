@@ -31,7 +31,7 @@ import { useAuth } from '../contexts/auth-context';
 import {
   bytesToHex,
   decrypt,
-  deriveKey,
+  deriveKeyFromEncodedSalt,
   encrypt,
   hexToBytes,
 } from '../utils/crypto';
@@ -114,9 +114,8 @@ export default function ClientDetail() {
       });
       const vc = await vcResponse.json();
 
-      const salt = hexToBytes(vc.master_key_salt);
       const wrappedMK = hexToBytes(vc.wrapped_master_key);
-      const adminKEK = await deriveKey(adminPassword, salt);
+      const adminKEK = await deriveKeyFromEncodedSalt(adminPassword, vc.master_key_salt);
       const masterKey = await decrypt(adminKEK, wrappedMK);
 
       const dataKey = globalThis.crypto.getRandomValues(new Uint8Array(32));
@@ -1,90 +1,126 @@
 import type { ChangeEvent, FormEvent } from 'react';
-import { useState } from 'react';
+import { useEffect, useState } from 'react';
 
 import {
   IconAlertCircle,
+  IconKey,
+  IconMailPlus,
   IconShield,
-  IconUserPlus,
-  IconUsers,
+  IconTrash,
 } from '@tabler/icons-react';
 
 import {
+  Badge,
   Box,
   Button,
   Card,
-  Chip,
   Dialog,
   Flex,
   Grid,
   Stack,
+  Table,
   Text,
   TextField,
   toast,
 } from '@pittorica/react';
 
 import { useAuth } from '../contexts/auth-context';
+import {
+  bytesToHex,
+  decrypt,
+  deriveKeyFromEncodedSalt,
+  grantAAD,
+  hexToBytes,
+  sealToPublicKey,
+} from '../utils/crypto';
+
+interface Invitation {
+  id: string;
+  email: string;
+  project_id?: string;
+  status: 'pending' | 'accepted' | 'completed' | 'expired' | 'revoked';
+  expires_at: string;
+  accepted_user_id?: string;
+  created_at: string;
+}
+
+const STATUS_COLORS: Record<Invitation['status'], string> = {
+  pending: 'blue',
+  accepted: 'orange',
+  completed: 'teal',
+  expired: 'gray',
+  revoked: 'red',
+};
 
 export default function Collaborators() {
+  const [invitations, setInvitations] = useState<Invitation[]>([]);
+  const [loading, setLoading] = useState(true);
   const [isModalOpen, setIsModalOpen] = useState(false);
-  const [newCollab, setNewCollab] = useState({
-    username: '',
+  const [newInvite, setNewInvite] = useState({
     email: '',
-    password: '',
     projectId: '',
+    expiresInHours: '72',
   });
   const [creating, setCreating] = useState(false);
+  const [issuedToken, setIssuedToken] = useState('');
+  const [grantTarget, setGrantTarget] = useState<Invitation | null>(null);
+  const [masterPassword, setMasterPassword] = useState('');
+  const [granting, setGranting] = useState(false);
   const { token } = useAuth();
 
-  async function handleCreateCollaborator(e: FormEvent<HTMLFormElement>) {
-    e.preventDefault();
-    if (
-      !newCollab.username ||
-      !newCollab.email ||
-      !newCollab.password ||
-      !newCollab.projectId
-    )
-      return;
+  const fetchInvitations = async () => {
+    if (!token) return;
+    try {
+      const response = await fetch('/api/v1/invitations', {
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      if (response.ok) {
+        const data = await response.json();
+        setInvitations(Array.isArray(data) ? data : []);
+      }
+    } catch (error) {
+      console.error('Failed to fetch invitations', error);
+    } finally {
+      setLoading(false);
+    }
+  };
 
-    setCreating(true);
+  useEffect(() => {
+    fetchInvitations();
+  }, [token]);
 
-    // In a production scenario, we would derive the KEK client-side
-    // For now, keeping the mock logic consistent with current backend integration
-    const mockWrappedKey = 'collab-wrapped-key-' + Math.random().toString(16);
+  async function handleCreateInvitation(e: FormEvent<HTMLFormElement>) {
+    e.preventDefault();
+    if (!newInvite.email) return;
 
+    setCreating(true);
     try {
-      const response = await fetch('/api/v1/collaborators', {
+      const response = await fetch('/api/v1/invitations', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
           Authorization: `Bearer ${token}`,
         },
         body: JSON.stringify({
-          username: newCollab.username,
-          email: newCollab.email,
-          password_hash: 'mock-hash', // Should be Argon2id in full production
-          salt: 'mock-salt',
-          project_id: newCollab.projectId,
-          wrapped_data_key: mockWrappedKey,
+          email: newInvite.email,
+          project_id: newInvite.projectId || undefined,
+          expires_in_hours: Number(newInvite.expiresInHours) || undefined,
         }),
       });
 
-      if (response.ok) {
-        setIsModalOpen(false);
-        const addedUsername = newCollab.username;
-        setNewCollab({ username: '', email: '', password: '', projectId: '' });
-        toast({
-          title: 'Access granted',
-          description: `Collaborator ${addedUsername} has been assigned to the project.`,
-          color: 'teal',
-        });
-      } else {
+      if (!response.ok) {
         const errText = await response.text();
-        throw new Error(errText || 'Failed to create collaborator');
+        throw new Error(errText || 'Failed to create invitation');
       }
+
+      const data = await response.json();
+      setIssuedToken(data.token);
+      setNewInvite({ email: '', projectId: '', expiresInHours: '72' });
+      fetchInvitations();
     } catch (error) {
-      console.error('Failed to create collaborator', error);
+      console.error('Failed to create invitation', error);
       toast({
-        title: 'Assignment failed',
+        title: 'Invitation failed',
         description:
           error instanceof Error ? error.message : 'An unknown error occurred',
         color: 'red',
@@ -94,6 +130,107 @@ export default function Collaborators() {
     }
   }
 
+  async function handleRevoke(invitation: Invitation) {
+    const response = await fetch(`/api/v1/invitations/${invitation.id}`, {
+      method: 'DELETE',
+      headers: { Authorization: `Bearer ${token}` },
+    });
+    if (response.ok) {
+      toast({
+        title: 'Invitation revoked',
+        description: `The invitation for ${invitation.email} can no longer be used.`,
+        color: 'teal',
+      });
+      fetchInvitations();
+    } else {
+      toast({
+        title: 'Revocation failed',
+        description: await response.text(),
+        color: 'red',
+      });
+    }
+  }
+
+  async function handleCompleteGrant(e: FormEvent<HTMLFormElement>) {
+    e.preventDefault();
+    if (!grantTarget?.project_id || !grantTarget.accepted_user_id) return;
+
+    setGranting(true);
+    try {
+      const headers = { Authorization: `Bearer ${token}` };
+
+      const vcResponse = await fetch('/api/v1/vault/config', { headers });
+      if (!vcResponse.ok)
+        throw new Error('Failed to fetch vault configuration');
+      const vc = await vcResponse.json();
+
+      const projectResponse = await fetch(
+        `/api/v1/projects/${grantTarget.project_id}`,
+        { headers }
+      );
+      if (!projectResponse.ok) throw new Error('Project not found');
+      const project = await projectResponse.json();
+
+      const keyResponse = await fetch(
+        `/api/v1/users/${grantTarget.accepted_user_id}/public-key`,
+        { headers }
+      );
+      if (!keyResponse.ok) throw new Error("Invitee's public key not found");
+      const { public_key: publicKey } = await keyResponse.json();
+
+      // Unwrap the project key with the Master Key and seal it to the invitee's public key
+      const adminKEK = await deriveKeyFromEncodedSalt(
+        masterPassword,
+        vc.master_key_salt
+      );
+      const masterKey = await decrypt(
+        adminKEK,
+        hexToBytes(vc.wrapped_master_key)
+      );
+      const dataKey = await decrypt(
+        masterKey,
+        hexToBytes(project.wrapped_data_key)
+      );
+      const wrappedDataKey = await sealToPublicKey(
+        hexToBytes(publicKey),
+        dataKey,
+        grantAAD(grantTarget.project_id)
+      );
+
+      const response = await fetch(
+        `/api/v1/invitations/${grantTarget.id}/grant`,
+        {
+          method: 'POST',
+          headers: { ...headers, 'Content-Type': 'application/json' },
+          body: JSON.stringify({ wrapped_data_key: bytesToHex(wrappedDataKey) }),
+        }
+      );
+      if (!response.ok) {
+        const errText = await response.text();
+        throw new Error(errText || 'Failed to complete grant');
+      }
+
+      toast({
+        title: 'Access granted',
+        description: `${grantTarget.email} can now access ${project.name}.`,
+        color: 'teal',
+      });
+      setGrantTarget(null);
+      fetchInvitations();
+    } catch (error) {
+      console.error('Failed to complete grant', error);
+      toast({
+        title: 'Grant failed',
+        description:
+          error instanceof Error ? error.message : 'An unknown error occurred',
+        color: 'red',
+      });
+    } finally {
+      setMasterPassword('');
+      setGranting(false);
+    }
+  }
+
   return (
     <Stack gap="6">
       <Flex justify="between" align="end">
@@ -102,18 +239,18 @@ export default function Collaborators() {
             Collaborators
           </Text>
           <Text color="muted" size="2">
-            Manage restricted access for team members.
+            Invite team members and grant them access to projects.
           </Text>
         </Stack>
         <Button variant="filled" size="md" onClick={() => setIsModalOpen(true)}>
           <Flex gap="2" align="center">
-            <IconUserPlus size={18} />
-            <Text>New Collaborator</Text>
+            <IconMailPlus size={18} />
+            <Text>Invite Collaborator</Text>
           </Flex>
         </Button>
       </Flex>
 
-      <Grid columns="2" gap="6">
+      <Grid columns="1" gap="6">
         <Card p="6">
           <Stack gap="4">
             <Flex color="source" align="center" gap="3">
@@ -123,9 +260,9 @@ export default function Collaborators() {
               </Text>
             </Flex>
             <Text color="muted" size="2">
-              Collaborators are restricted users who only possess the keys to
-              specific projects. They cannot create clients or view audit logs
-              unless explicitly authorized.
+              Invitees redeem a single-use token with{' '}
+              <code>bastion accept-invite</code>, choose their own password and
+              generate their keypair locally. You never learn their password.
             </Text>
             <Box
               p="3"
@@ -142,84 +279,231 @@ export default function Collaborators() {
                   style={{ marginTop: '2px' }}
                 />
                 <Text size="1" color="muted">
-                  When you add a collaborator, a new unique re-wrapped project
-                  key is generated specifically for their password.
+                  Once an invitation is accepted, complete the grant: the
+                  project key is sealed to the invitee's public key in your
+                  browser.
                 </Text>
               </Flex>
             </Box>
           </Stack>
         </Card>
-
-        <Card p="6">
-          <Flex
-            direction={'column'}
-            gap="4"
-            align="center"
-            justify="center"
-            style={{ height: '100%', textAlign: 'center' }}
-          >
-            <IconUsers size={48} color="var(--pittorica-color-muted)" />
-            <Stack align="center" gap="1">
-              <Text weight="bold">Collaborator List</Text>
-              <Text size="2" color="muted">
-                Management is currently limited to new assignments.
-              </Text>
-            </Stack>
-            <Chip variant="soft">Coming Soon: Detailed User List</Chip>
-          </Flex>
-        </Card>
       </Grid>
 
+      <Card p="0" style={{ overflow: 'hidden' }}>
+        <Table.Root>
+          <Table.Header>
+            <Table.Row>
+              <Table.ColumnHeader>Email</Table.ColumnHeader>
+              <Table.ColumnHeader>Project</Table.ColumnHeader>
+              <Table.ColumnHeader>Status</Table.ColumnHeader>
+              <Table.ColumnHeader>Expires</Table.ColumnHeader>
+              <Table.ColumnHeader style={{ textAlign: 'right' }}>
+                Actions
+              </Table.ColumnHeader>
+            </Table.Row>
+          </Table.Header>
+          <Table.Body>
+            {loading ? (
+              <Table.Row>
+                <Table.Cell colSpan={5}>
+                  <Flex p="8" justify="center">
+                    <Text color="muted">Loading invitations...</Text>
+                  </Flex>
+                </Table.Cell>
+              </Table.Row>
+            ) : invitations.length === 0 ? (
+              <Table.Row>
+                <Table.Cell colSpan={5}>
+                  <Flex p="8" justify="center">
+                    <Text color="muted">No invitations yet.</Text>
+                  </Flex>
+                </Table.Cell>
+              </Table.Row>
+            ) : (
+              invitations.map((invitation) => (
+                <Table.Row key={invitation.id}>
+                  <Table.Cell>
+                    <Text weight="bold">{invitation.email}</Text>
+                  </Table.Cell>
+                  <Table.Cell>
+                    <Text
+                      size="1"
+                      style={{ fontFamily: 'var(--pittorica-font-code)' }}
+                    >
+                      {invitation.project_id ?? '-'}
+                    </Text>
+                  </Table.Cell>
+                  <Table.Cell>
+                    <Badge
+                      variant="standard"
+                      color={STATUS_COLORS[invitation.status]}
+                    >
+                      {invitation.status}
+                    </Badge>
+                  </Table.Cell>
+                  <Table.Cell>
+                    <Text size="2" color="muted">
+                      {new Date(invitation.expires_at).toLocaleString()}
+                    </Text>
+                  </Table.Cell>
+                  <Table.Cell style={{ textAlign: 'right' }}>
+                    <Flex gap="2" justify="end">
+                      {invitation.status === 'accepted' &&
+                        invitation.project_id && (
+                          <Button
+                            variant="tonal"
+                            size="sm"
+                            onClick={() => setGrantTarget(invitation)}
+                          >
+                            <Flex gap="1" align="center">
+                              <IconKey size={14} />
+                              <Text>Complete Grant</Text>
+                            </Flex>
+                          </Button>
+                        )}
+                      {invitation.status === 'pending' && (
+                        <Button
+                          variant="text"
+                          size="sm"
+                          onClick={() => handleRevoke(invitation)}
+                        >
+                          <Flex gap="1" align="center">
+                            <IconTrash size={14} />
+                            <Text>Revoke</Text>
+                          </Flex>
+                        </Button>
+                      )}
+                    </Flex>
+                  </Table.Cell>
+                </Table.Row>
+              ))
+            )}
+          </Table.Body>
+        </Table.Root>
+      </Card>
+
       <Dialog
         open={isModalOpen}
-        onClose={() => setIsModalOpen(false)}
-        title="Add Project Collaborator"
+        onClose={() => {
+          setIsModalOpen(false);
+          setIssuedToken('');
+        }}
+        title="Invite Collaborator"
       >
-        <form onSubmit={handleCreateCollaborator}>
+        {issuedToken ? (
           <Stack gap="5">
             <Text color="muted" size="2">
-              Assign a user to a specific project with restricted credentials.
+              Share this token with the invitee over a trusted channel. It is
+              shown only once.
             </Text>
-            <Grid columns="2" gap="4">
-              <TextField.Root size="md" label="Username">
-                <TextField.Input
-                  placeholder="John Doe"
-                  value={newCollab.username}
-                  onChange={(e: ChangeEvent<HTMLInputElement>) =>
-                    setNewCollab({ ...newCollab, username: e.target.value })
-                  }
-                  required
-                />
-              </TextField.Root>
+            <Box
+              p="3"
+              style={{
+                fontFamily: 'var(--pittorica-font-code)',
+                wordBreak: 'break-all',
+                backgroundColor:
+                  'rgba(var(--pittorica-color-source-rgb), 0.05)',
+                borderRadius: 'var(--pittorica-radius-md)',
+              }}
+            >
+              {issuedToken}
+            </Box>
+            <Flex justify="end">
+              <Button
+                variant="filled"
+                size="md"
+                onClick={() => {
+                  setIsModalOpen(false);
+                  setIssuedToken('');
+                }}
+              >
+                Done
+              </Button>
+            </Flex>
+          </Stack>
+        ) : (
+          <form onSubmit={handleCreateInvitation}>
+            <Stack gap="5">
+              <Text color="muted" size="2">
+                Issue a single-use, expiring invitation bound to an email
+                address.
+              </Text>
               <TextField.Root size="md" label="Email Address">
                 <TextField.Input
                   type="email"
                   placeholder="john@example.com"
-                  value={newCollab.email}
+                  value={newInvite.email}
                   onChange={(e: ChangeEvent<HTMLInputElement>) =>
-                    setNewCollab({ ...newCollab, email: e.target.value })
+                    setNewInvite({ ...newInvite, email: e.target.value })
                   }
                   required
                 />
               </TextField.Root>
-            </Grid>
-            <TextField.Root size="md" label="Temporary Password">
+              <Grid columns="2" gap="4">
+                <TextField.Root size="md" label="Project UUID (optional)">
+                  <TextField.Input
+                    placeholder="00000000-0000-0000-0000-000000000000"
+                    value={newInvite.projectId}
+                    onChange={(e: ChangeEvent<HTMLInputElement>) =>
+                      setNewInvite({ ...newInvite, projectId: e.target.value })
+                    }
+                  />
+                </TextField.Root>
+                <TextField.Root size="md" label="Expires In (hours)">
+                  <TextField.Input
+                    type="number"
+                    min={1}
+                    max={720}
+                    value={newInvite.expiresInHours}
+                    onChange={(e: ChangeEvent<HTMLInputElement>) =>
+                      setNewInvite({
+                        ...newInvite,
+                        expiresInHours: e.target.value,
+                      })
+                    }
+                  />
+                </TextField.Root>
+              </Grid>
+              <Flex justify="end" gap="3">
+                <Button
+                  variant="text"
+                  size="md"
+                  onClick={() => setIsModalOpen(false)}
+                >
+                  Cancel
+                </Button>
+                <Button
+                  type="submit"
+                  variant="filled"
+                  size="md"
+                  disabled={creating}
+                >
+                  {creating ? 'Issuing...' : 'Create Invitation'}
+                </Button>
+              </Flex>
+            </Stack>
+          </form>
+        )}
+      </Dialog>
+
+      <Dialog
+        open={grantTarget !== null}
+        onClose={() => setGrantTarget(null)}
+        title="Complete Project Grant"
+      >
+        <form onSubmit={handleCompleteGrant}>
+          <Stack gap="5">
+            <Text color="muted" size="2">
+              Enter the Master Password to seal the project key to{' '}
+              {grantTarget?.email}'s public key.
+            </Text>
+            <TextField.Root size="md" label="Master Password">
               <TextField.Input
                 type="password"
-                placeholder="Assign a secure password"
-                value={newCollab.password}
-                onChange={(e: ChangeEvent<HTMLInputElement>) =>
-                  setNewCollab({ ...newCollab, password: e.target.value })
-                }
-                required
-              />
-            </TextField.Root>
-            <TextField.Root size="md" label="Target Project UUID">
-              <TextField.Input
-                placeholder="00000000-0000-0000-0000-000000000000"
-                value={newCollab.projectId}
+                autoFocus
+                value={masterPassword}
                 onChange={(e: ChangeEvent<HTMLInputElement>) =>
-                  setNewCollab({ ...newCollab, projectId: e.target.value })
+                  setMasterPassword(e.target.value)
                 }
                 required
               />
@@ -228,7 +512,7 @@ export default function Collaborators() {
               <Button
                 variant="text"
                 size="md"
-                onClick={() => setIsModalOpen(false)}
+                onClick={() => setGrantTarget(null)}
               >
                 Cancel
               </Button>
@@ -236,9 +520,9 @@ export default function Collaborators() {
                 type="submit"
                 variant="filled"
                 size="md"
-                disabled={creating}
+                disabled={granting}
               >
-                {creating ? 'Provisioning...' : 'Grant Access'}
+                {granting ? 'Sealing...' : 'Grant Access'}
               </Button>
             </Flex>
           </Stack>
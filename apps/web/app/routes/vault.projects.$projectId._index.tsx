@@ -36,9 +36,11 @@ import { useAuth } from '../contexts/auth-context';
 import {
   bytesToHex,
   decrypt,
-  deriveKey,
-  encrypt,
+  decryptWithAAD,
+  deriveKeyFromEncodedSalt,
+  encryptWithAAD,
   hexToBytes,
+  secretAAD,
 } from '../utils/crypto';
 
 interface Secret {
@@ -144,11 +146,28 @@ export default function SecretsManagement() {
   useEffect(() => {
     if (unlocked && projectDataKey && secrets.length > 0) {
       const decryptAll = async () => {
+        // Once the vault is upgraded, legacy secrets are not bound to their project, key and version
+        let allowLegacy = false;
+        try {
+          const statusResp = await fetch('/api/v1/status');
+          if (statusResp.ok) {
+            const status = (await statusResp.json()) as { legacy_secrets: boolean };
+            allowLegacy = status.legacy_secrets;
+          }
+        } catch (error) {
+          console.error('Failed to fetch server status', error);
+        }
+
         const newDecrypted: Record<string, string> = {};
         for (const s of secrets) {
           try {
             const ciphertext = hexToBytes(s.value);
-            const plaintext = await decrypt(projectDataKey, ciphertext);
+            const plaintext = await decryptWithAAD(
+              projectDataKey,
+              ciphertext,
+              secretAAD(projectId ?? '', s.key, s.version),
+              allowLegacy
+            );
             newDecrypted[s.id] = new TextDecoder().decode(plaintext);
           } catch {
             newDecrypted[s.id] = '[Decryption Error]';
@@ -173,9 +192,8 @@ export default function SecretsManagement() {
         throw new Error('Failed to fetch vault configuration');
       const vc = await vcResponse.json();
 
-      const salt = hexToBytes(vc.master_key_salt);
       const wrappedMK = hexToBytes(vc.wrapped_master_key);
-      const adminKEK = await deriveKey(masterPassword, salt);
+      const adminKEK = await deriveKeyFromEncodedSalt(masterPassword, vc.master_key_salt);
       const masterKey = await decrypt(adminKEK, wrappedMK);
 
       const wrappedDK = hexToBytes(project.wrapped_data_key);
@@ -210,7 +228,13 @@ export default function SecretsManagement() {
     try {
       const finalKey = newSecret.key.toUpperCase();
       const plaintext = new TextEncoder().encode(newSecret.value);
-      const ciphertext = await encrypt(projectDataKey, plaintext);
+      const current = secrets.find((s) => s.key === finalKey);
+      const version = current ? current.version + 1 : 1;
+      const ciphertext = await encryptWithAAD(
+        projectDataKey,
+        plaintext,
+        secretAAD(projectId ?? '', finalKey, version)
+      );
 
       const response = await fetch('/api/v1/secrets', {
         method: 'POST',
@@ -222,6 +246,7 @@ export default function SecretsManagement() {
           project_id: projectId,
           key: finalKey,
           value: bytesToHex(ciphertext),
+          version,
         }),
       });
 
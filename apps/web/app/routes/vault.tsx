@@ -96,7 +96,9 @@ export default function VaultLayout() {
 
       const regRes = await startRegistration(options);
 
-      await api.post('/auth/passkey/register/finish', regRes);
+      await api.post('/auth/passkey/register/finish', regRes, {
+        params: { ceremony_id: options.ceremony_id },
+      });
 
       toast({
         title: 'Passkey registered',
@@ -22,6 +22,59 @@ export async function deriveKey(password: string, salt: Uint8Array): Promise<Uin
   });
 }
 
+export interface KDFParams {
+  iterations: number;
+  memory: number; // KiB
+  parallelism: number;
+}
+
+/**
+ * Parses a salt produced by the server: either "$argon2id$v=19$m=...,t=...,p=...$<base64>"
+ * or a bare hex salt from before the parameters were encoded.
+ */
+export function parseSalt(encoded: string): { salt: Uint8Array; params: KDFParams } {
+  if (!encoded.startsWith("$")) {
+    return {
+      salt: hexToBytes(encoded),
+      params: {
+        iterations: ARGON2_PARAMS.iterations,
+        memory: ARGON2_PARAMS.memory,
+        parallelism: ARGON2_PARAMS.parallelism,
+      },
+    };
+  }
+
+  const parts = encoded.split("$");
+  const match = /^m=(\d+),t=(\d+),p=(\d+)$/.exec(parts[3] ?? "");
+  if (parts.length !== 5 || parts[1] !== "argon2id" || parts[2] !== "v=19" || !match) {
+    throw new Error("Invalid encoded salt");
+  }
+
+  const b64 = parts[4].padEnd(Math.ceil(parts[4].length / 4) * 4, "=");
+  const salt = Uint8Array.from(atob(b64), (c) => c.charCodeAt(0));
+
+  return {
+    salt,
+    params: { memory: Number(match[1]), iterations: Number(match[2]), parallelism: Number(match[3]) },
+  };
+}
+
+/**
+ * Derives a key using the salt and Argon2id parameters of an encoded salt.
+ */
+export async function deriveKeyFromEncodedSalt(password: string, encoded: string): Promise<Uint8Array> {
+  const { salt, params } = parseSalt(encoded);
+  return argon2id({
+    password,
+    salt,
+    iterations: params.iterations,
+    memorySize: params.memory,
+    parallelism: params.parallelism,
+    hashLength: ARGON2_PARAMS.hashLength,
+    outputType: "binary",
+  });
+}
+
 /**
  * Encrypts data using AES-GCM.
  * Returns Uint8Array containing [nonce (12 bytes) + ciphertext + tag (16 bytes)].
@@ -72,6 +125,281 @@ export async function decrypt(key: Uint8Array, ciphertextWithNonce: Uint8Array):
   return new Uint8Array(decrypted);
 }
 
+// Versioned envelope: "BV" | version | algorithm | key ID (8) | nonce (12) | ciphertext + tag.
+// Must stay in sync with packages/crypto/envelope.go.
+const ENVELOPE_MAGIC = [0x42, 0x56];
+const ENVELOPE_V1 = 1;
+const ALG_AES256_GCM = 1;
+const KEY_ID_LENGTH = 8;
+const ENVELOPE_PREFIX_LENGTH = 2 + 1 + 1 + KEY_ID_LENGTH;
+const ENVELOPE_HEADER_LENGTH = ENVELOPE_PREFIX_LENGTH + 12;
+
+function concatBytes(...parts: Uint8Array[]): Uint8Array {
+  const result = new Uint8Array(parts.reduce((n, p) => n + p.length, 0));
+  let offset = 0;
+  for (const part of parts) {
+    result.set(part, offset);
+    offset += part.length;
+  }
+  return result;
+}
+
+/**
+ * Returns the short, non-secret identifier of a key.
+ */
+export async function keyId(key: Uint8Array): Promise<Uint8Array> {
+  const input = concatBytes(new TextEncoder().encode("bastion/key-id/v1"), key);
+  const digest = await window.crypto.subtle.digest("SHA-256", input);
+  return new Uint8Array(digest).slice(0, KEY_ID_LENGTH);
+}
+
+/**
+ * Returns the additional data binding a secret to its project, key name and version.
+ */
+export function secretAAD(projectId: string, key: string, version: number): Uint8Array {
+  return new TextEncoder().encode(`bastion/secret/v1|${projectId}|${key}|${version}`);
+}
+
+function isEnvelope(payload: Uint8Array): boolean {
+  return (
+    payload.length > ENVELOPE_HEADER_LENGTH &&
+    payload[0] === ENVELOPE_MAGIC[0] &&
+    payload[1] === ENVELOPE_MAGIC[1]
+  );
+}
+
+/**
+ * Encrypts data in a versioned AES-GCM envelope bound to the additional data.
+ */
+export async function encryptWithAAD(
+  key: Uint8Array,
+  plaintext: Uint8Array,
+  aad: Uint8Array
+): Promise<Uint8Array> {
+  const cryptoKey = await window.crypto.subtle.importKey(
+    "raw",
+    new Uint8Array(key),
+    { name: "AES-GCM" },
+    false,
+    ["encrypt"]
+  );
+
+  const prefix = concatBytes(
+    new Uint8Array([...ENVELOPE_MAGIC, ENVELOPE_V1, ALG_AES256_GCM]),
+    await keyId(key)
+  );
+  const nonce = window.crypto.getRandomValues(new Uint8Array(12));
+  const encrypted = await window.crypto.subtle.encrypt(
+    { name: "AES-GCM", iv: nonce, additionalData: concatBytes(prefix, aad) },
+    cryptoKey,
+    new Uint8Array(plaintext)
+  );
+
+  return concatBytes(prefix, nonce, new Uint8Array(encrypted));
+}
+
+/**
+ * Decrypts a versioned envelope bound to the additional data.
+ * Legacy nonce + ciphertext payloads are still accepted, unless allowLegacy is false:
+ * they are not bound to the additional data.
+ */
+export async function decryptWithAAD(
+  key: Uint8Array,
+  payload: Uint8Array,
+  aad: Uint8Array,
+  allowLegacy = true
+): Promise<Uint8Array> {
+  if (isEnvelope(payload) && payload[2] === ENVELOPE_V1 && payload[3] === ALG_AES256_GCM) {
+    try {
+      const cryptoKey = await window.crypto.subtle.importKey(
+        "raw",
+        new Uint8Array(key),
+        { name: "AES-GCM" },
+        false,
+        ["decrypt"]
+      );
+      const prefix = payload.slice(0, ENVELOPE_PREFIX_LENGTH);
+      const decrypted = await window.crypto.subtle.decrypt(
+        {
+          name: "AES-GCM",
+          iv: payload.slice(ENVELOPE_PREFIX_LENGTH, ENVELOPE_HEADER_LENGTH),
+          additionalData: concatBytes(prefix, aad),
+        },
+        cryptoKey,
+        payload.slice(ENVELOPE_HEADER_LENGTH)
+      );
+      return new Uint8Array(decrypted);
+    } catch (error) {
+      if (!allowLegacy) throw error;
+      // A legacy payload may start with the magic bytes by chance
+      try {
+        return await decrypt(key, payload);
+      } catch {
+        throw error;
+      }
+    }
+  }
+  if (!allowLegacy) {
+    throw new Error('Legacy payloads are no longer accepted');
+  }
+  return decrypt(key, payload);
+}
+
+// Sealed box, used to wrap a key to a user's X25519 public key:
+// "BS" | version | ephemeral public key (32) | envelope.
+// Must stay in sync with packages/crypto/keypair.go.
+const SEALED_MAGIC = [0x42, 0x53];
+const SEALED_V1 = 1;
+const X25519_KEY_LENGTH = 32;
+const SEALED_HEADER_LENGTH = 2 + 1 + X25519_KEY_LENGTH;
+const SEAL_INFO = "bastion/sealed-box/v1";
+
+// Parameters for new hashes and KEKs; must match DefaultKDFParams in packages/crypto/kdf.go.
+const DEFAULT_KDF_PARAMS: KDFParams = { iterations: 3, memory: 64 * 1024, parallelism: 4 };
+
+export interface UserKeyPair {
+  public_key: string;
+  encrypted_private_key: string;
+  private_key_salt: string;
+}
+
+function base64UrlToBytes(value: string): Uint8Array {
+  const b64 = value.replace(/-/g, "+").replace(/_/g, "/");
+  return Uint8Array.from(atob(b64.padEnd(Math.ceil(b64.length / 4) * 4, "=")), (c) => c.charCodeAt(0));
+}
+
+function bytesToBase64Url(bytes: Uint8Array): string {
+  return btoa(String.fromCharCode(...bytes)).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+/**
+ * Encodes a salt with its Argon2id parameters, as read by parseSalt.
+ */
+export function encodeSalt(salt: Uint8Array, params: KDFParams = DEFAULT_KDF_PARAMS): string {
+  const b64 = btoa(String.fromCharCode(...salt)).replace(/=+$/, "");
+  return `$argon2id$v=19$m=${params.memory},t=${params.iterations},p=${params.parallelism}$${b64}`;
+}
+
+/**
+ * Hashes a password with a fresh salt. Returns the hex hash and the encoded salt.
+ */
+export async function hashPassword(password: string): Promise<{ hash: string; salt: string }> {
+  const salt = encodeSalt(window.crypto.getRandomValues(new Uint8Array(16)));
+  return { hash: bytesToHex(await deriveKeyFromEncodedSalt(password, salt)), salt };
+}
+
+export function privateKeyAAD(publicKeyHex: string): Uint8Array {
+  return new TextEncoder().encode(`bastion/private-key/v1|${publicKeyHex}`);
+}
+
+export function grantAAD(projectId: string): Uint8Array {
+  return new TextEncoder().encode(`bastion/grant/v1|${projectId}`);
+}
+
+/**
+ * Generates an X25519 keypair and returns the raw public and private keys.
+ */
+export async function generateKeyPair(): Promise<{ publicKey: Uint8Array; privateKey: Uint8Array }> {
+  const pair = (await window.crypto.subtle.generateKey({ name: "X25519" }, true, ["deriveBits"])) as CryptoKeyPair;
+  const jwk = await window.crypto.subtle.exportKey("jwk", pair.privateKey);
+  return { publicKey: base64UrlToBytes(jwk.x!), privateKey: base64UrlToBytes(jwk.d!) };
+}
+
+/**
+ * Generates a keypair for a user and encrypts the private key under their password.
+ */
+export async function createUserKeyPair(password: string): Promise<UserKeyPair> {
+  const { publicKey, privateKey } = await generateKeyPair();
+  const publicKeyHex = bytesToHex(publicKey);
+  const salt = encodeSalt(window.crypto.getRandomValues(new Uint8Array(16)));
+  const kek = await deriveKeyFromEncodedSalt(password, salt);
+
+  return {
+    public_key: publicKeyHex,
+    encrypted_private_key: bytesToHex(await encryptWithAAD(kek, privateKey, privateKeyAAD(publicKeyHex))),
+    private_key_salt: salt,
+  };
+}
+
+/**
+ * Decrypts a user's private key with their password.
+ */
+export async function unlockPrivateKey(keys: UserKeyPair, password: string): Promise<Uint8Array> {
+  const kek = await deriveKeyFromEncodedSalt(password, keys.private_key_salt);
+  return decryptWithAAD(kek, hexToBytes(keys.encrypted_private_key), privateKeyAAD(keys.public_key));
+}
+
+async function sealKey(shared: ArrayBuffer, header: Uint8Array, recipientPublicKey: Uint8Array): Promise<Uint8Array> {
+  const ikm = await window.crypto.subtle.importKey("raw", shared, "HKDF", false, ["deriveBits"]);
+  const bits = await window.crypto.subtle.deriveBits(
+    {
+      name: "HKDF",
+      hash: "SHA-256",
+      salt: concatBytes(header, recipientPublicKey),
+      info: new TextEncoder().encode(SEAL_INFO),
+    },
+    ikm,
+    256
+  );
+  return new Uint8Array(bits);
+}
+
+/**
+ * Seals data (typically a project data key) to a recipient's X25519 public key.
+ */
+export async function sealToPublicKey(
+  recipientPublicKey: Uint8Array,
+  plaintext: Uint8Array,
+  aad: Uint8Array
+): Promise<Uint8Array> {
+  const recipient = await window.crypto.subtle.importKey(
+    "raw",
+    new Uint8Array(recipientPublicKey),
+    { name: "X25519" },
+    false,
+    []
+  );
+  const ephemeral = (await window.crypto.subtle.generateKey({ name: "X25519" }, true, ["deriveBits"])) as CryptoKeyPair;
+  const shared = await window.crypto.subtle.deriveBits({ name: "X25519", public: recipient }, ephemeral.privateKey, 256);
+
+  const ephemeralPublicKey = new Uint8Array(await window.crypto.subtle.exportKey("raw", ephemeral.publicKey));
+  const header = concatBytes(new Uint8Array([...SEALED_MAGIC, SEALED_V1]), ephemeralPublicKey);
+  const key = await sealKey(shared, header, recipientPublicKey);
+
+  return concatBytes(header, await encryptWithAAD(key, plaintext, concatBytes(header, recipientPublicKey, aad)));
+}
+
+/**
+ * Opens a sealed box with the recipient's keypair.
+ */
+export async function openSealed(
+  privateKey: Uint8Array,
+  publicKey: Uint8Array,
+  sealed: Uint8Array,
+  aad: Uint8Array
+): Promise<Uint8Array> {
+  if (sealed.length <= SEALED_HEADER_LENGTH || sealed[0] !== SEALED_MAGIC[0] || sealed[1] !== SEALED_MAGIC[1]) {
+    throw new Error("Payload is not a sealed box");
+  }
+  if (sealed[2] !== SEALED_V1) {
+    throw new Error("Unsupported sealed box version");
+  }
+
+  const priv = await window.crypto.subtle.importKey(
+    "jwk",
+    { kty: "OKP", crv: "X25519", x: bytesToBase64Url(publicKey), d: bytesToBase64Url(privateKey) },
+    { name: "X25519" },
+    false,
+    ["deriveBits"]
+  );
+  const header = sealed.slice(0, SEALED_HEADER_LENGTH);
+  const ephemeral = await window.crypto.subtle.importKey("raw", header.slice(3), { name: "X25519" }, false, []);
+  const shared = await window.crypto.subtle.deriveBits({ name: "X25519", public: ephemeral }, priv, 256);
+  const key = await sealKey(shared, header, publicKey);
+
+  return decryptWithAAD(key, sealed.slice(SEALED_HEADER_LENGTH), concatBytes(header, publicKey, aad));
+}
+
 /**
  * Helper to convert hex string to Uint8Array.
  */
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type GrantAccessRequest struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	WrappedDataKey string     `json:"wrapped_data_key"`     // Data key sealed to the user's public key
+	Role           string     `json:"role,omitempty"`       // Project role, defaults to editor
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"` // Omit for a grant that never expires
+}
+
+// ListProjectAccess returns every user with access to a project.
+func (h *Handler) ListProjectAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.DB.GetProjectAccess(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.ProjectAccess{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GrantProjectAccess grants an existing user access to a project, using a data key the admin sealed
+// client-side to the user's public key. Granting again replaces the wrapped key and the role.
+func (h *Handler) GrantProjectAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var req GrantAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == uuid.Nil {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedDataKey) {
+		http.Error(w, "wrapped_data_key must be sealed to the user's public key", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.ProjectRoleEditor
+	}
+	if !auth.ValidProjectRole(req.Role) {
+		http.Error(w, "role must be viewer, editor or project-admin", http.StatusBadRequest)
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	project, err := h.DB.GetProjectByID(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if _, err := h.DB.GetUserKeyPair(r.Context(), req.UserID); err != nil {
+		http.Error(w, "User not found or has no keypair", http.StatusNotFound)
+		return
+	}
+	grantee, err := h.DB.GetUserByID(r.Context(), req.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if grantee.ClientID != nil && *grantee.ClientID != project.ClientID {
+		http.Error(w, "User is a portal account of another client", http.StatusConflict)
+		return
+	}
+
+	grantedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	if err := h.DB.GrantProjectAccess(r.Context(), req.UserID, projectID, req.WrappedDataKey, req.Role, grantedBy, req.ExpiresAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "GRANT_ACCESS", "PROJECT", projectID, map[string]interface{}{
+		"user_id":    req.UserID,
+		"role":       req.Role,
+		"granted_by": grantedBy,
+		"expires_at": req.ExpiresAt,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// RevokeProjectAccess removes a user's grant on a project. The revoked user may still hold a copy of the
+// data key, so callers should rotate it; `?rotate=true` records that a rotation was started.
+func (h *Handler) RevokeProjectAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RevokeProjectAccess(r.Context(), userID, projectID); err != nil {
+		if errors.Is(err, db.ErrAccessNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	revokedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REVOKE_ACCESS", "PROJECT", projectID, map[string]interface{}{
+		"user_id":    userID,
+		"revoked_by": revokedBy,
+		"rotate":     r.URL.Query().Get("rotate") == "true",
+		"ip":         r.RemoteAddr,
+	})
+}
+
+type GrantGroupAccessRequest struct {
+	GroupID        uuid.UUID `json:"group_id"`
+	WrappedDataKey string    `json:"wrapped_data_key"` // Data key sealed to the group's public key
+	Role           string    `json:"role,omitempty"`   // Project role, defaults to editor
+}
+
+// ListProjectGroupAccess returns every group with access to a project.
+func (h *Handler) ListProjectGroupAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.DB.GetProjectGroupAccess(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.GroupProjectAccess{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GrantGroupProjectAccess grants a group access to a project with a data key sealed to the group's public key.
+func (h *Handler) GrantGroupProjectAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var req GrantGroupAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.GroupID == uuid.Nil {
+		http.Error(w, "group_id is required", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedDataKey) {
+		http.Error(w, "wrapped_data_key must be sealed to the group's public key", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.ProjectRoleEditor
+	}
+	if !auth.ValidProjectRole(req.Role) {
+		http.Error(w, "role must be viewer, editor or project-admin", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.DB.GetProjectByID(r.Context(), projectID); err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if _, err := h.DB.GetGroup(r.Context(), req.GroupID); err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	grantedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	if err := h.DB.GrantGroupProjectAccess(r.Context(), req.GroupID, projectID, req.WrappedDataKey, req.Role, grantedBy); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "GRANT_GROUP_ACCESS", "PROJECT", projectID, map[string]interface{}{
+		"group_id":   req.GroupID,
+		"role":       req.Role,
+		"granted_by": grantedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// RevokeGroupProjectAccess removes a group's grant on a project.
+func (h *Handler) RevokeGroupProjectAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+	groupID, err := uuid.Parse(chi.URLParam(r, "group"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RevokeGroupProjectAccess(r.Context(), groupID, projectID); err != nil {
+		if errors.Is(err, db.ErrAccessNotFound) {
+			http.Error(w, "Group has no access to this project", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	revokedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REVOKE_GROUP_ACCESS", "PROJECT", projectID, map[string]interface{}{
+		"group_id":   groupID,
+		"revoked_by": revokedBy,
+		"rotate":     r.URL.Query().Get("rotate") == "true",
+		"ip":         r.RemoteAddr,
+	})
+}
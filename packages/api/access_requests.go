@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAccessRequestHours = 1
+	maxAccessRequestHours     = 24
+)
+
+// AccessRequestNotifier is told about access request events, e.g. to page the on-call admin or to tell
+// the requester that their grant is ready. Implementations must not block.
+type AccessRequestNotifier interface {
+	AccessRequested(req *models.AccessRequest)
+	AccessRequestDecided(req *models.AccessRequest)
+}
+
+// logNotifier is the default AccessRequestNotifier, writing events to the server log.
+type logNotifier struct{}
+
+func (logNotifier) AccessRequested(req *models.AccessRequest) {
+	log.Printf("Access request %s: %s asks for %s on project %s for %dh: %s",
+		req.ID, req.Username, req.Role, req.ProjectName, req.DurationHours, req.Reason)
+}
+
+func (logNotifier) AccessRequestDecided(req *models.AccessRequest) {
+	log.Printf("Access request %s by %s on project %s was %s", req.ID, req.Username, req.ProjectName, req.Status)
+}
+
+type CreateAccessRequestRequest struct {
+	ProjectID     uuid.UUID `json:"project_id"`
+	Role          string    `json:"role,omitempty"`           // Project role, defaults to editor
+	Reason        string    `json:"reason"`                   // Shown to the approving admin and kept in the audit log
+	DurationHours int       `json:"duration_hours,omitempty"` // Lifetime of the grant once approved, defaults to 1
+}
+
+type ApproveAccessRequestRequest struct {
+	WrappedDataKey string `json:"wrapped_data_key"` // Data key sealed to the requester's public key
+	Note           string `json:"note,omitempty"`
+}
+
+type DenyAccessRequestRequest struct {
+	Note string `json:"note,omitempty"`
+}
+
+// CreateAccessRequest files a request for a short-lived grant on a project.
+func (h *Handler) CreateAccessRequest(w http.ResponseWriter, r *http.Request) {
+	var req CreateAccessRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ProjectID == uuid.Nil {
+		http.Error(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.ProjectRoleEditor
+	}
+	if !auth.ValidProjectRole(req.Role) {
+		http.Error(w, "role must be viewer, editor or project-admin", http.StatusBadRequest)
+		return
+	}
+	if req.DurationHours == 0 {
+		req.DurationHours = defaultAccessRequestHours
+	}
+	if req.DurationHours < 0 || req.DurationHours > maxAccessRequestHours {
+		http.Error(w, "duration_hours must be between 1 and 24", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if userID == uuid.Nil {
+		http.Error(w, "The environment admin cannot request access", http.StatusBadRequest)
+		return
+	}
+
+	project, err := h.DB.GetProjectByID(r.Context(), req.ProjectID)
+	if err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if clientID, scoped := auth.ClientFromContext(r.Context()); scoped && clientID != project.ClientID {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if _, err := h.DB.GetUserKeyPair(r.Context(), userID); err != nil {
+		http.Error(w, "You need a keypair to request access", http.StatusConflict)
+		return
+	}
+
+	ar, err := h.DB.CreateAccessRequest(r.Context(), req.ProjectID, userID, req.Role, req.Reason, req.DurationHours)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ar)
+
+	h.DB.LogEvent(r.Context(), "REQUEST_ACCESS", "PROJECT", ar.ProjectID, map[string]interface{}{
+		"request_id":     ar.ID,
+		"user_id":        userID,
+		"role":           ar.Role,
+		"reason":         ar.Reason,
+		"duration_hours": ar.DurationHours,
+		"ip":             r.RemoteAddr,
+	})
+	h.Notifier.AccessRequested(ar)
+}
+
+// ListAccessRequests returns every access request to callers who may approve them, optionally filtered
+// by `?status=`, and only their own requests to everyone else.
+func (h *Handler) ListAccessRequests(w http.ResponseWriter, r *http.Request) {
+	var userID *uuid.UUID
+	if !auth.RoleAllows(auth.RoleFromContext(r.Context()), auth.PermManageAccess) {
+		id, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+		userID = &id
+	}
+
+	requests, err := h.DB.ListAccessRequests(r.Context(), userID, r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if requests == nil {
+		requests = []models.AccessRequest{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+// ApproveAccessRequest approves a pending request with a data key the admin sealed client-side to the
+// requester's public key. The resulting grant expires after the requested duration.
+func (h *Handler) ApproveAccessRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid access request ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ApproveAccessRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedDataKey) {
+		http.Error(w, "wrapped_data_key must be sealed to the requester's public key", http.StatusBadRequest)
+		return
+	}
+
+	decidedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	ar, err := h.DB.ApproveAccessRequest(r.Context(), id, req.WrappedDataKey, decidedBy, req.Note)
+	if err != nil {
+		if errors.Is(err, db.ErrAccessRequestNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ar)
+
+	h.DB.LogEvent(r.Context(), "APPROVE_ACCESS_REQUEST", "PROJECT", ar.ProjectID, map[string]interface{}{
+		"request_id":  ar.ID,
+		"user_id":     ar.UserID,
+		"role":        ar.Role,
+		"approved_by": decidedBy,
+		"expires_at":  ar.GrantExpiresAt,
+		"note":        ar.DecisionNote,
+		"ip":          r.RemoteAddr,
+	})
+	h.Notifier.AccessRequestDecided(ar)
+}
+
+// DenyAccessRequest denies a pending request.
+func (h *Handler) DenyAccessRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid access request ID", http.StatusBadRequest)
+		return
+	}
+
+	var req DenyAccessRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	decidedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	ar, err := h.DB.DenyAccessRequest(r.Context(), id, decidedBy, req.Note)
+	if err != nil {
+		if errors.Is(err, db.ErrAccessRequestNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ar)
+
+	h.DB.LogEvent(r.Context(), "DENY_ACCESS_REQUEST", "PROJECT", ar.ProjectID, map[string]interface{}{
+		"request_id": ar.ID,
+		"user_id":    ar.UserID,
+		"denied_by":  decidedBy,
+		"note":       ar.DecisionNote,
+		"ip":         r.RemoteAddr,
+	})
+	h.Notifier.AccessRequestDecided(ar)
+}
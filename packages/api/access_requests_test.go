@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	requested []*models.AccessRequest
+	decided   []*models.AccessRequest
+}
+
+func (n *recordingNotifier) AccessRequested(req *models.AccessRequest) {
+	n.requested = append(n.requested, req)
+}
+
+func (n *recordingNotifier) AccessRequestDecided(req *models.AccessRequest) {
+	n.decided = append(n.decided, req)
+}
+
+func withRole(r *http.Request, userID uuid.UUID, role string) *http.Request {
+	claims := jwt.MapClaims{"user_id": userID.String(), "role": role}
+	return withUser(r.WithContext(context.WithValue(r.Context(), auth.AdminContextKey, claims)), userID)
+}
+
+func TestCreateAccessRequest(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	notifier := &recordingNotifier{}
+	h.Notifier = notifier
+
+	userID := uuid.New()
+	projectID := uuid.New()
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	created := &models.AccessRequest{ID: uuid.New(), ProjectID: projectID, UserID: userID, Role: "viewer", Reason: "incident 42", DurationHours: 1, Status: models.AccessRequestPending}
+
+	mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID}, nil)
+	mockDB.On("GetUserKeyPair", mock.Anything, userID).Return(keys, nil)
+	mockDB.On("CreateAccessRequest", mock.Anything, projectID, userID, "viewer", "incident 42", 1).Return(created, nil)
+	mockDB.On("LogEvent", mock.Anything, "REQUEST_ACCESS", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(CreateAccessRequestRequest{ProjectID: projectID, Role: "viewer", Reason: "incident 42"})
+	req, _ := http.NewRequest("POST", "/api/v1/access-requests", bytes.NewBuffer(body))
+	req = withUser(req, userID)
+	rr := httptest.NewRecorder()
+
+	h.CreateAccessRequest(rr, req)
+
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.Len(t, notifier.requested, 1)
+	assert.Equal(t, created.ID, notifier.requested[0].ID)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreateAccessRequest_Validation(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	projectID := uuid.New()
+
+	cases := map[string]CreateAccessRequestRequest{
+		"missing reason": {ProjectID: projectID},
+		"too long":       {ProjectID: projectID, Reason: "deploy", DurationHours: 48},
+		"bad role":       {ProjectID: projectID, Reason: "deploy", Role: "owner"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			body, _ := json.Marshal(c)
+			req, _ := http.NewRequest("POST", "/api/v1/access-requests", bytes.NewBuffer(body))
+			req = withUser(req, uuid.New())
+			rr := httptest.NewRecorder()
+
+			h.CreateAccessRequest(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+func TestCreateAccessRequest_OtherClient(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	projectID := uuid.New()
+	mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID, ClientID: uuid.New()}, nil)
+
+	body, _ := json.Marshal(CreateAccessRequestRequest{ProjectID: projectID, Reason: "deploy"})
+	req, _ := http.NewRequest("POST", "/api/v1/access-requests", bytes.NewBuffer(body))
+	req = withClientScope(req, userID, uuid.New())
+	rr := httptest.NewRecorder()
+
+	h.CreateAccessRequest(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	mockDB.AssertNotCalled(t, "CreateAccessRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestListAccessRequests_OwnOnly(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	mockDB.On("ListAccessRequests", mock.Anything, &userID, "").Return([]models.AccessRequest{{UserID: userID}}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/v1/access-requests", nil)
+	req = withRole(req, userID, auth.RoleCollaborator)
+	rr := httptest.NewRecorder()
+
+	h.ListAccessRequests(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestListAccessRequests_Admin(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	mockDB.On("ListAccessRequests", mock.Anything, (*uuid.UUID)(nil), models.AccessRequestPending).Return(nil, nil)
+
+	req, _ := http.NewRequest("GET", "/api/v1/access-requests?status=pending", nil)
+	req = withRole(req, uuid.New(), auth.RoleAdmin)
+	rr := httptest.NewRecorder()
+
+	h.ListAccessRequests(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, "[]", rr.Body.String())
+}
+
+func TestApproveAccessRequest(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	notifier := &recordingNotifier{}
+	h.Notifier = notifier
+
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	dataKey, _ := crypto.GenerateRandomKey()
+	requestID := uuid.New()
+	projectID := uuid.New()
+	adminID := uuid.New()
+	wrapped, err := vault.WrapDataKeyForUser(keys.PublicKey, projectID, dataKey)
+	require.NoError(t, err)
+
+	expires := time.Now().Add(time.Hour)
+	approved := &models.AccessRequest{ID: requestID, ProjectID: projectID, UserID: uuid.New(), Status: models.AccessRequestApproved, GrantExpiresAt: &expires}
+	mockDB.On("ApproveAccessRequest", mock.Anything, requestID, wrapped, adminID, "ok").Return(approved, nil)
+	mockDB.On("LogEvent", mock.Anything, "APPROVE_ACCESS_REQUEST", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(ApproveAccessRequestRequest{WrappedDataKey: wrapped, Note: "ok"})
+	req, _ := http.NewRequest("POST", "/api/v1/access-requests/"+requestID.String()+"/approve", bytes.NewBuffer(body))
+	req = withUser(withURLParam(req, "id", requestID.String()), adminID)
+	rr := httptest.NewRecorder()
+
+	h.ApproveAccessRequest(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, notifier.decided, 1)
+	mockDB.AssertExpectations(t)
+}
+
+func TestApproveAccessRequest_RejectsUnsealedKey(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	requestID := uuid.New()
+
+	body, _ := json.Marshal(ApproveAccessRequestRequest{WrappedDataKey: "deadbeef"})
+	req, _ := http.NewRequest("POST", "/api/v1/access-requests/"+requestID.String()+"/approve", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", requestID.String())
+	rr := httptest.NewRecorder()
+
+	h.ApproveAccessRequest(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDenyAccessRequest_AlreadyDecided(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	requestID := uuid.New()
+	adminID := uuid.New()
+	mockDB.On("DenyAccessRequest", mock.Anything, requestID, adminID, "").Return(nil, db.ErrAccessRequestNotFound)
+
+	req, _ := http.NewRequest("POST", "/api/v1/access-requests/"+requestID.String()+"/deny", bytes.NewBufferString(`{}`))
+	req = withUser(withURLParam(req, "id", requestID.String()), adminID)
+	rr := httptest.NewRecorder()
+
+	h.DenyAccessRequest(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
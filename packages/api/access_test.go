@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListProjectAccess(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	entries := []models.ProjectAccess{{UserID: uuid.New(), ProjectID: projectID, Username: "dev"}}
+	mockDB.On("GetProjectAccess", mock.Anything, projectID).Return(entries, nil)
+
+	req, _ := http.NewRequest("GET", "/api/v1/projects/"+projectID.String()+"/access", nil)
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.ListProjectAccess(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got []models.ProjectAccess
+	json.NewDecoder(rr.Body).Decode(&got)
+	assert.Equal(t, "dev", got[0].Username)
+}
+
+func TestGrantProjectAccess(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	dataKey, _ := crypto.GenerateRandomKey()
+	projectID := uuid.New()
+	userID := uuid.New()
+	adminID := uuid.New()
+	wrapped, err := vault.WrapDataKeyForUser(keys.PublicKey, projectID, dataKey)
+	require.NoError(t, err)
+
+	mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID}, nil)
+	mockDB.On("GetUserKeyPair", mock.Anything, userID).Return(keys, nil)
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(&models.User{ID: userID}, nil)
+	mockDB.On("GrantProjectAccess", mock.Anything, userID, projectID, wrapped, "viewer", adminID, (*time.Time)(nil)).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "GRANT_ACCESS", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(GrantAccessRequest{UserID: userID, WrappedDataKey: wrapped, Role: "viewer"})
+	req, _ := http.NewRequest("POST", "/api/v1/projects/"+projectID.String()+"/access", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.GrantProjectAccess(rr, withUser(req, adminID))
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGrantProjectAccess_RequiresSealedKey(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	projectID := uuid.New()
+	body, _ := json.Marshal(GrantAccessRequest{UserID: uuid.New(), WrappedDataKey: "abcdef"})
+	req, _ := http.NewRequest("POST", "/api/v1/projects/"+projectID.String()+"/access", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.GrantProjectAccess(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRevokeProjectAccess(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	userID := uuid.New()
+	adminID := uuid.New()
+	mockDB.On("RevokeProjectAccess", mock.Anything, userID, projectID).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "REVOKE_ACCESS", "PROJECT", projectID, mock.MatchedBy(func(d map[string]interface{}) bool {
+		return d["revoked_by"] == adminID && d["rotate"] == true
+	})).Return(nil)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/projects/"+projectID.String()+"/access/"+userID.String()+"?rotate=true", nil)
+	req = withURLParam(req, "id", projectID.String())
+	req = withURLParam(req, "user", userID.String())
+	rr := httptest.NewRecorder()
+
+	h.RevokeProjectAccess(rr, withUser(req, adminID))
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRevokeProjectAccess_NotFound(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	userID := uuid.New()
+	mockDB.On("RevokeProjectAccess", mock.Anything, userID, projectID).Return(db.ErrAccessNotFound)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/projects/"+projectID.String()+"/access/"+userID.String(), nil)
+	req = withURLParam(req, "id", projectID.String())
+	req = withURLParam(req, "user", userID.String())
+	rr := httptest.NewRecorder()
+
+	h.RevokeProjectAccess(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestGrantProjectAccess_RejectsPastExpiry(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	projectID := uuid.New()
+	wrapped, _ := vault.WrapDataKeyForUser(keys.PublicKey, projectID, make([]byte, 32))
+	past := time.Now().Add(-time.Hour)
+
+	body, _ := json.Marshal(GrantAccessRequest{UserID: uuid.New(), WrappedDataKey: wrapped, ExpiresAt: &past})
+	req, _ := http.NewRequest("POST", "/api/v1/projects/"+projectID.String()+"/access", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.GrantProjectAccess(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestReapExpiredGrants(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	userID := uuid.New()
+	expiredAt := time.Now().Add(-time.Minute)
+	mockDB.On("DeleteExpiredGrants", mock.Anything).Return([]models.ProjectAccess{{UserID: userID, ProjectID: projectID, ExpiresAt: &expiredAt}}, nil)
+	mockDB.On("LogEvent", mock.Anything, "EXPIRE_ACCESS", "PROJECT", projectID, mock.MatchedBy(func(d map[string]interface{}) bool {
+		return d["user_id"] == userID
+	})).Return(nil)
+
+	n, err := h.ReapExpiredGrants(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	mockDB.AssertExpectations(t)
+}
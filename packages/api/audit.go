@@ -21,6 +21,7 @@ func (h *Handler) ListAuditLogs(w http.ResponseWriter, r *http.Request) {
 	filter := db.AuditFilter{
 		Action:     query.Get("action"),
 		TargetType: query.Get("target_type"),
+		ActorType:  query.Get("actor_type"),
 		Limit:      limit,
 	}
 
@@ -1,19 +1,15 @@
 package api
 
 import (
-	"crypto/subtle"
-	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 	"strings"
-	"time"
 
 	"github.com/dcdavidev/bastion/packages/auth"
 	"github.com/dcdavidev/bastion/packages/crypto"
 	"github.com/dcdavidev/bastion/packages/models"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type LoginRequest struct {
@@ -23,7 +19,9 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`         // Short-lived access token
+	RefreshToken string `json:"refresh_token"` // Single-use token to get the next access token
+	ExpiresIn    int    `json:"expires_in"`    // Seconds until Token expires
 }
 
 // LoginHandler handles both admin (env-based) and collaborator (db-based) logins.
@@ -34,83 +32,110 @@ func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var role string
-	var userID string
+	var role, username string
+	var userID uuid.UUID
+	var clientID *uuid.UUID
+
+	identifier := req.Username
+	if req.Email != "" {
+		identifier = req.Email
+	}
 
 	// 1. Check if it's a User Login (Database)
-	if req.Username != "" || req.Email != "" {
+	if identifier != "" {
 		var user *models.User
 		var storedHashHex, saltHex string
-		var err error
-
-		identifier := req.Username
-		if req.Email != "" {
-			identifier = req.Email
-		}
 
 		// Try Email lookup
 		if strings.Contains(identifier, "@") {
-			user, storedHashHex, saltHex, err = h.DB.GetUserByEmail(r.Context(), identifier)
+			user, storedHashHex, saltHex, _ = h.DB.GetUserByEmail(r.Context(), identifier)
 		}
 
 		// If not found by email (or not an email), try Username lookup
 		if user == nil {
-			user, storedHashHex, saltHex, err = h.DB.GetUserByUsername(r.Context(), identifier)
+			user, storedHashHex, saltHex, _ = h.DB.GetUserByUsername(r.Context(), identifier)
 		}
 
-		if err != nil || user == nil {
-			log.Printf("Login failed: identifier '%s' not found in database", identifier)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		throttleKey, throttleUser := loginThrottleKey(user, identifier)
+		if !h.checkLoginThrottle(w, r, throttleKey) {
+			log.Printf("Login refused for '%s': too many failed logins", identifier)
 			return
 		}
 
-		salt, _ := hex.DecodeString(saltHex)
-		storedHash, _ := hex.DecodeString(storedHashHex)
-		computedHash := crypto.DeriveKey([]byte(req.Password), salt)
+		// Unknown accounts and accounts without a password fail like a wrong password, after as long
+		if user == nil || storedHashHex == "" {
+			burnPasswordCheck(req.Password)
+			h.failLogin(w, r, throttleKey, throttleUser, identifier)
+			return
+		}
 
-		if subtle.ConstantTimeCompare(computedHash, storedHash) != 1 {
-			log.Printf("Login failed for user '%s': invalid password", identifier)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		ok, needsRehash := crypto.VerifyPassword(req.Password, storedHashHex, saltHex)
+		if !ok {
+			h.failLogin(w, r, throttleKey, throttleUser, identifier)
+			return
+		}
+
+		// Transparently upgrade hashes made with outdated Argon2id parameters
+		if needsRehash {
+			if hash, salt, err := crypto.HashPassword(req.Password); err == nil {
+				if err := h.DB.UpdateUserPassword(r.Context(), user.ID, hash, salt); err != nil {
+					log.Printf("Failed to rehash password for user '%s': %v", identifier, err)
+				}
+			}
+		}
+
+		// A second factor, if the user has or needs one, is asked for before any session exists
+		if h.requireSecondFactor(w, r, user) {
+			log.Printf("Login for user '%s' awaits a second factor", identifier)
 			return
 		}
 
 		role = user.Role
-		userID = user.ID.String()
+		userID = user.ID
+		clientID = user.ClientID
+		username = user.Username
 		log.Printf("Login successful: user '%s' authenticated via database", identifier)
 	} else {
 		// 2. Fallback to Admin Login (Environment Variables)
+		if !h.checkLoginThrottle(w, r, adminThrottleKey) {
+			log.Println("Login refused: too many failed admin logins")
+			return
+		}
 		if !auth.VerifyAdmin(req.Password) {
-			log.Println("Login failed: admin fallback unauthorized")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			h.failLogin(w, r, adminThrottleKey, nil, "")
+			return
+		}
+		// It cannot enroll a second factor, so it may not log in once admins must have one
+		required, err := h.DB.RoleRequiresMFA(r.Context(), auth.RoleAdmin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if required {
+			log.Println("Login refused: the environment admin cannot satisfy the second-factor policy")
+			http.Error(w, "Admins must use a second factor, which the environment admin cannot. Log in with a user account", http.StatusForbidden)
 			return
 		}
 		log.Println("Login successful: admin fallback used")
-		role = "ADMIN"
-		userID = "00000000-0000-0000-0000-000000000000" // Reserved Admin ID
-	}
-
-	// Generate JWT
-	secret := os.Getenv("BASTION_JWT_SECRET")
-	if secret == "" {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		role = auth.RoleAdmin
+		userID = uuid.Nil // Reserved Admin ID
+		username = "admin"
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"role":    role,
-		"admin":   role == "ADMIN",
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
+	h.DB.ClearLoginThrottle(r.Context(), userThrottleKey(userID))
+	h.startSession(w, r, userID, username, role, clientID)
+}
 
-	tokenString, err := token.SignedString([]byte(secret))
-	if err != nil {
-		http.Error(w, "Could not generate token", http.StatusInternalServerError)
-		return
+// failLogin counts a failed login and answers it. The answer and the log line are the same whether or not
+// the account exists.
+func (h *Handler) failLogin(w http.ResponseWriter, r *http.Request, throttleKey string, userID *uuid.UUID, identifier string) {
+	if identifier == "" {
+		log.Println("Login failed: invalid admin credentials")
+	} else {
+		log.Printf("Login failed for '%s': invalid credentials", identifier)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(LoginResponse{Token: tokenString})
+	h.recordLoginFailure(r, throttleKey, userID, identifier)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
 }
 
 // GetVaultConfigHandler returns the public vault configuration needed for client-side decryption.
@@ -0,0 +1,414 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultBreakGlassHours = 1
+	maxBreakGlassHours     = 4
+	minJustificationLength = 10
+	breakGlassAuditTarget  = "BREAK_GLASS" // Every break-glass event shares this target type, so ?target_type=BREAK_GLASS lists them all
+)
+
+// BreakGlassAlerter is told immediately when someone breaks the glass, e.g. to page the security team.
+// Implementations must not block.
+type BreakGlassAlerter interface {
+	BreakGlassUsed(session *models.BreakGlassSession)
+}
+
+// logAlerter is the default BreakGlassAlerter, writing alerts to the server log.
+type logAlerter struct{}
+
+func (logAlerter) BreakGlassUsed(s *models.BreakGlassSession) {
+	log.Printf("ALERT: break-glass access to project %s by %s until %s: %s",
+		s.ProjectName, s.Username, s.ExpiresAt.Format(time.RFC3339), s.Justification)
+}
+
+type CreateBreakGlassEscrowRequest struct {
+	PublicKey        string `json:"public_key"`
+	WrappedEscrowKey string `json:"wrapped_escrow_key"` // Escrow private key sealed to the creator's public key
+}
+
+type AddBreakGlassResponderRequest struct {
+	UserID           uuid.UUID `json:"user_id"`
+	WrappedEscrowKey string    `json:"wrapped_escrow_key"` // Escrow private key sealed to the responder's public key
+}
+
+type ArmBreakGlassRequest struct {
+	WrappedDataKey string `json:"wrapped_data_key"` // Project data key sealed to the escrow public key
+}
+
+type BreakGlassRequest struct {
+	ProjectID     uuid.UUID `json:"project_id"`
+	Justification string    `json:"justification"`
+	DurationHours int       `json:"duration_hours,omitempty"` // Defaults to 1, at most 4
+}
+
+type AcknowledgeBreakGlassRequest struct {
+	Note string `json:"note,omitempty"`
+}
+
+// GetBreakGlassEscrow returns the escrow public key, which project keys are sealed to when arming.
+func (h *Handler) GetBreakGlassEscrow(w http.ResponseWriter, r *http.Request) {
+	escrow, err := h.DB.GetBreakGlassEscrow(r.Context())
+	if err != nil {
+		if errors.Is(err, db.ErrNoBreakGlassEscrow) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(escrow)
+}
+
+// CreateBreakGlassEscrow sets up the escrow from a keypair generated client-side. The creator becomes
+// its first responder.
+func (h *Handler) CreateBreakGlassEscrow(w http.ResponseWriter, r *http.Request) {
+	var req CreateBreakGlassEscrowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if publicKey, err := hex.DecodeString(req.PublicKey); err != nil || len(publicKey) != crypto.X25519KeyLen {
+		http.Error(w, crypto.ErrInvalidPublicKey.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedEscrowKey) {
+		http.Error(w, "wrapped_escrow_key must be sealed to your public key", http.StatusBadRequest)
+		return
+	}
+
+	createdBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if _, err := h.DB.GetUserKeyPair(r.Context(), createdBy); err != nil {
+		http.Error(w, "You need a keypair to set up the escrow", http.StatusConflict)
+		return
+	}
+
+	escrow, err := h.DB.CreateBreakGlassEscrow(r.Context(), req.PublicKey, createdBy, req.WrappedEscrowKey)
+	if err != nil {
+		if errors.Is(err, db.ErrBreakGlassEscrowExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(escrow)
+
+	h.DB.LogEvent(r.Context(), "CREATE_BREAK_GLASS_ESCROW", breakGlassAuditTarget, uuid.Nil, map[string]interface{}{
+		"created_by": createdBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// ListBreakGlassResponders returns the users who can break the glass.
+func (h *Handler) ListBreakGlassResponders(w http.ResponseWriter, r *http.Request) {
+	responders, err := h.DB.ListBreakGlassResponders(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if responders == nil {
+		responders = []models.BreakGlassResponder{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responders)
+}
+
+// GetMyBreakGlassKey returns the escrow private key sealed to the authenticated responder.
+func (h *Handler) GetMyBreakGlassKey(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	responder, err := h.DB.GetBreakGlassResponder(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "You are not a break-glass responder", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responder)
+}
+
+// AddBreakGlassResponder lets a user break the glass. Only responders hold the escrow key, so the caller must be one.
+func (h *Handler) AddBreakGlassResponder(w http.ResponseWriter, r *http.Request) {
+	var req AddBreakGlassResponderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == uuid.Nil {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedEscrowKey) {
+		http.Error(w, "wrapped_escrow_key must be sealed to the responder's public key", http.StatusBadRequest)
+		return
+	}
+
+	addedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if _, err := h.DB.GetBreakGlassResponder(r.Context(), addedBy); err != nil {
+		http.Error(w, "Only break-glass responders can add responders", http.StatusForbidden)
+		return
+	}
+	if _, err := h.DB.GetUserKeyPair(r.Context(), req.UserID); err != nil {
+		http.Error(w, "User not found or has no keypair", http.StatusNotFound)
+		return
+	}
+	user, err := h.DB.GetUserByID(r.Context(), req.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.ClientID != nil {
+		http.Error(w, "Client portal accounts cannot be break-glass responders", http.StatusConflict)
+		return
+	}
+
+	if err := h.DB.AddBreakGlassResponder(r.Context(), req.UserID, req.WrappedEscrowKey, addedBy); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "ADD_BREAK_GLASS_RESPONDER", breakGlassAuditTarget, uuid.Nil, map[string]interface{}{
+		"user_id":  req.UserID,
+		"added_by": addedBy,
+		"ip":       r.RemoteAddr,
+	})
+}
+
+// RemoveBreakGlassResponder removes a responder. Projects armed before keep their escrowed keys, so
+// consider rotating the projects the responder could have opened.
+func (h *Handler) RemoveBreakGlassResponder(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RemoveBreakGlassResponder(r.Context(), userID); err != nil {
+		if errors.Is(err, db.ErrNotBreakGlassResponder) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	removedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REMOVE_BREAK_GLASS_RESPONDER", breakGlassAuditTarget, uuid.Nil, map[string]interface{}{
+		"user_id":    userID,
+		"removed_by": removedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// ArmBreakGlass stores a project's data key sealed to the escrow, making the project reachable by responders.
+func (h *Handler) ArmBreakGlass(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ArmBreakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedDataKey) {
+		http.Error(w, "wrapped_data_key must be sealed to the escrow public key", http.StatusBadRequest)
+		return
+	}
+
+	armedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if err := h.DB.ArmBreakGlass(r.Context(), projectID, req.WrappedDataKey, armedBy); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "ARM_BREAK_GLASS", "PROJECT", projectID, map[string]interface{}{
+		"armed_by": armedBy,
+		"ip":       r.RemoteAddr,
+	})
+}
+
+// DisarmBreakGlass deletes a project's escrowed data key.
+func (h *Handler) DisarmBreakGlass(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.DisarmBreakGlass(r.Context(), projectID); err != nil {
+		if errors.Is(err, db.ErrBreakGlassNotArmed) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	disarmedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "DISARM_BREAK_GLASS", "PROJECT", projectID, map[string]interface{}{
+		"disarmed_by": disarmedBy,
+		"ip":          r.RemoteAddr,
+	})
+}
+
+// ListArmedProjects returns the IDs of the projects armed for break-glass access.
+func (h *Handler) ListArmedProjects(w http.ResponseWriter, r *http.Request) {
+	ids, err := h.DB.ListArmedProjects(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ids == nil {
+		ids = []uuid.UUID{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
+
+// BreakGlass opens a time-limited, read-only session on an armed project for a responder with a
+// justification. The escrowed key is then served by GetProjectKey until the session expires.
+func (h *Handler) BreakGlass(w http.ResponseWriter, r *http.Request) {
+	var req BreakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ProjectID == uuid.Nil {
+		http.Error(w, "project_id is required", http.StatusBadRequest)
+		return
+	}
+	req.Justification = strings.TrimSpace(req.Justification)
+	if len(req.Justification) < minJustificationLength {
+		http.Error(w, "a justification of at least 10 characters is required", http.StatusBadRequest)
+		return
+	}
+	if req.DurationHours == 0 {
+		req.DurationHours = defaultBreakGlassHours
+	}
+	if req.DurationHours < 0 || req.DurationHours > maxBreakGlassHours {
+		http.Error(w, "duration_hours must be between 1 and 4", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	expiresAt := time.Now().Add(time.Duration(req.DurationHours) * time.Hour)
+	session, err := h.DB.StartBreakGlassSession(r.Context(), req.ProjectID, userID, req.Justification, expiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotBreakGlassResponder):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, db.ErrBreakGlassNotArmed):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+
+	h.DB.LogEvent(r.Context(), "BREAK_GLASS", breakGlassAuditTarget, session.ID, map[string]interface{}{
+		"project_id":    session.ProjectID,
+		"user_id":       userID,
+		"justification": session.Justification,
+		"expires_at":    session.ExpiresAt,
+		"alert":         true,
+		"ip":            r.RemoteAddr,
+	})
+	h.Alerter.BreakGlassUsed(session)
+}
+
+// ListBreakGlassSessions returns break-glass sessions, only the unacknowledged ones with `?unacknowledged=true`.
+func (h *Handler) ListBreakGlassSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.DB.ListBreakGlassSessions(r.Context(), r.URL.Query().Get("unacknowledged") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []models.BreakGlassSession{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// AcknowledgeBreakGlassSession records an admin's review of a break-glass session.
+func (h *Handler) AcknowledgeBreakGlassSession(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AcknowledgeBreakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	acknowledgedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	session, err := h.DB.AcknowledgeBreakGlassSession(r.Context(), id, acknowledgedBy, req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrSelfAcknowledgment):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			h.DB.LogEvent(r.Context(), "SELF_ACKNOWLEDGMENT_DENIED", breakGlassAuditTarget, id, map[string]interface{}{
+				"user_id": acknowledgedBy,
+				"ip":      r.RemoteAddr,
+			})
+		case errors.Is(err, db.ErrBreakGlassSessionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+
+	h.DB.LogEvent(r.Context(), "ACKNOWLEDGE_BREAK_GLASS", breakGlassAuditTarget, id, map[string]interface{}{
+		"project_id":      session.ProjectID,
+		"user_id":         session.UserID,
+		"acknowledged_by": acknowledgedBy,
+		"note":            req.Note,
+		"ip":              r.RemoteAddr,
+	})
+}
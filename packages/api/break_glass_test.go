@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingAlerter struct {
+	sessions []*models.BreakGlassSession
+}
+
+func (a *recordingAlerter) BreakGlassUsed(s *models.BreakGlassSession) {
+	a.sessions = append(a.sessions, s)
+}
+
+func TestBreakGlass(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	alerter := &recordingAlerter{}
+	h.Alerter = alerter
+
+	userID := uuid.New()
+	projectID := uuid.New()
+	justification := "prod outage, on-call lead unreachable"
+	session := &models.BreakGlassSession{ID: uuid.New(), ProjectID: projectID, UserID: userID, Justification: justification, ExpiresAt: time.Now().Add(time.Hour)}
+
+	mockDB.On("StartBreakGlassSession", mock.Anything, projectID, userID, justification, mock.AnythingOfType("time.Time")).Return(session, nil)
+	mockDB.On("LogEvent", mock.Anything, "BREAK_GLASS", "BREAK_GLASS", session.ID, mock.MatchedBy(func(m map[string]interface{}) bool {
+		return m["alert"] == true && m["justification"] == justification
+	})).Return(nil)
+
+	body, _ := json.Marshal(BreakGlassRequest{ProjectID: projectID, Justification: "  " + justification + " "})
+	req, _ := http.NewRequest("POST", "/api/v1/break-glass", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.BreakGlass(rr, withUser(req, userID))
+
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.Len(t, alerter.sessions, 1)
+	assert.Equal(t, session.ID, alerter.sessions[0].ID)
+	mockDB.AssertExpectations(t)
+}
+
+func TestBreakGlass_Validation(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	projectID := uuid.New()
+
+	cases := map[string]BreakGlassRequest{
+		"missing project":       {Justification: "prod outage, on-call lead unreachable"},
+		"short justification":   {ProjectID: projectID, Justification: "   urgent   "},
+		"duration over the cap": {ProjectID: projectID, Justification: "prod outage, on-call lead unreachable", DurationHours: 8},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			body, _ := json.Marshal(c)
+			req, _ := http.NewRequest("POST", "/api/v1/break-glass", bytes.NewBuffer(body))
+			rr := httptest.NewRecorder()
+
+			h.BreakGlass(rr, withUser(req, uuid.New()))
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+func TestBreakGlass_NotResponder(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	alerter := &recordingAlerter{}
+	h.Alerter = alerter
+
+	userID := uuid.New()
+	projectID := uuid.New()
+	mockDB.On("StartBreakGlassSession", mock.Anything, projectID, userID, mock.Anything, mock.Anything).Return(nil, db.ErrNotBreakGlassResponder)
+
+	body, _ := json.Marshal(BreakGlassRequest{ProjectID: projectID, Justification: "prod outage, on-call lead unreachable"})
+	req, _ := http.NewRequest("POST", "/api/v1/break-glass", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.BreakGlass(rr, withUser(req, userID))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Empty(t, alerter.sessions)
+	mockDB.AssertNotCalled(t, "LogEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAddBreakGlassResponder_RequiresResponder(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	callerID := uuid.New()
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	escrowPub, escrowPriv, err := vault.NewGroupKeyPair()
+	require.NoError(t, err)
+	wrapped, err := vault.WrapGroupKeyForMember(keys.PublicKey, escrowPub, escrowPriv)
+	require.NoError(t, err)
+	mockDB.On("GetBreakGlassResponder", mock.Anything, callerID).Return(nil, db.ErrNotBreakGlassResponder)
+
+	body, _ := json.Marshal(AddBreakGlassResponderRequest{UserID: uuid.New(), WrappedEscrowKey: wrapped})
+	req, _ := http.NewRequest("POST", "/api/v1/break-glass/responders", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.AddBreakGlassResponder(rr, withUser(req, callerID))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockDB.AssertNotCalled(t, "AddBreakGlassResponder", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAcknowledgeBreakGlassSession(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	id := uuid.New()
+	now := time.Now()
+	session := &models.BreakGlassSession{ID: id, ProjectID: uuid.New(), UserID: uuid.New(), AcknowledgedBy: &adminID, AcknowledgedAt: &now}
+
+	mockDB.On("AcknowledgeBreakGlassSession", mock.Anything, id, adminID, "confirmed with incident 17").Return(session, nil)
+	mockDB.On("LogEvent", mock.Anything, "ACKNOWLEDGE_BREAK_GLASS", "BREAK_GLASS", id, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(AcknowledgeBreakGlassRequest{Note: "confirmed with incident 17"})
+	req, _ := http.NewRequest("POST", "/api/v1/break-glass/sessions/"+id.String()+"/acknowledge", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", id.String())
+	rr := httptest.NewRecorder()
+
+	h.AcknowledgeBreakGlassSession(rr, withUser(req, adminID))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAcknowledgeBreakGlassSession_Self(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	responderID := uuid.New()
+	id := uuid.New()
+
+	mockDB.On("AcknowledgeBreakGlassSession", mock.Anything, id, responderID, "all fine").Return(nil, db.ErrSelfAcknowledgment)
+	mockDB.On("LogEvent", mock.Anything, "SELF_ACKNOWLEDGMENT_DENIED", "BREAK_GLASS", id, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(AcknowledgeBreakGlassRequest{Note: "all fine"})
+	req, _ := http.NewRequest("POST", "/api/v1/break-glass/sessions/"+id.String()+"/acknowledge", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", id.String())
+	rr := httptest.NewRecorder()
+
+	// The responder who broke the glass cannot sign off on their own session
+	h.AcknowledgeBreakGlassSession(rr, withUser(req, responderID))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "SELF_ACKNOWLEDGMENT_DENIED", "BREAK_GLASS", id, mock.Anything)
+	mockDB.AssertNotCalled(t, "LogEvent", mock.Anything, "ACKNOWLEDGE_BREAK_GLASS", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetProjectKey_FallsBackToBreakGlass(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	userID := uuid.New()
+	escrowKey := &models.GroupProjectKey{GroupPublicKey: "escrow-pub", WrappedGroupKey: "wek", WrappedDataKey: "wdk"}
+
+	mockDB.On("GetProjectKeyForUser", mock.Anything, projectID, userID, false).Return("", errors.New("no rows"))
+	mockDB.On("GetGroupProjectKeyForUser", mock.Anything, projectID, userID).Return(nil, errors.New("no rows"))
+	mockDB.On("GetBreakGlassProjectKey", mock.Anything, projectID, userID).Return(escrowKey, nil)
+
+	req, _ := http.NewRequest("GET", "/api/v1/projects/"+projectID.String()+"/key", nil)
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.GetProjectKey(rr, withUser(req, userID))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got models.GroupProjectKey
+	json.NewDecoder(rr.Body).Decode(&got)
+	assert.Equal(t, *escrowKey, got)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// ceremonyTTL is how long a passkey registration or login may take between its begin and finish requests.
+const ceremonyTTL = 5 * time.Minute
+
+// Kinds of passkey ceremonies.
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+// CeremonyStore keeps passkey ceremonies between their begin and finish requests. Take returns a ceremony
+// only once, and fails with db.ErrCeremonyNotFound if it is unknown, of another kind or expired.
+type CeremonyStore interface {
+	Save(ctx context.Context, c *models.WebAuthnCeremony) error
+	Take(ctx context.Context, id, kind string) (*models.WebAuthnCeremony, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// PostgresCeremonyStore keeps ceremonies in the database, so any server replica can finish them.
+type PostgresCeremonyStore struct {
+	DB db.Database
+}
+
+// NewPostgresCeremonyStore returns a store keeping ceremonies in database.
+func NewPostgresCeremonyStore(database db.Database) *PostgresCeremonyStore {
+	return &PostgresCeremonyStore{DB: database}
+}
+
+func (s *PostgresCeremonyStore) Save(ctx context.Context, c *models.WebAuthnCeremony) error {
+	return s.DB.CreateWebAuthnCeremony(ctx, c)
+}
+
+func (s *PostgresCeremonyStore) Take(ctx context.Context, id, kind string) (*models.WebAuthnCeremony, error) {
+	return s.DB.TakeWebAuthnCeremony(ctx, id, kind)
+}
+
+func (s *PostgresCeremonyStore) DeleteExpired(ctx context.Context) (int64, error) {
+	return s.DB.DeleteExpiredWebAuthnCeremonies(ctx)
+}
+
+// MemoryCeremonyStore keeps ceremonies in the server's memory. It suits a single server; with replicas, a
+// ceremony must finish on the one that began it.
+type MemoryCeremonyStore struct {
+	mu         sync.Mutex
+	ceremonies map[string]models.WebAuthnCeremony
+	now        func() time.Time
+}
+
+// NewMemoryCeremonyStore returns an empty in-memory store.
+func NewMemoryCeremonyStore() *MemoryCeremonyStore {
+	return &MemoryCeremonyStore{ceremonies: make(map[string]models.WebAuthnCeremony), now: time.Now}
+}
+
+func (s *MemoryCeremonyStore) Save(ctx context.Context, c *models.WebAuthnCeremony) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ceremonies[c.ID] = *c
+	return nil
+}
+
+func (s *MemoryCeremonyStore) Take(ctx context.Context, id, kind string) (*models.WebAuthnCeremony, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.ceremonies[id]
+	if !ok || c.Kind != kind {
+		return nil, db.ErrCeremonyNotFound
+	}
+	delete(s.ceremonies, id)
+	if !c.ExpiresAt.After(s.now()) {
+		return nil, db.ErrCeremonyNotFound
+	}
+	return &c, nil
+}
+
+func (s *MemoryCeremonyStore) DeleteExpired(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	now := s.now()
+	for id, c := range s.ceremonies {
+		if !c.ExpiresAt.After(now) {
+			delete(s.ceremonies, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// beginCeremony stores the WebAuthn session of a new ceremony under a random ID, for the client to send
+// back when finishing it.
+func (h *Handler) beginCeremony(ctx context.Context, kind string, userID *uuid.UUID, session *webauthn.SessionData) (string, error) {
+	id, err := crypto.GenerateToken("")
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.Ceremonies.Save(ctx, &models.WebAuthnCeremony{
+		ID:          id,
+		Kind:        kind,
+		UserID:      userID,
+		SessionData: data,
+		ExpiresAt:   time.Now().Add(ceremonyTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// finishCeremony takes a ceremony from the store and returns it with its WebAuthn session.
+func (h *Handler) finishCeremony(ctx context.Context, id, kind string) (*models.WebAuthnCeremony, *webauthn.SessionData, error) {
+	if id == "" {
+		return nil, nil, db.ErrCeremonyNotFound
+	}
+	c, err := h.Ceremonies.Take(ctx, id, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(c.SessionData, &session); err != nil {
+		return nil, nil, err
+	}
+	return c, &session, nil
+}
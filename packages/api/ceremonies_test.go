@@ -0,0 +1,234 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCeremonyStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryCeremonyStore()
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Save(ctx, &models.WebAuthnCeremony{ID: "a", Kind: CeremonyLogin, ExpiresAt: now.Add(ceremonyTTL)}))
+
+	// A ceremony of another kind is not found, and stays for the right one
+	_, err := s.Take(ctx, "a", CeremonyRegistration)
+	assert.ErrorIs(t, err, db.ErrCeremonyNotFound)
+
+	c, err := s.Take(ctx, "a", CeremonyLogin)
+	require.NoError(t, err)
+	assert.Equal(t, "a", c.ID)
+
+	// Each ceremony can be finished once
+	_, err = s.Take(ctx, "a", CeremonyLogin)
+	assert.ErrorIs(t, err, db.ErrCeremonyNotFound)
+
+	// Expired ceremonies are not returned, and are removed by DeleteExpired
+	require.NoError(t, s.Save(ctx, &models.WebAuthnCeremony{ID: "b", Kind: CeremonyLogin, ExpiresAt: now.Add(ceremonyTTL)}))
+	require.NoError(t, s.Save(ctx, &models.WebAuthnCeremony{ID: "c", Kind: CeremonyLogin, ExpiresAt: now.Add(ceremonyTTL)}))
+	now = now.Add(ceremonyTTL)
+	_, err = s.Take(ctx, "b", CeremonyLogin)
+	assert.ErrorIs(t, err, db.ErrCeremonyNotFound)
+
+	n, err := s.DeleteExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestCeremony_RoundTrip(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	h.Ceremonies = NewMemoryCeremonyStore()
+	userID := uuid.New()
+
+	session := &webauthn.SessionData{Challenge: "challenge", UserID: userID[:]}
+	id, err := h.beginCeremony(context.Background(), CeremonyRegistration, &userID, session)
+	require.NoError(t, err)
+	assert.NotEmpty(t, id)
+
+	c, got, err := h.finishCeremony(context.Background(), id, CeremonyRegistration)
+	require.NoError(t, err)
+	assert.Equal(t, userID, *c.UserID)
+	assert.Equal(t, "challenge", got.Challenge)
+
+	_, _, err = h.finishCeremony(context.Background(), "", CeremonyRegistration)
+	assert.ErrorIs(t, err, db.ErrCeremonyNotFound)
+}
+
+func TestPasskeyLoginFinish_UnknownCeremony(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	mockDB.On("TakeWebAuthnCeremony", mock.Anything, "forged", CeremonyLogin).Return(nil, db.ErrCeremonyNotFound)
+
+	rr := httptest.NewRecorder()
+	h.PasskeyLoginFinish(rr, httptest.NewRequest("POST", "/api/v1/auth/passkey/login/finish?ceremony_id=forged", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockDB.AssertNotCalled(t, "GetUserByID", mock.Anything, mock.Anything)
+}
+
+func TestPasskeyRegisterFinish_OtherUsersCeremony(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	h.Ceremonies = NewMemoryCeremonyStore()
+
+	alice, mallory := uuid.New(), uuid.New()
+	id, err := h.beginCeremony(context.Background(), CeremonyRegistration, &alice, &webauthn.SessionData{Challenge: "challenge"})
+	require.NoError(t, err)
+
+	// A registration begun by alice cannot add mallory's authenticator to any account
+	rr := httptest.NewRecorder()
+	h.PasskeyRegisterFinish(rr, withUser(httptest.NewRequest("POST", "/api/v1/auth/passkey/register/finish?ceremony_id="+id, nil), mallory))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestPasskeyLoginBegin_Usernameless(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	h.Ceremonies = NewMemoryCeremonyStore()
+
+	rr := httptest.NewRecorder()
+	h.PasskeyLoginBegin(rr, httptest.NewRequest("GET", "/api/v1/auth/passkey/login/begin", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var options PasskeyLoginOptions
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&options))
+	assert.NotEmpty(t, options.Response.Challenge)
+	assert.Empty(t, options.Response.AllowedCredentials)
+
+	// The ceremony belongs to no user until the authenticator names one
+	c, _, err := h.finishCeremony(context.Background(), options.CeremonyID, CeremonyLogin)
+	require.NoError(t, err)
+	assert.Nil(t, c.UserID)
+}
+
+func TestPasskeyLoginBegin_Email(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	h.Ceremonies = NewMemoryCeremonyStore()
+
+	user := &models.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com"}
+	creds := []models.WebAuthnCredential{{ID: []byte{1}, Name: "Security key"}}
+	mockDB.On("GetUserByEmail", mock.Anything, "alice@example.com").Return(user, "", "", nil)
+	mockDB.On("GetWebAuthnCredentials", mock.Anything, user.ID).Return(creds, nil)
+
+	// Passkeys registered before usernameless login are offered to the authenticator by ID
+	rr := httptest.NewRecorder()
+	h.PasskeyLoginBegin(rr, httptest.NewRequest("GET", "/api/v1/auth/passkey/login/begin?email=alice@example.com", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var options PasskeyLoginOptions
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&options))
+	require.Len(t, options.Response.AllowedCredentials, 1)
+
+	c, _, err := h.finishCeremony(context.Background(), options.CeremonyID, CeremonyLogin)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, *c.UserID)
+}
+
+func TestPasskeyLoginBegin_UnknownEmail(t *testing.T) {
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	h.Ceremonies = NewMemoryCeremonyStore()
+
+	user := &models.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com"}
+	creds := []models.WebAuthnCredential{{ID: bytes.Repeat([]byte{1}, 32), Name: "Laptop", Transport: []string{"hybrid", "internal"}}}
+	mockDB.On("GetUserByEmail", mock.Anything, "alice@example.com").Return(user, "", "", nil)
+	mockDB.On("GetWebAuthnCredentials", mock.Anything, user.ID).Return(creds, nil)
+	mockDB.On("GetUserByEmail", mock.Anything, "mallory@example.com").Return(nil, "", "", pgx.ErrNoRows)
+
+	begin := func(email string) (int, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		h.PasskeyLoginBegin(rr, httptest.NewRequest("GET", "/api/v1/auth/passkey/login/begin?email="+email, nil))
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		return rr.Code, body
+	}
+	// shape replaces every value with its type, keeping the keys, so only the structure is compared
+	var shape func(v interface{}) interface{}
+	shape = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			res := make(map[string]interface{}, len(v))
+			for k, e := range v {
+				res[k] = shape(e)
+			}
+			return res
+		case []interface{}:
+			res := make([]interface{}, len(v))
+			for i, e := range v {
+				res[i] = shape(e)
+			}
+			return res
+		default:
+			return fmt.Sprintf("%T", v)
+		}
+	}
+
+	knownCode, known := begin("alice@example.com")
+	unknownCode, unknown := begin("mallory@example.com")
+
+	// An email without an account cannot be told from one with passkeys
+	assert.Equal(t, http.StatusOK, knownCode)
+	assert.Equal(t, knownCode, unknownCode)
+	assert.Equal(t, shape(known), shape(unknown))
+
+	// The decoy credential stays the same between attempts, like a real one
+	_, again := begin("mallory@example.com")
+	allowed := func(body map[string]interface{}) interface{} {
+		return body["publicKey"].(map[string]interface{})["allowCredentials"]
+	}
+	assert.Equal(t, allowed(unknown), allowed(again))
+
+	// The decoy ceremony belongs to no user, so it cannot log anyone in
+	c, _, err := h.finishCeremony(context.Background(), unknown["ceremony_id"].(string), CeremonyLogin)
+	require.NoError(t, err)
+	assert.Nil(t, c.UserID)
+}
+
+func TestDiscoverableUser(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	user := &models.User{ID: uuid.New(), Username: "alice"}
+	creds := []models.WebAuthnCredential{{ID: []byte{1}, Name: "Laptop"}}
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+	mockDB.On("GetWebAuthnCredentials", mock.Anything, user.ID).Return(creds, nil)
+	unknown := uuid.New()
+	mockDB.On("GetUserByID", mock.Anything, unknown).Return(nil, pgx.ErrNoRows)
+
+	lookup := h.discoverableUser(context.Background())
+
+	found, err := lookup([]byte{1}, user.ID[:])
+	require.NoError(t, err)
+	assert.Equal(t, user, found.(*WebAuthnUser).User)
+	assert.Len(t, found.WebAuthnCredentials(), 1)
+
+	_, err = lookup([]byte{1}, unknown[:])
+	assert.Error(t, err)
+
+	// The environment admin has no passkeys, and handles that are not user IDs name nobody
+	_, err = lookup([]byte{1}, uuid.Nil[:])
+	assert.Error(t, err)
+	_, err = lookup([]byte{1}, []byte("alice"))
+	assert.Error(t, err)
+	mockDB.AssertNumberOfCalls(t, "GetUserByID", 2)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type SetProjectProtectionRequest struct {
+	Protected bool `json:"protected"`
+}
+
+type CreateChangeSetRequest struct {
+	ProjectID   uuid.UUID                `json:"project_id"`
+	Description string                   `json:"description"`
+	Secrets     []models.ChangeSetSecret `json:"secrets"`
+}
+
+type DecideChangeSetRequest struct {
+	Note string `json:"note,omitempty"`
+}
+
+// SetProjectProtection turns two-person approval for a project's writes on or off.
+func (h *Handler) SetProjectProtection(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetProjectProtectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.SetProjectProtected(r.Context(), projectID, req.Protected); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	action := "PROTECT_PROJECT"
+	if !req.Protected {
+		action = "UNPROTECT_PROJECT"
+	}
+	changedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), action, "PROJECT", projectID, map[string]interface{}{
+		"changed_by": changedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// ChangeSetProject resolves the project of the change set named by the "id" route parameter, for
+// project permission checks.
+func (h *Handler) ChangeSetProject(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	cs, err := h.DB.GetChangeSet(r.Context(), id)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return cs.ProjectID, nil
+}
+
+// CreateChangeSet stages secret writes to a protected project for approval by another user.
+func (h *Handler) CreateChangeSet(w http.ResponseWriter, r *http.Request) {
+	var req CreateChangeSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ProjectID == uuid.Nil || len(req.Secrets) == 0 {
+		http.Error(w, "project_id and secrets are required", http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[string]bool, len(req.Secrets))
+	for _, s := range req.Secrets {
+		if s.Key == "" || s.Value == "" || s.Version <= 0 {
+			http.Error(w, "every secret needs a key, a value and the version its ciphertext is bound to", http.StatusBadRequest)
+			return
+		}
+		if seen[s.Key] {
+			http.Error(w, "a change set may hold only one version per key", http.StatusBadRequest)
+			return
+		}
+		seen[s.Key] = true
+	}
+
+	project, err := h.DB.GetProjectByID(r.Context(), req.ProjectID)
+	if err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !project.Protected {
+		http.Error(w, "Project is not protected, write its secrets directly", http.StatusConflict)
+		return
+	}
+
+	h.stageChangeSet(w, r, req)
+}
+
+// stageChangeSet creates a change set, responds 202 Accepted with it and records the proposal.
+func (h *Handler) stageChangeSet(w http.ResponseWriter, r *http.Request, req CreateChangeSetRequest) {
+	createdBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	cs, err := h.DB.CreateChangeSet(r.Context(), req.ProjectID, req.Description, req.Secrets, createdBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(cs)
+
+	h.DB.LogEvent(r.Context(), "PROPOSE_CHANGE_SET", "PROJECT", cs.ProjectID, map[string]interface{}{
+		"change_set_id": cs.ID,
+		"keys":          changeSetKeys(cs.Secrets),
+		"created_by":    createdBy,
+		"ip":            r.RemoteAddr,
+	})
+}
+
+// ListChangeSets returns the change sets of a project, optionally filtered by `?status=`.
+func (h *Handler) ListChangeSets(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(r.URL.Query().Get("project_id"))
+	if err != nil {
+		http.Error(w, "Invalid project_id", http.StatusBadRequest)
+		return
+	}
+
+	changeSets, err := h.DB.ListChangeSets(r.Context(), projectID, r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if changeSets == nil {
+		changeSets = []models.ChangeSet{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changeSets)
+}
+
+// GetChangeSet returns a change set with its staged ciphertexts, so a reviewer can decrypt them client-side.
+func (h *Handler) GetChangeSet(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid change set ID", http.StatusBadRequest)
+		return
+	}
+
+	cs, err := h.DB.GetChangeSet(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Change set not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cs)
+}
+
+// ApproveChangeSet promotes a pending change set into the project's secrets. Its author cannot approve it.
+func (h *Handler) ApproveChangeSet(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid change set ID", http.StatusBadRequest)
+		return
+	}
+
+	var req DecideChangeSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	cs, err := h.DB.GetChangeSet(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Change set not found", http.StatusNotFound)
+		return
+	}
+
+	approvedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	secrets, err := h.DB.ApproveChangeSet(r.Context(), id, approvedBy, req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrSelfApproval):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			h.DB.LogEvent(r.Context(), "SELF_APPROVAL_DENIED", "PROJECT", cs.ProjectID, map[string]interface{}{
+				"change_set_id": id,
+				"user_id":       approvedBy,
+				"ip":            r.RemoteAddr,
+			})
+		case errors.Is(err, db.ErrChangeSetNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, db.ErrSecretVersionExists):
+			http.Error(w, "A staged secret version was written in the meantime; reject this change set and propose it again", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secrets)
+
+	h.DB.LogEvent(r.Context(), "APPROVE_CHANGE_SET", "PROJECT", cs.ProjectID, map[string]interface{}{
+		"change_set_id": id,
+		"keys":          changeSetKeys(cs.Secrets),
+		"created_by":    cs.CreatedBy,
+		"approved_by":   approvedBy,
+		"note":          req.Note,
+		"ip":            r.RemoteAddr,
+	})
+}
+
+// RejectChangeSet rejects a pending change set. Its author may reject it to withdraw it.
+func (h *Handler) RejectChangeSet(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid change set ID", http.StatusBadRequest)
+		return
+	}
+
+	var req DecideChangeSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	cs, err := h.DB.GetChangeSet(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Change set not found", http.StatusNotFound)
+		return
+	}
+
+	rejectedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if err := h.DB.RejectChangeSet(r.Context(), id, rejectedBy, req.Note); err != nil {
+		if errors.Is(err, db.ErrChangeSetNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "REJECT_CHANGE_SET", "PROJECT", cs.ProjectID, map[string]interface{}{
+		"change_set_id": id,
+		"created_by":    cs.CreatedBy,
+		"rejected_by":   rejectedBy,
+		"note":          req.Note,
+		"ip":            r.RemoteAddr,
+	})
+}
+
+func changeSetKeys(secrets []models.ChangeSetSecret) []string {
+	keys := make([]string, len(secrets))
+	for i, s := range secrets {
+		keys[i] = s.Key
+	}
+	return keys
+}
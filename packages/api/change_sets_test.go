@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateSecret_ProtectedProjectStagesChangeSet(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	userID := uuid.New()
+	staged := []models.ChangeSetSecret{{Key: "DB_URL", Value: "cipher", Version: 3}}
+	cs := &models.ChangeSet{ID: uuid.New(), ProjectID: projectID, Status: models.ChangeSetPending, CreatedBy: userID, Secrets: staged}
+
+	mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID, Protected: true}, nil)
+	mockDB.On("CreateChangeSet", mock.Anything, projectID, "", staged, userID).Return(cs, nil)
+	mockDB.On("LogEvent", mock.Anything, "PROPOSE_CHANGE_SET", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(CreateSecretRequest{ProjectID: projectID, Key: "DB_URL", Value: "cipher", Version: 3})
+	req, _ := http.NewRequest("POST", "/api/v1/secrets", bytes.NewBuffer(body))
+	req = withUser(req, userID)
+	rr := httptest.NewRecorder()
+
+	h.CreateSecret(rr, req)
+
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	mockDB.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "CreateSecret", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateSecret_UnprotectedProject(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	secret := &models.Secret{ID: uuid.New(), ProjectID: projectID, Key: "K", Value: "cipher", Version: 1}
+	mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID}, nil)
+	mockDB.On("CreateSecret", mock.Anything, projectID, "K", "cipher", 1).Return(secret, nil)
+	mockDB.On("LogEvent", mock.Anything, "CREATE_SECRET", "SECRET", secret.ID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(CreateSecretRequest{ProjectID: projectID, Key: "K", Value: "cipher", Version: 1})
+	req, _ := http.NewRequest("POST", "/api/v1/secrets", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.CreateSecret(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+}
+
+func TestCreateChangeSet_Validation(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	projectID := uuid.New()
+
+	cases := map[string]CreateChangeSetRequest{
+		"no secrets":    {ProjectID: projectID},
+		"no version":    {ProjectID: projectID, Secrets: []models.ChangeSetSecret{{Key: "K", Value: "v"}}},
+		"duplicate key": {ProjectID: projectID, Secrets: []models.ChangeSetSecret{{Key: "K", Value: "v", Version: 1}, {Key: "K", Value: "w", Version: 2}}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			body, _ := json.Marshal(c)
+			req, _ := http.NewRequest("POST", "/api/v1/change-sets", bytes.NewBuffer(body))
+			rr := httptest.NewRecorder()
+
+			h.CreateChangeSet(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+func TestApproveChangeSet(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	id := uuid.New()
+	projectID := uuid.New()
+	author := uuid.New()
+	reviewer := uuid.New()
+	cs := &models.ChangeSet{ID: id, ProjectID: projectID, CreatedBy: author, Status: models.ChangeSetPending, Secrets: []models.ChangeSetSecret{{Key: "K", Value: "c", Version: 2}}}
+
+	mockDB.On("GetChangeSet", mock.Anything, id).Return(cs, nil)
+	mockDB.On("ApproveChangeSet", mock.Anything, id, reviewer, "lgtm").Return([]models.Secret{{Key: "K", Version: 2}}, nil)
+	mockDB.On("LogEvent", mock.Anything, "APPROVE_CHANGE_SET", "PROJECT", projectID, mock.MatchedBy(func(m map[string]interface{}) bool {
+		return m["approved_by"] == reviewer && m["created_by"] == author
+	})).Return(nil)
+
+	req, _ := http.NewRequest("POST", "/api/v1/change-sets/"+id.String()+"/approve", bytes.NewBufferString(`{"note":"lgtm"}`))
+	req = withUser(withURLParam(req, "id", id.String()), reviewer)
+	rr := httptest.NewRecorder()
+
+	h.ApproveChangeSet(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestApproveChangeSet_SelfApproval(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	id := uuid.New()
+	projectID := uuid.New()
+	author := uuid.New()
+
+	mockDB.On("GetChangeSet", mock.Anything, id).Return(&models.ChangeSet{ID: id, ProjectID: projectID, CreatedBy: author}, nil)
+	mockDB.On("ApproveChangeSet", mock.Anything, id, author, "").Return(nil, db.ErrSelfApproval)
+	mockDB.On("LogEvent", mock.Anything, "SELF_APPROVAL_DENIED", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("POST", "/api/v1/change-sets/"+id.String()+"/approve", bytes.NewBufferString(`{}`))
+	req = withUser(withURLParam(req, "id", id.String()), author)
+	rr := httptest.NewRecorder()
+
+	h.ApproveChangeSet(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestApproveChangeSet_Conflict(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	id := uuid.New()
+	reviewer := uuid.New()
+	mockDB.On("GetChangeSet", mock.Anything, id).Return(&models.ChangeSet{ID: id, CreatedBy: uuid.New()}, nil)
+	mockDB.On("ApproveChangeSet", mock.Anything, id, reviewer, "").Return(nil, db.ErrSecretVersionExists)
+
+	req, _ := http.NewRequest("POST", "/api/v1/change-sets/"+id.String()+"/approve", bytes.NewBufferString(`{}`))
+	req = withUser(withURLParam(req, "id", id.String()), reviewer)
+	rr := httptest.NewRecorder()
+
+	h.ApproveChangeSet(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestRejectChangeSet(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	id := uuid.New()
+	projectID := uuid.New()
+	reviewer := uuid.New()
+	mockDB.On("GetChangeSet", mock.Anything, id).Return(&models.ChangeSet{ID: id, ProjectID: projectID, CreatedBy: uuid.New()}, nil)
+	mockDB.On("RejectChangeSet", mock.Anything, id, reviewer, "wrong host").Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "REJECT_CHANGE_SET", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("POST", "/api/v1/change-sets/"+id.String()+"/reject", bytes.NewBufferString(`{"note":"wrong host"}`))
+	req = withUser(withURLParam(req, "id", id.String()), reviewer)
+	rr := httptest.NewRecorder()
+
+	h.RejectChangeSet(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertExpectations(t)
+}
@@ -2,10 +2,15 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type CreateClientRequest struct {
@@ -36,8 +41,18 @@ func (h *Handler) CreateClient(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(client)
 }
 
-// ListClients returns all clients.
+// ListClients returns all clients, or only their own for client portal accounts.
 func (h *Handler) ListClients(w http.ResponseWriter, r *http.Request) {
+	if clientID, scoped := auth.ClientFromContext(r.Context()); scoped {
+		clients := []models.Client{}
+		if client, err := h.DB.GetClientByID(r.Context(), clientID); err == nil {
+			clients = append(clients, *client)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clients)
+		return
+	}
+
 	clients, err := h.DB.GetClients(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -64,3 +79,100 @@ func (h *Handler) DeleteClient(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ListClientUsers returns the portal accounts restricted to a client.
+func (h *Handler) ListClientUsers(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	users, err := h.DB.ListClientUsers(r.Context(), clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// AddClientUser turns a collaborator into a portal account of a client. Their grants on other clients'
+// projects are revoked; the restriction is in their token from their next login.
+func (h *Handler) AddClientUser(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil || userID == uuid.Nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.DB.GetClientByID(r.Context(), clientID); err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.DB.SetUserClient(r.Context(), userID, &clientID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, db.ErrClientScopedRole) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	changedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "ADD_CLIENT_USER", "CLIENT", clientID, map[string]interface{}{
+		"user_id":    userID,
+		"changed_by": changedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// RemoveClientUser lifts a portal account's restriction to a client, making it a regular collaborator.
+func (h *Handler) RemoveClientUser(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil || userID == uuid.Nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(r.Context(), userID)
+	if err != nil || user.ClientID == nil || *user.ClientID != clientID {
+		http.Error(w, "User is not a portal account of this client", http.StatusNotFound)
+		return
+	}
+
+	if err := h.DB.SetUserClient(r.Context(), userID, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	changedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REMOVE_CLIENT_USER", "CLIENT", clientID, map[string]interface{}{
+		"user_id":    userID,
+		"changed_by": changedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
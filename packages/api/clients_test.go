@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func withClientScope(r *http.Request, userID, clientID uuid.UUID) *http.Request {
+	claims := jwt.MapClaims{"user_id": userID.String(), "role": auth.RoleCollaborator, "client_id": clientID.String()}
+	ctx := context.WithValue(r.Context(), auth.AdminContextKey, claims)
+	return withUser(r.WithContext(ctx), userID)
+}
+
+func TestListClients_ClientScoped(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	clientID := uuid.New()
+	mockDB.On("GetClientByID", mock.Anything, clientID).Return(&models.Client{ID: clientID, Name: "Acme"}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/v1/clients", nil)
+	rr := httptest.NewRecorder()
+
+	h.ListClients(rr, withClientScope(req, uuid.New(), clientID))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got []models.Client
+	json.NewDecoder(rr.Body).Decode(&got)
+	require.Len(t, got, 1)
+	assert.Equal(t, "Acme", got[0].Name)
+	mockDB.AssertNotCalled(t, "GetClients", mock.Anything)
+}
+
+func TestListProjectsByClient_OtherClientForbidden(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	req, _ := http.NewRequest("GET", "/api/v1/projects?client_id="+uuid.New().String(), nil)
+	rr := httptest.NewRecorder()
+
+	h.ListProjectsByClient(rr, withClientScope(req, uuid.New(), uuid.New()))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockDB.AssertNotCalled(t, "GetProjectsByClient", mock.Anything, mock.Anything)
+}
+
+func TestListProjectsByClient_OnlyGranted(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	clientID, userID, adminID := uuid.New(), uuid.New(), uuid.New()
+	granted := []models.Project{{ID: uuid.New(), ClientID: clientID, Name: "api"}}
+	all := append(granted, models.Project{ID: uuid.New(), ClientID: clientID, Name: "billing"})
+	mockDB.On("GetGrantedProjectsByClient", mock.Anything, userID, clientID).Return(granted, nil)
+	mockDB.On("GetProjectsByClient", mock.Anything, clientID).Return(all, nil)
+
+	// Collaborators see the projects they hold a grant on
+	req, _ := http.NewRequest("GET", "/api/v1/projects?client_id="+clientID.String(), nil)
+	rr := httptest.NewRecorder()
+	h.ListProjectsByClient(rr, withRole(req, userID, auth.RoleCollaborator))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got []models.Project
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	assert.Len(t, got, 1)
+
+	// Admins, who read every project's secrets, see them all
+	req, _ = http.NewRequest("GET", "/api/v1/projects?client_id="+clientID.String(), nil)
+	rr = httptest.NewRecorder()
+	h.ListProjectsByClient(rr, withRole(req, adminID, auth.RoleAdmin))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	assert.Len(t, got, 2)
+}
+
+func TestAddClientUser(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	clientID := uuid.New()
+	userID := uuid.New()
+	mockDB.On("GetClientByID", mock.Anything, clientID).Return(&models.Client{ID: clientID}, nil)
+	mockDB.On("SetUserClient", mock.Anything, userID, &clientID).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "ADD_CLIENT_USER", "CLIENT", clientID, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("PUT", "/api/v1/clients/"+clientID.String()+"/users/"+userID.String(), nil)
+	req = withURLParam(req, "id", clientID.String())
+	req = withURLParam(req, "user", userID.String())
+	rr := httptest.NewRecorder()
+
+	h.AddClientUser(rr, withUser(req, uuid.Nil))
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAddClientUser_RejectsGlobalRoles(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	clientID := uuid.New()
+	userID := uuid.New()
+	mockDB.On("GetClientByID", mock.Anything, clientID).Return(&models.Client{ID: clientID}, nil)
+	mockDB.On("SetUserClient", mock.Anything, userID, &clientID).Return(db.ErrClientScopedRole)
+
+	req, _ := http.NewRequest("PUT", "/api/v1/clients/"+clientID.String()+"/users/"+userID.String(), nil)
+	req = withURLParam(req, "id", clientID.String())
+	req = withURLParam(req, "user", userID.String())
+	rr := httptest.NewRecorder()
+
+	h.AddClientUser(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestRemoveClientUser_OtherClient(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	clientID := uuid.New()
+	otherClientID := uuid.New()
+	userID := uuid.New()
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(&models.User{ID: userID, ClientID: &otherClientID}, nil)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/clients/"+clientID.String()+"/users/"+userID.String(), nil)
+	req = withURLParam(req, "id", clientID.String())
+	req = withURLParam(req, "user", userID.String())
+	rr := httptest.NewRecorder()
+
+	h.RemoveClientUser(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	mockDB.AssertNotCalled(t, "SetUserClient", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CreateGroupRequest struct {
+	Name            string `json:"name"`
+	PublicKey       string `json:"public_key"`
+	WrappedGroupKey string `json:"wrapped_group_key"` // Group private key sealed to the creator's public key
+}
+
+type AddGroupMemberRequest struct {
+	UserID          uuid.UUID `json:"user_id"`
+	WrappedGroupKey string    `json:"wrapped_group_key"` // Group private key sealed to the new member's public key
+}
+
+// ListGroups returns all groups. Group names and public keys are not secret, but client portal accounts
+// only see the groups they belong to.
+func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	var groups []models.Group
+	var err error
+	if _, scoped := auth.ClientFromContext(r.Context()); scoped {
+		userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+		groups, err = h.DB.ListGroupsForUser(r.Context(), userID)
+	} else {
+		groups, err = h.DB.ListGroups(r.Context())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if groups == nil {
+		groups = []models.Group{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// GetGroup returns a single group by ID.
+func (h *Handler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, scoped := auth.ClientFromContext(r.Context()); scoped {
+		userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+		if _, err := h.DB.GetGroupMember(r.Context(), id, userID); err != nil {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	group, err := h.DB.GetGroup(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// CreateGroup creates a group from a keypair generated client-side. The creator becomes its first member.
+func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if publicKey, err := hex.DecodeString(req.PublicKey); err != nil || len(publicKey) != crypto.X25519KeyLen {
+		http.Error(w, crypto.ErrInvalidPublicKey.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedGroupKey) {
+		http.Error(w, "wrapped_group_key must be sealed to your public key", http.StatusBadRequest)
+		return
+	}
+
+	createdBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if _, err := h.DB.GetUserKeyPair(r.Context(), createdBy); err != nil {
+		http.Error(w, "You need a keypair to create a group", http.StatusConflict)
+		return
+	}
+
+	group, err := h.DB.CreateGroup(r.Context(), req.Name, req.PublicKey, createdBy, req.WrappedGroupKey)
+	if err != nil {
+		if errors.Is(err, db.ErrGroupExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+
+	h.DB.LogEvent(r.Context(), "CREATE_GROUP", "GROUP", group.ID, map[string]interface{}{
+		"name":       group.Name,
+		"created_by": createdBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// DeleteGroup removes a group, its memberships and its project grants.
+func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.DeleteGroup(r.Context(), id); err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	deletedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "DELETE_GROUP", "GROUP", id, map[string]interface{}{
+		"deleted_by": deletedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// ListGroupMembers returns the members of a group.
+func (h *Handler) ListGroupMembers(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	members, err := h.DB.ListGroupMembers(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []models.GroupMember{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// GetMyGroupKey returns the group private key sealed to the authenticated member.
+func (h *Handler) GetMyGroupKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	member, err := h.DB.GetGroupMember(r.Context(), id, userID)
+	if err != nil {
+		http.Error(w, "You are not a member of this group", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+// AddGroupMember adds a user to a group. Only members hold the group private key, so the caller must be one.
+func (h *Handler) AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AddGroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == uuid.Nil {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedGroupKey) {
+		http.Error(w, "wrapped_group_key must be sealed to the member's public key", http.StatusBadRequest)
+		return
+	}
+
+	addedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if _, err := h.DB.GetGroupMember(r.Context(), groupID, addedBy); err != nil {
+		http.Error(w, "Only members of the group can add members", http.StatusForbidden)
+		return
+	}
+	if _, err := h.DB.GetUserKeyPair(r.Context(), req.UserID); err != nil {
+		http.Error(w, "User not found or has no keypair", http.StatusNotFound)
+		return
+	}
+
+	if err := h.DB.AddGroupMember(r.Context(), groupID, req.UserID, req.WrappedGroupKey, addedBy); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "ADD_GROUP_MEMBER", "GROUP", groupID, map[string]interface{}{
+		"user_id":  req.UserID,
+		"added_by": addedBy,
+		"ip":       r.RemoteAddr,
+	})
+}
+
+// RemoveGroupMember removes a user from a group. The group's project grants are not re-wrapped.
+func (h *Handler) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RemoveGroupMember(r.Context(), groupID, userID); err != nil {
+		if errors.Is(err, db.ErrNotGroupMember) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	removedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REMOVE_GROUP_MEMBER", "GROUP", groupID, map[string]interface{}{
+		"user_id":    userID,
+		"removed_by": removedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateGroup(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	groupPub, groupPriv, err := vault.NewGroupKeyPair()
+	require.NoError(t, err)
+	wrapped, err := vault.WrapGroupKeyForMember(keys.PublicKey, groupPub, groupPriv)
+	require.NoError(t, err)
+
+	group := &models.Group{ID: uuid.New(), Name: "backend", PublicKey: groupPub, CreatedBy: adminID, MemberCount: 1}
+	mockDB.On("GetUserKeyPair", mock.Anything, adminID).Return(keys, nil)
+	mockDB.On("CreateGroup", mock.Anything, "backend", groupPub, adminID, wrapped).Return(group, nil)
+	mockDB.On("LogEvent", mock.Anything, "CREATE_GROUP", "GROUP", group.ID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(CreateGroupRequest{Name: "backend", PublicKey: groupPub, WrappedGroupKey: wrapped})
+	req, _ := http.NewRequest("POST", "/api/v1/groups", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.CreateGroup(rr, withUser(req, adminID))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreateGroup_Validation(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	groupPub, _, _ := vault.NewGroupKeyPair()
+	for _, req := range []CreateGroupRequest{
+		{PublicKey: groupPub, WrappedGroupKey: "abcdef"},
+		{Name: "backend", PublicKey: "zz", WrappedGroupKey: "abcdef"},
+		{Name: "backend", PublicKey: groupPub, WrappedGroupKey: "abcdef"},
+	} {
+		body, _ := json.Marshal(req)
+		r, _ := http.NewRequest("POST", "/api/v1/groups", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+
+		h.CreateGroup(rr, withUser(r, uuid.New()))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestAddGroupMember_RequiresMembership(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	groupPub, groupPriv, _ := vault.NewGroupKeyPair()
+	wrapped, _ := vault.WrapGroupKeyForMember(keys.PublicKey, groupPub, groupPriv)
+
+	groupID := uuid.New()
+	callerID := uuid.New()
+	mockDB.On("GetGroupMember", mock.Anything, groupID, callerID).Return(nil, db.ErrNotGroupMember)
+
+	body, _ := json.Marshal(AddGroupMemberRequest{UserID: uuid.New(), WrappedGroupKey: wrapped})
+	req, _ := http.NewRequest("POST", "/api/v1/groups/"+groupID.String()+"/members", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", groupID.String())
+	rr := httptest.NewRecorder()
+
+	h.AddGroupMember(rr, withUser(req, callerID))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockDB.AssertNotCalled(t, "AddGroupMember", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGrantGroupProjectAccess(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	groupPub, _, _ := vault.NewGroupKeyPair()
+	projectID := uuid.New()
+	groupID := uuid.New()
+	adminID := uuid.New()
+	wrapped, err := vault.WrapDataKeyForUser(groupPub, projectID, make([]byte, 32))
+	require.NoError(t, err)
+
+	mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID}, nil)
+	mockDB.On("GetGroup", mock.Anything, groupID).Return(&models.Group{ID: groupID, PublicKey: groupPub}, nil)
+	mockDB.On("GrantGroupProjectAccess", mock.Anything, groupID, projectID, wrapped, "editor", adminID).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "GRANT_GROUP_ACCESS", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(GrantGroupAccessRequest{GroupID: groupID, WrappedDataKey: wrapped})
+	req, _ := http.NewRequest("POST", "/api/v1/projects/"+projectID.String()+"/groups", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.GrantGroupProjectAccess(rr, withUser(req, adminID))
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetProjectKey_FallsBackToGroup(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	userID := uuid.New()
+	groupKey := &models.GroupProjectKey{GroupID: uuid.New(), GroupPublicKey: "pub", WrappedGroupKey: "wgk", WrappedDataKey: "wdk"}
+
+	mockDB.On("GetProjectKeyForUser", mock.Anything, projectID, userID, false).Return("", errors.New("no rows"))
+	mockDB.On("GetGroupProjectKeyForUser", mock.Anything, projectID, userID).Return(groupKey, nil)
+
+	req, _ := http.NewRequest("GET", "/api/v1/projects/"+projectID.String()+"/key", nil)
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.GetProjectKey(rr, withUser(req, userID))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got models.GroupProjectKey
+	json.NewDecoder(rr.Body).Decode(&got)
+	assert.Equal(t, *groupKey, got)
+}
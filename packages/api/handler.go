@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
-	"sync"
 
+	"github.com/dcdavidev/bastion/packages/auth"
 	"github.com/dcdavidev/bastion/packages/db"
 	"github.com/go-webauthn/webauthn/webauthn"
 )
@@ -15,7 +15,12 @@ import (
 type Handler struct {
 	DB       db.Database
 	WebAuthn *webauthn.WebAuthn
-	sessions sync.Map // Store for WebAuthn session data
+	Notifier AccessRequestNotifier // Told about access requests and their decisions
+	Alerter  BreakGlassAlerter     // Told immediately about break-glass access
+	OIDC     *auth.OIDCProvider    // Single sign-on provider, nil if not configured
+
+	Ceremonies  CeremonyStore    // Passkey ceremonies between their begin and finish requests
+	LoginPolicy auth.LoginPolicy // Backoff and lockout after failed logins
 }
 
 // NewHandler creates a new API handler with the provided database and initializes WebAuthn.
@@ -38,6 +43,11 @@ func NewHandler(database db.Database) *Handler {
 	return &Handler{
 		DB:       database,
 		WebAuthn: w,
+		Notifier: logNotifier{},
+		Alerter:  logAlerter{},
+
+		Ceremonies:  NewPostgresCeremonyStore(database),
+		LoginPolicy: auth.DefaultLoginPolicy(),
 	}
 }
 
@@ -50,14 +60,17 @@ type StatusResponse struct {
 		HasPending     bool `json:"has_pending"`
 		IsDirty        bool `json:"is_dirty"`
 	} `json:"migrations"`
-	HasAdmin bool   `json:"has_admin"`
-	Version  string `json:"version"`
+	HasAdmin      bool   `json:"has_admin"`
+	SSOEnabled    bool   `json:"sso_enabled"`    // Users can log in through single sign-on
+	LegacySecrets bool   `json:"legacy_secrets"` // Clients still accept secrets in the legacy unbound format
+	Version       string `json:"version"`
 }
 
 func (h *Handler) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	resp := StatusResponse{
 		Version:         "1.0.0", // Replace with version constant if available
 		JwtSecretStatus: "missing",
+		SSOEnabled:      h.OIDC != nil,
 	}
 
 	jwtSecret := os.Getenv("BASTION_JWT_SECRET")
@@ -103,6 +116,10 @@ func (h *Handler) StatusHandler(w http.ResponseWriter, r *http.Request) {
 			// Check admin
 			hasAdmin, _ := h.DB.HasAdmin(r.Context())
 			resp.HasAdmin = hasAdmin
+
+			if config, err := h.DB.GetVaultConfig(r.Context()); err == nil {
+				resp.LegacySecrets = config.LegacySecrets
+			}
 		}
 	}
 
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// InviteTokenPrefix makes invite tokens recognizable, e.g. by secret scanners.
+const InviteTokenPrefix = "bst_inv_"
+
+const (
+	defaultInvitationTTL = 72 * time.Hour
+	maxInvitationTTL     = 30 * 24 * time.Hour
+)
+
+type CreateInvitationRequest struct {
+	Email          string     `json:"email"`
+	ProjectID      *uuid.UUID `json:"project_id,omitempty"`       // Project to grant once the invite is accepted
+	ProjectRole    string     `json:"project_role,omitempty"`     // Role of that grant, defaults to editor
+	ClientID       *uuid.UUID `json:"client_id,omitempty"`        // Makes the invitee a portal account of this client
+	ExpiresInHours int        `json:"expires_in_hours,omitempty"` // Defaults to 72 hours
+}
+
+type CreateInvitationResponse struct {
+	Invitation *models.Invitation `json:"invitation"`
+	Token      string             `json:"token"` // Shown only once
+}
+
+type AcceptInvitationRequest struct {
+	Token    string              `json:"token"`
+	Email    string              `json:"email"`
+	Username string              `json:"username"`
+	Password string              `json:"password"`
+	KeyPair  *models.UserKeyPair `json:"key_pair"`
+}
+
+type CompleteInvitationGrantRequest struct {
+	WrappedDataKey string `json:"wrapped_data_key"` // Data key sealed to the invitee's public key
+}
+
+// CreateInvitation issues a single-use, expiring invite token bound to an email address.
+func (h *Handler) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var req CreateInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultInvitationTTL
+	if req.ExpiresInHours != 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl <= 0 || ttl > maxInvitationTTL {
+		http.Error(w, "expires_in_hours must be between 1 and 720", http.StatusBadRequest)
+		return
+	}
+
+	if req.ProjectRole == "" {
+		req.ProjectRole = auth.ProjectRoleEditor
+	}
+	if !auth.ValidProjectRole(req.ProjectRole) {
+		http.Error(w, "project_role must be viewer, editor or project-admin", http.StatusBadRequest)
+		return
+	}
+
+	if req.ClientID != nil {
+		if _, err := h.DB.GetClientByID(r.Context(), *req.ClientID); err != nil {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	if req.ProjectID != nil {
+		project, err := h.DB.GetProjectByID(r.Context(), *req.ProjectID)
+		if err != nil {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		if req.ClientID != nil && project.ClientID != *req.ClientID {
+			http.Error(w, "project_id must belong to client_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, err := crypto.GenerateToken(InviteTokenPrefix)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+
+	createdBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	invitation, err := h.DB.CreateInvitation(r.Context(), req.Email, req.ProjectID, req.ProjectRole, req.ClientID, createdBy, crypto.HashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateInvitationResponse{Invitation: invitation, Token: token})
+
+	h.DB.LogEvent(r.Context(), "CREATE_INVITATION", "INVITATION", invitation.ID, map[string]interface{}{
+		"email":      invitation.Email,
+		"project_id": req.ProjectID,
+		"role":       req.ProjectRole,
+		"client_id":  req.ClientID,
+		"expires_at": invitation.ExpiresAt,
+		"created_by": createdBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// ListInvitations returns all invitations with their current status.
+func (h *Handler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := h.DB.ListInvitations(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if invitations == nil {
+		invitations = []models.Invitation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// RevokeInvitation revokes an invitation that has not been redeemed yet.
+func (h *Handler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RevokeInvitation(r.Context(), id); err != nil {
+		if errors.Is(err, db.ErrInvitationNotFound) {
+			http.Error(w, "Invitation not found or already used", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	revokedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REVOKE_INVITATION", "INVITATION", id, map[string]interface{}{
+		"revoked_by": revokedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// AcceptInvitation redeems an invite token. It is public: the token is the credential.
+// The invitee chooses their own password and sends a keypair generated locally.
+func (h *Handler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" || req.Email == "" || req.Username == "" || req.Password == "" || req.KeyPair == nil {
+		http.Error(w, "token, email, username, password and key_pair are required", http.StatusBadRequest)
+		return
+	}
+	if err := vault.ValidateKeyPair(req.KeyPair); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check the token before hashing the password, so invalid tokens cost no key derivation
+	tokenHash := crypto.HashToken(req.Token)
+	pending, err := h.DB.GetPendingInvitation(r.Context(), tokenHash)
+	if err != nil {
+		if errors.Is(err, db.ErrInvitationInvalid) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !strings.EqualFold(pending.Email, req.Email) {
+		http.Error(w, db.ErrInvitationInvalid.Error(), http.StatusGone)
+		return
+	}
+
+	hash, salt, err := crypto.HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "Could not hash password", http.StatusInternalServerError)
+		return
+	}
+
+	invitation, user, err := h.DB.AcceptInvitation(r.Context(), db.AcceptInvitationParams{
+		TokenHash:    tokenHash,
+		Email:        req.Email,
+		Username:     req.Username,
+		PasswordHash: hash,
+		Salt:         salt,
+		KeyPair:      req.KeyPair,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvitationInvalid):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, db.ErrUserExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+
+	h.DB.LogEvent(r.Context(), "ACCEPT_INVITATION", "INVITATION", invitation.ID, map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"ip":       r.RemoteAddr,
+	})
+}
+
+// CompleteInvitationGrant grants the invitation's project to the invitee, using a data key the admin
+// sealed client-side to the invitee's public key.
+func (h *Handler) CompleteInvitationGrant(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CompleteInvitationGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedDataKey) {
+		http.Error(w, "wrapped_data_key must be sealed to the invitee's public key", http.StatusBadRequest)
+		return
+	}
+
+	grantedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	invitation, err := h.DB.CompleteInvitationGrant(r.Context(), id, req.WrappedDataKey, grantedBy)
+	if err != nil {
+		if errors.Is(err, db.ErrInvitationNotFound) {
+			http.Error(w, "Invitation not found, not accepted yet, or already completed", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitation)
+
+	h.DB.LogEvent(r.Context(), "COMPLETE_INVITATION", "INVITATION", invitation.ID, map[string]interface{}{
+		"user_id":    invitation.AcceptedUserID,
+		"project_id": invitation.ProjectID,
+		"granted_by": grantedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+func isSealedKey(wrappedHex string) bool {
+	wrapped, err := hex.DecodeString(wrappedHex)
+	return err == nil && crypto.IsSealed(wrapped)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateInvitation(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	invitation := &models.Invitation{ID: uuid.New(), Email: "dev@example.com", Status: models.InvitationPending}
+
+	var storedHash string
+	mockDB.On("CreateInvitation", mock.Anything, "dev@example.com", (*uuid.UUID)(nil), "editor", (*uuid.UUID)(nil), adminID, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			storedHash = args.String(6)
+			expiresAt := args.Get(7).(time.Time)
+			assert.WithinDuration(t, time.Now().Add(24*time.Hour), expiresAt, time.Minute)
+		}).
+		Return(invitation, nil)
+	mockDB.On("LogEvent", mock.Anything, "CREATE_INVITATION", "INVITATION", invitation.ID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(CreateInvitationRequest{Email: "dev@example.com", ExpiresInHours: 24})
+	req, _ := http.NewRequest("POST", "/api/v1/invitations", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.CreateInvitation(rr, withUser(req, adminID))
+
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var resp CreateInvitationResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	assert.True(t, strings.HasPrefix(resp.Token, InviteTokenPrefix))
+
+	// Only the hash of the token is stored
+	assert.Equal(t, crypto.HashToken(resp.Token), storedHash)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreateInvitation_Validation(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	for _, body := range []string{
+		`{"email":"not-an-email"}`,
+		`{"email":"dev@example.com","expires_in_hours":-1}`,
+		`{"email":"dev@example.com","expires_in_hours":10000}`,
+		`{"email":"dev@example.com","project_role":"owner"}`,
+	} {
+		req, _ := http.NewRequest("POST", "/api/v1/invitations", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		h.CreateInvitation(rr, withUser(req, uuid.New()))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	keys, _, err := vault.NewUserKeyPair("chosen-password")
+	require.NoError(t, err)
+
+	token := InviteTokenPrefix + "token"
+	invitation := &models.Invitation{ID: uuid.New(), Email: "dev@example.com"}
+	user := &models.User{ID: uuid.New(), Username: "dev", Email: "dev@example.com", Role: "COLLABORATOR"}
+
+	mockDB.On("GetPendingInvitation", mock.Anything, crypto.HashToken(token)).Return(invitation, nil)
+	mockDB.On("AcceptInvitation", mock.Anything, mock.MatchedBy(func(p db.AcceptInvitationParams) bool {
+		ok, _ := crypto.VerifyPassword("chosen-password", p.PasswordHash, p.Salt)
+		return p.TokenHash == crypto.HashToken(token) && p.Email == "dev@example.com" && p.Username == "dev" && ok && p.KeyPair.PublicKey == keys.PublicKey
+	})).Return(invitation, user, nil)
+	mockDB.On("LogEvent", mock.Anything, "ACCEPT_INVITATION", "INVITATION", invitation.ID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(AcceptInvitationRequest{Token: token, Email: "dev@example.com", Username: "dev", Password: "chosen-password", KeyPair: keys})
+	req, _ := http.NewRequest("POST", "/api/v1/invitations/accept", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.AcceptInvitation(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAcceptInvitation_Invalid(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	mockDB.On("GetPendingInvitation", mock.Anything, crypto.HashToken("expired")).Return(nil, db.ErrInvitationInvalid)
+	mockDB.On("GetPendingInvitation", mock.Anything, crypto.HashToken("other-email")).Return(&models.Invitation{ID: uuid.New(), Email: "someone@example.com"}, nil)
+
+	for _, token := range []string{"expired", "other-email"} {
+		body, _ := json.Marshal(AcceptInvitationRequest{Token: token, Email: "dev@example.com", Username: "dev", Password: "pw", KeyPair: keys})
+		req, _ := http.NewRequest("POST", "/api/v1/invitations/accept", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+
+		h.AcceptInvitation(rr, req)
+
+		assert.Equal(t, http.StatusGone, rr.Code)
+	}
+	// Refused before the password is hashed or an account is created
+	mockDB.AssertNotCalled(t, "AcceptInvitation", mock.Anything, mock.Anything)
+	mockDB.AssertNotCalled(t, "LogEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRevokeInvitation_NotFound(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	id := uuid.New()
+	mockDB.On("RevokeInvitation", mock.Anything, id).Return(db.ErrInvitationNotFound)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/invitations/"+id.String(), nil)
+	req = withURLParam(req, "id", id.String())
+	rr := httptest.NewRecorder()
+
+	h.RevokeInvitation(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestCompleteInvitationGrant(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	keys, _, _ := vault.NewUserKeyPair("pw")
+	dataKey, _ := crypto.GenerateRandomKey()
+	projectID := uuid.New()
+	wrapped, err := vault.WrapDataKeyForUser(keys.PublicKey, projectID, dataKey)
+	require.NoError(t, err)
+
+	id := uuid.New()
+	userID := uuid.New()
+	invitation := &models.Invitation{ID: id, ProjectID: &projectID, AcceptedUserID: &userID, Status: models.InvitationCompleted}
+
+	mockDB.On("CompleteInvitationGrant", mock.Anything, id, wrapped, uuid.Nil).Return(invitation, nil)
+	mockDB.On("LogEvent", mock.Anything, "COMPLETE_INVITATION", "INVITATION", id, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(CompleteInvitationGrantRequest{WrappedDataKey: wrapped})
+	req, _ := http.NewRequest("POST", "/api/v1/invitations/"+id.String()+"/grant", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", id.String())
+	rr := httptest.NewRecorder()
+
+	h.CompleteInvitationGrant(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCompleteInvitationGrant_RequiresSealedKey(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	id := uuid.New()
+	body, _ := json.Marshal(CompleteInvitationGrantRequest{WrappedDataKey: "abcdef"})
+	req, _ := http.NewRequest("POST", "/api/v1/invitations/"+id.String()+"/grant", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", id.String())
+	rr := httptest.NewRecorder()
+
+	h.CompleteInvitationGrant(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,627 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	mfaTokenPrefix = "bst_mfa_"
+	// mfaChallengeTTL is how long a password login waits for its second factor.
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaMaxAttempts is how many wrong codes a challenge takes before the password is needed again.
+	mfaMaxAttempts = 5
+	// totpIssuer names Bastion in authenticator apps.
+	totpIssuer = "Bastion"
+)
+
+// Purposes of a login challenge.
+const (
+	MFAPurposeVerify = "verify" // The user has a second factor and must provide it
+	MFAPurposeEnroll = "enroll" // The user's role requires a second factor they have yet to enroll
+)
+
+// errInvalidSecondFactor is returned for a wrong, reused or missing code.
+var errInvalidSecondFactor = errors.New("Invalid code")
+
+// MFAChallengeResponse answers, with 401, a correct password that needs a second factor. The login
+// continues with the token: through /auth/mfa/verify for "mfa_required", or by enrolling through
+// /auth/mfa/enroll for "mfa_enrollment_required".
+type MFAChallengeResponse struct {
+	Error     string   `json:"error"`
+	MFAToken  string   `json:"mfa_token"`
+	Methods   []string `json:"methods,omitempty"` // Accepted second factors
+	ExpiresIn int      `json:"expires_in"`        // Seconds until the token expires
+}
+
+// MFACodeRequest carries a second factor: a TOTP code or a recovery code. MFAToken is set while logging in.
+type MFACodeRequest struct {
+	MFAToken     string `json:"mfa_token,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// TOTPEnrollment is a new TOTP secret, for the user to add to their authenticator app.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`      // Base32, for manual entry
+	URI    string `json:"otpauth_uri"` // For a QR code
+}
+
+// MFAEnrollResponse confirms an enrollment with the recovery codes, shown only once. When the enrollment
+// completes a login, the session's tokens are included.
+type MFAEnrollResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	*LoginResponse
+}
+
+type MFAPolicy struct {
+	RequiredRoles []string `json:"required_roles"`
+}
+
+// requireSecondFactor answers a correct password with a challenge if the user has a second factor, or must
+// enroll one. It reports whether it wrote a response, in which case no session is started yet.
+func (h *Handler) requireSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	totp, err := h.DB.GetUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	resp := MFAChallengeResponse{Error: "mfa_required", Methods: []string{"totp", "recovery_code"}}
+	purpose := MFAPurposeVerify
+	if totp.EnabledAt == nil {
+		required, err := h.DB.RoleRequiresMFA(r.Context(), user.Role)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		if !required {
+			return false
+		}
+		resp = MFAChallengeResponse{Error: "mfa_enrollment_required"}
+		purpose = MFAPurposeEnroll
+	}
+
+	token, err := crypto.GenerateToken(mfaTokenPrefix)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return true
+	}
+	if _, err := h.DB.CreateMFAChallenge(r.Context(), user.ID, crypto.HashToken(token), purpose, time.Now().Add(mfaChallengeTTL)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	resp.MFAToken = token
+	resp.ExpiresIn = int(mfaChallengeTTL.Seconds())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(resp)
+	return true
+}
+
+// VerifyMFA completes a password login with a TOTP code or a recovery code.
+func (h *Handler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge := h.loadMFAChallenge(w, r, req.MFAToken, MFAPurposeVerify)
+	if challenge == nil {
+		return
+	}
+	if !h.checkLoginThrottle(w, r, userThrottleKey(challenge.UserID)) {
+		return
+	}
+
+	method, recoveryCodesLeft, err := h.checkSecondFactor(r.Context(), challenge.UserID, req)
+	if err != nil {
+		h.failMFAChallenge(w, r, challenge, err)
+		return
+	}
+
+	h.completeMFALogin(w, r, challenge, map[string]interface{}{"method": method})
+
+	if method == "recovery_code" {
+		h.DB.LogEvent(r.Context(), "USE_RECOVERY_CODE", "USER", challenge.UserID, map[string]interface{}{
+			"recovery_codes_left": recoveryCodesLeft,
+			"ip":                  r.RemoteAddr,
+		})
+	}
+}
+
+// BeginMFAEnrollment starts the TOTP enrollment a user's role requires, during their login.
+func (h *Handler) BeginMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge := h.loadMFAChallenge(w, r, req.MFAToken, MFAPurposeEnroll)
+	if challenge == nil {
+		return
+	}
+	user, err := h.DB.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	enrollment, err := h.beginTOTPEnrollment(r.Context(), user)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// ConfirmMFAEnrollment enables TOTP with the first code from the authenticator app and completes the login.
+func (h *Handler) ConfirmMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge := h.loadMFAChallenge(w, r, req.MFAToken, MFAPurposeEnroll)
+	if challenge == nil {
+		return
+	}
+	if !h.checkLoginThrottle(w, r, userThrottleKey(challenge.UserID)) {
+		return
+	}
+
+	codes, err := h.confirmTOTPEnrollment(r.Context(), challenge.UserID, req.Code)
+	if err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			h.failMFAChallenge(w, r, challenge, err)
+			return
+		}
+		writeMFAError(w, err)
+		return
+	}
+
+	h.DB.LogEvent(r.Context(), "ENABLE_MFA", "USER", challenge.UserID, map[string]interface{}{
+		"method": "totp",
+		"ip":     r.RemoteAddr,
+	})
+
+	if err := h.DB.ConsumeMFAChallenge(r.Context(), challenge.ID); err != nil {
+		http.Error(w, "Invalid or expired login challenge", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.DB.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	h.DB.ClearLoginThrottle(r.Context(), userThrottleKey(user.ID))
+	tokens, session, err := h.openSession(r, user.ID, user.Username, user.Role, user.ClientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAEnrollResponse{RecoveryCodes: codes, LoginResponse: tokens})
+
+	h.DB.LogEvent(r.Context(), "MFA_LOGIN", "USER", user.ID, map[string]interface{}{
+		"method":     "totp",
+		"session_id": session.ID,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// GetMyMFA returns the authenticated user's second factor status.
+func (h *Handler) GetMyMFA(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	status, err := h.DB.GetMFAStatus(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// EnrollMyTOTP starts a TOTP enrollment for the authenticated user. It takes effect once confirmed.
+func (h *Handler) EnrollMyTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if userID == uuid.Nil {
+		http.Error(w, "The environment admin cannot enroll a second factor", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	enrollment, err := h.beginTOTPEnrollment(r.Context(), user)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// ConfirmMyTOTP enables the authenticated user's pending TOTP enrollment with a first code.
+func (h *Handler) ConfirmMyTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.confirmTOTPEnrollment(r.Context(), userID, req.Code)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAEnrollResponse{RecoveryCodes: codes})
+
+	h.DB.LogEvent(r.Context(), "ENABLE_MFA", "USER", userID, map[string]interface{}{
+		"method": "totp",
+		"ip":     r.RemoteAddr,
+	})
+}
+
+// DisableMyTOTP turns off the authenticated user's TOTP. It takes a current code or a recovery code, so a
+// stolen session cannot remove the second factor, and is refused if the user's role requires one.
+func (h *Handler) DisableMyTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.DB.GetMFAStatus(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if status.Required {
+		http.Error(w, "Your role requires two-factor authentication", http.StatusConflict)
+		return
+	}
+
+	if !h.checkMySecondFactor(w, r, userID, req, "disable_totp") {
+		return
+	}
+	if err := h.DB.DisableTOTP(r.Context(), userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "DISABLE_MFA", "USER", userID, map[string]interface{}{
+		"ip": r.RemoteAddr,
+	})
+}
+
+// RegenerateMyRecoveryCodes replaces the authenticated user's recovery codes, after checking a current code.
+func (h *Handler) RegenerateMyRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkMySecondFactor(w, r, userID, req, "regenerate_recovery_codes") {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Could not generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := h.DB.ReplaceRecoveryCodes(r.Context(), userID, hashes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAEnrollResponse{RecoveryCodes: codes})
+
+	h.DB.LogEvent(r.Context(), "REGENERATE_RECOVERY_CODES", "USER", userID, map[string]interface{}{
+		"ip": r.RemoteAddr,
+	})
+}
+
+// ResetUserMFA removes a user's second factor, e.g. after they lost both their device and recovery codes.
+// If their role requires one, they enroll again at their next login.
+func (h *Handler) ResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.DB.GetUserByID(r.Context(), userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.DB.DisableTOTP(r.Context(), userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	resetBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "RESET_MFA", "USER", userID, map[string]interface{}{
+		"reset_by": resetBy,
+		"ip":       r.RemoteAddr,
+	})
+}
+
+// GetMFAPolicy returns the global roles that must use a second factor.
+func (h *Handler) GetMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.DB.ListMFARequiredRoles(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAPolicy{RequiredRoles: roles})
+}
+
+// SetMFAPolicy sets the global roles that must use a second factor. Their users without one enroll at
+// their next password login.
+func (h *Handler) SetMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	var req MFAPolicy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RequiredRoles == nil {
+		req.RequiredRoles = []string{}
+	}
+	for _, role := range req.RequiredRoles {
+		if !auth.ValidRole(role) {
+			http.Error(w, "Unknown role: "+role, http.StatusBadRequest)
+			return
+		}
+		// The environment admin has no account to enroll a second factor with
+		if role == auth.RoleAdmin && auth.AdminLoginEnabled() {
+			http.Error(w, "The environment admin cannot use a second factor. Unset "+auth.EnvAdminHash+" before requiring one for ADMIN", http.StatusConflict)
+			return
+		}
+	}
+
+	setBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if err := h.DB.SetMFARequiredRoles(r.Context(), req.RequiredRoles, setBy); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+
+	h.DB.LogEvent(r.Context(), "SET_MFA_POLICY", "MFA_POLICY", uuid.Nil, map[string]interface{}{
+		"required_roles": req.RequiredRoles,
+		"set_by":         setBy,
+		"ip":             r.RemoteAddr,
+	})
+}
+
+// loadMFAChallenge returns the challenge of a login token, or nil after writing a 401.
+func (h *Handler) loadMFAChallenge(w http.ResponseWriter, r *http.Request, token, purpose string) *models.MFAChallenge {
+	challenge, err := h.DB.GetMFAChallenge(r.Context(), crypto.HashToken(token))
+	if err != nil || challenge.Purpose != purpose || challenge.Attempts >= mfaMaxAttempts {
+		http.Error(w, "Invalid or expired login challenge", http.StatusUnauthorized)
+		return nil
+	}
+	return challenge
+}
+
+// checkMySecondFactor checks the code an authenticated user gave to change their own second factor. It is
+// throttled like a login, so a stolen session cannot guess codes, and writes the error response itself.
+func (h *Handler) checkMySecondFactor(w http.ResponseWriter, r *http.Request, userID uuid.UUID, req MFACodeRequest, action string) bool {
+	if !h.checkLoginThrottle(w, r, userThrottleKey(userID)) {
+		return false
+	}
+
+	_, _, err := h.checkSecondFactor(r.Context(), userID, req)
+	if err == nil {
+		return true
+	}
+	writeMFAError(w, err)
+	if !errors.Is(err, errInvalidSecondFactor) {
+		return false
+	}
+
+	h.recordLoginFailure(r, userThrottleKey(userID), &userID, "")
+	h.DB.LogEvent(r.Context(), "MFA_FAILED", "USER", userID, map[string]interface{}{
+		"action": action,
+		"ip":     r.RemoteAddr,
+	})
+	return false
+}
+
+// failMFAChallenge counts a wrong code, dropping the challenge after too many, and writes a 401.
+func (h *Handler) failMFAChallenge(w http.ResponseWriter, r *http.Request, challenge *models.MFAChallenge, err error) {
+	if !errors.Is(err, errInvalidSecondFactor) {
+		writeMFAError(w, err)
+		return
+	}
+
+	attempts, _ := h.DB.FailMFAChallenge(r.Context(), challenge.ID)
+	if attempts >= mfaMaxAttempts {
+		h.DB.ConsumeMFAChallenge(r.Context(), challenge.ID)
+	}
+	// Wrong codes also count against the account, so new challenges don't give an attacker more guesses
+	h.recordLoginFailure(r, userThrottleKey(challenge.UserID), &challenge.UserID, "")
+
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+
+	h.DB.LogEvent(r.Context(), "MFA_FAILED", "USER", challenge.UserID, map[string]interface{}{
+		"attempts": attempts,
+		"ip":       r.RemoteAddr,
+	})
+}
+
+// completeMFALogin consumes a challenge and starts the session it was waiting for.
+func (h *Handler) completeMFALogin(w http.ResponseWriter, r *http.Request, challenge *models.MFAChallenge, details map[string]interface{}) {
+	// Two requests with valid codes race here; only one gets the session
+	if err := h.DB.ConsumeMFAChallenge(r.Context(), challenge.ID); err != nil {
+		http.Error(w, "Invalid or expired login challenge", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	h.DB.ClearLoginThrottle(r.Context(), userThrottleKey(user.ID))
+	session := h.startSession(w, r, user.ID, user.Username, user.Role, user.ClientID)
+	if session == nil {
+		return
+	}
+
+	details["session_id"] = session.ID
+	details["ip"] = r.RemoteAddr
+	h.DB.LogEvent(r.Context(), "MFA_LOGIN", "USER", user.ID, details)
+}
+
+// checkSecondFactor verifies a TOTP code, or spends a recovery code, and returns which one was used and,
+// for a recovery code, how many are left.
+func (h *Handler) checkSecondFactor(ctx context.Context, userID uuid.UUID, req MFACodeRequest) (string, int, error) {
+	if req.RecoveryCode != "" {
+		left, err := h.DB.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(req.RecoveryCode))
+		if errors.Is(err, db.ErrRecoveryCodeInvalid) {
+			return "", 0, errInvalidSecondFactor
+		}
+		return "recovery_code", left, err
+	}
+
+	totp, err := h.DB.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return "", 0, err
+	}
+	if totp.EnabledAt == nil {
+		return "", 0, db.ErrTOTPNotPending
+	}
+	secret, err := auth.OpenTOTPSecret(userID, totp.Secret)
+	if err != nil {
+		return "", 0, err
+	}
+
+	step, ok := auth.VerifyTOTP(secret, req.Code, time.Now(), totp.LastStep)
+	if !ok {
+		return "", 0, errInvalidSecondFactor
+	}
+	if err := h.DB.UseTOTPStep(ctx, userID, step); err != nil {
+		if errors.Is(err, db.ErrTOTPCodeUsed) {
+			return "", 0, errInvalidSecondFactor
+		}
+		return "", 0, err
+	}
+	return "totp", 0, nil
+}
+
+// beginTOTPEnrollment generates a TOTP secret and stores it, sealed, as the user's pending enrollment.
+func (h *Handler) beginTOTPEnrollment(ctx context.Context, user *models.User) (*TOTPEnrollment, error) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := auth.SealTOTPSecret(user.ID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.DB.SetPendingTOTP(ctx, user.ID, sealed); err != nil {
+		return nil, err
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+	return &TOTPEnrollment{Secret: auth.EncodeTOTPSecret(secret), URI: auth.TOTPURI(totpIssuer, account, secret)}, nil
+}
+
+// confirmTOTPEnrollment enables a pending enrollment if code is valid, and returns new recovery codes.
+func (h *Handler) confirmTOTPEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	totp, err := h.DB.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Secret == "" || totp.EnabledAt != nil {
+		return nil, db.ErrTOTPNotPending
+	}
+	secret, err := auth.OpenTOTPSecret(userID, totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := auth.VerifyTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, errInvalidSecondFactor
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.DB.EnableTOTP(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+func writeMFAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidSecondFactor):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, db.ErrTOTPEnabled), errors.Is(err, db.ErrTOTPNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
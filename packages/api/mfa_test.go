@@ -0,0 +1,341 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// enrolledTOTP returns a user's secret and their enabled TOTP enrollment, as stored.
+func enrolledTOTP(t *testing.T, userID uuid.UUID) ([]byte, *models.UserTOTP) {
+	t.Helper()
+	secret, err := auth.NewTOTPSecret()
+	require.NoError(t, err)
+	sealed, err := auth.SealTOTPSecret(userID, secret)
+	require.NoError(t, err)
+	enabledAt := time.Now().Add(-time.Hour)
+	return secret, &models.UserTOTP{Secret: sealed, EnabledAt: &enabledAt}
+}
+
+func postJSON(path string, body interface{}) *http.Request {
+	payload, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(payload))
+	return req
+}
+
+func TestLoginHandler_RequiresSecondFactor(t *testing.T) {
+	useSigningKey(t)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	hash, salt, err := crypto.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+	_, totp := enrolledTOTP(t, user.ID)
+
+	mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, hash, salt, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, user.ID).Return(totp, nil)
+	mockDB.On("CreateMFAChallenge", mock.Anything, user.ID, mock.AnythingOfType("string"), MFAPurposeVerify, mock.Anything).Return(&models.MFAChallenge{ID: uuid.New()}, nil)
+
+	rr := httptest.NewRecorder()
+	h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Username: "alice", Password: "correct horse"}))
+
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	var resp MFAChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, "mfa_required", resp.Error)
+	assert.Contains(t, resp.MFAToken, mfaTokenPrefix)
+
+	// Only the hash of the challenge token is stored, and no session exists yet
+	mockDB.AssertCalled(t, "CreateMFAChallenge", mock.Anything, user.ID, crypto.HashToken(resp.MFAToken), MFAPurposeVerify, mock.Anything)
+	mockDB.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLoginHandler_EnforcesEnrollment(t *testing.T) {
+	useSigningKey(t)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	hash, salt, err := crypto.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleAdmin}
+
+	mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, hash, salt, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, user.ID).Return(&models.UserTOTP{}, nil)
+	mockDB.On("RoleRequiresMFA", mock.Anything, auth.RoleAdmin).Return(true, nil)
+	mockDB.On("CreateMFAChallenge", mock.Anything, user.ID, mock.AnythingOfType("string"), MFAPurposeEnroll, mock.Anything).Return(&models.MFAChallenge{ID: uuid.New()}, nil)
+
+	rr := httptest.NewRecorder()
+	h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Username: "alice", Password: "correct horse"}))
+
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	var resp MFAChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, "mfa_enrollment_required", resp.Error)
+	mockDB.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestVerifyMFA(t *testing.T) {
+	useSigningKey(t)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+	secret, totp := enrolledTOTP(t, user.ID)
+	challenge := &models.MFAChallenge{ID: uuid.New(), UserID: user.ID, Purpose: MFAPurposeVerify}
+	step := auth.TOTPStep(time.Now())
+
+	mockDB.On("GetMFAChallenge", mock.Anything, crypto.HashToken("bst_mfa_token")).Return(challenge, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, user.ID).Return(totp, nil)
+	mockDB.On("UseTOTPStep", mock.Anything, user.ID, step).Return(nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("ClearLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(true, nil)
+	mockDB.On("ConsumeMFAChallenge", mock.Anything, challenge.ID).Return(nil)
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+	mockDB.On("CreateSession", mock.Anything, user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&models.Session{ID: uuid.New()}, nil)
+	mockDB.On("LogEvent", mock.Anything, "MFA_LOGIN", "USER", user.ID, mock.Anything).Return(nil)
+
+	rr := httptest.NewRecorder()
+	h.VerifyMFA(rr, postJSON("/api/v1/auth/mfa/verify", MFACodeRequest{MFAToken: "bst_mfa_token", Code: auth.TOTPCode(secret, step)}))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.NotEmpty(t, resp.Token)
+	assert.NotEmpty(t, resp.RefreshToken)
+}
+
+func TestVerifyMFA_WrongCode(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+
+	userID := uuid.New()
+	_, totp := enrolledTOTP(t, userID)
+	challenge := &models.MFAChallenge{ID: uuid.New(), UserID: userID, Purpose: MFAPurposeVerify, Attempts: mfaMaxAttempts - 1}
+
+	mockDB.On("GetMFAChallenge", mock.Anything, crypto.HashToken("bst_mfa_token")).Return(challenge, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, userID).Return(totp, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+userID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("FailMFAChallenge", mock.Anything, challenge.ID).Return(mfaMaxAttempts, nil)
+	mockDB.On("RecordLoginFailure", mock.Anything, "user:"+userID.String(), &userID, mock.Anything).Return(1, nil)
+	mockDB.On("ConsumeMFAChallenge", mock.Anything, challenge.ID).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "MFA_FAILED", "USER", userID, mock.Anything).Return(nil)
+
+	rr := httptest.NewRecorder()
+	h.VerifyMFA(rr, postJSON("/api/v1/auth/mfa/verify", MFACodeRequest{MFAToken: "bst_mfa_token", Code: "000000x"}))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// The last allowed attempt failed, so the password is needed again
+	mockDB.AssertCalled(t, "ConsumeMFAChallenge", mock.Anything, challenge.ID)
+	mockDB.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestVerifyMFA_RecoveryCode(t *testing.T) {
+	useSigningKey(t)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+	challenge := &models.MFAChallenge{ID: uuid.New(), UserID: user.ID, Purpose: MFAPurposeVerify}
+
+	mockDB.On("GetMFAChallenge", mock.Anything, crypto.HashToken("bst_mfa_token")).Return(challenge, nil)
+	mockDB.On("UseRecoveryCode", mock.Anything, user.ID, auth.HashRecoveryCode("k3x9d-7mqpa")).Return(9, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("ClearLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(true, nil)
+	mockDB.On("ConsumeMFAChallenge", mock.Anything, challenge.ID).Return(nil)
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+	mockDB.On("CreateSession", mock.Anything, user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&models.Session{ID: uuid.New()}, nil)
+	mockDB.On("LogEvent", mock.Anything, "MFA_LOGIN", "USER", user.ID, mock.Anything).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "USE_RECOVERY_CODE", "USER", user.ID, mock.Anything).Return(nil)
+
+	rr := httptest.NewRecorder()
+	h.VerifyMFA(rr, postJSON("/api/v1/auth/mfa/verify", MFACodeRequest{MFAToken: "bst_mfa_token", RecoveryCode: "K3X9D-7MQPA"}))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "USE_RECOVERY_CODE", "USER", user.ID, mock.Anything)
+}
+
+func TestVerifyMFA_RejectsEnrollmentChallenge(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	challenge := &models.MFAChallenge{ID: uuid.New(), UserID: uuid.New(), Purpose: MFAPurposeEnroll}
+	mockDB.On("GetMFAChallenge", mock.Anything, crypto.HashToken("bst_mfa_token")).Return(challenge, nil)
+
+	rr := httptest.NewRecorder()
+	h.VerifyMFA(rr, postJSON("/api/v1/auth/mfa/verify", MFACodeRequest{MFAToken: "bst_mfa_token", RecoveryCode: "k3x9d-7mqpa"}))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	mockDB.AssertNotCalled(t, "UseRecoveryCode", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestConfirmMyTOTP(t *testing.T) {
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	secret, err := auth.NewTOTPSecret()
+	require.NoError(t, err)
+	sealed, err := auth.SealTOTPSecret(userID, secret)
+	require.NoError(t, err)
+	step := auth.TOTPStep(time.Now())
+
+	mockDB.On("GetUserTOTP", mock.Anything, userID).Return(&models.UserTOTP{Secret: sealed}, nil)
+	var hashes []string
+	mockDB.On("EnableTOTP", mock.Anything, userID, step, mock.MatchedBy(func(h []string) bool { hashes = h; return true })).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "ENABLE_MFA", "USER", userID, mock.Anything).Return(nil)
+
+	req := withUser(postJSON("/api/v1/auth/mfa/totp/confirm", MFACodeRequest{Code: auth.TOTPCode(secret, step)}), userID)
+	rr := httptest.NewRecorder()
+	h.ConfirmMyTOTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp MFAEnrollResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Len(t, resp.RecoveryCodes, auth.RecoveryCodeCount)
+	assert.Nil(t, resp.LoginResponse)
+
+	// Only the hashes of the recovery codes are stored
+	require.Len(t, hashes, auth.RecoveryCodeCount)
+	assert.Equal(t, auth.HashRecoveryCode(resp.RecoveryCodes[0]), hashes[0])
+}
+
+func TestDisableMyTOTP_RequiredByRole(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	mockDB.On("GetMFAStatus", mock.Anything, userID).Return(&models.MFAStatus{TOTPEnabled: true, Required: true}, nil)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/auth/mfa/totp", bytes.NewBufferString(`{"recovery_code":"k3x9d-7mqpa"}`))
+	rr := httptest.NewRecorder()
+	h.DisableMyTOTP(rr, withUser(req, userID))
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	mockDB.AssertNotCalled(t, "DisableTOTP", mock.Anything, mock.Anything)
+}
+
+func TestDisableMyTOTP_WrongCode(t *testing.T) {
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	_, totp := enrolledTOTP(t, userID)
+	mockDB.On("GetMFAStatus", mock.Anything, userID).Return(&models.MFAStatus{TOTPEnabled: true}, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+userID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, userID).Return(totp, nil)
+	mockDB.On("RecordLoginFailure", mock.Anything, "user:"+userID.String(), &userID, mock.Anything).Return(1, nil)
+	mockDB.On("LogEvent", mock.Anything, "MFA_FAILED", "USER", userID, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/auth/mfa/totp", bytes.NewBufferString(`{"code":"000000x"}`))
+	rr := httptest.NewRecorder()
+	h.DisableMyTOTP(rr, withUser(req, userID))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	mockDB.AssertCalled(t, "RecordLoginFailure", mock.Anything, "user:"+userID.String(), &userID, mock.Anything)
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "MFA_FAILED", "USER", userID, mock.Anything)
+	mockDB.AssertNotCalled(t, "DisableTOTP", mock.Anything, mock.Anything)
+}
+
+func TestRegenerateMyRecoveryCodes_Throttled(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	lockedUntil := time.Now().Add(time.Minute)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+userID.String()).Return(&models.LoginThrottle{LockedUntil: &lockedUntil}, nil)
+
+	rr := httptest.NewRecorder()
+	h.RegenerateMyRecoveryCodes(rr, withUser(postJSON("/api/v1/auth/mfa/recovery-codes", MFACodeRequest{RecoveryCode: "k3x9d-7mqpa"}), userID))
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	mockDB.AssertNotCalled(t, "UseRecoveryCode", mock.Anything, mock.Anything, mock.Anything)
+	mockDB.AssertNotCalled(t, "ReplaceRecoveryCodes", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSetMFAPolicy(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	mockDB.On("SetMFARequiredRoles", mock.Anything, []string{auth.RoleAdmin}, adminID).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "SET_MFA_POLICY", "MFA_POLICY", uuid.Nil, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("PUT", "/api/v1/auth/mfa/policy", bytes.NewBufferString(`{"required_roles":["ADMIN"]}`))
+	rr := httptest.NewRecorder()
+	h.SetMFAPolicy(rr, withUser(req, adminID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/v1/auth/mfa/policy", bytes.NewBufferString(`{"required_roles":["ROOT"]}`))
+	rr = httptest.NewRecorder()
+	h.SetMFAPolicy(rr, withUser(req, adminID))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestSetMFAPolicy_EnvironmentAdmin(t *testing.T) {
+	hash, salt, err := crypto.HashPassword("admin password")
+	require.NoError(t, err)
+	t.Setenv(auth.EnvAdminHash, hash)
+	t.Setenv(auth.EnvAdminSalt, salt)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	// The environment admin cannot enroll, so ADMIN cannot require a second factor while it can log in
+	req, _ := http.NewRequest("PUT", "/api/v1/auth/mfa/policy", bytes.NewBufferString(`{"required_roles":["ADMIN"]}`))
+	rr := httptest.NewRecorder()
+	h.SetMFAPolicy(rr, withUser(req, uuid.New()))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	mockDB.AssertNotCalled(t, "SetMFARequiredRoles", mock.Anything, mock.Anything, mock.Anything)
+
+	// and if the policy came first, it cannot log in
+	mockDB.On("GetLoginThrottle", mock.Anything, adminThrottleKey).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("RoleRequiresMFA", mock.Anything, auth.RoleAdmin).Return(true, nil)
+	rr = httptest.NewRecorder()
+	h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Password: "admin password"}))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockDB.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCheckSecondFactor_ReplayedCode(t *testing.T) {
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	secret, totp := enrolledTOTP(t, userID)
+	step := auth.TOTPStep(time.Now())
+
+	// The same code was just accepted by a concurrent request
+	mockDB.On("GetUserTOTP", mock.Anything, userID).Return(totp, nil)
+	mockDB.On("UseTOTPStep", mock.Anything, userID, step).Return(db.ErrTOTPCodeUsed)
+
+	_, _, err := h.checkSecondFactor(context.Background(), userID, MFACodeRequest{Code: auth.TOTPCode(secret, step)})
+	assert.ErrorIs(t, err, errInvalidSecondFactor)
+}
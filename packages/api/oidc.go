@@ -0,0 +1,298 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	oidcLoginCodePrefix = "bst_sso_"
+	// oidcLoginTTL is how long a user has to authenticate at the provider.
+	oidcLoginTTL = 10 * time.Minute
+	// oidcLoginCodeTTL is how long the client has to redeem its login code after the callback.
+	oidcLoginCodeTTL = time.Minute
+)
+
+// errNoSSOAccount is returned when a verified identity matches no user and may not be provisioned.
+var errNoSSOAccount = errors.New("no Bastion account matches this identity")
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+type OIDCTokenRequest struct {
+	Code         string `json:"code"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// OIDCLogin starts a single sign-on login and redirects the browser to the provider. The client that starts
+// it names where the result goes (return_to, a loopback address for the CLI or the web app) and sends the
+// PKCE challenge of a verifier it keeps, so only it can redeem the login code.
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	returnTo, challenge := q.Get("return_to"), q.Get("code_challenge")
+	if !h.OIDC.Config.AllowedReturnURL(returnTo) {
+		http.Error(w, "return_to must be a loopback address or the web app's origin", http.StatusBadRequest)
+		return
+	}
+	if method := q.Get("code_challenge_method"); method != "" && method != "S256" {
+		http.Error(w, "Only the S256 code_challenge_method is supported", http.StatusBadRequest)
+		return
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(challenge) != 43 {
+		http.Error(w, "code_challenge must be the S256 challenge of a PKCE verifier", http.StatusBadRequest)
+		return
+	}
+
+	state, err := crypto.GenerateToken("")
+	if err != nil {
+		http.Error(w, "Could not generate state", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := crypto.GenerateToken("")
+	if err != nil {
+		http.Error(w, "Could not generate nonce", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := crypto.GenerateToken("")
+	if err != nil {
+		http.Error(w, "Could not generate code verifier", http.StatusInternalServerError)
+		return
+	}
+
+	redirect, err := h.OIDC.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Single sign-on: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	login := &models.OIDCLogin{
+		Nonce:         nonce,
+		CodeVerifier:  verifier,
+		ReturnTo:      returnTo,
+		CodeChallenge: challenge,
+		ExpiresAt:     time.Now().Add(oidcLoginTTL),
+	}
+	if err := h.DB.CreateOIDCLogin(r.Context(), crypto.HashToken(state), login); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// OIDCCallback receives the provider's authorization code, verifies the user's identity and sends the
+// browser back to the client with a one-time login code, or with an OAuth error.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	login, err := h.DB.TakeOIDCLogin(r.Context(), crypto.HashToken(q.Get("state")))
+	if err != nil {
+		if errors.Is(err, db.ErrOIDCLoginNotFound) {
+			http.Error(w, "Unknown or expired login, please start again", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if e := q.Get("error"); e != "" {
+		redirectToClient(w, r, login.ReturnTo, url.Values{"error": {e}, "error_description": {q.Get("error_description")}})
+		return
+	}
+
+	identity, err := h.OIDC.Exchange(r.Context(), q.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		redirectToClient(w, r, login.ReturnTo, url.Values{"error": {"access_denied"}, "error_description": {"Could not verify the identity provider's response"}})
+		return
+	}
+
+	user, err := h.resolveOIDCUser(r, identity)
+	if err != nil {
+		h.DB.LogEvent(r.Context(), "SSO_LOGIN_DENIED", "USER", uuid.Nil, map[string]interface{}{
+			"issuer":  identity.Issuer,
+			"subject": identity.Subject,
+			"email":   identity.Email,
+			"reason":  err.Error(),
+			"ip":      r.RemoteAddr,
+		})
+		redirectToClient(w, r, login.ReturnTo, url.Values{"error": {"access_denied"}, "error_description": {err.Error()}})
+		return
+	}
+
+	code, err := crypto.GenerateToken(oidcLoginCodePrefix)
+	if err != nil {
+		redirectToClient(w, r, login.ReturnTo, url.Values{"error": {"server_error"}})
+		return
+	}
+	if err := h.DB.CompleteOIDCLogin(r.Context(), login.ID, user.ID, crypto.HashToken(code), time.Now().Add(oidcLoginCodeTTL)); err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		redirectToClient(w, r, login.ReturnTo, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	redirectToClient(w, r, login.ReturnTo, url.Values{"code": {code}})
+}
+
+// OIDCToken exchanges a login code and the PKCE verifier of the client that started the login for the
+// tokens of a new session.
+func (h *Handler) OIDCToken(w http.ResponseWriter, r *http.Request) {
+	var req OIDCTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.CodeVerifier == "" {
+		http.Error(w, "code and code_verifier are required", http.StatusBadRequest)
+		return
+	}
+
+	login, err := h.DB.RedeemOIDCLoginCode(r.Context(), crypto.HashToken(req.Code))
+	if err != nil {
+		if errors.Is(err, db.ErrOIDCLoginNotFound) {
+			http.Error(w, "Invalid or expired login code", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The code is burnt either way, so a wrong verifier cannot be retried
+	if subtle.ConstantTimeCompare([]byte(auth.PKCEChallenge(req.CodeVerifier)), []byte(login.CodeChallenge)) != 1 {
+		http.Error(w, "Invalid or expired login code", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(r.Context(), *login.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired login code", http.StatusBadRequest)
+		return
+	}
+
+	session := h.startSession(w, r, user.ID, user.Username, user.Role, user.ClientID)
+	if session == nil {
+		return
+	}
+
+	h.DB.LogEvent(r.Context(), "SSO_LOGIN", "USER", user.ID, map[string]interface{}{
+		"session_id": session.ID,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// resolveOIDCUser maps a verified identity to a user: the user linked to it, else the user with its email
+// if the provider verified it, which is then linked, else a provisioned account if that is enabled.
+func (h *Handler) resolveOIDCUser(r *http.Request, identity *auth.OIDCIdentity) (*models.User, error) {
+	ctx := r.Context()
+
+	user, err := h.DB.GetUserByOIDCIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	// An unverified email could be anyone's, so it neither matches nor provisions an account
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errNoSSOAccount
+	}
+
+	if user, _, _, err := h.DB.GetUserByEmail(ctx, identity.Email); err == nil {
+		if err := h.DB.LinkUserOIDCIdentity(ctx, user.ID, identity.Issuer, identity.Subject); err != nil {
+			if errors.Is(err, db.ErrIdentityLinked) {
+				return nil, errors.New("this Bastion account is linked to another identity")
+			}
+			return nil, err
+		}
+
+		h.DB.LogEvent(ctx, "LINK_SSO_IDENTITY", "USER", user.ID, map[string]interface{}{
+			"issuer":  identity.Issuer,
+			"subject": identity.Subject,
+			"email":   identity.Email,
+			"ip":      r.RemoteAddr,
+		})
+		return user, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	if !h.OIDC.Config.AutoProvision || !h.OIDC.Config.DomainAllowed(identity.Email) {
+		return nil, errNoSSOAccount
+	}
+
+	// On a username clash, retry once with a suffix derived from the subject
+	username := ssoUsername(identity)
+	user, err = h.DB.CreateOIDCUser(ctx, username, identity.Email, h.OIDC.Config.DefaultRole, identity.Issuer, identity.Subject)
+	if errors.Is(err, db.ErrUserExists) {
+		sum := sha256.Sum256([]byte(identity.Subject))
+		username += "-" + hex.EncodeToString(sum[:3])
+		user, err = h.DB.CreateOIDCUser(ctx, username, identity.Email, h.OIDC.Config.DefaultRole, identity.Issuer, identity.Subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.DB.LogEvent(ctx, "PROVISION_USER", "USER", user.ID, map[string]interface{}{
+		"username": user.Username,
+		"issuer":   identity.Issuer,
+		"subject":  identity.Subject,
+		"email":    identity.Email,
+		"role":     user.Role,
+		"ip":       r.RemoteAddr,
+	})
+	return user, nil
+}
+
+// ssoUsername derives a username for a provisioned account from the identity's preferred username or email.
+func ssoUsername(identity *auth.OIDCIdentity) string {
+	name := identity.PreferredUsername
+	if name == "" {
+		name = identity.Email
+	}
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	}
+	name = strings.Trim(usernameUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	if name == "" {
+		name = "user"
+	}
+	return name
+}
+
+// redirectToClient sends the browser back to the client that started a login, adding params to the
+// return_to URL checked when the login started.
+func redirectToClient(w http.ResponseWriter, r *http.Request, returnTo string, params url.Values) {
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		http.Error(w, "Invalid return URL", http.StatusBadRequest)
+		return
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
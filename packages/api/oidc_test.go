@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/auth/oidctest"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const ssoReturnTo = "http://127.0.0.1:53682/callback"
+
+// ssoHandler returns a handler using a mock identity provider that logs user in.
+func ssoHandler(t *testing.T, user jwt.MapClaims, autoProvision bool) (*Handler, *MockDatabase) {
+	t.Helper()
+	idp := oidctest.NewProvider("bastion")
+	t.Cleanup(idp.Close)
+	idp.User = user
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+	h.OIDC = auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:         idp.Issuer(),
+		ClientID:       "bastion",
+		RedirectURL:    "http://localhost:8287/api/v1/auth/oidc/callback",
+		Scopes:         []string{"openid", "email", "profile"},
+		AutoProvision:  autoProvision,
+		DefaultRole:    auth.RoleCollaborator,
+		AllowedDomains: []string{"example.com"},
+	})
+	mockDB.On("LogEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	return h, mockDB
+}
+
+// ssoCallback starts a login for a client holding verifier, lets the provider authenticate the user and
+// returns the query the browser brings back to the client.
+func ssoCallback(t *testing.T, h *Handler, mockDB *MockDatabase, verifier string) url.Values {
+	t.Helper()
+
+	var login *models.OIDCLogin
+	var stateHash string
+	mockDB.On("CreateOIDCLogin", mock.Anything, mock.AnythingOfType("string"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		stateHash = args.String(1)
+		login = args.Get(2).(*models.OIDCLogin)
+		login.ID = uuid.New()
+	}).Once()
+
+	query := url.Values{"return_to": {ssoReturnTo}, "code_challenge": {auth.PKCEChallenge(verifier)}, "code_challenge_method": {"S256"}}
+	rr := httptest.NewRecorder()
+	h.OIDCLogin(rr, httptest.NewRequest("GET", "/api/v1/auth/oidc/login?"+query.Encode(), nil))
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	require.NotNil(t, login)
+	assert.Equal(t, ssoReturnTo, login.ReturnTo)
+
+	// The browser goes through the provider, which sends it back to the callback
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "/api/v1/auth/oidc/callback", callback.Path)
+	assert.Equal(t, stateHash, crypto.HashToken(callback.Query().Get("state")))
+
+	mockDB.On("TakeOIDCLogin", mock.Anything, stateHash).Return(login, nil).Once()
+	mockDB.On("CompleteOIDCLogin", mock.Anything, login.ID, mock.Anything, mock.AnythingOfType("string"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		userID := args.Get(2).(uuid.UUID)
+		login.UserID = &userID
+		mockDB.On("RedeemOIDCLoginCode", mock.Anything, args.String(3)).Return(login, nil).Once()
+	}).Maybe()
+
+	rr = httptest.NewRecorder()
+	h.OIDCCallback(rr, httptest.NewRequest("GET", callback.String(), nil))
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+
+	back, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:53682", back.Host)
+	return back.Query()
+}
+
+func redeemSSOCode(h *Handler, code, verifier string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(OIDCTokenRequest{Code: code, CodeVerifier: verifier})
+	rr := httptest.NewRecorder()
+	h.OIDCToken(rr, httptest.NewRequest("POST", "/api/v1/auth/oidc/token", bytes.NewBuffer(body)))
+	return rr
+}
+
+func TestOIDCLogin_LinksUserByVerifiedEmail(t *testing.T) {
+	useSigningKey(t)
+	h, mockDB := ssoHandler(t, jwt.MapClaims{"sub": "idp-alice", "email": "alice@example.com", "email_verified": true}, false)
+
+	user := &models.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com", Role: auth.RoleCollaborator}
+	mockDB.On("GetUserByOIDCIdentity", mock.Anything, mock.Anything, "idp-alice").Return(nil, pgx.ErrNoRows)
+	mockDB.On("GetUserByEmail", mock.Anything, "alice@example.com").Return(user, "hash", "salt", nil)
+	mockDB.On("LinkUserOIDCIdentity", mock.Anything, user.ID, mock.Anything, "idp-alice").Return(nil)
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+	mockDB.On("CreateSession", mock.Anything, user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&models.Session{ID: uuid.New(), UserID: user.ID}, nil)
+
+	verifier, _ := crypto.GenerateToken("")
+	back := ssoCallback(t, h, mockDB, verifier)
+	require.Empty(t, back.Get("error"), back.Get("error_description"))
+	assert.Contains(t, back.Get("code"), oidcLoginCodePrefix)
+
+	rr := redeemSSOCode(h, back.Get("code"), verifier)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.NotEmpty(t, resp.Token)
+	assert.NotEmpty(t, resp.RefreshToken)
+
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "LINK_SSO_IDENTITY", "USER", user.ID, mock.Anything)
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "SSO_LOGIN", "USER", user.ID, mock.Anything)
+}
+
+func TestOIDCLogin_ProvisionsUser(t *testing.T) {
+	useSigningKey(t)
+	h, mockDB := ssoHandler(t, jwt.MapClaims{"sub": "idp-bob", "email": "Bob.Smith@example.com", "email_verified": true}, true)
+
+	user := &models.User{ID: uuid.New(), Username: "bob.smith", Email: "Bob.Smith@example.com", Role: auth.RoleCollaborator, SSO: true}
+	mockDB.On("GetUserByOIDCIdentity", mock.Anything, mock.Anything, "idp-bob").Return(nil, pgx.ErrNoRows)
+	mockDB.On("GetUserByEmail", mock.Anything, "Bob.Smith@example.com").Return(nil, "", "", pgx.ErrNoRows)
+	// The username is taken, so the second attempt gets a suffix
+	mockDB.On("CreateOIDCUser", mock.Anything, "bob.smith", "Bob.Smith@example.com", auth.RoleCollaborator, mock.Anything, "idp-bob").Return(nil, db.ErrUserExists).Once()
+	mockDB.On("CreateOIDCUser", mock.Anything, mock.MatchedBy(func(name string) bool { return len(name) == len("bob.smith-")+6 }), "Bob.Smith@example.com", auth.RoleCollaborator, mock.Anything, "idp-bob").Return(user, nil).Once()
+
+	verifier, _ := crypto.GenerateToken("")
+	back := ssoCallback(t, h, mockDB, verifier)
+	require.Empty(t, back.Get("error"), back.Get("error_description"))
+
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "PROVISION_USER", "USER", user.ID, mock.Anything)
+	mockDB.AssertNumberOfCalls(t, "CreateOIDCUser", 2)
+}
+
+func TestOIDCLogin_DeniesUnknownIdentity(t *testing.T) {
+	tests := []struct {
+		name          string
+		user          jwt.MapClaims
+		autoProvision bool
+	}{
+		{"provisioning disabled", jwt.MapClaims{"sub": "idp-eve", "email": "eve@example.com", "email_verified": true}, false},
+		{"unverified email", jwt.MapClaims{"sub": "idp-eve", "email": "alice@example.com", "email_verified": false}, true},
+		{"domain not allowed", jwt.MapClaims{"sub": "idp-eve", "email": "eve@elsewhere.com", "email_verified": true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mockDB := ssoHandler(t, tt.user, tt.autoProvision)
+			mockDB.On("GetUserByOIDCIdentity", mock.Anything, mock.Anything, "idp-eve").Return(nil, pgx.ErrNoRows)
+			mockDB.On("GetUserByEmail", mock.Anything, mock.Anything).Return(nil, "", "", pgx.ErrNoRows)
+
+			verifier, _ := crypto.GenerateToken("")
+			back := ssoCallback(t, h, mockDB, verifier)
+			assert.Equal(t, "access_denied", back.Get("error"))
+			assert.Empty(t, back.Get("code"))
+
+			mockDB.AssertNotCalled(t, "GetUserByEmail", mock.Anything, "alice@example.com")
+			mockDB.AssertNotCalled(t, "CreateOIDCUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			mockDB.AssertNotCalled(t, "CompleteOIDCLogin", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			mockDB.AssertCalled(t, "LogEvent", mock.Anything, "SSO_LOGIN_DENIED", "USER", uuid.Nil, mock.Anything)
+		})
+	}
+}
+
+func TestOIDCToken_RequiresVerifier(t *testing.T) {
+	useSigningKey(t)
+	h, mockDB := ssoHandler(t, jwt.MapClaims{"sub": "idp-alice"}, false)
+
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+	mockDB.On("GetUserByOIDCIdentity", mock.Anything, mock.Anything, "idp-alice").Return(user, nil)
+
+	verifier, _ := crypto.GenerateToken("")
+	back := ssoCallback(t, h, mockDB, verifier)
+	require.NotEmpty(t, back.Get("code"))
+
+	// An intercepted code is useless without the verifier of the client that started the login
+	attacker, _ := crypto.GenerateToken("")
+	rr := redeemSSOCode(h, back.Get("code"), attacker)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockDB.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// And it is burnt
+	mockDB.On("RedeemOIDCLoginCode", mock.Anything, crypto.HashToken(back.Get("code"))).Return(nil, db.ErrOIDCLoginNotFound)
+	rr = redeemSSOCode(h, back.Get("code"), verifier)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestOIDCLogin_RejectsForeignReturnURL(t *testing.T) {
+	h, mockDB := ssoHandler(t, nil, false)
+
+	query := url.Values{"return_to": {"https://evil.example.com/steal"}, "code_challenge": {auth.PKCEChallenge("verifier")}}
+	rr := httptest.NewRecorder()
+	h.OIDCLogin(rr, httptest.NewRequest("GET", "/api/v1/auth/oidc/login?"+query.Encode(), nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	query = url.Values{"return_to": {ssoReturnTo}}
+	rr = httptest.NewRecorder()
+	h.OIDCLogin(rr, httptest.NewRequest("GET", "/api/v1/auth/oidc/login?"+query.Encode(), nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	mockDB.AssertNotCalled(t, "CreateOIDCLogin", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOIDCCallback_ReplayedState(t *testing.T) {
+	h, mockDB := ssoHandler(t, nil, false)
+	mockDB.On("TakeOIDCLogin", mock.Anything, crypto.HashToken("used-state")).Return(nil, db.ErrOIDCLoginNotFound)
+
+	rr := httptest.NewRecorder()
+	h.OIDCCallback(rr, httptest.NewRequest("GET", "/api/v1/auth/oidc/callback?state=used-state&code=abc", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
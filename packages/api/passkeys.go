@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxPasskeyNameLength bounds passkey names, which are only labels for their owner.
+const maxPasskeyNameLength = 64
+
+type RenamePasskeyRequest struct {
+	Name string `json:"name"`
+}
+
+// passkeyName validates the name of a passkey.
+func passkeyName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+	if len(name) > maxPasskeyNameLength {
+		return "", fmt.Errorf("name must be at most %d characters", maxPasskeyNameLength)
+	}
+	return name, nil
+}
+
+// ListMyPasskeys returns the authenticated user's passkeys.
+func (h *Handler) ListMyPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	creds, err := h.DB.GetWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if creds == nil {
+		creds = []models.WebAuthnCredential{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creds)
+}
+
+// RenameMyPasskey renames one of the authenticated user's passkeys.
+func (h *Handler) RenameMyPasskey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RenamePasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, err := passkeyName(req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if err := h.DB.RenameWebAuthnCredential(r.Context(), userID, id, name); err != nil {
+		if errors.Is(err, db.ErrPasskeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "RENAME_PASSKEY", "USER", userID, map[string]interface{}{
+		"passkey_id": id,
+		"name":       name,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// DeleteMyPasskey removes one of the authenticated user's passkeys. The last passkey of an account that
+// cannot log in any other way is kept, so nobody locks themselves out.
+func (h *Handler) DeleteMyPasskey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	otherLogin, err := h.hasOtherLoginMethod(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cred, err := h.DB.DeleteWebAuthnCredential(r.Context(), userID, id, !otherLogin)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrPasskeyNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, db.ErrLastPasskey):
+			http.Error(w, "This is your only way to log in: set a password or register another passkey first", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "REMOVE_PASSKEY", "USER", userID, map[string]interface{}{
+		"passkey_id": cred.PublicID,
+		"name":       cred.Name,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// hasOtherLoginMethod reports whether a user can log in without a passkey: with a password, or through
+// single sign-on while it is configured.
+func (h *Handler) hasOtherLoginMethod(ctx context.Context, userID uuid.UUID) (bool, error) {
+	if userID == uuid.Nil {
+		return true, nil // The environment admin logs in with its password
+	}
+	user, err := h.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if user.SSO && h.OIDC != nil {
+		return true, nil
+	}
+	_, hash, _, err := h.DB.GetUserByUsername(ctx, user.Username)
+	if err != nil {
+		return false, err
+	}
+	return hash != "", nil
+}
+
+// alertPasskeyClone reports a login with a passkey whose signature counter did not increase, a sign that
+// the authenticator was cloned. The login itself is allowed: counters are advisory, and some
+// authenticators get them wrong.
+func (h *Handler) alertPasskeyClone(r *http.Request, user *models.User, cred *models.WebAuthnCredential) {
+	log.Printf("ALERT: passkey %q of %s may have been cloned: its signature counter did not increase", cred.Name, user.Username)
+	h.DB.LogEvent(r.Context(), "PASSKEY_CLONE_WARNING", "USER", user.ID, map[string]interface{}{
+		"passkey_id": cred.PublicID,
+		"name":       cred.Name,
+		"sign_count": cred.SignCount,
+		"alert":      true,
+		"ip":         r.RemoteAddr,
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListMyPasskeys_Empty(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	mockDB.On("GetWebAuthnCredentials", mock.Anything, userID).Return([]models.WebAuthnCredential(nil), nil)
+
+	rr := httptest.NewRecorder()
+	h.ListMyPasskeys(rr, withUser(httptest.NewRequest("GET", "/api/v1/auth/passkeys", nil), userID))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, "[]", rr.Body.String())
+}
+
+func TestRenameMyPasskey(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID, passkeyID := uuid.New(), uuid.New()
+	mockDB.On("RenameWebAuthnCredential", mock.Anything, userID, passkeyID, "YubiKey").Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "RENAME_PASSKEY", "USER", userID, mock.Anything).Return(nil)
+
+	rr := httptest.NewRecorder()
+	req := withURLParam(withUser(postJSON("/api/v1/auth/passkeys/"+passkeyID.String()+"/name", RenamePasskeyRequest{Name: "  YubiKey "}), userID), "id", passkeyID.String())
+	h.RenameMyPasskey(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	// Names are required and bounded
+	for _, name := range []string{" ", strings.Repeat("x", maxPasskeyNameLength+1)} {
+		rr = httptest.NewRecorder()
+		req = withURLParam(withUser(postJSON("/api/v1/auth/passkeys/"+passkeyID.String()+"/name", RenamePasskeyRequest{Name: name}), userID), "id", passkeyID.String())
+		h.RenameMyPasskey(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	}
+
+	// Other users' passkeys are not found
+	other := uuid.New()
+	mockDB.On("RenameWebAuthnCredential", mock.Anything, userID, other, "Mine").Return(db.ErrPasskeyNotFound)
+	rr = httptest.NewRecorder()
+	req = withURLParam(withUser(postJSON("/api/v1/auth/passkeys/"+other.String()+"/name", RenamePasskeyRequest{Name: "Mine"}), userID), "id", other.String())
+	h.RenameMyPasskey(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestDeleteMyPasskey_KeepsOnlyLoginMethod(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	// Provisioned by single sign-on, which is no longer configured, and without a password
+	user := &models.User{ID: uuid.New(), Username: "bob", SSO: true}
+	passkeyID := uuid.New()
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+	mockDB.On("GetUserByUsername", mock.Anything, "bob").Return(user, "", "", nil)
+	mockDB.On("DeleteWebAuthnCredential", mock.Anything, user.ID, passkeyID, true).Return(nil, db.ErrLastPasskey)
+
+	rr := httptest.NewRecorder()
+	req := withURLParam(withUser(httptest.NewRequest("DELETE", "/api/v1/auth/passkeys/"+passkeyID.String(), nil), user.ID), "id", passkeyID.String())
+	h.DeleteMyPasskey(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	mockDB.AssertNotCalled(t, "LogEvent", mock.Anything, "REMOVE_PASSKEY", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeleteMyPasskey_WithPassword(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	user := &models.User{ID: uuid.New(), Username: "alice"}
+	passkeyID := uuid.New()
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+	mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, "hash", "salt", nil)
+	mockDB.On("DeleteWebAuthnCredential", mock.Anything, user.ID, passkeyID, false).Return(&models.WebAuthnCredential{PublicID: passkeyID, Name: "Laptop"}, nil)
+	mockDB.On("LogEvent", mock.Anything, "REMOVE_PASSKEY", "USER", user.ID, mock.Anything).Return(nil)
+
+	rr := httptest.NewRecorder()
+	req := withURLParam(withUser(httptest.NewRequest("DELETE", "/api/v1/auth/passkeys/"+passkeyID.String(), nil), user.ID), "id", passkeyID.String())
+	h.DeleteMyPasskey(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertCalled(t, "DeleteWebAuthnCredential", mock.Anything, user.ID, passkeyID, false)
+}
+
+func TestWebAuthnCredential_JSON(t *testing.T) {
+	// Users refer to passkeys by their public ID; the authenticator's credential ID is only informative
+	cred := models.WebAuthnCredential{PublicID: uuid.New(), ID: []byte{1, 2, 3}, Name: "Phone"}
+	data, err := json.Marshal(cred)
+	require.NoError(t, err)
+
+	var out map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &out))
+	assert.Equal(t, cred.PublicID.String(), out["id"])
+	assert.Equal(t, "AQID", out["credential_id"])
+	assert.NotContains(t, out, "last_used_at")
+}
@@ -2,11 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -40,7 +42,8 @@ func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(project)
 }
 
-// ListProjectsByClient returns all projects for a specific client.
+// ListProjectsByClient returns the projects of a client. Unless their global role reads every project's
+// secrets, users only see the projects they hold a grant on.
 func (h *Handler) ListProjectsByClient(w http.ResponseWriter, r *http.Request) {
 	clientIDStr := r.URL.Query().Get("client_id")
 	if clientIDStr == "" {
@@ -54,11 +57,25 @@ func (h *Handler) ListProjectsByClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	projects, err := h.DB.GetProjectsByClient(r.Context(), clientID)
+	if scope, scoped := auth.ClientFromContext(r.Context()); scoped && scope != clientID {
+		http.Error(w, "Forbidden: you can only list projects of your own client", http.StatusForbidden)
+		return
+	}
+
+	var projects []models.Project
+	if auth.RoleAllows(auth.RoleFromContext(r.Context()), auth.PermReadSecrets) {
+		projects, err = h.DB.GetProjectsByClient(r.Context(), clientID)
+	} else {
+		userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+		projects, err = h.DB.GetGrantedProjectsByClient(r.Context(), userID, clientID)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if projects == nil {
+		projects = []models.Project{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(projects)
@@ -92,18 +109,35 @@ func (h *Handler) GetProjectKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract claims from context (added by JWTMiddleware)
-	claims, ok := r.Context().Value(auth.AdminContextKey).(jwt.MapClaims)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	isAdmin := auth.IsAdmin(r.Context())
+
+	// Service accounts only hold keys sealed to their own public key
+	if _, ok := auth.APITokenFromContext(r.Context()); ok {
+		wrappedKey, err := h.DB.GetServiceAccountProjectKey(r.Context(), projectID, userID)
+		if err != nil {
+			http.Error(w, "Service account holds no key for this project", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"wrapped_data_key": wrappedKey})
 		return
 	}
 
-	userIDStr, _ := claims["user_id"].(string)
-	userID, _ := uuid.Parse(userIDStr)
-	isAdmin, _ := claims["admin"].(bool)
-
 	wrappedKey, err := h.DB.GetProjectKeyForUser(r.Context(), projectID, userID, isAdmin)
+	if err != nil && !isAdmin {
+		// Fall back to a grant held by one of the user's groups
+		groupKey, groupErr := h.DB.GetGroupProjectKeyForUser(r.Context(), projectID, userID)
+		if groupErr != nil {
+			// Or to the escrowed key during an active break-glass session
+			groupKey, groupErr = h.DB.GetBreakGlassProjectKey(r.Context(), projectID, userID)
+		}
+		if groupErr == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(groupKey)
+			return
+		}
+	}
 	if err != nil {
 		http.Error(w, "Access denied or project not found", http.StatusForbidden)
 		return
@@ -129,3 +163,86 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+type RotateProjectKeyRequest struct {
+	OldWrappedDataKey string               `json:"old_wrapped_data_key"` // The wrapped data key the rotation was made from
+	WrappedDataKey    string               `json:"wrapped_data_key"`
+	Secrets           map[uuid.UUID]string `json:"secrets"`         // Secret version ID -> re-encrypted value
+	AccessKeys        map[uuid.UUID]string `json:"access_keys"`     // User ID -> re-wrapped data key
+	GroupKeys         map[uuid.UUID]string `json:"group_keys"`      // Group ID -> re-wrapped data key
+	ServiceKeys       map[uuid.UUID]string `json:"service_keys"`    // Service account ID -> re-wrapped data key
+	BreakGlassKey     string               `json:"break_glass_key"` // Data key sealed to the break-glass escrow
+	DeleteHistory     bool                 `json:"delete_history"`  // Only the latest versions were re-encrypted; delete older ones
+}
+
+type RotateProjectKeyResponse struct {
+	SecretsReencrypted   int         `json:"secrets_reencrypted"`
+	AccessKeysRewrapped  int         `json:"access_keys_rewrapped"`
+	GroupKeysRewrapped   int         `json:"group_keys_rewrapped"`
+	ServiceKeysRewrapped int         `json:"service_keys_rewrapped"`
+	RevokedUsers         []uuid.UUID `json:"revoked_users"`
+}
+
+// RotateProjectKey atomically commits a client-side rotation of a project's data key.
+func (h *Handler) RotateProjectKey(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RotateProjectKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.WrappedDataKey == "" || req.OldWrappedDataKey == "" {
+		http.Error(w, "wrapped_data_key and old_wrapped_data_key are required", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := h.DB.RotateProjectKey(r.Context(), projectID, db.ProjectKeyRotation{
+		OldWrappedDataKey: req.OldWrappedDataKey,
+		WrappedDataKey:    req.WrappedDataKey,
+		Secrets:           req.Secrets,
+		AccessKeys:        req.AccessKeys,
+		GroupKeys:         req.GroupKeys,
+		ServiceKeys:       req.ServiceKeys,
+		BreakGlassKey:     req.BreakGlassKey,
+		DeleteHistory:     req.DeleteHistory,
+	})
+	if errors.Is(err, db.ErrSecretSetChanged) || errors.Is(err, db.ErrVaultKeysChanged) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if revoked == nil {
+		revoked = []uuid.UUID{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RotateProjectKeyResponse{
+		SecretsReencrypted:   len(req.Secrets),
+		AccessKeysRewrapped:  len(req.AccessKeys),
+		GroupKeysRewrapped:   len(req.GroupKeys),
+		ServiceKeysRewrapped: len(req.ServiceKeys),
+		RevokedUsers:         revoked,
+	})
+
+	// Log audit event
+	h.DB.LogEvent(r.Context(), "ROTATE_PROJECT_KEY", "PROJECT", projectID, map[string]interface{}{
+		"secrets":        len(req.Secrets),
+		"access_keys":    len(req.AccessKeys),
+		"group_keys":     len(req.GroupKeys),
+		"service_keys":   len(req.ServiceKeys),
+		"break_glass":    req.BreakGlassKey != "",
+		"revoked_users":  revoked,
+		"delete_history": req.DeleteHistory,
+		"ip":             r.RemoteAddr,
+	})
+}
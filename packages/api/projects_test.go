@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// withURLParam attaches a chi route parameter to the request.
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		rctx = chi.NewRouteContext()
+	}
+	rctx.URLParams.Add(key, value)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestRotateProjectKey(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	secretID := uuid.New()
+	revokedID := uuid.New()
+
+	rotation := db.ProjectKeyRotation{
+		OldWrappedDataKey: "old-wrapped",
+		WrappedDataKey:    "new-wrapped",
+		Secrets:           map[uuid.UUID]string{secretID: "new-value"},
+		AccessKeys:        map[uuid.UUID]string{},
+	}
+
+	mockDB.On("RotateProjectKey", mock.Anything, projectID, rotation).Return([]uuid.UUID{revokedID}, nil)
+	mockDB.On("LogEvent", mock.Anything, "ROTATE_PROJECT_KEY", "PROJECT", projectID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(RotateProjectKeyRequest{
+		OldWrappedDataKey: "old-wrapped",
+		WrappedDataKey:    "new-wrapped",
+		Secrets:           rotation.Secrets,
+		AccessKeys:        rotation.AccessKeys,
+	})
+	req, _ := http.NewRequest("POST", "/api/v1/projects/"+projectID.String()+"/rotate", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.RotateProjectKey(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var resp RotateProjectKeyResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.SecretsReencrypted)
+	assert.Equal(t, []uuid.UUID{revokedID}, resp.RevokedUsers)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRotateProjectKey_Conflict(t *testing.T) {
+	// The secrets changed, or the data key or Master Key was rotated since the client read them
+	for _, conflict := range []error{db.ErrSecretSetChanged, db.ErrVaultKeysChanged} {
+		mockDB := new(MockDatabase)
+		h := NewHandler(mockDB)
+
+		projectID := uuid.New()
+		mockDB.On("RotateProjectKey", mock.Anything, projectID, mock.Anything).Return(nil, conflict)
+
+		req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(`{"old_wrapped_data_key":"o","wrapped_data_key":"k","secrets":{}}`))
+		req = withURLParam(req, "id", projectID.String())
+		rr := httptest.NewRecorder()
+
+		h.RotateProjectKey(rr, req)
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	}
+}
+
+func TestRotateProjectKey_RequiresOldKey(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(`{"wrapped_data_key":"k","secrets":{}}`))
+	req = withURLParam(req, "id", projectID.String())
+	rr := httptest.NewRecorder()
+
+	h.RotateProjectKey(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockDB.AssertNotCalled(t, "RotateProjectKey", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ReapExpiredGrants deletes the wrapped data keys of expired grants and records each one in the audit log.
+// It returns the number of grants removed.
+func (h *Handler) ReapExpiredGrants(ctx context.Context) (int, error) {
+	grants, err := h.DB.DeleteExpiredGrants(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range grants {
+		h.DB.LogEvent(ctx, "EXPIRE_ACCESS", "PROJECT", g.ProjectID, map[string]interface{}{
+			"user_id":    g.UserID,
+			"role":       g.Role,
+			"granted_by": g.GrantedBy,
+			"expires_at": g.ExpiresAt,
+		})
+	}
+	return len(grants), nil
+}
+
+// RunGrantReaper calls ReapExpiredGrants every interval until ctx is cancelled. Expired grants are
+// already rejected on access; the reaper only removes their key material.
+func (h *Handler) RunGrantReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := h.ReapExpiredGrants(ctx); err != nil {
+			log.Printf("Grant reaper failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Grant reaper removed %d expired grants", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunSessionReaper deletes expired sessions and their refresh tokens, signing keys past their retention,
+// abandoned single sign-on logins, two-factor challenges and passkey ceremonies, and stale failed login
+// counters, every interval until ctx is cancelled. All are already rejected or ignored on use; the reaper only keeps the
+// tables small.
+func (h *Handler) RunSessionReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := h.DB.DeleteExpiredSessions(ctx); err != nil {
+			log.Printf("Session reaper failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Session reaper removed %d expired sessions", n)
+		}
+		if n, err := h.DB.DeleteExpiredSigningKeys(ctx); err != nil {
+			log.Printf("Session reaper failed to remove signing keys: %v", err)
+		} else if n > 0 {
+			log.Printf("Session reaper removed %d expired signing keys", n)
+		}
+		if n, err := h.DB.DeleteExpiredOIDCLogins(ctx); err != nil {
+			log.Printf("Session reaper failed to remove single sign-on logins: %v", err)
+		} else if n > 0 {
+			log.Printf("Session reaper removed %d expired single sign-on logins", n)
+		}
+		if n, err := h.DB.DeleteExpiredMFAChallenges(ctx); err != nil {
+			log.Printf("Session reaper failed to remove two-factor challenges: %v", err)
+		} else if n > 0 {
+			log.Printf("Session reaper removed %d expired two-factor challenges", n)
+		}
+		if n, err := h.Ceremonies.DeleteExpired(ctx); err != nil {
+			log.Printf("Session reaper failed to remove passkey ceremonies: %v", err)
+		} else if n > 0 {
+			log.Printf("Session reaper removed %d expired passkey ceremonies", n)
+		}
+		if n, err := h.DB.DeleteStaleLoginThrottles(ctx, h.LoginPolicy.FailureWindow); err != nil {
+			log.Printf("Session reaper failed to remove failed login counters: %v", err)
+		} else if n > 0 {
+			log.Printf("Session reaper removed %d stale failed login counters", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,18 +2,23 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/google/uuid"
 )
 
 type CreateSecretRequest struct {
 	ProjectID uuid.UUID `json:"project_id"`
 	Key       string    `json:"key"`
-	Value     string    `json:"value"` // Already encrypted
+	Value     string    `json:"value"`             // Already encrypted
+	Version   int       `json:"version,omitempty"` // Version the ciphertext is bound to; 0 picks the next one
 }
 
-// CreateSecret handles the creation of a new secret version.
+// CreateSecret handles the creation of a new secret version. On protected projects the write is staged
+// as a change set instead and 202 Accepted is returned.
 func (h *Handler) CreateSecret(w http.ResponseWriter, r *http.Request) {
 	var req CreateSecretRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -26,7 +31,34 @@ func (h *Handler) CreateSecret(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	secret, err := h.DB.CreateSecret(r.Context(), req.ProjectID, req.Key, req.Value)
+	if req.Version < 0 {
+		http.Error(w, "version must be positive", http.StatusBadRequest)
+		return
+	}
+
+	project, err := h.DB.GetProjectByID(r.Context(), req.ProjectID)
+	if err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if project.Protected {
+		// The write is staged until another user approves it
+		if req.Version == 0 {
+			http.Error(w, "Project is protected: the ciphertext must be bound to an explicit version", http.StatusBadRequest)
+			return
+		}
+		h.stageChangeSet(w, r, CreateChangeSetRequest{
+			ProjectID: req.ProjectID,
+			Secrets:   []models.ChangeSetSecret{{Key: req.Key, Value: req.Value, Version: req.Version}},
+		})
+		return
+	}
+
+	secret, err := h.DB.CreateSecret(r.Context(), req.ProjectID, req.Key, req.Value, req.Version)
+	if errors.Is(err, db.ErrSecretVersionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,432 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name          string      `json:"name"`
+	Permission    string      `json:"permission,omitempty"` // "read" (default) or "read-write"
+	ProjectIDs    []uuid.UUID `json:"project_ids"`
+	AllowedIPs    []string    `json:"allowed_ips,omitempty"`     // IPs or CIDRs; empty allows any address
+	ExpiresInDays int         `json:"expires_in_days,omitempty"` // 0 for a token that does not expire
+}
+
+type CreateAPITokenResponse struct {
+	APIToken *models.APIToken `json:"api_token"`
+	Token    string           `json:"token"` // Shown only once
+}
+
+type SetServiceAccountKeyRequest struct {
+	PublicKey string `json:"public_key"` // The private key stays with the machine
+}
+
+type GrantServiceAccountAccessRequest struct {
+	ServiceAccountID uuid.UUID `json:"service_account_id"`
+	WrappedDataKey   string    `json:"wrapped_data_key"` // Data key sealed to the service account's public key
+}
+
+// ListServiceAccounts returns all service accounts.
+func (h *Handler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.DB.ListServiceAccounts(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if accounts == nil {
+		accounts = []models.ServiceAccount{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+// CreateServiceAccount creates a service account. It can do nothing until it is given a token.
+func (h *Handler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var req CreateServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	createdBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	account, err := h.DB.CreateServiceAccount(r.Context(), req.Name, req.Description, createdBy)
+	if err != nil {
+		if errors.Is(err, db.ErrServiceAccountExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+
+	h.DB.LogEvent(r.Context(), "CREATE_SERVICE_ACCOUNT", "SERVICE_ACCOUNT", account.ID, map[string]interface{}{
+		"name":       account.Name,
+		"created_by": createdBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// DeleteServiceAccount removes a service account, revoking all its tokens.
+func (h *Handler) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.DeleteServiceAccount(r.Context(), id); err != nil {
+		if errors.Is(err, db.ErrServiceAccountNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	deletedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "DELETE_SERVICE_ACCOUNT", "SERVICE_ACCOUNT", id, map[string]interface{}{
+		"deleted_by": deletedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// ListAPITokens returns the tokens of a service account, without their secret values.
+func (h *Handler) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.DB.ListAPITokens(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAPIToken issues a token for a service account, scoped to some projects. The token is returned
+// once; only its hash is stored.
+func (h *Handler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	accountID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if req.Permission == "" {
+		req.Permission = models.APITokenRead
+	}
+	if req.Permission != models.APITokenRead && req.Permission != models.APITokenReadWrite {
+		http.Error(w, "permission must be read or read-write", http.StatusBadRequest)
+		return
+	}
+	if len(req.ProjectIDs) == 0 {
+		http.Error(w, "project_ids must name at least one project", http.StatusBadRequest)
+		return
+	}
+	allowedIPs, err := normalizeCIDRs(req.AllowedIPs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expires_in_days must not be negative", http.StatusBadRequest)
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	token, err := crypto.GenerateToken(auth.APITokenPrefix)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+
+	createdBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	apiToken, err := h.DB.CreateAPIToken(r.Context(), db.CreateAPITokenParams{
+		ServiceAccountID: accountID,
+		Name:             req.Name,
+		TokenHash:        crypto.HashToken(token),
+		Permission:       req.Permission,
+		ProjectIDs:       req.ProjectIDs,
+		AllowedIPs:       allowedIPs,
+		ExpiresAt:        expiresAt,
+		CreatedBy:        createdBy,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrServiceAccountNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, db.ErrAPITokenExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPITokenResponse{APIToken: apiToken, Token: token})
+
+	h.DB.LogEvent(r.Context(), "CREATE_API_TOKEN", "SERVICE_ACCOUNT", accountID, map[string]interface{}{
+		"token_id":    apiToken.ID,
+		"name":        apiToken.Name,
+		"permission":  apiToken.Permission,
+		"project_ids": apiToken.ProjectIDs,
+		"allowed_ips": apiToken.AllowedIPs,
+		"expires_at":  apiToken.ExpiresAt,
+		"created_by":  createdBy,
+		"ip":          r.RemoteAddr,
+	})
+}
+
+// RevokeAPIToken revokes a token of a service account immediately.
+func (h *Handler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	accountID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+		return
+	}
+	tokenID, err := uuid.Parse(chi.URLParam(r, "token"))
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RevokeAPIToken(r.Context(), accountID, tokenID); err != nil {
+		if errors.Is(err, db.ErrAPITokenNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	revokedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REVOKE_API_TOKEN", "SERVICE_ACCOUNT", accountID, map[string]interface{}{
+		"token_id":   tokenID,
+		"revoked_by": revokedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// SetServiceAccountKey sets the public key of a service account generated client-side. Replacing a key
+// drops the project keys sealed to the old one; they must be granted again.
+func (h *Handler) SetServiceAccountKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetServiceAccountKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if publicKey, err := hex.DecodeString(req.PublicKey); err != nil || len(publicKey) != crypto.X25519KeyLen {
+		http.Error(w, crypto.ErrInvalidPublicKey.Error(), http.StatusBadRequest)
+		return
+	}
+
+	removed, err := h.DB.SetServiceAccountPublicKey(r.Context(), id, req.PublicKey)
+	if err != nil {
+		if errors.Is(err, db.ErrServiceAccountNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"removed_grants": removed})
+
+	setBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "SET_SERVICE_ACCOUNT_KEY", "SERVICE_ACCOUNT", id, map[string]interface{}{
+		"removed_grants": removed,
+		"set_by":         setBy,
+		"ip":             r.RemoteAddr,
+	})
+}
+
+// ListProjectServiceAccounts returns the service accounts holding a project's data key.
+func (h *Handler) ListProjectServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.DB.GetProjectServiceAccountAccess(r.Context(), projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.ServiceAccountProjectAccess{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GrantServiceAccountProjectAccess stores a project data key sealed client-side to a service account's
+// public key, so a machine holding the private key can decrypt the project's secrets. The account still
+// needs a token scoped to the project to fetch them.
+func (h *Handler) GrantServiceAccountProjectAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var req GrantServiceAccountAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ServiceAccountID == uuid.Nil {
+		http.Error(w, "service_account_id is required", http.StatusBadRequest)
+		return
+	}
+	if !isSealedKey(req.WrappedDataKey) {
+		http.Error(w, "wrapped_data_key must be sealed to the service account's public key", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.DB.GetProjectByID(r.Context(), projectID); err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	account, err := h.DB.GetServiceAccount(r.Context(), req.ServiceAccountID)
+	if err != nil {
+		http.Error(w, "Service account not found", http.StatusNotFound)
+		return
+	}
+	if account.PublicKey == "" {
+		http.Error(w, "Service account has no keypair", http.StatusConflict)
+		return
+	}
+
+	grantedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+
+	if err := h.DB.GrantServiceAccountProjectAccess(r.Context(), req.ServiceAccountID, projectID, req.WrappedDataKey, grantedBy); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "GRANT_SERVICE_ACCOUNT_ACCESS", "PROJECT", projectID, map[string]interface{}{
+		"service_account_id": req.ServiceAccountID,
+		"granted_by":         grantedBy,
+		"ip":                 r.RemoteAddr,
+	})
+}
+
+// RevokeServiceAccountProjectAccess removes the project data key sealed to a service account.
+func (h *Handler) RevokeServiceAccountProjectAccess(w http.ResponseWriter, r *http.Request) {
+	projectID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+	accountID, err := uuid.Parse(chi.URLParam(r, "account"))
+	if err != nil {
+		http.Error(w, "Invalid service account ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RevokeServiceAccountProjectAccess(r.Context(), accountID, projectID); err != nil {
+		if errors.Is(err, db.ErrAccessNotFound) {
+			http.Error(w, "Service account has no access to this project", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	revokedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "REVOKE_SERVICE_ACCOUNT_ACCESS", "PROJECT", projectID, map[string]interface{}{
+		"service_account_id": accountID,
+		"revoked_by":         revokedBy,
+		"ip":                 r.RemoteAddr,
+	})
+}
+
+// normalizeCIDRs validates an allowlist, turning bare IP addresses into single-address CIDRs.
+func normalizeCIDRs(entries []string) ([]string, error) {
+	cidrs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if ip := net.ParseIP(entry); ip != nil {
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("allowed_ips must contain IP addresses or CIDRs")
+		}
+		cidrs = append(cidrs, network.String())
+	}
+	return cidrs, nil
+}
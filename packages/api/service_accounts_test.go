@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAPIToken(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	accountID := uuid.New()
+	projectID := uuid.New()
+
+	var params db.CreateAPITokenParams
+	mockDB.On("CreateAPIToken", mock.Anything, mock.MatchedBy(func(p db.CreateAPITokenParams) bool {
+		params = p
+		return true
+	})).Return(&models.APIToken{ID: uuid.New(), ServiceAccountID: accountID, Name: "deploy", Permission: models.APITokenRead, ProjectIDs: []uuid.UUID{projectID}}, nil)
+	mockDB.On("LogEvent", mock.Anything, "CREATE_API_TOKEN", "SERVICE_ACCOUNT", accountID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(CreateAPITokenRequest{Name: "deploy", ProjectIDs: []uuid.UUID{projectID}, AllowedIPs: []string{"10.0.0.7", "192.168.1.0/24"}, ExpiresInDays: 30})
+	req, _ := http.NewRequest("POST", "/api/v1/service-accounts/"+accountID.String()+"/tokens", bytes.NewBuffer(body))
+	req = withURLParam(req, "id", accountID.String())
+	rr := httptest.NewRecorder()
+
+	h.CreateAPIToken(rr, withUser(req, adminID))
+
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var resp CreateAPITokenResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.True(t, strings.HasPrefix(resp.Token, auth.APITokenPrefix))
+
+	// Only the hash is stored, and the defaults and allowlist are normalized
+	assert.Equal(t, crypto.HashToken(resp.Token), params.TokenHash)
+	assert.Equal(t, models.APITokenRead, params.Permission)
+	assert.Equal(t, []string{"10.0.0.7/32", "192.168.1.0/24"}, params.AllowedIPs)
+	require.NotNil(t, params.ExpiresAt)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreateAPIToken_Validation(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+	projectID := uuid.New()
+
+	cases := map[string]CreateAPITokenRequest{
+		"missing name":    {ProjectIDs: []uuid.UUID{projectID}},
+		"no projects":     {Name: "deploy"},
+		"bad permission":  {Name: "deploy", ProjectIDs: []uuid.UUID{projectID}, Permission: "admin"},
+		"bad allowlist":   {Name: "deploy", ProjectIDs: []uuid.UUID{projectID}, AllowedIPs: []string{"office"}},
+		"negative expiry": {Name: "deploy", ProjectIDs: []uuid.UUID{projectID}, ExpiresInDays: -1},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			accountID := uuid.New()
+			body, _ := json.Marshal(c)
+			req, _ := http.NewRequest("POST", "/api/v1/service-accounts/"+accountID.String()+"/tokens", bytes.NewBuffer(body))
+			req = withURLParam(req, "id", accountID.String())
+			rr := httptest.NewRecorder()
+
+			h.CreateAPIToken(rr, withUser(req, uuid.New()))
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+func TestCreateServiceAccount_Conflict(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	mockDB.On("CreateServiceAccount", mock.Anything, "ci", "", adminID).Return(nil, db.ErrServiceAccountExists)
+
+	body, _ := json.Marshal(CreateServiceAccountRequest{Name: " ci "})
+	req, _ := http.NewRequest("POST", "/api/v1/service-accounts", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.CreateServiceAccount(rr, withUser(req, adminID))
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestGetProjectKey_ServiceAccount(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	accountID := uuid.New()
+	projectID := uuid.New()
+	otherID := uuid.New()
+	mockDB.On("GetServiceAccountProjectKey", mock.Anything, projectID, accountID).Return("sealed-key", nil)
+	mockDB.On("GetServiceAccountProjectKey", mock.Anything, otherID, accountID).Return("", db.ErrAccessNotFound)
+
+	tokenRequest := func(id uuid.UUID) *http.Request {
+		req, _ := http.NewRequest("GET", "/api/v1/projects/"+id.String()+"/key", nil)
+		req = withUser(withURLParam(req, "id", id.String()), accountID)
+		return req.WithContext(context.WithValue(req.Context(), auth.APITokenKey, &models.APIToken{ServiceAccountID: accountID}))
+	}
+
+	rr := httptest.NewRecorder()
+	h.GetProjectKey(rr, tokenRequest(projectID))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp map[string]string
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, "sealed-key", resp["wrapped_data_key"])
+
+	// Never falls back to user or group grants
+	rr = httptest.NewRecorder()
+	h.GetProjectKey(rr, tokenRequest(otherID))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	mockDB.AssertNotCalled(t, "GetProjectKeyForUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGrantServiceAccountProjectAccess(t *testing.T) {
+	adminID := uuid.New()
+	projectID := uuid.New()
+
+	publicKey, _, err := crypto.GenerateKeyPair()
+	require.NoError(t, err)
+	wrapped, err := vault.WrapDataKeyForUser(hex.EncodeToString(publicKey), projectID, make([]byte, 32))
+	require.NoError(t, err)
+
+	t.Run("seals to the account", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		h := NewHandler(mockDB)
+		account := &models.ServiceAccount{ID: uuid.New(), Name: "ci", PublicKey: hex.EncodeToString(publicKey)}
+
+		mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID}, nil)
+		mockDB.On("GetServiceAccount", mock.Anything, account.ID).Return(account, nil)
+		mockDB.On("GrantServiceAccountProjectAccess", mock.Anything, account.ID, projectID, wrapped, adminID).Return(nil)
+		mockDB.On("LogEvent", mock.Anything, "GRANT_SERVICE_ACCOUNT_ACCESS", "PROJECT", projectID, mock.Anything).Return(nil)
+
+		body, _ := json.Marshal(GrantServiceAccountAccessRequest{ServiceAccountID: account.ID, WrappedDataKey: wrapped})
+		req, _ := http.NewRequest("POST", "/api/v1/projects/"+projectID.String()+"/service-accounts", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+
+		h.GrantServiceAccountProjectAccess(rr, withUser(withURLParam(req, "id", projectID.String()), adminID))
+
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("requires a keypair", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		h := NewHandler(mockDB)
+		account := &models.ServiceAccount{ID: uuid.New(), Name: "legacy"}
+
+		mockDB.On("GetProjectByID", mock.Anything, projectID).Return(&models.Project{ID: projectID}, nil)
+		mockDB.On("GetServiceAccount", mock.Anything, account.ID).Return(account, nil)
+
+		body, _ := json.Marshal(GrantServiceAccountAccessRequest{ServiceAccountID: account.ID, WrappedDataKey: wrapped})
+		req, _ := http.NewRequest("POST", "/api/v1/projects/"+projectID.String()+"/service-accounts", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+
+		h.GrantServiceAccountProjectAccess(rr, withUser(withURLParam(req, "id", projectID.String()), adminID))
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		mockDB.AssertNotCalled(t, "GrantServiceAccountProjectAccess", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestSetServiceAccountKey(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	accountID := uuid.New()
+	publicKey, _, err := crypto.GenerateKeyPair()
+	require.NoError(t, err)
+	mockDB.On("SetServiceAccountPublicKey", mock.Anything, accountID, hex.EncodeToString(publicKey)).Return(int64(2), nil)
+	mockDB.On("LogEvent", mock.Anything, "SET_SERVICE_ACCOUNT_KEY", "SERVICE_ACCOUNT", accountID, mock.Anything).Return(nil)
+
+	for _, key := range []string{"not-hex", hex.EncodeToString(publicKey[:16])} {
+		body, _ := json.Marshal(SetServiceAccountKeyRequest{PublicKey: key})
+		req, _ := http.NewRequest("PUT", "/api/v1/service-accounts/"+accountID.String()+"/key", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		h.SetServiceAccountKey(rr, withUser(withURLParam(req, "id", accountID.String()), uuid.New()))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	}
+
+	body, _ := json.Marshal(SetServiceAccountKeyRequest{PublicKey: hex.EncodeToString(publicKey)})
+	req, _ := http.NewRequest("PUT", "/api/v1/service-accounts/"+accountID.String()+"/key", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.SetServiceAccountKey(rr, withUser(withURLParam(req, "id", accountID.String()), uuid.New()))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp map[string]int64
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, int64(2), resp["removed_grants"])
+	mockDB.AssertExpectations(t)
+}
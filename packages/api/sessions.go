@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const refreshTokenPrefix = "bst_rt_"
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// startSession opens a session for an authenticated user and writes its first access and refresh tokens.
+// It returns the session, or nil after writing an error.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID uuid.UUID, username, role string, clientID *uuid.UUID) *models.Session {
+	tokens, session, err := h.openSession(r, userID, username, role, clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+	return session
+}
+
+// openSession opens a session for an authenticated user and returns its first access and refresh tokens.
+func (h *Handler) openSession(r *http.Request, userID uuid.UUID, username, role string, clientID *uuid.UUID) (*LoginResponse, *models.Session, error) {
+	refreshToken, err := crypto.GenerateToken(refreshTokenPrefix)
+	if err != nil {
+		return nil, nil, errors.New("Could not generate token")
+	}
+
+	session, err := h.DB.CreateSession(r.Context(), userID, crypto.HashToken(refreshToken), r.UserAgent(), r.RemoteAddr, time.Now().Add(auth.SessionTTL))
+	if err != nil {
+		return nil, nil, errors.New("Could not create session")
+	}
+
+	token, err := auth.GenerateToken(userID, username, role, clientID, session.ID)
+	if err != nil {
+		return nil, nil, errors.New("Could not generate token")
+	}
+
+	return &LoginResponse{Token: token, RefreshToken: refreshToken, ExpiresIn: int(auth.AccessTokenTTL.Seconds())}, session, nil
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new refresh token. Each refresh
+// token works once: presenting a used one revokes the session, since it means the token was copied.
+// The access token carries the user's current role, so role changes apply at the next refresh.
+func (h *Handler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, err := crypto.GenerateToken(refreshTokenPrefix)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.DB.RotateRefreshToken(r.Context(), crypto.HashToken(req.RefreshToken), crypto.HashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, db.ErrRefreshTokenReused) && session != nil {
+			h.DB.LogEvent(r.Context(), "REFRESH_TOKEN_REUSE", "USER", session.UserID, map[string]interface{}{
+				"session_id": session.ID,
+				"alert":      true,
+				"ip":         r.RemoteAddr,
+			})
+		}
+		if errors.Is(err, db.ErrRefreshTokenReused) || errors.Is(err, db.ErrSessionNotFound) {
+			http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	username, role := "admin", auth.RoleAdmin
+	var clientID *uuid.UUID
+	if session.UserID != uuid.Nil {
+		user, err := h.DB.GetUserByID(r.Context(), session.UserID)
+		if err != nil {
+			// The account is gone; so is the session
+			h.DB.RevokeSession(r.Context(), session.UserID, session.ID, "user deleted")
+			http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		}
+		username, role, clientID = user.Username, user.Role, user.ClientID
+	}
+
+	token, err := auth.GenerateToken(session.UserID, username, role, clientID, session.ID)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginResponse{Token: token, RefreshToken: refreshToken, ExpiresIn: int(auth.AccessTokenTTL.Seconds())})
+}
+
+// LogoutHandler revokes the session of the request's access token.
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	sessionID, _ := r.Context().Value(auth.SessionKey).(uuid.UUID)
+
+	if err := h.DB.RevokeSession(r.Context(), userID, sessionID, "logout"); err != nil && !errors.Is(err, db.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "LOGOUT", "USER", userID, map[string]interface{}{
+		"session_id": sessionID,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// ListMySessions returns the authenticated user's active sessions, flagging the one making the request.
+func (h *Handler) ListMySessions(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	sessionID, _ := r.Context().Value(auth.SessionKey).(uuid.UUID)
+
+	sessions, err := h.DB.ListSessions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeMySession revokes one of the authenticated user's sessions, e.g. on a lost laptop.
+func (h *Handler) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if err := h.DB.RevokeSession(r.Context(), userID, id, "revoked by user"); err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "REVOKE_SESSION", "USER", userID, map[string]interface{}{
+		"session_id": id,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// RevokeUserSessions revokes every active session of a user, signing them out everywhere.
+func (h *Handler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	revokedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	count, err := h.DB.RevokeUserSessions(r.Context(), userID, "revoked by admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"revoked": count})
+
+	h.DB.LogEvent(r.Context(), "REVOKE_SESSIONS", "USER", userID, map[string]interface{}{
+		"revoked":    count,
+		"revoked_by": revokedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// useSigningKey loads a fresh signing key, as the server does on startup.
+func useSigningKey(t *testing.T) {
+	t.Helper()
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+	key, err := auth.NewSigningKey(auth.AlgEdDSA)
+	require.NoError(t, err)
+	store := new(MockDatabase)
+	store.On("ListSigningKeys", mock.Anything).Return([]models.SigningKey{*key}, nil)
+	require.NoError(t, auth.LoadSigningKeys(context.Background(), store))
+}
+
+func TestLoginHandler_StartsSession(t *testing.T) {
+	useSigningKey(t)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	hash, salt, err := crypto.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+	session := &models.Session{ID: uuid.New(), UserID: user.ID}
+
+	mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, hash, salt, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, user.ID).Return(&models.UserTOTP{}, nil)
+	mockDB.On("RoleRequiresMFA", mock.Anything, auth.RoleCollaborator).Return(false, nil)
+	mockDB.On("ClearLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(true, nil)
+	mockDB.On("CreateSession", mock.Anything, user.ID, mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything).Return(session, nil)
+
+	body, _ := json.Marshal(LoginRequest{Username: "alice", Password: "correct horse"})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.LoginHandler(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.NotEmpty(t, resp.RefreshToken)
+	assert.Equal(t, int(auth.AccessTokenTTL.Seconds()), resp.ExpiresIn)
+
+	// Only the hash of the refresh token is stored
+	mockDB.AssertCalled(t, "CreateSession", mock.Anything, user.ID, crypto.HashToken(resp.RefreshToken), mock.Anything, mock.Anything, mock.Anything)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(resp.Token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID.String(), claims["jti"])
+}
+
+func TestRefreshHandler(t *testing.T) {
+	useSigningKey(t)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleAuditor}
+	session := &models.Session{ID: uuid.New(), UserID: user.ID}
+
+	mockDB.On("RotateRefreshToken", mock.Anything, crypto.HashToken("bst_rt_old"), mock.AnythingOfType("string")).Return(session, nil)
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: "bst_rt_old"})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.RefreshHandler(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.NotEqual(t, "bst_rt_old", resp.RefreshToken)
+	mockDB.AssertCalled(t, "RotateRefreshToken", mock.Anything, crypto.HashToken("bst_rt_old"), crypto.HashToken(resp.RefreshToken))
+
+	// The new access token carries the user's current role
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(resp.Token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleAuditor, claims["role"])
+	assert.Equal(t, session.ID.String(), claims["jti"])
+}
+
+func TestRefreshHandler_ReuseRevokesSession(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	session := &models.Session{ID: uuid.New(), UserID: uuid.New()}
+	mockDB.On("RotateRefreshToken", mock.Anything, crypto.HashToken("bst_rt_stolen"), mock.Anything).Return(session, db.ErrRefreshTokenReused)
+	mockDB.On("LogEvent", mock.Anything, "REFRESH_TOKEN_REUSE", "USER", session.UserID, mock.MatchedBy(func(m map[string]interface{}) bool {
+		return m["alert"] == true && m["session_id"] == session.ID
+	})).Return(nil)
+
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: "bst_rt_stolen"})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.RefreshHandler(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestLogoutHandler(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	sessionID := uuid.New()
+	mockDB.On("RevokeSession", mock.Anything, userID, sessionID, "logout").Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "LOGOUT", "USER", userID, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("POST", "/api/v1/auth/logout", nil)
+	req = withUser(req, userID)
+	req = req.WithContext(context.WithValue(req.Context(), auth.SessionKey, sessionID))
+	rr := httptest.NewRecorder()
+
+	h.LogoutHandler(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	userID := uuid.New()
+	mockDB.On("RevokeUserSessions", mock.Anything, userID, "revoked by admin").Return(int64(3), nil)
+	mockDB.On("LogEvent", mock.Anything, "REVOKE_SESSIONS", "USER", userID, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/users/"+userID.String()+"/sessions", nil)
+	req = withURLParam(req, "user", userID.String())
+	rr := httptest.NewRecorder()
+
+	h.RevokeUserSessions(rr, withUser(req, adminID))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"revoked":3}`, rr.Body.String())
+	mockDB.AssertExpectations(t)
+}
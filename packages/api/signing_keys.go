@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+)
+
+type RotateSigningKeyRequest struct {
+	Algorithm string `json:"algorithm,omitempty"` // EdDSA (default) or ES256
+}
+
+// JWKSHandler serves the public keys that verify access tokens, so other services can check them.
+func (h *Handler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.PublicJWKS())
+}
+
+// ListSigningKeys returns the signing keys that still verify tokens, without their private keys.
+func (h *Handler) ListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.DB.ListSigningKeys(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []models.SigningKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RotateSigningKey generates a new signing key and makes it current. The previous key keeps verifying
+// tokens for auth.SigningKeyRetention, so nobody is signed out.
+func (h *Handler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	var req RotateSigningKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = auth.AlgEdDSA
+	}
+	if !auth.ValidSigningAlgorithm(req.Algorithm) {
+		http.Error(w, "algorithm must be EdDSA or ES256", http.StatusBadRequest)
+		return
+	}
+
+	key, err := auth.NewSigningKey(req.Algorithm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.DB.RotateSigningKey(r.Context(), key, auth.SigningKeyRetention); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Other instances pick the new key up when they see its kid or on their next reload
+	if err := auth.LoadSigningKeys(r.Context(), h.DB); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+
+	rotatedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "ROTATE_SIGNING_KEY", "SIGNING_KEY", uuid.Nil, map[string]interface{}{
+		"kid":        key.KID,
+		"algorithm":  key.Algorithm,
+		"rotated_by": rotatedBy,
+		"ip":         r.RemoteAddr,
+	})
+}
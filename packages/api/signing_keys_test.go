@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateSigningKey(t *testing.T) {
+	useSigningKey(t)
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	var stored *models.SigningKey
+	mockDB.On("RotateSigningKey", mock.Anything, mock.Anything, auth.SigningKeyRetention).
+		Run(func(args mock.Arguments) {
+			stored = args.Get(1).(*models.SigningKey)
+			mockDB.On("ListSigningKeys", mock.Anything).Return([]models.SigningKey{*stored}, nil)
+		}).
+		Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "ROTATE_SIGNING_KEY", "SIGNING_KEY", uuid.Nil, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(RotateSigningKeyRequest{Algorithm: auth.AlgES256})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/signing-keys/rotate", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.RotateSigningKey(rr, withUser(req, uuid.New()))
+
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.NotContains(t, rr.Body.String(), stored.PrivateKey, "the private key must never be returned")
+
+	// The new key is served in the JWKS right away
+	rr = httptest.NewRecorder()
+	h.JWKSHandler(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	var jwks auth.JWKSet
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&jwks))
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, stored.KID, jwks.Keys[0].KeyID)
+	assert.Equal(t, "EC", jwks.Keys[0].KeyType)
+}
+
+func TestRotateSigningKey_InvalidAlgorithm(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	body, _ := json.Marshal(RotateSigningKeyRequest{Algorithm: "HS256"})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/signing-keys/rotate", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.RotateSigningKey(rr, withUser(req, uuid.New()))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/dcdavidev/bastion/packages/db"
 	"github.com/dcdavidev/bastion/packages/models"
@@ -41,6 +42,64 @@ func (m *MockDatabase) CreateUser(ctx context.Context, u, e, h, s, r string) (*m
 func (m *MockDatabase) UpdateUserPassword(ctx context.Context, userID uuid.UUID, hash, salt string) error {
 	return m.Called(ctx, userID, hash, salt).Error(0)
 }
+func (m *MockDatabase) SetUserKeyPair(ctx context.Context, userID uuid.UUID, keys *models.UserKeyPair) error {
+	return m.Called(ctx, userID, keys).Error(0)
+}
+func (m *MockDatabase) GetUserKeyPair(ctx context.Context, userID uuid.UUID) (*models.UserKeyPair, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UserKeyPair), args.Error(1)
+}
+func (m *MockDatabase) DeleteUserKeyPair(ctx context.Context, userID uuid.UUID) error {
+	return m.Called(ctx, userID).Error(0)
+}
+func (m *MockDatabase) CreateInvitation(ctx context.Context, email string, projectID *uuid.UUID, projectRole string, clientID *uuid.UUID, createdBy uuid.UUID, tokenHash string, expiresAt time.Time) (*models.Invitation, error) {
+	args := m.Called(ctx, email, projectID, projectRole, clientID, createdBy, tokenHash, expiresAt)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Invitation), args.Error(1)
+}
+func (m *MockDatabase) GetInvitation(ctx context.Context, id uuid.UUID) (*models.Invitation, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Invitation), args.Error(1)
+}
+func (m *MockDatabase) GetPendingInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	args := m.Called(ctx, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Invitation), args.Error(1)
+}
+func (m *MockDatabase) ListInvitations(ctx context.Context) ([]models.Invitation, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Invitation), args.Error(1)
+}
+func (m *MockDatabase) RevokeInvitation(ctx context.Context, id uuid.UUID) error {
+	return m.Called(ctx, id).Error(0)
+}
+func (m *MockDatabase) AcceptInvitation(ctx context.Context, params db.AcceptInvitationParams) (*models.Invitation, *models.User, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*models.Invitation), args.Get(1).(*models.User), args.Error(2)
+}
+func (m *MockDatabase) CompleteInvitationGrant(ctx context.Context, id uuid.UUID, wrappedKey string, grantedBy uuid.UUID) (*models.Invitation, error) {
+	args := m.Called(ctx, id, wrappedKey, grantedBy)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Invitation), args.Error(1)
+}
 func (m *MockDatabase) GetUserByUsername(ctx context.Context, u string) (*models.User, string, string, error) {
 	args := m.Called(ctx, u)
 	if args.Get(0) == nil {
@@ -62,8 +121,548 @@ func (m *MockDatabase) GetUserByID(ctx context.Context, id uuid.UUID) (*models.U
 	}
 	return args.Get(0).(*models.User), args.Error(1)
 }
-func (m *MockDatabase) GrantProjectAccess(ctx context.Context, u, p uuid.UUID, k string) error {
-	return m.Called(ctx, u, p, k).Error(0)
+func (m *MockDatabase) SetUserRole(ctx context.Context, u uuid.UUID, role string) error {
+	return m.Called(ctx, u, role).Error(0)
+}
+func (m *MockDatabase) GrantProjectAccess(ctx context.Context, u, p uuid.UUID, k, role string, by uuid.UUID, exp *time.Time) error {
+	return m.Called(ctx, u, p, k, role, by, exp).Error(0)
+}
+func (m *MockDatabase) DeleteExpiredGrants(ctx context.Context) ([]models.ProjectAccess, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.ProjectAccess), args.Error(1)
+}
+func (m *MockDatabase) GetProjectRole(ctx context.Context, u, p uuid.UUID) (string, error) {
+	args := m.Called(ctx, u, p)
+	return args.String(0), args.Error(1)
+}
+func (m *MockDatabase) RevokeProjectAccess(ctx context.Context, u, p uuid.UUID) error {
+	return m.Called(ctx, u, p).Error(0)
+}
+func (m *MockDatabase) GetProjectAccess(ctx context.Context, p uuid.UUID) ([]models.ProjectAccess, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).([]models.ProjectAccess), args.Error(1)
+}
+
+// Sessions
+func (m *MockDatabase) CreateSession(ctx context.Context, u uuid.UUID, hash, ua, ip string, exp time.Time) (*models.Session, error) {
+	args := m.Called(ctx, u, hash, ua, ip, exp)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Session), args.Error(1)
+}
+func (m *MockDatabase) RotateRefreshToken(ctx context.Context, hash, newHash string) (*models.Session, error) {
+	args := m.Called(ctx, hash, newHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Session), args.Error(1)
+}
+func (m *MockDatabase) SessionActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+func (m *MockDatabase) ListSessions(ctx context.Context, u uuid.UUID) ([]models.Session, error) {
+	args := m.Called(ctx, u)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Session), args.Error(1)
+}
+func (m *MockDatabase) RevokeSession(ctx context.Context, u, id uuid.UUID, reason string) error {
+	args := m.Called(ctx, u, id, reason)
+	return args.Error(0)
+}
+func (m *MockDatabase) RevokeUserSessions(ctx context.Context, u uuid.UUID, reason string) (int64, error) {
+	args := m.Called(ctx, u, reason)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDatabase) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// Two-factor authentication
+func (m *MockDatabase) GetUserTOTP(ctx context.Context, userID uuid.UUID) (*models.UserTOTP, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UserTOTP), args.Error(1)
+}
+func (m *MockDatabase) SetPendingTOTP(ctx context.Context, userID uuid.UUID, sealedSecret string) error {
+	args := m.Called(ctx, userID, sealedSecret)
+	return args.Error(0)
+}
+func (m *MockDatabase) EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	args := m.Called(ctx, userID, step, recoveryCodeHashes)
+	return args.Error(0)
+}
+func (m *MockDatabase) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+func (m *MockDatabase) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	args := m.Called(ctx, userID, step)
+	return args.Error(0)
+}
+func (m *MockDatabase) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (int, error) {
+	args := m.Called(ctx, userID, codeHash)
+	return args.Int(0), args.Error(1)
+}
+func (m *MockDatabase) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	args := m.Called(ctx, userID, codeHashes)
+	return args.Error(0)
+}
+func (m *MockDatabase) GetMFAStatus(ctx context.Context, userID uuid.UUID) (*models.MFAStatus, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.MFAStatus), args.Error(1)
+}
+func (m *MockDatabase) CreateMFAChallenge(ctx context.Context, userID uuid.UUID, tokenHash, purpose string, expiresAt time.Time) (*models.MFAChallenge, error) {
+	args := m.Called(ctx, userID, tokenHash, purpose, expiresAt)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.MFAChallenge), args.Error(1)
+}
+func (m *MockDatabase) GetMFAChallenge(ctx context.Context, tokenHash string) (*models.MFAChallenge, error) {
+	args := m.Called(ctx, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.MFAChallenge), args.Error(1)
+}
+func (m *MockDatabase) FailMFAChallenge(ctx context.Context, id uuid.UUID) (int, error) {
+	args := m.Called(ctx, id)
+	return args.Int(0), args.Error(1)
+}
+func (m *MockDatabase) ConsumeMFAChallenge(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+func (m *MockDatabase) DeleteExpiredMFAChallenges(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDatabase) ListMFARequiredRoles(ctx context.Context) ([]string, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+func (m *MockDatabase) SetMFARequiredRoles(ctx context.Context, roles []string, setBy uuid.UUID) error {
+	args := m.Called(ctx, roles, setBy)
+	return args.Error(0)
+}
+func (m *MockDatabase) RoleRequiresMFA(ctx context.Context, role string) (bool, error) {
+	args := m.Called(ctx, role)
+	return args.Bool(0), args.Error(1)
+}
+
+// Login throttling
+func (m *MockDatabase) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.LoginThrottle), args.Error(1)
+}
+func (m *MockDatabase) RecordLoginFailure(ctx context.Context, key string, userID *uuid.UUID, window time.Duration) (int, error) {
+	args := m.Called(ctx, key, userID, window)
+	return args.Int(0), args.Error(1)
+}
+func (m *MockDatabase) LockLogin(ctx context.Context, key string, until time.Time) error {
+	args := m.Called(ctx, key, until)
+	return args.Error(0)
+}
+func (m *MockDatabase) ClearLoginThrottle(ctx context.Context, key string) (bool, error) {
+	args := m.Called(ctx, key)
+	return args.Bool(0), args.Error(1)
+}
+func (m *MockDatabase) ListLoginLockouts(ctx context.Context) ([]models.LoginLockout, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.LoginLockout), args.Error(1)
+}
+func (m *MockDatabase) DeleteStaleLoginThrottles(ctx context.Context, window time.Duration) (int64, error) {
+	args := m.Called(ctx, window)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// Single sign-on
+func (m *MockDatabase) CreateOIDCLogin(ctx context.Context, stateHash string, login *models.OIDCLogin) error {
+	args := m.Called(ctx, stateHash, login)
+	return args.Error(0)
+}
+func (m *MockDatabase) TakeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error) {
+	args := m.Called(ctx, stateHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OIDCLogin), args.Error(1)
+}
+func (m *MockDatabase) CompleteOIDCLogin(ctx context.Context, id, userID uuid.UUID, loginCodeHash string, expiresAt time.Time) error {
+	args := m.Called(ctx, id, userID, loginCodeHash, expiresAt)
+	return args.Error(0)
+}
+func (m *MockDatabase) RedeemOIDCLoginCode(ctx context.Context, loginCodeHash string) (*models.OIDCLogin, error) {
+	args := m.Called(ctx, loginCodeHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OIDCLogin), args.Error(1)
+}
+func (m *MockDatabase) DeleteExpiredOIDCLogins(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDatabase) GetUserByOIDCIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	args := m.Called(ctx, issuer, subject)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+func (m *MockDatabase) LinkUserOIDCIdentity(ctx context.Context, userID uuid.UUID, issuer, subject string) error {
+	args := m.Called(ctx, userID, issuer, subject)
+	return args.Error(0)
+}
+func (m *MockDatabase) CreateOIDCUser(ctx context.Context, username, email, role, issuer, subject string) (*models.User, error) {
+	args := m.Called(ctx, username, email, role, issuer, subject)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// Signing keys
+func (m *MockDatabase) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SigningKey), args.Error(1)
+}
+func (m *MockDatabase) RotateSigningKey(ctx context.Context, key *models.SigningKey, retention time.Duration) error {
+	args := m.Called(ctx, key, retention)
+	return args.Error(0)
+}
+func (m *MockDatabase) DeleteExpiredSigningKeys(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// Service accounts and API tokens
+func (m *MockDatabase) CreateServiceAccount(ctx context.Context, name, desc string, by uuid.UUID) (*models.ServiceAccount, error) {
+	args := m.Called(ctx, name, desc, by)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ServiceAccount), args.Error(1)
+}
+func (m *MockDatabase) ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.ServiceAccount), args.Error(1)
+}
+func (m *MockDatabase) GetServiceAccount(ctx context.Context, id uuid.UUID) (*models.ServiceAccount, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ServiceAccount), args.Error(1)
+}
+func (m *MockDatabase) DeleteServiceAccount(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+func (m *MockDatabase) SetServiceAccountPublicKey(ctx context.Context, id uuid.UUID, pk string) (int64, error) {
+	args := m.Called(ctx, id, pk)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDatabase) GrantServiceAccountProjectAccess(ctx context.Context, sa, p uuid.UUID, key string, by uuid.UUID) error {
+	args := m.Called(ctx, sa, p, key, by)
+	return args.Error(0)
+}
+func (m *MockDatabase) RevokeServiceAccountProjectAccess(ctx context.Context, sa, p uuid.UUID) error {
+	args := m.Called(ctx, sa, p)
+	return args.Error(0)
+}
+func (m *MockDatabase) GetProjectServiceAccountAccess(ctx context.Context, p uuid.UUID) ([]models.ServiceAccountProjectAccess, error) {
+	args := m.Called(ctx, p)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.ServiceAccountProjectAccess), args.Error(1)
+}
+func (m *MockDatabase) GetServiceAccountProjectKey(ctx context.Context, p, sa uuid.UUID) (string, error) {
+	args := m.Called(ctx, p, sa)
+	return args.String(0), args.Error(1)
+}
+func (m *MockDatabase) CreateAPIToken(ctx context.Context, params db.CreateAPITokenParams) (*models.APIToken, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIToken), args.Error(1)
+}
+func (m *MockDatabase) ListAPITokens(ctx context.Context, sa uuid.UUID) ([]models.APIToken, error) {
+	args := m.Called(ctx, sa)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.APIToken), args.Error(1)
+}
+func (m *MockDatabase) RevokeAPIToken(ctx context.Context, sa, id uuid.UUID) error {
+	args := m.Called(ctx, sa, id)
+	return args.Error(0)
+}
+func (m *MockDatabase) AuthenticateAPIToken(ctx context.Context, hash string) (*models.APIToken, error) {
+	args := m.Called(ctx, hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIToken), args.Error(1)
+}
+
+// Protected projects and change sets
+func (m *MockDatabase) SetProjectProtected(ctx context.Context, id uuid.UUID, protected bool) error {
+	args := m.Called(ctx, id, protected)
+	return args.Error(0)
+}
+func (m *MockDatabase) CreateChangeSet(ctx context.Context, p uuid.UUID, d string, s []models.ChangeSetSecret, by uuid.UUID) (*models.ChangeSet, error) {
+	args := m.Called(ctx, p, d, s, by)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ChangeSet), args.Error(1)
+}
+func (m *MockDatabase) GetChangeSet(ctx context.Context, id uuid.UUID) (*models.ChangeSet, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ChangeSet), args.Error(1)
+}
+func (m *MockDatabase) ListChangeSets(ctx context.Context, p uuid.UUID, status string) ([]models.ChangeSet, error) {
+	args := m.Called(ctx, p, status)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.ChangeSet), args.Error(1)
+}
+func (m *MockDatabase) ApproveChangeSet(ctx context.Context, id, by uuid.UUID, note string) ([]models.Secret, error) {
+	args := m.Called(ctx, id, by, note)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Secret), args.Error(1)
+}
+func (m *MockDatabase) RejectChangeSet(ctx context.Context, id, by uuid.UUID, note string) error {
+	args := m.Called(ctx, id, by, note)
+	return args.Error(0)
+}
+
+// Break-glass access
+func (m *MockDatabase) CreateBreakGlassEscrow(ctx context.Context, pub string, by uuid.UUID, wrapped string) (*models.BreakGlassEscrow, error) {
+	args := m.Called(ctx, pub, by, wrapped)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.BreakGlassEscrow), args.Error(1)
+}
+func (m *MockDatabase) GetBreakGlassEscrow(ctx context.Context) (*models.BreakGlassEscrow, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.BreakGlassEscrow), args.Error(1)
+}
+func (m *MockDatabase) ListBreakGlassResponders(ctx context.Context) ([]models.BreakGlassResponder, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.BreakGlassResponder), args.Error(1)
+}
+func (m *MockDatabase) GetBreakGlassResponder(ctx context.Context, u uuid.UUID) (*models.BreakGlassResponder, error) {
+	args := m.Called(ctx, u)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.BreakGlassResponder), args.Error(1)
+}
+func (m *MockDatabase) AddBreakGlassResponder(ctx context.Context, u uuid.UUID, wrapped string, by uuid.UUID) error {
+	args := m.Called(ctx, u, wrapped, by)
+	return args.Error(0)
+}
+func (m *MockDatabase) RemoveBreakGlassResponder(ctx context.Context, u uuid.UUID) error {
+	args := m.Called(ctx, u)
+	return args.Error(0)
+}
+func (m *MockDatabase) ArmBreakGlass(ctx context.Context, p uuid.UUID, wrapped string, by uuid.UUID) error {
+	args := m.Called(ctx, p, wrapped, by)
+	return args.Error(0)
+}
+func (m *MockDatabase) DisarmBreakGlass(ctx context.Context, p uuid.UUID) error {
+	args := m.Called(ctx, p)
+	return args.Error(0)
+}
+func (m *MockDatabase) ListArmedProjects(ctx context.Context) ([]uuid.UUID, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]uuid.UUID), args.Error(1)
+}
+func (m *MockDatabase) StartBreakGlassSession(ctx context.Context, p, u uuid.UUID, j string, exp time.Time) (*models.BreakGlassSession, error) {
+	args := m.Called(ctx, p, u, j, exp)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.BreakGlassSession), args.Error(1)
+}
+func (m *MockDatabase) ListBreakGlassSessions(ctx context.Context, unacknowledgedOnly bool) ([]models.BreakGlassSession, error) {
+	args := m.Called(ctx, unacknowledgedOnly)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.BreakGlassSession), args.Error(1)
+}
+func (m *MockDatabase) AcknowledgeBreakGlassSession(ctx context.Context, id, by uuid.UUID, note string) (*models.BreakGlassSession, error) {
+	args := m.Called(ctx, id, by, note)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.BreakGlassSession), args.Error(1)
+}
+func (m *MockDatabase) GetBreakGlassProjectKey(ctx context.Context, p, u uuid.UUID) (*models.GroupProjectKey, error) {
+	args := m.Called(ctx, p, u)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.GroupProjectKey), args.Error(1)
+}
+
+// Access requests
+func (m *MockDatabase) CreateAccessRequest(ctx context.Context, p, u uuid.UUID, role, reason string, hours int) (*models.AccessRequest, error) {
+	args := m.Called(ctx, p, u, role, reason, hours)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.AccessRequest), args.Error(1)
+}
+func (m *MockDatabase) GetAccessRequest(ctx context.Context, id uuid.UUID) (*models.AccessRequest, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.AccessRequest), args.Error(1)
+}
+func (m *MockDatabase) ListAccessRequests(ctx context.Context, u *uuid.UUID, status string) ([]models.AccessRequest, error) {
+	args := m.Called(ctx, u, status)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.AccessRequest), args.Error(1)
+}
+func (m *MockDatabase) ApproveAccessRequest(ctx context.Context, id uuid.UUID, k string, by uuid.UUID, note string) (*models.AccessRequest, error) {
+	args := m.Called(ctx, id, k, by, note)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.AccessRequest), args.Error(1)
+}
+func (m *MockDatabase) DenyAccessRequest(ctx context.Context, id uuid.UUID, by uuid.UUID, note string) (*models.AccessRequest, error) {
+	args := m.Called(ctx, id, by, note)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.AccessRequest), args.Error(1)
+}
+
+// Groups
+func (m *MockDatabase) CreateGroup(ctx context.Context, name, publicKey string, createdBy uuid.UUID, wrappedGroupKey string) (*models.Group, error) {
+	args := m.Called(ctx, name, publicKey, createdBy, wrappedGroupKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Group), args.Error(1)
+}
+func (m *MockDatabase) ListGroups(ctx context.Context) ([]models.Group, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Group), args.Error(1)
+}
+func (m *MockDatabase) ListGroupsForUser(ctx context.Context, userID uuid.UUID) ([]models.Group, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Group), args.Error(1)
+}
+func (m *MockDatabase) GetGroup(ctx context.Context, id uuid.UUID) (*models.Group, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Group), args.Error(1)
+}
+func (m *MockDatabase) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	return m.Called(ctx, id).Error(0)
+}
+func (m *MockDatabase) ListGroupMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error) {
+	args := m.Called(ctx, groupID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.GroupMember), args.Error(1)
+}
+func (m *MockDatabase) GetGroupMember(ctx context.Context, groupID, userID uuid.UUID) (*models.GroupMember, error) {
+	args := m.Called(ctx, groupID, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.GroupMember), args.Error(1)
+}
+func (m *MockDatabase) AddGroupMember(ctx context.Context, groupID, userID uuid.UUID, wrappedGroupKey string, addedBy uuid.UUID) error {
+	return m.Called(ctx, groupID, userID, wrappedGroupKey, addedBy).Error(0)
+}
+func (m *MockDatabase) RemoveGroupMember(ctx context.Context, groupID, userID uuid.UUID) error {
+	return m.Called(ctx, groupID, userID).Error(0)
+}
+func (m *MockDatabase) GrantGroupProjectAccess(ctx context.Context, groupID, projectID uuid.UUID, wrappedKey, role string, grantedBy uuid.UUID) error {
+	return m.Called(ctx, groupID, projectID, wrappedKey, role, grantedBy).Error(0)
+}
+func (m *MockDatabase) RevokeGroupProjectAccess(ctx context.Context, groupID, projectID uuid.UUID) error {
+	return m.Called(ctx, groupID, projectID).Error(0)
+}
+func (m *MockDatabase) GetProjectGroupAccess(ctx context.Context, projectID uuid.UUID) ([]models.GroupProjectAccess, error) {
+	args := m.Called(ctx, projectID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.GroupProjectAccess), args.Error(1)
+}
+func (m *MockDatabase) GetGroupProjectKeyForUser(ctx context.Context, projectID, userID uuid.UUID) (*models.GroupProjectKey, error) {
+	args := m.Called(ctx, projectID, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.GroupProjectKey), args.Error(1)
 }
 
 // WebAuthn
@@ -77,6 +676,30 @@ func (m *MockDatabase) GetWebAuthnCredentials(ctx context.Context, userID uuid.U
 func (m *MockDatabase) UpdateWebAuthnCredential(ctx context.Context, cred *models.WebAuthnCredential) error {
 	return m.Called(ctx, cred).Error(0)
 }
+func (m *MockDatabase) RenameWebAuthnCredential(ctx context.Context, userID, publicID uuid.UUID, name string) error {
+	return m.Called(ctx, userID, publicID, name).Error(0)
+}
+func (m *MockDatabase) DeleteWebAuthnCredential(ctx context.Context, userID, publicID uuid.UUID, keepOne bool) (*models.WebAuthnCredential, error) {
+	args := m.Called(ctx, userID, publicID, keepOne)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WebAuthnCredential), args.Error(1)
+}
+func (m *MockDatabase) CreateWebAuthnCeremony(ctx context.Context, c *models.WebAuthnCeremony) error {
+	return m.Called(ctx, c).Error(0)
+}
+func (m *MockDatabase) TakeWebAuthnCeremony(ctx context.Context, id, kind string) (*models.WebAuthnCeremony, error) {
+	args := m.Called(ctx, id, kind)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WebAuthnCeremony), args.Error(1)
+}
+func (m *MockDatabase) DeleteExpiredWebAuthnCeremonies(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
 
 func (m *MockDatabase) GetVaultConfig(ctx context.Context) (*db.VaultConfig, error) {
 	args := m.Called(ctx)
@@ -88,9 +711,15 @@ func (m *MockDatabase) GetVaultConfig(ctx context.Context) (*db.VaultConfig, err
 func (m *MockDatabase) InitializeVault(ctx context.Context, w, s string) error {
 	return m.Called(ctx, w, s).Error(0)
 }
+func (m *MockDatabase) DisallowLegacySecrets(ctx context.Context) error {
+	return m.Called(ctx).Error(0)
+}
 func (m *MockDatabase) UpdateVaultConfig(ctx context.Context, w, s string) error {
 	return m.Called(ctx, w, s).Error(0)
 }
+func (m *MockDatabase) RotateMasterKey(ctx context.Context, rotation db.MasterKeyRotation) error {
+	return m.Called(ctx, rotation).Error(0)
+}
 func (m *MockDatabase) CreateClient(ctx context.Context, n string) (*models.Client, error) {
 	args := m.Called(ctx, n)
 	if args.Get(0) == nil {
@@ -112,6 +741,16 @@ func (m *MockDatabase) GetClientByID(ctx context.Context, id uuid.UUID) (*models
 func (m *MockDatabase) DeleteClient(ctx context.Context, id uuid.UUID) error {
 	return m.Called(ctx, id).Error(0)
 }
+func (m *MockDatabase) ListClientUsers(ctx context.Context, clientID uuid.UUID) ([]models.User, error) {
+	args := m.Called(ctx, clientID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.User), args.Error(1)
+}
+func (m *MockDatabase) SetUserClient(ctx context.Context, userID uuid.UUID, clientID *uuid.UUID) error {
+	return m.Called(ctx, userID, clientID).Error(0)
+}
 func (m *MockDatabase) CreateProject(ctx context.Context, c uuid.UUID, n, k string) (*models.Project, error) {
 	args := m.Called(ctx, c, n, k)
 	if args.Get(0) == nil {
@@ -123,6 +762,14 @@ func (m *MockDatabase) GetProjectsByClient(ctx context.Context, c uuid.UUID) ([]
 	args := m.Called(ctx, c)
 	return args.Get(0).([]models.Project), args.Error(1)
 }
+func (m *MockDatabase) GetGrantedProjectsByClient(ctx context.Context, u, c uuid.UUID) ([]models.Project, error) {
+	args := m.Called(ctx, u, c)
+	return args.Get(0).([]models.Project), args.Error(1)
+}
+func (m *MockDatabase) GetAllProjects(ctx context.Context) ([]models.Project, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]models.Project), args.Error(1)
+}
 func (m *MockDatabase) GetProjectByID(ctx context.Context, id uuid.UUID) (*models.Project, error) {
 	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
@@ -137,8 +784,15 @@ func (m *MockDatabase) GetProjectKeyForUser(ctx context.Context, p, u uuid.UUID,
 	args := m.Called(ctx, p, u, a)
 	return args.String(0), args.Error(1)
 }
-func (m *MockDatabase) CreateSecret(ctx context.Context, p uuid.UUID, k, v string) (*models.Secret, error) {
-	args := m.Called(ctx, p, k, v)
+func (m *MockDatabase) RotateProjectKey(ctx context.Context, p uuid.UUID, rot db.ProjectKeyRotation) ([]uuid.UUID, error) {
+	args := m.Called(ctx, p, rot)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]uuid.UUID), args.Error(1)
+}
+func (m *MockDatabase) CreateSecret(ctx context.Context, p uuid.UUID, k, v string, ver int) (*models.Secret, error) {
+	args := m.Called(ctx, p, k, v, ver)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -152,6 +806,13 @@ func (m *MockDatabase) GetSecretHistory(ctx context.Context, p uuid.UUID, k stri
 	args := m.Called(ctx, p, k)
 	return args.Get(0).([]models.Secret), args.Error(1)
 }
+func (m *MockDatabase) GetAllSecretVersions(ctx context.Context, p uuid.UUID) ([]models.Secret, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).([]models.Secret), args.Error(1)
+}
+func (m *MockDatabase) UpdateSecretValues(ctx context.Context, p uuid.UUID, values map[uuid.UUID]string) error {
+	return m.Called(ctx, p, values).Error(0)
+}
 func (m *MockDatabase) LogEvent(ctx context.Context, a, t string, tid uuid.UUID, meta map[string]interface{}) error {
 	return m.Called(ctx, a, t, tid, meta).Error(0)
 }
@@ -174,6 +835,7 @@ func TestStatusHandler(t *testing.T) {
 	mockDB.On("Ping", mock.Anything).Return(nil)
 	mockDB.On("GetMigrationStatus").Return(uint(5), false, nil)
 	mockDB.On("HasAdmin", mock.Anything).Return(true, nil)
+	mockDB.On("GetVaultConfig", mock.Anything).Return(&db.VaultConfig{LegacySecrets: true}, nil)
 
 	req, _ := http.NewRequest("GET", "/api/v1/status", nil)
 	rr := httptest.NewRecorder()
@@ -191,6 +853,7 @@ func TestStatusHandler(t *testing.T) {
 	assert.Equal(t, uint(5), resp.Migrations.CurrentVersion)
 	assert.False(t, resp.Migrations.HasPending)
 	assert.True(t, resp.HasAdmin)
+	assert.True(t, resp.LegacySecrets)
 
 	mockDB.AssertExpectations(t)
 }
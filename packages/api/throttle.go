@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// adminThrottleKey counts the failed logins of the environment admin.
+const adminThrottleKey = "admin"
+
+var dummyPassword struct {
+	once       sync.Once
+	hash, salt string
+}
+
+// burnPasswordCheck verifies a password against a throwaway hash, so that a login for an unknown account,
+// or one without a password, takes as long as a wrong password and cannot be told apart by timing.
+func burnPasswordCheck(password string) {
+	dummyPassword.once.Do(func() {
+		dummyPassword.hash, dummyPassword.salt, _ = crypto.HashPassword("bastion-dummy-password")
+	})
+	crypto.VerifyPassword(password, dummyPassword.hash, dummyPassword.salt)
+}
+
+// loginThrottleKey names the counter of failed logins for an account. An identifier that matches no
+// account gets a counter of its own, so it locks out like a real account and gives nothing away.
+func loginThrottleKey(user *models.User, identifier string) (string, *uuid.UUID) {
+	switch {
+	case user != nil:
+		return userThrottleKey(user.ID), &user.ID
+	case identifier == "":
+		return adminThrottleKey, nil
+	default:
+		return "identifier:" + crypto.HashToken(strings.ToLower(strings.TrimSpace(identifier))), nil
+	}
+}
+
+func userThrottleKey(userID uuid.UUID) string {
+	if userID == uuid.Nil {
+		return adminThrottleKey
+	}
+	return "user:" + userID.String()
+}
+
+// checkLoginThrottle refuses a login attempt, with 429 and a Retry-After header, while its account is
+// locked or must wait after its last failure. It reports whether the attempt may go ahead.
+func (h *Handler) checkLoginThrottle(w http.ResponseWriter, r *http.Request, key string) bool {
+	throttle, err := h.DB.GetLoginThrottle(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		wait = throttle.LockedUntil.Sub(now)
+	} else if throttle.LastFailureAt != nil {
+		wait = throttle.LastFailureAt.Add(h.LoginPolicy.Backoff(throttle.Failures)).Sub(now)
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	w.Header().Set("Retry-After", auth.RetryAfter(wait))
+	http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+	return false
+}
+
+// recordLoginFailure counts a failed login and locks the account once it reaches the policy's limit.
+func (h *Handler) recordLoginFailure(r *http.Request, key string, userID *uuid.UUID, identifier string) {
+	failures, err := h.DB.RecordLoginFailure(r.Context(), key, userID, h.LoginPolicy.FailureWindow)
+	if err != nil || failures < h.LoginPolicy.MaxFailures {
+		return
+	}
+
+	until := time.Now().Add(h.LoginPolicy.LockoutDuration)
+	if err := h.DB.LockLogin(r.Context(), key, until); err != nil {
+		return
+	}
+
+	target := uuid.Nil
+	if userID != nil {
+		target = *userID
+	}
+	h.DB.LogEvent(r.Context(), "ACCOUNT_LOCKED", "USER", target, map[string]interface{}{
+		"identifier":   identifier,
+		"failures":     failures,
+		"locked_until": until,
+		"ip":           r.RemoteAddr,
+	})
+}
+
+// ListLoginLockouts returns the accounts locked after too many failed logins.
+func (h *Handler) ListLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := h.DB.ListLoginLockouts(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockouts)
+}
+
+// UnlockUser lifts a user's lockout and forgets their failed logins. The nil user ID unlocks the
+// environment admin.
+func (h *Handler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if userID != uuid.Nil {
+		if _, err := h.DB.GetUserByID(r.Context(), userID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	cleared, err := h.DB.ClearLoginThrottle(r.Context(), userThrottleKey(userID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !cleared {
+		http.Error(w, "Account is not locked", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	unlockedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "UNLOCK_ACCOUNT", "USER", userID, map[string]interface{}{
+		"unlocked_by": unlockedBy,
+		"ip":          r.RemoteAddr,
+	})
+}
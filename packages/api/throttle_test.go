@@ -0,0 +1,165 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginHandler_UnknownUser(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	key, _ := loginThrottleKey(nil, "mallory@example.com")
+	mockDB.On("GetUserByEmail", mock.Anything, "mallory@example.com").Return(nil, "", "", pgx.ErrNoRows)
+	mockDB.On("GetUserByUsername", mock.Anything, "mallory@example.com").Return(nil, "", "", pgx.ErrNoRows)
+	mockDB.On("GetLoginThrottle", mock.Anything, key).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("RecordLoginFailure", mock.Anything, key, (*uuid.UUID)(nil), h.LoginPolicy.FailureWindow).Return(1, nil)
+
+	rr := httptest.NewRecorder()
+	h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Email: "mallory@example.com", Password: "guess"}))
+
+	// Same answer as a wrong password, and counted like one
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "Unauthorized\n", rr.Body.String())
+	mockDB.AssertCalled(t, "RecordLoginFailure", mock.Anything, key, (*uuid.UUID)(nil), h.LoginPolicy.FailureWindow)
+
+	// The counter of an unknown identifier ignores case, like email lookups
+	upper, _ := loginThrottleKey(nil, "Mallory@Example.com")
+	assert.Equal(t, key, upper)
+}
+
+func TestLoginHandler_AccountWithoutPassword(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	// Accounts provisioned by single sign-on have no password
+	user := &models.User{ID: uuid.New(), Username: "sso-user", SSO: true}
+	mockDB.On("GetUserByUsername", mock.Anything, "sso-user").Return(user, "", "", nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("RecordLoginFailure", mock.Anything, "user:"+user.ID.String(), &user.ID, mock.Anything).Return(1, nil)
+
+	rr := httptest.NewRecorder()
+	h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Username: "sso-user", Password: ""}))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "Unauthorized\n", rr.Body.String())
+}
+
+func TestLoginHandler_LocksAfterMaxFailures(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	hash, salt, err := crypto.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+	key := "user:" + user.ID.String()
+
+	mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, hash, salt, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, key).Return(&models.LoginThrottle{}, nil)
+	mockDB.On("RecordLoginFailure", mock.Anything, key, &user.ID, mock.Anything).Return(h.LoginPolicy.MaxFailures, nil)
+	mockDB.On("LockLogin", mock.Anything, key, mock.Anything).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "ACCOUNT_LOCKED", "USER", user.ID, mock.Anything).Return(nil)
+
+	rr := httptest.NewRecorder()
+	h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Username: "alice", Password: "wrong"}))
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	mockDB.AssertCalled(t, "LockLogin", mock.Anything, key, mock.Anything)
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "ACCOUNT_LOCKED", "USER", user.ID, mock.Anything)
+}
+
+func TestLoginHandler_Throttled(t *testing.T) {
+	hash, salt, err := crypto.HashPassword("correct horse")
+	require.NoError(t, err)
+	now := time.Now()
+	lockedUntil := now.Add(10 * time.Minute)
+
+	tests := []struct {
+		name     string
+		throttle *models.LoginThrottle
+	}{
+		{"locked", &models.LoginThrottle{LastFailureAt: &now, LockedUntil: &lockedUntil}},
+		{"backing off", &models.LoginThrottle{Failures: 5, LastFailureAt: &now}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockDatabase)
+			h := NewHandler(mockDB)
+
+			user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+			mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, hash, salt, nil)
+			mockDB.On("GetLoginThrottle", mock.Anything, "user:"+user.ID.String()).Return(tt.throttle, nil)
+
+			rr := httptest.NewRecorder()
+			h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Username: "alice", Password: "correct horse"}))
+
+			// Even the right password is refused, so it can't be confirmed while throttled
+			assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+			assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+			mockDB.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestLoginHandler_BackoffExpired(t *testing.T) {
+	useSigningKey(t)
+
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	hash, salt, err := crypto.HashPassword("correct horse")
+	require.NoError(t, err)
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: auth.RoleCollaborator}
+	key := "user:" + user.ID.String()
+	lastFailure := time.Now().Add(-time.Hour)
+
+	mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, hash, salt, nil)
+	mockDB.On("GetLoginThrottle", mock.Anything, key).Return(&models.LoginThrottle{Failures: 5, LastFailureAt: &lastFailure}, nil)
+	mockDB.On("GetUserTOTP", mock.Anything, user.ID).Return(&models.UserTOTP{}, nil)
+	mockDB.On("RoleRequiresMFA", mock.Anything, auth.RoleCollaborator).Return(false, nil)
+	mockDB.On("ClearLoginThrottle", mock.Anything, key).Return(true, nil)
+	mockDB.On("CreateSession", mock.Anything, user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&models.Session{ID: uuid.New()}, nil)
+
+	rr := httptest.NewRecorder()
+	h.LoginHandler(rr, postJSON("/api/v1/auth/login", LoginRequest{Username: "alice", Password: "correct horse"}))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// A successful login forgets the failures
+	mockDB.AssertCalled(t, "ClearLoginThrottle", mock.Anything, key)
+}
+
+func TestUnlockUser(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	adminID := uuid.New()
+	userID := uuid.New()
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(&models.User{ID: userID}, nil)
+	mockDB.On("ClearLoginThrottle", mock.Anything, "user:"+userID.String()).Return(true, nil)
+	mockDB.On("ClearLoginThrottle", mock.Anything, adminThrottleKey).Return(false, nil)
+	mockDB.On("LogEvent", mock.Anything, "UNLOCK_ACCOUNT", "USER", userID, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/users/"+userID.String()+"/lockout", nil)
+	rr := httptest.NewRecorder()
+	h.UnlockUser(rr, withUser(withURLParam(req, "user", userID.String()), adminID))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertCalled(t, "LogEvent", mock.Anything, "UNLOCK_ACCOUNT", "USER", userID, mock.Anything)
+
+	// The nil ID names the environment admin, which is not locked here
+	req, _ = http.NewRequest("DELETE", "/api/v1/users/"+uuid.Nil.String()+"/lockout", nil)
+	rr = httptest.NewRecorder()
+	h.UnlockUser(rr, withUser(withURLParam(req, "user", uuid.Nil.String()), adminID))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -2,72 +2,170 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
-type CreateCollaboratorRequest struct {
-	Username       string    `json:"username"`
-	Email          string    `json:"email,omitempty"`
-	PasswordHash   string    `json:"password_hash"`
-	Salt           string    `json:"salt"`
-	ProjectID      uuid.UUID `json:"project_id"`
-	WrappedDataKey string    `json:"wrapped_data_key"`
+type PublicKeyResponse struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	PublicKey string    `json:"public_key"`
 }
 
-// CreateCollaborator handles the creation of a new collaborator.
-func (h *Handler) CreateCollaborator(w http.ResponseWriter, r *http.Request) {
-	// Check if the current user is an admin (later integration with JWT claims)
-	// For now, we assume the middleware protects it or we check manually
-
-	var req CreateCollaboratorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+// GetMe returns the currently authenticated user's information.
+func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request) {
+	uid, ok := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// 1. Create User
-	user, err := h.DB.CreateUser(r.Context(), req.Username, req.Email, req.PasswordHash, req.Salt, "COLLABORATOR")
+	user, err := h.DB.GetUserByID(r.Context(), uid)
 	if err != nil {
-		http.Error(w, "Could not create user: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// GetMyKeyPair returns the authenticated user's keypair, with the private key still encrypted.
+func (h *Handler) GetMyKeyPair(w http.ResponseWriter, r *http.Request) {
+	uid, ok := r.Context().Value(auth.UserKey).(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// 2. Grant Project Access
-	err = h.DB.GrantProjectAccess(r.Context(), user.ID, req.ProjectID, req.WrappedDataKey)
+	keys, err := h.DB.GetUserKeyPair(r.Context(), uid)
 	if err != nil {
-		http.Error(w, "Could not grant project access: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "No keypair found", http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
-
-	// Log audit event
-	h.DB.LogEvent(r.Context(), "CREATE_COLLABORATOR", "USER", user.ID, map[string]interface{}{
-		"username":   user.Username,
-		"project_id": req.ProjectID,
-		"ip":         r.RemoteAddr,
-	})
+	json.NewEncoder(w).Encode(keys)
 }
 
-// GetMe returns the currently authenticated user's information.
-func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request) {
+// SetMyKeyPair stores the authenticated user's keypair, generated and encrypted client-side.
+// The public key cannot be changed once set; re-encrypting the private key (e.g. after a password change) is allowed.
+func (h *Handler) SetMyKeyPair(w http.ResponseWriter, r *http.Request) {
 	uid, ok := r.Context().Value(auth.UserKey).(uuid.UUID)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if uid == uuid.Nil {
+		http.Error(w, "The environment admin cannot hold a keypair", http.StatusBadRequest)
+		return
+	}
 
-	user, err := h.DB.GetUserByID(r.Context(), uid)
-	if err != nil {
+	var keys models.UserKeyPair
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := vault.ValidateKeyPair(&keys); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.SetUserKeyPair(r.Context(), uid, &keys); err != nil {
+		switch {
+		case errors.Is(err, db.ErrKeyPairExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	h.DB.LogEvent(r.Context(), "SET_KEYPAIR", "USER", uid, map[string]interface{}{
+		"public_key": keys.PublicKey,
+		"ip":         r.RemoteAddr,
+	})
+}
+
+// GetUserPublicKey returns a user's public key, looked up by ID or username, so data keys can be wrapped to it.
+func (h *Handler) GetUserPublicKey(w http.ResponseWriter, r *http.Request) {
+	ref := chi.URLParam(r, "user")
+
+	var user *models.User
+	var err error
+	if id, parseErr := uuid.Parse(ref); parseErr == nil {
+		user, err = h.DB.GetUserByID(r.Context(), id)
+	} else {
+		user, _, _, err = h.DB.GetUserByUsername(r.Context(), ref)
+	}
+	if err != nil || user == nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
+	keys, err := h.DB.GetUserKeyPair(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "User has no keypair", http.StatusNotFound)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(PublicKeyResponse{UserID: user.ID, Username: user.Username, PublicKey: keys.PublicKey})
+}
+
+type SetUserRoleRequest struct {
+	Role string `json:"role"` // ADMIN, COLLABORATOR or AUDITOR
+}
+
+// SetUserRole changes a user's global role.
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil || userID == uuid.Nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetUserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		http.Error(w, "role must be ADMIN, COLLABORATOR or AUDITOR", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.SetUserRole(r.Context(), userID, req.Role); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, db.ErrClientScopedRole) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	changedBy, _ := r.Context().Value(auth.UserKey).(uuid.UUID)
+	h.DB.LogEvent(r.Context(), "SET_ROLE", "USER", userID, map[string]interface{}{
+		"role":       req.Role,
+		"changed_by": changedBy,
+		"ip":         r.RemoteAddr,
+	})
 }
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/dcdavidev/bastion/packages/vault"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func withUser(r *http.Request, userID uuid.UUID) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), auth.UserKey, userID))
+}
+
+func TestSetMyKeyPair(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	keys, _, err := vault.NewUserKeyPair("password")
+	require.NoError(t, err)
+
+	mockDB.On("SetUserKeyPair", mock.Anything, userID, keys).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "SET_KEYPAIR", "USER", userID, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(keys)
+	req, _ := http.NewRequest("PUT", "/api/v1/auth/keys", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.SetMyKeyPair(rr, withUser(req, userID))
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestSetMyKeyPair_Conflict(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	userID := uuid.New()
+	keys, _, _ := vault.NewUserKeyPair("password")
+	mockDB.On("SetUserKeyPair", mock.Anything, userID, keys).Return(db.ErrKeyPairExists)
+
+	body, _ := json.Marshal(keys)
+	req, _ := http.NewRequest("PUT", "/api/v1/auth/keys", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.SetMyKeyPair(rr, withUser(req, userID))
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestSetMyKeyPair_Invalid(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	body, _ := json.Marshal(models.UserKeyPair{PublicKey: "abcd", EncryptedPrivateKey: "abcd", PrivateKeySalt: "abcd"})
+	req, _ := http.NewRequest("PUT", "/api/v1/auth/keys", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.SetMyKeyPair(rr, withUser(req, uuid.New()))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGetUserPublicKey(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	user := &models.User{ID: uuid.New(), Username: "alice"}
+	keys, _, _ := vault.NewUserKeyPair("password")
+
+	mockDB.On("GetUserByUsername", mock.Anything, "alice").Return(user, "", "", nil)
+	mockDB.On("GetUserKeyPair", mock.Anything, user.ID).Return(keys, nil)
+
+	req, _ := http.NewRequest("GET", "/api/v1/users/alice/public-key", nil)
+	req = withURLParam(req, "user", "alice")
+	rr := httptest.NewRecorder()
+
+	h.GetUserPublicKey(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp PublicKeyResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	assert.Equal(t, user.ID, resp.UserID)
+	assert.Equal(t, keys.PublicKey, resp.PublicKey)
+}
+
+func TestGetUserPublicKey_NoKeyPair(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	user := &models.User{ID: uuid.New(), Username: "bob"}
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+	mockDB.On("GetUserKeyPair", mock.Anything, user.ID).Return(nil, pgx.ErrNoRows)
+
+	req, _ := http.NewRequest("GET", "/api/v1/users/"+user.ID.String()+"/public-key", nil)
+	req = withURLParam(req, "user", user.ID.String())
+	rr := httptest.NewRecorder()
+
+	h.GetUserPublicKey(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
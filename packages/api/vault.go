@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/google/uuid"
+)
+
+type RotateMasterKeyRequest struct {
+	OldWrappedMasterKey string               `json:"old_wrapped_master_key"` // The wrapped Master Key the rotation was made from
+	OldMasterKeySalt    string               `json:"old_master_key_salt"`
+	WrappedMasterKey    string               `json:"wrapped_master_key"`
+	MasterKeySalt       string               `json:"master_key_salt"`
+	OldProjectKeys      map[uuid.UUID]string `json:"old_project_keys"` // Project data keys as wrapped with the old Master Key
+	ProjectKeys         map[uuid.UUID]string `json:"project_keys"`     // Project data keys re-wrapped with the new Master Key
+}
+
+// RotateMasterKey replaces the wrapped Master Key and re-wrapped project data keys in a single transaction.
+// All wrapping happens client-side: neither the old nor the new Master Key is ever sent in plaintext. The
+// client names the keys it started from, and the rotation is refused with a 409 if any was replaced since.
+func (h *Handler) RotateMasterKey(w http.ResponseWriter, r *http.Request) {
+	var req RotateMasterKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.WrappedMasterKey == "" || req.MasterKeySalt == "" || req.OldWrappedMasterKey == "" || req.OldMasterKeySalt == "" {
+		http.Error(w, "wrapped_master_key, master_key_salt, old_wrapped_master_key and old_master_key_salt are required", http.StatusBadRequest)
+		return
+	}
+
+	for id, key := range req.ProjectKeys {
+		if id == uuid.Nil || key == "" || req.OldProjectKeys[id] == "" {
+			http.Error(w, "project_keys and old_project_keys must map project IDs to wrapped data keys", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.DB.RotateMasterKey(r.Context(), db.MasterKeyRotation{
+		OldWrappedMasterKey: req.OldWrappedMasterKey,
+		OldMasterKeySalt:    req.OldMasterKeySalt,
+		WrappedMasterKey:    req.WrappedMasterKey,
+		MasterKeySalt:       req.MasterKeySalt,
+		OldProjectKeys:      req.OldProjectKeys,
+		ProjectKeys:         req.ProjectKeys,
+	})
+	if errors.Is(err, db.ErrProjectSetChanged) || errors.Is(err, db.ErrVaultKeysChanged) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	// Log audit event
+	h.DB.LogEvent(r.Context(), "ROTATE_MASTER_KEY", "VAULT", uuid.Nil, map[string]interface{}{
+		"projects": len(req.ProjectKeys),
+		"ip":       r.RemoteAddr,
+	})
+}
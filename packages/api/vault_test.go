@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRotateMasterKey(t *testing.T) {
+	mockDB := new(MockDatabase)
+	h := NewHandler(mockDB)
+
+	projectID := uuid.New()
+	req := RotateMasterKeyRequest{
+		OldWrappedMasterKey: "old-wrapped",
+		OldMasterKeySalt:    "old-salt",
+		WrappedMasterKey:    "wrapped",
+		MasterKeySalt:       "salt",
+		OldProjectKeys:      map[uuid.UUID]string{projectID: "0123"},
+		ProjectKeys:         map[uuid.UUID]string{projectID: "abcd"},
+	}
+
+	mockDB.On("RotateMasterKey", mock.Anything, db.MasterKeyRotation{
+		OldWrappedMasterKey: "old-wrapped",
+		OldMasterKeySalt:    "old-salt",
+		WrappedMasterKey:    "wrapped",
+		MasterKeySalt:       "salt",
+		OldProjectKeys:      req.OldProjectKeys,
+		ProjectKeys:         req.ProjectKeys,
+	}).Return(nil)
+	mockDB.On("LogEvent", mock.Anything, "ROTATE_MASTER_KEY", "VAULT", uuid.Nil, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequest("POST", "/api/v1/vault/rotate", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	h.RotateMasterKey(rr, httpReq)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRotateMasterKey_Conflict(t *testing.T) {
+	// Another rotation committed since the client read the keys: its keys must not be overwritten
+	for _, conflict := range []error{db.ErrProjectSetChanged, db.ErrVaultKeysChanged} {
+		mockDB := new(MockDatabase)
+		h := NewHandler(mockDB)
+
+		mockDB.On("RotateMasterKey", mock.Anything, mock.Anything).Return(conflict)
+
+		body, _ := json.Marshal(RotateMasterKeyRequest{OldWrappedMasterKey: "old-wrapped", OldMasterKeySalt: "old-salt", WrappedMasterKey: "wrapped", MasterKeySalt: "salt"})
+		req, _ := http.NewRequest("POST", "/api/v1/vault/rotate", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+
+		h.RotateMasterKey(rr, req)
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		mockDB.AssertNotCalled(t, "LogEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestRotateMasterKey_MissingFields(t *testing.T) {
+	h := NewHandler(new(MockDatabase))
+
+	req, _ := http.NewRequest("POST", "/api/v1/vault/rotate", bytes.NewBufferString(`{"project_keys":{}}`))
+	rr := httptest.NewRecorder()
+
+	h.RotateMasterKey(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Every re-wrapped key must name the key it replaces
+	body, _ := json.Marshal(RotateMasterKeyRequest{
+		OldWrappedMasterKey: "old-wrapped",
+		OldMasterKeySalt:    "old-salt",
+		WrappedMasterKey:    "wrapped",
+		MasterKeySalt:       "salt",
+		ProjectKeys:         map[uuid.UUID]string{uuid.New(): "abcd"},
+	})
+	req, _ = http.NewRequest("POST", "/api/v1/vault/rotate", bytes.NewBuffer(body))
+	rr = httptest.NewRecorder()
+
+	h.RotateMasterKey(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
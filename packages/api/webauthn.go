@@ -1,7 +1,10 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/dcdavidev/bastion/packages/auth"
@@ -11,6 +14,18 @@ import (
 	"github.com/google/uuid"
 )
 
+// PasskeyRegistrationOptions are the options of a passkey registration, with the ID of its ceremony.
+type PasskeyRegistrationOptions struct {
+	CeremonyID string `json:"ceremony_id"` // Sent back as ?ceremony_id= when finishing
+	*protocol.CredentialCreation
+}
+
+// PasskeyLoginOptions are the options of a passkey login, with the ID of its ceremony.
+type PasskeyLoginOptions struct {
+	CeremonyID string `json:"ceremony_id"` // Sent back as ?ceremony_id= when finishing
+	*protocol.CredentialAssertion
+}
+
 // PasskeyRegisterBegin generates registration options for a new passkey.
 func (h *Handler) PasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value(auth.UserKey).(uuid.UUID)
@@ -30,39 +45,58 @@ func (h *Handler) PasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
 
 	webauthUser := &WebAuthnUser{User: user, Credentials: creds}
 
-	options, session, err := h.WebAuthn.BeginRegistration(webauthUser)
+	// Only discoverable credentials can log in without a username. An authenticator keeps one per user, so
+	// registering it again would leave its previous passkey unusable: exclude the ones already registered
+	options, session, err := h.WebAuthn.BeginRegistration(webauthUser,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(webauthUser.WebAuthnCredentials()).CredentialDescriptors()),
+	)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Store session data using user ID as key
-	h.sessions.Store("reg_"+user.ID.String(), session)
+	ceremonyID, err := h.beginCeremony(r.Context(), CeremonyRegistration, &user.ID, session)
+	if err != nil {
+		http.Error(w, "Could not start registration", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(options)
+	json.NewEncoder(w).Encode(PasskeyRegistrationOptions{CeremonyID: ceremonyID, CredentialCreation: options})
 }
 
-// PasskeyRegisterFinish finalizes passkey registration.
+// PasskeyRegisterFinish finalizes passkey registration. The passkey is named after ?name=, or numbered.
 func (h *Handler) PasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value(auth.UserKey).(uuid.UUID)
 
+	// A registration can only be finished by the user who began it
+	ceremony, session, err := h.finishCeremony(r.Context(), r.URL.Query().Get("ceremony_id"), CeremonyRegistration)
+	if err != nil || ceremony.UserID == nil || *ceremony.UserID != uid {
+		http.Error(w, "Registration session not found or expired", http.StatusBadRequest)
+		return
+	}
+
 	user, err := h.DB.GetUserByID(r.Context(), uid)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
-	// Retrieve session data
-	sessionData, ok := h.sessions.Load("reg_" + user.ID.String())
-	if !ok {
-		http.Error(w, "Registration session not found", http.StatusBadRequest)
+	creds, err := h.DB.GetWebAuthnCredentials(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	session := sessionData.(*webauthn.SessionData)
-	h.sessions.Delete("reg_" + user.ID.String())
+	name := fmt.Sprintf("Passkey %d", len(creds)+1)
+	if r.URL.Query().Has("name") {
+		if name, err = passkeyName(r.URL.Query().Get("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	webauthUser := &WebAuthnUser{User: user}
+	webauthUser := &WebAuthnUser{User: user, Credentials: creds}
 
 	credential, err := h.WebAuthn.FinishRegistration(webauthUser, *session, r)
 	if err != nil {
@@ -73,6 +107,7 @@ func (h *Handler) PasskeyRegisterFinish(w http.ResponseWriter, r *http.Request)
 	// Save credential to DB
 	webauthCred := &models.WebAuthnCredential{
 		ID:              credential.ID,
+		Name:            name,
 		PublicKey:       credential.PublicKey,
 		AttestationType: credential.AttestationType,
 		Transport:       h.fromWebAuthnTransport(credential.Transport),
@@ -86,95 +121,152 @@ func (h *Handler) PasskeyRegisterFinish(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(webauthCred)
+
+	h.DB.LogEvent(r.Context(), "ADD_PASSKEY", "USER", user.ID, map[string]interface{}{
+		"passkey_id": webauthCred.PublicID,
+		"name":       webauthCred.Name,
+		"ip":         r.RemoteAddr,
+	})
 }
 
-// PasskeyLoginBegin generates authentication options for a passkey login.
+// PasskeyLoginBegin generates authentication options for a usernameless passkey login. The challenge is
+// not tied to any user: the authenticator offers its discoverable credentials for this server, and the user
+// is identified when the login finishes. With `?email=`, the challenge is for that user's passkeys instead,
+// so those registered before usernameless login, which the authenticator may not hold, still work.
 func (h *Handler) PasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
-	email := r.URL.Query().Get("email")
-	if email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
+	if email := r.URL.Query().Get("email"); email != "" {
+		h.passkeyLoginBeginForUser(w, r, email)
 		return
 	}
 
-	user, _, _, err := h.DB.GetUserByEmail(r.Context(), email)
+	options, session, err := h.WebAuthn.BeginDiscoverableLogin()
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	creds, err := h.DB.GetWebAuthnCredentials(r.Context(), user.ID)
+	ceremonyID, err := h.beginCeremony(r.Context(), CeremonyLogin, nil, session)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Could not start login", http.StatusInternalServerError)
 		return
 	}
 
-	webauthUser := &WebAuthnUser{User: user, Credentials: creds}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PasskeyLoginOptions{CeremonyID: ceremonyID, CredentialAssertion: options})
+}
+
+// passkeyLoginBeginForUser begins a login with the passkeys of the user with the given email. An email
+// without passkeys, or without an account, gets a decoy challenge for a credential that does not exist, so
+// the answer does not tell which accounts exist. Its login fails like a passkey that is not recognized.
+func (h *Handler) passkeyLoginBeginForUser(w http.ResponseWriter, r *http.Request, email string) {
+	var userID *uuid.UUID
+	var creds []models.WebAuthnCredential
+	user, _, _, err := h.DB.GetUserByEmail(r.Context(), email)
+	if err == nil && user != nil {
+		creds, err = h.DB.GetWebAuthnCredentials(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		userID = &user.ID
+	}
+	if len(creds) == 0 {
+		decoyID, err := auth.DecoyCredentialID(email)
+		if err != nil {
+			http.Error(w, "Could not start login", http.StatusInternalServerError)
+			return
+		}
+		user = &models.User{ID: uuid.New(), Username: email}
+		creds = []models.WebAuthnCredential{{ID: decoyID, Transport: []string{"hybrid", "internal"}}}
+		userID = nil
+	}
 
-	options, session, err := h.WebAuthn.BeginLogin(webauthUser)
+	options, session, err := h.WebAuthn.BeginLogin(&WebAuthnUser{User: user, Credentials: creds})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Store session data using user ID as key
-	h.sessions.Store("login_"+user.ID.String(), session)
+	ceremonyID, err := h.beginCeremony(r.Context(), CeremonyLogin, userID, session)
+	if err != nil {
+		http.Error(w, "Could not start login", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(options)
+	json.NewEncoder(w).Encode(PasskeyLoginOptions{CeremonyID: ceremonyID, CredentialAssertion: options})
 }
 
-// PasskeyLoginFinish finalizes passkey authentication and returns a JWT.
+// PasskeyLoginFinish finalizes passkey authentication and returns a JWT. The user is the one the ceremony
+// was begun for, or else the one whose ID the authenticator returned as the credential's user handle.
 func (h *Handler) PasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
-	email := r.URL.Query().Get("email")
-	if email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
-		return
-	}
-
-	user, _, _, err := h.DB.GetUserByEmail(r.Context(), email)
+	ceremony, session, err := h.finishCeremony(r.Context(), r.URL.Query().Get("ceremony_id"), CeremonyLogin)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		http.Error(w, "Login session not found or expired", http.StatusBadRequest)
 		return
 	}
 
-	// Retrieve session data
-	sessionData, ok := h.sessions.Load("login_" + user.ID.String())
-	if !ok {
-		http.Error(w, "Login session not found", http.StatusBadRequest)
-		return
+	var found webauthn.User
+	var credential *webauthn.Credential
+	if ceremony.UserID != nil {
+		found, err = h.webAuthnUser(r.Context(), *ceremony.UserID)
+		if err == nil {
+			credential, err = h.WebAuthn.FinishLogin(found, *session, r)
+		}
+	} else {
+		found, credential, err = h.WebAuthn.FinishPasskeyLogin(h.discoverableUser(r.Context()), *session, r)
 	}
-	session := sessionData.(*webauthn.SessionData)
-	h.sessions.Delete("login_" + user.ID.String())
-
-	creds, _ := h.DB.GetWebAuthnCredentials(r.Context(), user.ID)
-	webauthUser := &WebAuthnUser{User: user, Credentials: creds}
-
-	credential, err := h.WebAuthn.FinishLogin(webauthUser, *session, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		// Unknown users and bad signatures get the same answer
+		http.Error(w, "Passkey not recognized", http.StatusUnauthorized)
 		return
 	}
+	webauthUser := found.(*WebAuthnUser)
+	user, creds := webauthUser.User, webauthUser.Credentials
 
-	// Update sign count
+	// Record the login. The library keeps a clone warning once raised, so only a new one is alerted on
 	for i := range creds {
 		if string(creds[i].ID) == string(credential.ID) {
+			newWarning := credential.Authenticator.CloneWarning && !creds[i].CloneWarning
 			creds[i].SignCount = credential.Authenticator.SignCount
+			creds[i].CloneWarning = credential.Authenticator.CloneWarning
 			h.DB.UpdateWebAuthnCredential(r.Context(), &creds[i])
+			if newWarning {
+				h.alertPasskeyClone(r, user, &creds[i])
+			}
 			break
 		}
 	}
 
-	// Generate JWT
-	token, err := auth.GenerateToken(user.ID, user.Username, user.Role == "ADMIN")
-	if err != nil {
-		http.Error(w, "Could not generate token", http.StatusInternalServerError)
-		return
+	h.startSession(w, r, user.ID, user.Username, user.Role, user.ClientID)
+}
+
+// discoverableUser looks up the user of a discoverable credential from its user handle, the user ID set
+// when the passkey was registered.
+func (h *Handler) discoverableUser(ctx context.Context) webauthn.DiscoverableUserHandler {
+	return func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil || userID == uuid.Nil {
+			return nil, errors.New("invalid user handle")
+		}
+		return h.webAuthnUser(ctx, userID)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+// webAuthnUser loads a user with their passkeys.
+func (h *Handler) webAuthnUser(ctx context.Context, userID uuid.UUID) (*WebAuthnUser, error) {
+	user, err := h.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := h.DB.GetWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &WebAuthnUser{User: user, Credentials: creds}, nil
 }
 
 func (h *Handler) fromWebAuthnTransport(t []protocol.AuthenticatorTransport) []string {
@@ -1,8 +1,6 @@
 package auth
 
 import (
-	"crypto/subtle"
-	"encoding/hex"
 	"os"
 
 	"github.com/dcdavidev/bastion/packages/crypto"
@@ -14,6 +12,11 @@ const (
 	EnvAdminSalt = "BASTION_ADMIN_PASSWORD_SALT"
 )
 
+// AdminLoginEnabled reports whether the environment admin can log in.
+func AdminLoginEnabled() bool {
+	return os.Getenv(EnvAdminHash) != "" && os.Getenv(EnvAdminSalt) != ""
+}
+
 // VerifyAdmin checks if the provided password matches the hash stored in environment variables.
 func VerifyAdmin(password string) bool {
 	storedHashHex := os.Getenv(EnvAdminHash)
@@ -23,19 +26,7 @@ func VerifyAdmin(password string) bool {
 		return false
 	}
 
-	storedHash, err := hex.DecodeString(storedHashHex)
-	if err != nil {
-		return false
-	}
-
-	salt, err := hex.DecodeString(storedSaltHex)
-	if err != nil {
-		return false
-	}
-
-	// Derive hash from the provided password using the stored salt
-	computedHash := crypto.DeriveKey([]byte(password), salt)
-
-	// Use ConstantTimeCompare to prevent timing attacks
-	return subtle.ConstantTimeCompare(computedHash, storedHash) == 1
+	// The salt may be a bare hex salt or an encoded salt carrying its Argon2id parameters
+	ok, _ := crypto.VerifyPassword(password, storedHashHex, storedSaltHex)
+	return ok
 }
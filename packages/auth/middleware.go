@@ -2,12 +2,13 @@ package auth
 
 import (
 	"context"
-	"fmt"
+	"net"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
@@ -17,78 +18,199 @@ type contextKey string
 const (
 	AdminContextKey contextKey = "admin_claims"
 	UserKey         contextKey = "user_id"
+	SessionKey      contextKey = "session_id"
+	APITokenKey     contextKey = "api_token"
 )
 
-// GenerateToken creates a new JWT for a user.
-func GenerateToken(userID uuid.UUID, username string, isAdmin bool) (string, error) {
-	secret := os.Getenv("BASTION_JWT_SECRET")
-	if secret == "" {
-		return "", fmt.Errorf("BASTION_JWT_SECRET not set")
-	}
+// APITokenPrefix starts every service account API token, telling them apart from JWTs.
+const APITokenPrefix = "bst_sat_"
+
+// Actor types recorded in the audit log.
+const (
+	ActorUser           = "user"
+	ActorServiceAccount = "service_account"
+)
+
+const (
+	// AccessTokenTTL is how long an access token is valid. Clients renew it with their refresh token.
+	AccessTokenTTL = 15 * time.Minute
+	// SessionTTL is how long a session, and so its refresh tokens, lasts after login.
+	SessionTTL = 30 * 24 * time.Hour
+)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// SessionLookup reports whether the session an access token was issued for is still active.
+type SessionLookup interface {
+	SessionActive(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+// APITokenLookup resolves a valid service account API token from its hash.
+type APITokenLookup interface {
+	AuthenticateAPIToken(ctx context.Context, tokenHash string) (*models.APIToken, error)
+}
+
+// TokenStore verifies both kinds of bearer tokens: user access tokens and service account API tokens.
+type TokenStore interface {
+	SessionLookup
+	APITokenLookup
+	SigningKeyStore
+}
+
+// GenerateToken creates a short-lived access token for a user's session, signed with the current signing
+// key. The session ID is its jti, so revoking the session revokes the token. clientID is set for client
+// portal accounts.
+func GenerateToken(userID uuid.UUID, username, role string, clientID *uuid.UUID, sessionID uuid.UUID) (string, error) {
+	claims := jwt.MapClaims{
 		"user_id":  userID.String(),
 		"username": username,
-		"admin":    isAdmin,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-	})
+		"role":     role,
+		"jti":      sessionID.String(),
+		"iat":      time.Now().Unix(),
+		"exp":      time.Now().Add(AccessTokenTTL).Unix(),
+	}
+	if clientID != nil {
+		claims["client_id"] = clientID.String()
+	}
 
-	return token.SignedString([]byte(secret))
+	return signToken(claims)
 }
 
-// JWTMiddleware validates the JWT token and ensures the user is authenticated.
-func JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
-			return
-		}
+// JWTMiddleware validates the JWT token against the signing key named by its kid and ensures the user is authenticated by a session that has not
+// been revoked. Service account API tokens are accepted too, from their allowed addresses only.
+func JWTMiddleware(store TokenStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				return
+			}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
-			return
-		}
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
+				return
+			}
+
+			tokenString := parts[1]
+			if strings.HasPrefix(tokenString, APITokenPrefix) {
+				serveAPIToken(store, tokenString, next, w, r)
+				return
+			}
 
-		tokenString := parts[1]
-		secret := os.Getenv("BASTION_JWT_SECRET")
+			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+				return verificationKey(r.Context(), store, token)
+			}, jwt.WithValidMethods([]string{AlgEdDSA, AlgES256}))
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			if err != nil || !token.Valid {
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
 			}
-			return []byte(secret), nil
+
+			claims, ok := token.Claims.(jwt.MapClaims)
+			if !ok {
+				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+				return
+			}
+
+			jti, _ := claims["jti"].(string)
+			sessionID, err := uuid.Parse(jti)
+			if err != nil {
+				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+				return
+			}
+			active, err := store.SessionActive(r.Context(), sessionID)
+			if err != nil {
+				http.Error(w, "Could not verify session", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				http.Error(w, "Session revoked or expired", http.StatusUnauthorized)
+				return
+			}
+
+			uidStr, _ := claims["user_id"].(string)
+			uid, _ := uuid.Parse(uidStr)
+
+			// Add claims and user ID to context
+			ctx := context.WithValue(r.Context(), AdminContextKey, claims)
+			ctx = context.WithValue(ctx, UserKey, uid)
+			ctx = context.WithValue(ctx, SessionKey, sessionID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-			return
-		}
+// serveAPIToken authenticates a request made with a service account API token. The service account acts
+// as the request's user, without a global role; its token scope is checked by RequireProjectPermission.
+func serveAPIToken(store APITokenLookup, tokenString string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	token, err := store.AuthenticateAPIToken(r.Context(), crypto.HashToken(tokenString))
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	if !addressAllowed(r.RemoteAddr, token.AllowedIPs) {
+		http.Error(w, "Token not allowed from this address", http.StatusForbidden)
+		return
+	}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
-			return
-		}
+	claims := jwt.MapClaims{
+		"user_id":  token.ServiceAccountID.String(),
+		"username": token.ServiceAccountName,
+	}
+	ctx := context.WithValue(r.Context(), AdminContextKey, claims)
+	ctx = context.WithValue(ctx, UserKey, token.ServiceAccountID)
+	ctx = context.WithValue(ctx, APITokenKey, token)
 
-		uidStr, _ := claims["user_id"].(string)
-		uid, _ := uuid.Parse(uidStr)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
 
-		// Add claims and user ID to context
-		ctx := context.WithValue(r.Context(), AdminContextKey, claims)
-		ctx = context.WithValue(ctx, UserKey, uid)
+// addressAllowed reports whether remoteAddr lies in one of the CIDRs. An empty list allows any address.
+// The server's RealIP middleware has set remoteAddr from forwarded headers only if they came from a
+// trusted proxy.
+func addressAllowed(remoteAddr string, cidrs []string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// APITokenFromContext returns the API token a request was authenticated with, if any.
+func APITokenFromContext(ctx context.Context) (*models.APIToken, bool) {
+	token, ok := ctx.Value(APITokenKey).(*models.APIToken)
+	return token, ok && token != nil
+}
+
+// ActorFromContext returns who is making a request: a user or a service account, with its ID. The type
+// is empty outside of authenticated requests, e.g. for background jobs.
+func ActorFromContext(ctx context.Context) (string, uuid.UUID) {
+	if token, ok := APITokenFromContext(ctx); ok {
+		return ActorServiceAccount, token.ServiceAccountID
+	}
+	if userID, ok := ctx.Value(UserKey).(uuid.UUID); ok {
+		return ActorUser, userID
+	}
+	return "", uuid.Nil
 }
 
-// AdminMiddleware ensures that the authenticated user has admin privileges.
-func AdminMiddleware(next http.Handler) http.Handler {
+// RequireUser rejects requests made with API tokens, which may only reach project-scoped routes.
+func RequireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims, ok := r.Context().Value(AdminContextKey).(jwt.MapClaims)
-		if !ok || claims["admin"] != true {
-			http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
+		if _, ok := APITokenFromContext(r.Context()); ok {
+			http.Error(w, "Forbidden: not available to API tokens", http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
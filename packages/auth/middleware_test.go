@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStore struct {
+	sessions    map[uuid.UUID]bool
+	tokens      map[string]*models.APIToken // By hash
+	signingKeys []models.SigningKey
+}
+
+func (f fakeStore) SessionActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	return f.sessions[id], nil
+}
+
+func (f fakeStore) AuthenticateAPIToken(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	if t, ok := f.tokens[tokenHash]; ok {
+		return t, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f fakeStore) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	return f.signingKeys, nil
+}
+
+func (f fakeStore) RotateSigningKey(ctx context.Context, key *models.SigningKey, retention time.Duration) error {
+	return errors.New("read-only store")
+}
+
+func serveWithToken(store TokenStore, token string) (*httptest.ResponseRecorder, uuid.UUID) {
+	var seen uuid.UUID
+	handler := JWTMiddleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(SessionKey).(uuid.UUID)
+	}))
+
+	req := httptest.NewRequest("GET", "/api/v1/auth/me", nil)
+	req.RemoteAddr = "10.1.2.3:51234"
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, seen
+}
+
+func TestJWTMiddleware_ChecksSession(t *testing.T) {
+	useSigningKey(t, AlgEdDSA)
+
+	active := uuid.New()
+	revoked := uuid.New()
+	sessions := fakeStore{sessions: map[uuid.UUID]bool{active: true}}
+
+	token, err := GenerateToken(uuid.New(), "alice", RoleCollaborator, nil, active)
+	require.NoError(t, err)
+	rr, seen := serveWithToken(sessions, token)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, active, seen)
+
+	token, err = GenerateToken(uuid.New(), "alice", RoleCollaborator, nil, revoked)
+	require.NoError(t, err)
+	rr, _ = serveWithToken(sessions, token)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestJWTMiddleware_RejectsTokenWithoutSession(t *testing.T) {
+	useSigningKey(t, AlgEdDSA)
+
+	// A token in the old format, without jti, can't be revoked and is no longer accepted
+	token, err := signToken(jwt.MapClaims{
+		"user_id": uuid.New().String(),
+		"role":    RoleAdmin,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	rr, _ := serveWithToken(fakeStore{}, token)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestJWTMiddleware_APIToken(t *testing.T) {
+	accountID := uuid.New()
+	open := &models.APIToken{ServiceAccountID: accountID, ServiceAccountName: "ci"}
+	fenced := &models.APIToken{ServiceAccountID: accountID, ServiceAccountName: "ci", AllowedIPs: []string{"192.168.0.0/16"}}
+	store := fakeStore{tokens: map[string]*models.APIToken{
+		crypto.HashToken(APITokenPrefix + "open"):   open,
+		crypto.HashToken(APITokenPrefix + "fenced"): fenced,
+	}}
+
+	var actorType string
+	var actorID uuid.UUID
+	handler := JWTMiddleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actorType, actorID = ActorFromContext(r.Context())
+	}))
+	serve := func(token string) int {
+		req := httptest.NewRequest("GET", "/api/v1/secrets", nil)
+		req.RemoteAddr = "10.1.2.3:51234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(APITokenPrefix+"open"))
+	assert.Equal(t, ActorServiceAccount, actorType)
+	assert.Equal(t, accountID, actorID)
+
+	assert.Equal(t, http.StatusForbidden, serve(APITokenPrefix+"fenced"))
+
+	// A forged X-Forwarded-For from an untrusted peer does not get past the allowlist
+	forged := RealIP(nil)(handler)
+	req := httptest.NewRequest("GET", "/api/v1/secrets", nil)
+	req.RemoteAddr = "10.1.2.3:51234"
+	req.Header.Set("X-Forwarded-For", "192.168.1.1")
+	req.Header.Set("Authorization", "Bearer "+APITokenPrefix+"fenced")
+	rr := httptest.NewRecorder()
+	forged.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(APITokenPrefix+"unknown"))
+}
+
+func TestAPITokenAllows(t *testing.T) {
+	inScope := uuid.New()
+	read := &models.APIToken{Permission: models.APITokenRead, ProjectIDs: []uuid.UUID{inScope}}
+	readWrite := &models.APIToken{Permission: models.APITokenReadWrite, ProjectIDs: []uuid.UUID{inScope}}
+
+	assert.True(t, APITokenAllows(read, inScope, PermReadSecrets))
+	assert.False(t, APITokenAllows(read, inScope, PermWriteSecrets))
+	assert.True(t, APITokenAllows(readWrite, inScope, PermWriteSecrets))
+	assert.False(t, APITokenAllows(readWrite, inScope, PermManageAccess))
+	assert.False(t, APITokenAllows(readWrite, uuid.New(), PermReadSecrets))
+}
+
+func TestRequireUser(t *testing.T) {
+	handler := RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/api/v1/clients", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), APITokenKey, &models.APIToken{})))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), UserKey, uuid.New())))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
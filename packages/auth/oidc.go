@@ -0,0 +1,477 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcKeysReloadInterval limits how often an ID token signed with an unknown key refetches the provider's JWKS.
+const oidcKeysReloadInterval = 10 * time.Second
+
+// OIDCConfig configures single sign-on through an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer         string   // Issuer URL; its discovery document is at /.well-known/openid-configuration
+	ClientID       string   // Client registered at the provider for Bastion
+	ClientSecret   string   // Empty for a public client, which relies on PKCE alone
+	RedirectURL    string   // Bastion's callback, registered at the provider
+	Scopes         []string // Requested scopes; openid is always included
+	AutoProvision  bool     // Create accounts for identities that match no user
+	DefaultRole    string   // Global role of provisioned accounts
+	AllowedDomains []string // Email domains that may be provisioned; empty allows any
+	ReturnOrigins  []string // Web app origins a login may return to, besides loopback addresses
+}
+
+// OIDCConfigFromEnv reads the single sign-on configuration from BASTION_OIDC_* variables. It returns nil if
+// BASTION_OIDC_ISSUER is not set, which disables single sign-on.
+func OIDCConfigFromEnv() (*OIDCConfig, error) {
+	issuer := os.Getenv("BASTION_OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	origin := os.Getenv("BASTION_ORIGIN")
+	if origin == "" {
+		origin = "http://localhost:8287"
+	}
+	origin = strings.TrimSuffix(origin, "/")
+
+	cfg := &OIDCConfig{
+		Issuer:         issuer,
+		ClientID:       os.Getenv("BASTION_OIDC_CLIENT_ID"),
+		ClientSecret:   os.Getenv("BASTION_OIDC_CLIENT_SECRET"),
+		RedirectURL:    os.Getenv("BASTION_OIDC_REDIRECT_URL"),
+		Scopes:         strings.Fields(os.Getenv("BASTION_OIDC_SCOPES")),
+		AutoProvision:  os.Getenv("BASTION_OIDC_AUTO_PROVISION") == "true",
+		DefaultRole:    os.Getenv("BASTION_OIDC_DEFAULT_ROLE"),
+		AllowedDomains: splitList(os.Getenv("BASTION_OIDC_ALLOWED_DOMAINS")),
+		ReturnOrigins:  []string{origin},
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("BASTION_OIDC_CLIENT_ID not set")
+	}
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = origin + "/api/v1/auth/oidc/callback"
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = RoleCollaborator
+	}
+	if !ValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("BASTION_OIDC_DEFAULT_ROLE: unknown role %q", cfg.DefaultRole)
+	}
+	return cfg, nil
+}
+
+// AllowedReturnURL reports whether a login may send its result to raw: a loopback address, where the CLI
+// listens, or one of the web app origins. Anything else would hand login codes to a third party.
+func (c *OIDCConfig) AllowedReturnURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Fragment != "" || u.User != nil {
+		return false
+	}
+	if u.Scheme == "http" {
+		switch u.Hostname() {
+		case "127.0.0.1", "::1", "localhost":
+			return true
+		}
+	}
+	for _, origin := range c.ReturnOrigins {
+		if strings.EqualFold(u.Scheme+"://"+u.Host, strings.TrimSuffix(origin, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// DomainAllowed reports whether accounts may be provisioned for an email address.
+func (c *OIDCConfig) DomainAllowed(email string) bool {
+	if len(c.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range c.AllowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCIdentity is a user as asserted by a verified ID token.
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// oidcMetadata is the part of the provider's discovery document Bastion uses.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider runs the authorization code flow with PKCE against an OpenID Connect provider. The discovery
+// document and signing keys are fetched on first use and cached, so a provider outage does not keep
+// Bastion from starting.
+type OIDCProvider struct {
+	Config OIDCConfig
+
+	client        *http.Client
+	mu            sync.Mutex
+	metadata      *oidcMetadata
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider creates a provider for cfg.
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		Config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PKCEChallenge returns the S256 code challenge of a PKCE code verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL that starts a login. The provider sends state back to the callback;
+// the ID token must carry nonce, and the code can only be redeemed with codeVerifier.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Config.Scopes
+	if !contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the provider and returns the identity of its verified ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	if code == "" {
+		return nil, errors.New("missing authorization code")
+	}
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request rejected: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// idTokenClaims are the claims of an ID token Bastion reads.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string      `json:"nonce"`
+	AuthorizedParty   string      `json:"azp"`
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"` // Some providers send "true" as a string
+	Name              string      `json:"name"`
+	PreferredUsername string      `json:"preferred_username"`
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's keys, its issuer, audience, expiry
+// and nonce, and returns the identity it asserts.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.Config.ClientID {
+		return nil, errors.New("invalid ID token: issued to another party")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: missing subject")
+	}
+
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+
+	return &OIDCIdentity{
+		Issuer:            meta.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     verified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// discover fetches and caches the provider's discovery document.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	meta := &oidcMetadata{}
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Config.Issuer, "/")+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, fmt.Errorf("provider discovery failed: %w", err)
+	}
+	// The document must belong to the configured issuer, or ID tokens could be minted by someone else
+	if meta.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("provider discovery failed: issuer %q does not match %q", meta.Issuer, p.Config.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("provider discovery failed: incomplete metadata")
+	}
+	p.metadata = meta
+	return meta, nil
+}
+
+// key returns the provider's public key for kid, refetching the JWKS once in a while if it is unknown,
+// e.g. after the provider rotated. Tokens without a kid are accepted if the provider has a single key.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lookup := func() interface{} {
+		if kid == "" && len(p.keys) == 1 {
+			for _, k := range p.keys {
+				return k
+			}
+		}
+		return p.keys[kid]
+	}
+
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) > oidcKeysReloadInterval {
+		var set JWKSet
+		if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+			return nil, fmt.Errorf("failed to fetch provider keys: %w", err)
+		}
+		keys := make(map[string]interface{}, len(set.Keys))
+		for _, jwk := range set.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+			if key, err := jwk.PublicKey(); err == nil {
+				keys[jwk.KeyID] = key
+			}
+		}
+		p.keys = keys
+		p.keysFetchedAt = time.Now()
+	}
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown provider key %q", kid)
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// PublicKey decodes an RSA, EC or Ed25519 JWK into the public key golang-jwt verifies with.
+func (k JWK) PublicKey() (interface{}, error) {
+	b64 := base64.RawURLEncoding
+	switch k.KeyType {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
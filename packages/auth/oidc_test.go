@@ -0,0 +1,166 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/auth"
+	"github.com/dcdavidev/bastion/packages/auth/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOIDCProvider(t *testing.T) (*oidctest.Provider, *auth.OIDCProvider) {
+	t.Helper()
+	idp := oidctest.NewProvider("bastion")
+	t.Cleanup(idp.Close)
+	provider := auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:      idp.Issuer(),
+		ClientID:    "bastion",
+		RedirectURL: "http://localhost:8287/api/v1/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	})
+	return idp, provider
+}
+
+// authorize follows a login URL to the provider and returns the query of its redirect to the callback.
+func authorize(t *testing.T, loginURL string) url.Values {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(loginURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return location.Query()
+}
+
+func TestOIDCProvider_Exchange(t *testing.T) {
+	idp, provider := newTestOIDCProvider(t)
+	idp.User = jwt.MapClaims{"sub": "user-123", "email": "alice@example.com", "email_verified": true, "preferred_username": "alice"}
+
+	loginURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-verifier-verifier-verifier-verifier")
+	require.NoError(t, err)
+	callback := authorize(t, loginURL)
+	assert.Equal(t, "state-1", callback.Get("state"))
+
+	identity, err := provider.Exchange(context.Background(), callback.Get("code"), "verifier-verifier-verifier-verifier-verifier", "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer(), identity.Issuer)
+	assert.Equal(t, "user-123", identity.Subject)
+	assert.Equal(t, "alice@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+	assert.Equal(t, "alice", identity.PreferredUsername)
+
+	// Codes work once
+	_, err = provider.Exchange(context.Background(), callback.Get("code"), "verifier-verifier-verifier-verifier-verifier", "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestOIDCProvider_RejectsWrongVerifier(t *testing.T) {
+	idp, provider := newTestOIDCProvider(t)
+	idp.User = jwt.MapClaims{"sub": "user-123"}
+
+	loginURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-verifier-verifier-verifier-verifier")
+	require.NoError(t, err)
+	callback := authorize(t, loginURL)
+
+	_, err = provider.Exchange(context.Background(), callback.Get("code"), "someone-elses-verifier-someone-elses-verifier", "nonce-1")
+	assert.ErrorContains(t, err, "invalid_grant")
+}
+
+func TestOIDCProvider_VerifyIDToken(t *testing.T) {
+	idp, provider := newTestOIDCProvider(t)
+
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": idp.Issuer(), "aud": "bastion", "sub": "user-123", "nonce": "nonce-1",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = "oidctest-key"
+	forgedToken, err := forged.SignedString(forger)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", idp.IDToken(jwt.MapClaims{"sub": "user-123", "nonce": "nonce-1"}), true},
+		{"wrong nonce", idp.IDToken(jwt.MapClaims{"sub": "user-123", "nonce": "nonce-2"}), false},
+		{"no nonce", idp.IDToken(jwt.MapClaims{"sub": "user-123"}), false},
+		{"other audience", idp.IDToken(jwt.MapClaims{"sub": "user-123", "nonce": "nonce-1", "aud": "another-app"}), false},
+		{"other authorized party", idp.IDToken(jwt.MapClaims{"sub": "user-123", "nonce": "nonce-1", "aud": []string{"bastion", "another-app"}, "azp": "another-app"}), false},
+		{"other issuer", idp.IDToken(jwt.MapClaims{"sub": "user-123", "nonce": "nonce-1", "iss": "https://evil.example.com"}), false},
+		{"expired", idp.IDToken(jwt.MapClaims{"sub": "user-123", "nonce": "nonce-1", "exp": time.Now().Add(-time.Hour).Unix()}), false},
+		{"no subject", idp.IDToken(jwt.MapClaims{"nonce": "nonce-1"}), false},
+		{"forged signature", forgedToken, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := provider.VerifyIDToken(context.Background(), tt.token, "nonce-1")
+			if tt.ok {
+				require.NoError(t, err)
+				assert.Equal(t, "user-123", identity.Subject)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_IssuerMismatch(t *testing.T) {
+	idp := oidctest.NewProvider("bastion")
+	defer idp.Close()
+
+	// The discovery document names the provider's real issuer, not the configured one
+	provider := auth.NewOIDCProvider(auth.OIDCConfig{Issuer: idp.Issuer() + "/", ClientID: "bastion"})
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestOIDCConfig_AllowedReturnURL(t *testing.T) {
+	cfg := auth.OIDCConfig{ReturnOrigins: []string{"https://bastion.example.com"}}
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"http://127.0.0.1:53682/callback", true},
+		{"http://[::1]:53682/callback", true},
+		{"http://localhost:5173/login/sso", true},
+		{"https://bastion.example.com/login/sso", true},
+		{"https://bastion.example.com.evil.com/login/sso", false},
+		{"https://evil.example.com/callback", false},
+		{"http://bastion.example.com/login/sso", false},
+		{"https://127.0.0.1:53682/callback", false},
+		{"http://user@127.0.0.1:53682/callback", false},
+		{"/login/sso", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.ok, cfg.AllowedReturnURL(tt.url), tt.url)
+	}
+}
+
+func TestOIDCConfig_DomainAllowed(t *testing.T) {
+	assert.True(t, (&auth.OIDCConfig{}).DomainAllowed("alice@anywhere.com"))
+
+	cfg := auth.OIDCConfig{AllowedDomains: []string{"example.com"}}
+	assert.True(t, cfg.DomainAllowed("alice@example.com"))
+	assert.True(t, cfg.DomainAllowed("alice@EXAMPLE.com"))
+	assert.False(t, cfg.DomainAllowed("alice@sub.example.com"))
+	assert.False(t, cfg.DomainAllowed("alice@example.com.evil.com"))
+	assert.False(t, cfg.DomainAllowed("alice"))
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For and X-Real-IP headers are believed. Any
+// client can send those headers, so from every other peer they are ignored.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma-separated list of IPs and CIDRs.
+func ParseTrustedProxies(list string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range splitList(list) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// TrustedProxiesFromEnv reads BASTION_TRUSTED_PROXIES. When unset, no proxy is trusted.
+func TrustedProxiesFromEnv() (TrustedProxies, error) {
+	return ParseTrustedProxies(os.Getenv("BASTION_TRUSTED_PROXIES"))
+}
+
+func (t TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddr returns the IP address of the client that sent a request. It is the TCP peer, unless the peer
+// is a trusted proxy: then it is the rightmost address of X-Forwarded-For that is not a trusted proxy
+// itself, or X-Real-IP.
+func (t TrustedProxies) ClientAddr(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if ip := net.ParseIP(peer); ip == nil || !t.trusts(ip) {
+		return peer
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break // Anything left of a malformed hop may be forged
+			}
+			client = ip.String()
+			if !t.trusts(ip) {
+				break
+			}
+		}
+		return client
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
+
+// RealIP sets each request's RemoteAddr to its client's address, as ClientAddr finds it, so audit logs,
+// API token allowlists and rate limits all see the same address.
+func RealIP(trusted TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RemoteAddr = trusted.ClientAddr(r)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.7,2001:db8::1")
+	require.NoError(t, err)
+	assert.Len(t, proxies, 3)
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.internal")
+	assert.Error(t, err)
+
+	proxies, err = ParseTrustedProxies("")
+	require.NoError(t, err)
+	assert.Empty(t, proxies)
+}
+
+func TestTrustedProxies_ClientAddr(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+
+	request := func(peer string, headers map[string]string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = peer
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+
+	// Forwarded headers from an untrusted peer are ignored
+	assert.Equal(t, "198.51.100.9", proxies.ClientAddr(request("198.51.100.9:4000", map[string]string{"X-Forwarded-For": "192.168.1.1", "X-Real-IP": "192.168.1.1"})))
+	assert.Equal(t, "198.51.100.9", TrustedProxies(nil).ClientAddr(request("198.51.100.9:4000", map[string]string{"X-Forwarded-For": "192.168.1.1"})))
+
+	// A trusted proxy's forwarded address is believed
+	assert.Equal(t, "203.0.113.5", proxies.ClientAddr(request("10.0.0.2:4000", map[string]string{"X-Forwarded-For": "203.0.113.5"})))
+	assert.Equal(t, "203.0.113.5", proxies.ClientAddr(request("10.0.0.2:4000", map[string]string{"X-Real-IP": "203.0.113.5"})))
+
+	// Addresses the client prepended itself are skipped: the client is the last hop no trusted proxy added
+	assert.Equal(t, "203.0.113.5", proxies.ClientAddr(request("10.0.0.2:4000", map[string]string{"X-Forwarded-For": "192.168.1.1, 203.0.113.5, 10.0.0.3"})))
+	assert.Equal(t, "203.0.113.5", proxies.ClientAddr(request("10.0.0.2:4000", map[string]string{"X-Forwarded-For": "forged, 203.0.113.5"})))
+
+	// Without forwarded headers, the proxy itself is the client
+	assert.Equal(t, "10.0.0.2", proxies.ClientAddr(request("10.0.0.2:4000", nil)))
+}
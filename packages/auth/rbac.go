@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Global roles, stored in users.role.
+const (
+	RoleAdmin        = "ADMIN"        // Full control over the vault
+	RoleCollaborator = "COLLABORATOR" // Permissions come only from project grants
+	RoleAuditor      = "AUDITOR"      // Read-only access to the audit log
+)
+
+// Project roles, attached to a user's grant on a project.
+const (
+	ProjectRoleViewer = "viewer"        // Read secrets
+	ProjectRoleEditor = "editor"        // Read and write secrets
+	ProjectRoleAdmin  = "project-admin" // Editor who can also manage the project's access list
+)
+
+// Permission is a single action a role may be allowed to perform.
+type Permission string
+
+const (
+	PermReadSecrets  Permission = "secrets:read"
+	PermWriteSecrets Permission = "secrets:write"
+	PermManageAccess Permission = "access:manage"
+	PermReadAudit    Permission = "audit:read"
+	PermManageVault  Permission = "vault:manage" // Clients, projects, invitations and key rotation
+)
+
+var globalRolePermissions = map[string][]Permission{
+	RoleAdmin:   {PermReadSecrets, PermWriteSecrets, PermManageAccess, PermReadAudit, PermManageVault},
+	RoleAuditor: {PermReadAudit},
+}
+
+var projectRolePermissions = map[string][]Permission{
+	ProjectRoleViewer: {PermReadSecrets},
+	ProjectRoleEditor: {PermReadSecrets, PermWriteSecrets},
+	ProjectRoleAdmin:  {PermReadSecrets, PermWriteSecrets, PermManageAccess},
+}
+
+// ValidRole reports whether role is a known global role.
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleCollaborator || role == RoleAuditor
+}
+
+// ValidProjectRole reports whether role is a known project role.
+func ValidProjectRole(role string) bool {
+	_, ok := projectRolePermissions[role]
+	return ok
+}
+
+// RoleAllows reports whether a global role grants a permission on every project.
+func RoleAllows(role string, perm Permission) bool {
+	return hasPermission(globalRolePermissions[role], perm)
+}
+
+// ProjectRoleAllows reports whether a project role grants a permission on its project.
+func ProjectRoleAllows(role string, perm Permission) bool {
+	return hasPermission(projectRolePermissions[role], perm)
+}
+
+func hasPermission(perms []Permission, perm Permission) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleFromContext returns the global role of the authenticated user. Client portal accounts never hold
+// more than the collaborator role, whatever their token says.
+func RoleFromContext(ctx context.Context) string {
+	if _, scoped := ClientFromContext(ctx); scoped {
+		return RoleCollaborator
+	}
+	claims, _ := ctx.Value(AdminContextKey).(jwt.MapClaims)
+	role, _ := claims["role"].(string)
+	return role
+}
+
+// ClientFromContext returns the client a portal account is restricted to, and whether it is restricted.
+func ClientFromContext(ctx context.Context) (uuid.UUID, bool) {
+	claims, _ := ctx.Value(AdminContextKey).(jwt.MapClaims)
+	raw, _ := claims["client_id"].(string)
+	if raw == "" {
+		return uuid.Nil, false
+	}
+	clientID, err := uuid.Parse(raw)
+	if err != nil {
+		// A malformed scope must not widen access; restrict to no client at all
+		return uuid.Nil, true
+	}
+	return clientID, true
+}
+
+// IsAdmin reports whether the authenticated user holds the global admin role.
+func IsAdmin(ctx context.Context) bool {
+	return RoleFromContext(ctx) == RoleAdmin
+}
+
+// RequirePermission ensures that the authenticated user's global role grants perm.
+func RequirePermission(perm Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !RoleAllows(RoleFromContext(r.Context()), perm) {
+				http.Error(w, fmt.Sprintf("Forbidden: %s permission required", perm), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ProjectRoleLookup resolves the role of a user's grant on a project.
+type ProjectRoleLookup interface {
+	GetProjectRole(ctx context.Context, userID, projectID uuid.UUID) (string, error)
+}
+
+// ProjectIDFunc extracts the project a request targets.
+type ProjectIDFunc func(r *http.Request) (uuid.UUID, error)
+
+// ProjectFromURLParam reads the project ID from a chi route parameter.
+func ProjectFromURLParam(name string) ProjectIDFunc {
+	return func(r *http.Request) (uuid.UUID, error) {
+		return uuid.Parse(chi.URLParam(r, name))
+	}
+}
+
+// ProjectFromQuery reads the project ID from a query parameter.
+func ProjectFromQuery(name string) ProjectIDFunc {
+	return func(r *http.Request) (uuid.UUID, error) {
+		return uuid.Parse(r.URL.Query().Get(name))
+	}
+}
+
+// maxProjectBodyBytes bounds the request bodies ProjectFromJSONBody reads before the caller is authorized.
+const maxProjectBodyBytes = 4 << 20
+
+// ProjectFromJSONBody reads the project ID from a field of the JSON request body, leaving the body
+// intact for the handler. Bodies over maxProjectBodyBytes are refused.
+func ProjectFromJSONBody(field string) ProjectIDFunc {
+	return func(r *http.Request) (uuid.UUID, error) {
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxProjectBodyBytes))
+		if err != nil {
+			return uuid.Nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return uuid.Nil, err
+		}
+		id, _ := fields[field].(string)
+		return uuid.Parse(id)
+	}
+}
+
+// APITokenAllows reports whether an API token grants perm on a project: the project must be in its scope,
+// and its permission must cover perm. Tokens never manage access.
+func APITokenAllows(token *models.APIToken, projectID uuid.UUID, perm Permission) bool {
+	canWrite := token.Permission == models.APITokenReadWrite
+	if perm != PermReadSecrets && !(perm == PermWriteSecrets && canWrite) {
+		return false
+	}
+	for _, id := range token.ProjectIDs {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireProjectPermission ensures that the authenticated user may perform perm on the targeted project,
+// either through their global role or through the role of their grant on that project. Requests made
+// with an API token are limited to the token's scope.
+func RequireProjectPermission(lookup ProjectRoleLookup, perm Permission, projectID ProjectIDFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := APITokenFromContext(r.Context()); ok {
+				pid, err := projectID(r)
+				if err != nil {
+					projectIDError(w, err)
+					return
+				}
+				if !APITokenAllows(token, pid, perm) {
+					http.Error(w, fmt.Sprintf("Forbidden: token does not grant %s on this project", perm), http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if RoleAllows(RoleFromContext(r.Context()), perm) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			pid, err := projectID(r)
+			if err != nil {
+				projectIDError(w, err)
+				return
+			}
+
+			userID, _ := r.Context().Value(UserKey).(uuid.UUID)
+			role, err := lookup.GetProjectRole(r.Context(), userID, pid)
+			if err != nil || !ProjectRoleAllows(role, perm) {
+				http.Error(w, fmt.Sprintf("Forbidden: %s permission required on this project", perm), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func projectIDError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Invalid or missing project ID", http.StatusBadRequest)
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRoles map[uuid.UUID]string
+
+func (f fakeRoles) GetProjectRole(ctx context.Context, userID, projectID uuid.UUID) (string, error) {
+	role, ok := f[userID]
+	if !ok {
+		return "", errors.New("no grant")
+	}
+	return role, nil
+}
+
+func withClaims(r *http.Request, userID uuid.UUID, role string) *http.Request {
+	ctx := context.WithValue(r.Context(), AdminContextKey, jwt.MapClaims{"user_id": userID.String(), "role": role})
+	ctx = context.WithValue(ctx, UserKey, userID)
+	return r.WithContext(ctx)
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRoleAllows(t *testing.T) {
+	assert.True(t, RoleAllows(RoleAdmin, PermManageVault))
+	assert.True(t, RoleAllows(RoleAuditor, PermReadAudit))
+	assert.False(t, RoleAllows(RoleAuditor, PermReadSecrets))
+	assert.False(t, RoleAllows(RoleCollaborator, PermReadAudit))
+
+	assert.True(t, ProjectRoleAllows(ProjectRoleViewer, PermReadSecrets))
+	assert.False(t, ProjectRoleAllows(ProjectRoleViewer, PermWriteSecrets))
+	assert.True(t, ProjectRoleAllows(ProjectRoleEditor, PermWriteSecrets))
+	assert.False(t, ProjectRoleAllows(ProjectRoleEditor, PermManageAccess))
+	assert.True(t, ProjectRoleAllows(ProjectRoleAdmin, PermManageAccess))
+	assert.False(t, ProjectRoleAllows(ProjectRoleAdmin, PermManageVault))
+}
+
+func TestRequirePermission(t *testing.T) {
+	handler := RequirePermission(PermReadAudit)(okHandler)
+
+	for role, want := range map[string]int{
+		RoleAdmin:        http.StatusOK,
+		RoleAuditor:      http.StatusOK,
+		RoleCollaborator: http.StatusForbidden,
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/audit", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, withClaims(req, uuid.New(), role))
+		assert.Equal(t, want, rr.Code, role)
+	}
+}
+
+func TestRequireProjectPermission(t *testing.T) {
+	viewer, editor, stranger := uuid.New(), uuid.New(), uuid.New()
+	roles := fakeRoles{viewer: ProjectRoleViewer, editor: ProjectRoleEditor}
+	projectID := uuid.New()
+
+	handler := RequireProjectPermission(roles, PermWriteSecrets, ProjectFromQuery("project_id"))(okHandler)
+
+	for user, want := range map[uuid.UUID]int{
+		viewer:   http.StatusForbidden,
+		editor:   http.StatusOK,
+		stranger: http.StatusForbidden,
+	} {
+		req, _ := http.NewRequest("GET", "/api/v1/secrets?project_id="+projectID.String(), nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, withClaims(req, user, RoleCollaborator))
+		assert.Equal(t, want, rr.Code)
+	}
+
+	// Global admins do not need a grant
+	req, _ := http.NewRequest("GET", "/api/v1/secrets?project_id="+projectID.String(), nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withClaims(req, uuid.Nil, RoleAdmin))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestProjectFromJSONBody_PreservesBody(t *testing.T) {
+	projectID := uuid.New()
+	body := `{"project_id":"` + projectID.String() + `","key":"API_KEY"}`
+
+	handler := RequireProjectPermission(fakeRoles{}, PermWriteSecrets, ProjectFromJSONBody("project_id"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ := io.ReadAll(r.Body)
+			assert.Equal(t, body, string(got))
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	req, _ := http.NewRequest("POST", "/api/v1/secrets", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withClaims(req, uuid.Nil, RoleAdmin))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Without a global permission the project ID must be readable
+	req, _ = http.NewRequest("POST", "/api/v1/secrets", strings.NewReader(`{"key":"API_KEY"}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withClaims(req, uuid.New(), RoleCollaborator))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// and is read from a bounded body, since the caller is not authorized yet
+	huge := `{"key":"` + strings.Repeat("x", maxProjectBodyBytes) + `","project_id":"` + projectID.String() + `"}`
+	req, _ = http.NewRequest("POST", "/api/v1/secrets", strings.NewReader(huge))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withClaims(req, uuid.New(), RoleCollaborator))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+func TestClientFromContext(t *testing.T) {
+	clientID := uuid.New()
+	req, _ := http.NewRequest("GET", "/api/v1/clients", nil)
+
+	scoped := withClaims(req, uuid.New(), RoleAdmin)
+	claims := scoped.Context().Value(AdminContextKey).(jwt.MapClaims)
+	claims["client_id"] = clientID.String()
+
+	got, ok := ClientFromContext(scoped.Context())
+	assert.True(t, ok)
+	assert.Equal(t, clientID, got)
+
+	// Portal accounts never hold a global role, even if the token claims one
+	assert.Equal(t, RoleCollaborator, RoleFromContext(scoped.Context()))
+	assert.False(t, IsAdmin(scoped.Context()))
+
+	_, ok = ClientFromContext(withClaims(req, uuid.New(), RoleAdmin).Context())
+	assert.False(t, ok)
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms for access tokens.
+const (
+	AlgEdDSA = "EdDSA"
+	AlgES256 = "ES256"
+)
+
+// SigningKeyRetention is how long a rotated-out key still verifies tokens. It covers the access tokens it
+// signed and gives services caching the JWKS time to pick up its successor.
+const SigningKeyRetention = time.Hour
+
+// signingKeyReloadInterval limits how often an unknown kid triggers a reload from the store.
+const signingKeyReloadInterval = 10 * time.Second
+
+// ErrNoSigningKey is returned when tokens are issued before a signing key was loaded.
+var ErrNoSigningKey = errors.New("no signing key loaded")
+
+// SigningKeyStore persists signing keys, with their private keys encrypted under BASTION_JWT_SECRET.
+type SigningKeyStore interface {
+	// ListSigningKeys returns the keys that still verify tokens, newest first.
+	ListSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	// RotateSigningKey retires the current key, keeping it for retention, and makes key the current one.
+	RotateSigningKey(ctx context.Context, key *models.SigningKey, retention time.Duration) error
+}
+
+// signingKey is a decrypted signing key. Retired keys only verify.
+type signingKey struct {
+	kid       string
+	algorithm string
+	private   interface{}
+	public    interface{}
+	expiresAt *time.Time
+}
+
+// keyRing holds the keys of this process: the current one signs, all of them verify.
+type keyRing struct {
+	mu         sync.RWMutex
+	current    *signingKey
+	byKID      map[string]*signingKey
+	reloadedAt time.Time
+}
+
+var ring = &keyRing{byKID: map[string]*signingKey{}}
+
+// ValidSigningAlgorithm reports whether alg is a supported signing algorithm.
+func ValidSigningAlgorithm(alg string) bool {
+	return alg == AlgEdDSA || alg == AlgES256
+}
+
+// NewSigningKey generates a signing key. Its private key is encrypted under BASTION_JWT_SECRET, so the
+// store never holds it in the clear.
+func NewSigningKey(algorithm string) (*models.SigningKey, error) {
+	var private, public interface{}
+	switch algorithm {
+	case AlgEdDSA:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private, public = priv, pub
+	case AlgES256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private, public = priv, &priv.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kid is derived from the public key, so it can't collide across rotations
+	sum := sha256.Sum256(publicDER)
+	kid := base64.RawURLEncoding.EncodeToString(sum[:12])
+
+	kek, err := signingKeyKEK()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := crypto.EncryptWithAAD(kek, privateDER, signingKeyAAD(kid))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		KID:        kid,
+		Algorithm:  algorithm,
+		PublicKey:  base64.StdEncoding.EncodeToString(publicDER),
+		PrivateKey: hex.EncodeToString(sealed),
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// LoadSigningKeys installs the keys of the store. If there is no current key yet, one is generated with
+// the algorithm from BASTION_JWT_ALGORITHM, EdDSA by default.
+func LoadSigningKeys(ctx context.Context, store SigningKeyStore) error {
+	keys, err := store.ListSigningKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	if len(keys) == 0 || keys[0].RetiredAt != nil {
+		algorithm := os.Getenv("BASTION_JWT_ALGORITHM")
+		if algorithm == "" {
+			algorithm = AlgEdDSA
+		}
+		key, err := NewSigningKey(algorithm)
+		if err != nil {
+			return err
+		}
+		if err := store.RotateSigningKey(ctx, key, SigningKeyRetention); err != nil {
+			return fmt.Errorf("failed to store signing key: %w", err)
+		}
+		if keys, err = store.ListSigningKeys(ctx); err != nil {
+			return fmt.Errorf("failed to list signing keys: %w", err)
+		}
+	}
+
+	return installSigningKeys(keys)
+}
+
+// WatchSigningKeys reloads the keys periodically, so rotations made through another instance or the CLI
+// are picked up, until ctx is done.
+func WatchSigningKeys(ctx context.Context, store SigningKeyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := LoadSigningKeys(ctx, store); err != nil {
+				log.Printf("signing keys: %v", err)
+			}
+		}
+	}
+}
+
+func installSigningKeys(keys []models.SigningKey) error {
+	kek, err := signingKeyKEK()
+	if err != nil {
+		return err
+	}
+
+	var current *signingKey
+	byKID := make(map[string]*signingKey, len(keys))
+	for _, k := range keys {
+		publicDER, err := base64.StdEncoding.DecodeString(k.PublicKey)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", k.KID, err)
+		}
+		public, err := x509.ParsePKIXPublicKey(publicDER)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", k.KID, err)
+		}
+		key := &signingKey{kid: k.KID, algorithm: k.Algorithm, public: public, expiresAt: k.ExpiresAt}
+
+		if k.RetiredAt == nil && current == nil {
+			sealed, err := hex.DecodeString(k.PrivateKey)
+			if err != nil {
+				return fmt.Errorf("signing key %s: %w", k.KID, err)
+			}
+			privateDER, err := crypto.OpenEnvelope(kek, sealed, signingKeyAAD(k.KID))
+			if err != nil {
+				return fmt.Errorf("signing key %s could not be decrypted, was BASTION_JWT_SECRET changed? %w", k.KID, err)
+			}
+			if key.private, err = x509.ParsePKCS8PrivateKey(privateDER); err != nil {
+				return fmt.Errorf("signing key %s: %w", k.KID, err)
+			}
+			current = key
+		}
+		byKID[k.KID] = key
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.current = current
+	ring.byKID = byKID
+	ring.reloadedAt = time.Now()
+	return nil
+}
+
+// signToken signs claims with the current key, naming it in the kid header.
+func signToken(claims jwt.Claims) (string, error) {
+	ring.mu.RLock()
+	key := ring.current
+	ring.mu.RUnlock()
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.algorithm), claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// verificationKey returns the public key named by a token's kid, reloading the keys once in a while if
+// the kid is unknown, e.g. right after another instance rotated.
+func verificationKey(ctx context.Context, store SigningKeyStore, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	key := ring.lookup(kid)
+	if key == nil && store != nil && ring.reloadDue() {
+		if err := LoadSigningKeys(ctx, store); err != nil {
+			return nil, err
+		}
+		key = ring.lookup(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if key.expiresAt != nil && time.Now().After(*key.expiresAt) {
+		return nil, fmt.Errorf("signing key %q has expired", kid)
+	}
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+func (r *keyRing) lookup(kid string) *signingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byKID[kid]
+}
+
+func (r *keyRing) reloadDue() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return time.Since(r.reloadedAt) > signingKeyReloadInterval
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"` // RSA modulus, for keys of OpenID Connect providers
+	E         string `json:"e,omitempty"` // RSA exponent
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns the public keys that verify tokens, so other services can check Bastion tokens
+// without sharing a secret.
+func PublicJWKS() JWKSet {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range ring.byKID {
+		if key.expiresAt != nil && time.Now().After(*key.expiresAt) {
+			continue
+		}
+		jwk := JWK{Use: "sig", Algorithm: key.algorithm, KeyID: key.kid}
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *ecdsa.PublicKey:
+			jwk.KeyType, jwk.Curve = "EC", "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32)))
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// signingKeyKEK derives the key that encrypts signing keys at rest from BASTION_JWT_SECRET.
+func signingKeyKEK() ([]byte, error) {
+	return serverKEK("bastion/jwt-signing-keys/v1")
+}
+
+// serverKEK derives a key for encrypting server-side secrets at rest from BASTION_JWT_SECRET with HKDF.
+// Each use has its own label, so the keys are independent.
+func serverKEK(label string) ([]byte, error) {
+	secret := os.Getenv("BASTION_JWT_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("BASTION_JWT_SECRET not set")
+	}
+	return crypto.DeriveSubkey([]byte(secret), label)
+}
+
+func signingKeyAAD(kid string) []byte {
+	return []byte("bastion/jwt-signing-key/" + kid)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useSigningKey installs a fresh signing key of the given algorithm as the current one.
+func useSigningKey(t *testing.T, algorithm string) *models.SigningKey {
+	t.Helper()
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+	key, err := NewSigningKey(algorithm)
+	require.NoError(t, err)
+	require.NoError(t, installSigningKeys([]models.SigningKey{*key}))
+	return key
+}
+
+func TestSigningKeys_Algorithms(t *testing.T) {
+	for _, algorithm := range []string{AlgEdDSA, AlgES256} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := useSigningKey(t, algorithm)
+			sessionID := uuid.New()
+			store := fakeStore{sessions: map[uuid.UUID]bool{sessionID: true}}
+
+			token, err := GenerateToken(uuid.New(), "alice", RoleCollaborator, nil, sessionID)
+			require.NoError(t, err)
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, key.KID, parsed.Header["kid"])
+			assert.Equal(t, algorithm, parsed.Header["alg"])
+
+			rr, _ := serveWithToken(store, token)
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			jwks := PublicJWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, key.KID, jwks.Keys[0].KeyID)
+			assert.Equal(t, algorithm, jwks.Keys[0].Algorithm)
+
+			// The document is usable by a standard JWKS consumer
+			raw, err := json.Marshal(jwks)
+			require.NoError(t, err)
+			assert.Contains(t, string(raw), `"use":"sig"`)
+		})
+	}
+}
+
+func TestSigningKeys_Rotation(t *testing.T) {
+	old := useSigningKey(t, AlgEdDSA)
+	sessionID := uuid.New()
+	store := fakeStore{sessions: map[uuid.UUID]bool{sessionID: true}}
+
+	oldToken, err := GenerateToken(uuid.New(), "alice", RoleCollaborator, nil, sessionID)
+	require.NoError(t, err)
+
+	next, err := NewSigningKey(AlgES256)
+	require.NoError(t, err)
+	retiredAt := time.Now()
+	expiresAt := retiredAt.Add(SigningKeyRetention)
+	old.RetiredAt, old.ExpiresAt = &retiredAt, &expiresAt
+	require.NoError(t, installSigningKeys([]models.SigningKey{*next, *old}))
+
+	// New tokens are signed with the new key, old ones verify during the rotation window
+	newToken, err := GenerateToken(uuid.New(), "alice", RoleCollaborator, nil, sessionID)
+	require.NoError(t, err)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	assert.Equal(t, next.KID, parsed.Header["kid"])
+
+	rr, _ := serveWithToken(store, oldToken)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = serveWithToken(store, newToken)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, PublicJWKS().Keys, 2)
+
+	// After the window, the retired key no longer verifies
+	expired := time.Now().Add(-time.Minute)
+	old.ExpiresAt = &expired
+	require.NoError(t, installSigningKeys([]models.SigningKey{*next, *old}))
+	rr, _ = serveWithToken(store, oldToken)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Len(t, PublicJWKS().Keys, 1)
+}
+
+func TestSigningKeys_ReloadsUnknownKID(t *testing.T) {
+	useSigningKey(t, AlgEdDSA)
+	sessionID := uuid.New()
+
+	// Another instance rotated: this one has not seen the new key yet
+	other, err := NewSigningKey(AlgEdDSA)
+	require.NoError(t, err)
+	require.NoError(t, installSigningKeys([]models.SigningKey{*other}))
+	token, err := GenerateToken(uuid.New(), "alice", RoleCollaborator, nil, sessionID)
+	require.NoError(t, err)
+	useSigningKey(t, AlgEdDSA)
+	ring.reloadedAt = time.Time{}
+
+	store := fakeStore{sessions: map[uuid.UUID]bool{sessionID: true}, signingKeys: []models.SigningKey{*other}}
+	rr, _ := serveWithToken(store, token)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestSigningKeys_RejectsForgedTokens(t *testing.T) {
+	key := useSigningKey(t, AlgEdDSA)
+	sessionID := uuid.New()
+	store := fakeStore{sessions: map[uuid.UUID]bool{sessionID: true}}
+	claims := jwt.MapClaims{
+		"user_id": uuid.New().String(),
+		"role":    RoleAdmin,
+		"jti":     sessionID.String(),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}
+
+	// HMAC with the old shared secret, or with the public key, must not pass for the current kid
+	for _, secret := range []string{"test-secret", key.PublicKey} {
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		forged.Header["kid"] = key.KID
+		token, err := forged.SignedString([]byte(secret))
+		require.NoError(t, err)
+
+		rr, _ := serveWithToken(store, token)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestLoadSigningKeys_WrongSecret(t *testing.T) {
+	key := useSigningKey(t, AlgEdDSA)
+
+	t.Setenv("BASTION_JWT_SECRET", "another-secret")
+	err := LoadSigningKeys(context.Background(), fakeStore{signingKeys: []models.SigningKey{*key}})
+	assert.Error(t, err)
+}
+
+func TestInstallSigningKeys_RejectsUnboundPayload(t *testing.T) {
+	key := useSigningKey(t, AlgEdDSA)
+	kek, err := signingKeyKEK()
+	require.NoError(t, err)
+	sealed, err := hex.DecodeString(key.PrivateKey)
+	require.NoError(t, err)
+	privateDER, err := crypto.OpenEnvelope(kek, sealed, signingKeyAAD(key.KID))
+	require.NoError(t, err)
+
+	// Signing keys were never stored without their kid as additional data, so a bare nonce||ciphertext is refused
+	legacy, err := crypto.Encrypt(kek, privateDER)
+	require.NoError(t, err)
+	key.PrivateKey = hex.EncodeToString(legacy)
+	assert.Error(t, installSigningKeys([]models.SigningKey{*key}))
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginPolicy limits password guessing against one account: after a few failures in a row each attempt
+// must wait longer, and after MaxFailures the account is locked for LockoutDuration.
+type LoginPolicy struct {
+	MaxFailures     int           // Failures in a row that lock the account
+	LockoutDuration time.Duration // How long a lockout lasts
+	BackoffAfter    int           // Failures in a row before attempts must wait
+	MaxBackoff      time.Duration // Longest wait between attempts
+	FailureWindow   time.Duration // Failures are forgotten after this long without one
+	IPRateLimit     int           // Login requests per minute from one IP address
+}
+
+// DefaultLoginPolicy returns the policy used unless configured otherwise.
+func DefaultLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+		BackoffAfter:    3,
+		MaxBackoff:      time.Minute,
+		FailureWindow:   time.Hour,
+		IPRateLimit:     20,
+	}
+}
+
+// LoginPolicyFromEnv returns the default policy with the limits set by BASTION_LOGIN_MAX_FAILURES,
+// BASTION_LOGIN_LOCKOUT and BASTION_LOGIN_RATE_LIMIT.
+func LoginPolicyFromEnv() (LoginPolicy, error) {
+	p := DefaultLoginPolicy()
+
+	if v := os.Getenv("BASTION_LOGIN_MAX_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("BASTION_LOGIN_MAX_FAILURES must be a positive number, got %q", v)
+		}
+		p.MaxFailures = n
+	}
+	if v := os.Getenv("BASTION_LOGIN_LOCKOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("BASTION_LOGIN_LOCKOUT must be a positive duration such as 15m, got %q", v)
+		}
+		p.LockoutDuration = d
+	}
+	if v := os.Getenv("BASTION_LOGIN_RATE_LIMIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("BASTION_LOGIN_RATE_LIMIT must be a positive number, got %q", v)
+		}
+		p.IPRateLimit = n
+	}
+	return p, nil
+}
+
+// Backoff returns how long to wait after the last of failures in a row before the next attempt: nothing
+// for the first few, then one second, doubling up to MaxBackoff.
+func (p LoginPolicy) Backoff(failures int) time.Duration {
+	if failures < p.BackoffAfter {
+		return 0
+	}
+	exp := failures - p.BackoffAfter
+	if exp > 30 {
+		return p.MaxBackoff
+	}
+	return time.Duration(math.Min(float64(time.Second<<exp), float64(p.MaxBackoff)))
+}
+
+// RateLimiter limits requests per key with a token bucket: a key may burst up to its limit, then is
+// refilled steadily over a minute. It only counts requests within one server.
+type RateLimiter struct {
+	perMinute int
+	proxies   TrustedProxies
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	now       func() time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perMinute requests per key. Its middleware keys requests by
+// client address, believing forwarded addresses only from the given proxies.
+func NewRateLimiter(perMinute int, proxies TrustedProxies) *RateLimiter {
+	return &RateLimiter{perMinute: perMinute, proxies: proxies, buckets: make(map[string]*rateBucket), now: time.Now}
+}
+
+// Allow takes a token for key. If none is left, it returns false and how long until one is.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	rate := float64(l.perMinute) / time.Minute.Seconds()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		// Full buckets are the same as no bucket, so drop them now and then to bound memory
+		if len(l.buckets) >= 10000 {
+			l.sweep(now, rate)
+		}
+		b = &rateBucket{tokens: float64(l.perMinute), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.perMinute), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *RateLimiter) sweep(now time.Time, rate float64) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(l.perMinute) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware answers 429 with a Retry-After header to clients over the limit, by IP address.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(l.proxies.ClientAddr(r)); !ok {
+			w.Header().Set("Retry-After", RetryAfter(wait))
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RetryAfter formats a wait for the Retry-After header, in whole seconds rounded up.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// DecoyCredentialID returns the credential ID offered for a passkey login by an email without passkeys, so
+// it is answered like one with them. It is keyed from BASTION_JWT_SECRET, so it stays the same between
+// attempts but cannot be told from a real one.
+func DecoyCredentialID(email string) ([]byte, error) {
+	key, err := serverKEK("bastion/passkey-decoys/v1")
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(email)))
+	return mac.Sum(nil), nil
+}
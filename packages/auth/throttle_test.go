@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginPolicy_Backoff(t *testing.T) {
+	p := DefaultLoginPolicy()
+
+	assert.Equal(t, time.Duration(0), p.Backoff(0))
+	assert.Equal(t, time.Duration(0), p.Backoff(p.BackoffAfter-1))
+	assert.Equal(t, time.Second, p.Backoff(p.BackoffAfter))
+	assert.Equal(t, 2*time.Second, p.Backoff(p.BackoffAfter+1))
+	assert.Equal(t, 4*time.Second, p.Backoff(p.BackoffAfter+2))
+	assert.Equal(t, p.MaxBackoff, p.Backoff(p.BackoffAfter+20))
+	assert.Equal(t, p.MaxBackoff, p.Backoff(1000))
+}
+
+func TestLoginPolicyFromEnv(t *testing.T) {
+	t.Setenv("BASTION_LOGIN_MAX_FAILURES", "5")
+	t.Setenv("BASTION_LOGIN_LOCKOUT", "1h")
+	t.Setenv("BASTION_LOGIN_RATE_LIMIT", "60")
+	p, err := LoginPolicyFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, 5, p.MaxFailures)
+	assert.Equal(t, time.Hour, p.LockoutDuration)
+	assert.Equal(t, 60, p.IPRateLimit)
+
+	t.Setenv("BASTION_LOGIN_LOCKOUT", "forever")
+	_, err = LoginPolicyFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("BASTION_LOGIN_LOCKOUT", "")
+	t.Setenv("BASTION_LOGIN_MAX_FAILURES", "0")
+	_, err = LoginPolicyFromEnv()
+	assert.Error(t, err)
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(3, nil)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("10.0.0.1")
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 20*time.Second, wait.Round(time.Second))
+
+	// Other addresses have buckets of their own
+	ok, _ = l.Allow("10.0.0.2")
+	assert.True(t, ok)
+
+	// A token comes back every 20 seconds
+	now = now.Add(20 * time.Second)
+	ok, _ = l.Allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = l.Allow("10.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.1")
+	require.NoError(t, err)
+	l := NewRateLimiter(1, proxies)
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+	req.RemoteAddr = "192.0.2.1:50000"
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The port changes with every connection, the address doesn't
+	req.RemoteAddr = "192.0.2.1:50001"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+
+	// A forged X-Forwarded-For does not get a fresh bucket
+	req.Header.Set("X-Forwarded-For", "203.0.113.99")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	// Clients behind a trusted proxy are counted apart
+	for _, client := range []string{"203.0.113.1", "203.0.113.2"} {
+		req = httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		req.Header.Set("X-Forwarded-For", client)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/google/uuid"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults of every authenticator app.
+const (
+	TOTPPeriod = 30 * time.Second
+	TOTPDigits = 6
+	// totpSkew is how many periods before and after the current one are accepted, for clock drift.
+	totpSkew = 1
+	// totpSecretSize is the size of a TOTP secret, the HMAC-SHA1 block-aligned size RFC 4226 recommends.
+	totpSecretSize = 20
+)
+
+// RecoveryCodeCount is how many single-use recovery codes a user gets when enrolling.
+const RecoveryCodeCount = 10
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret generates a TOTP secret.
+func NewTOTPSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeTOTPSecret returns a secret in the base32 form authenticator apps accept for manual entry.
+func EncodeTOTPSecret(secret []byte) string {
+	return totpEncoding.EncodeToString(secret)
+}
+
+// TOTPURI returns the otpauth:// URI an authenticator app enrolls from, usually shown as a QR code.
+func TOTPURI(issuer, account string, secret []byte) string {
+	params := url.Values{
+		"secret":    {EncodeTOTPSecret(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(TOTPDigits)},
+		"period":    {fmt.Sprint(int(TOTPPeriod.Seconds()))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPStep returns the time step t falls in.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// TOTPCode returns the code of a time step.
+func TOTPCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226, section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// VerifyTOTP checks a code against the steps around t and returns the step it matched. Steps up to
+// lastStep were already used and are rejected, so a code cannot be replayed.
+func VerifyTOTP(secret []byte, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(TOTPCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// SealTOTPSecret encrypts a user's TOTP secret for storage. Unlike user keys, the server must read it to
+// verify codes, so it is encrypted under a key derived from BASTION_JWT_SECRET and bound to the user.
+func SealTOTPSecret(userID uuid.UUID, secret []byte) (string, error) {
+	kek, err := serverKEK("bastion/totp-secrets/v1")
+	if err != nil {
+		return "", err
+	}
+	sealed, err := crypto.EncryptWithAAD(kek, secret, totpAAD(userID))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sealed), nil
+}
+
+// OpenTOTPSecret decrypts a secret sealed by SealTOTPSecret.
+func OpenTOTPSecret(userID uuid.UUID, sealedHex string) ([]byte, error) {
+	kek, err := serverKEK("bastion/totp-secrets/v1")
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := hex.DecodeString(sealedHex)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := crypto.OpenEnvelope(kek, sealed, totpAAD(userID))
+	if err != nil {
+		return nil, fmt.Errorf("TOTP secret could not be decrypted, was BASTION_JWT_SECRET changed? %w", err)
+	}
+	return secret, nil
+}
+
+func totpAAD(userID uuid.UUID) []byte {
+	return []byte("bastion/totp-secret/" + userID.String())
+}
+
+// NewRecoveryCodes generates single-use recovery codes, formatted for reading, e.g. "k3x9d-7mqpa".
+func NewRecoveryCodes() ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz023456789" // 32 symbols, without i, l, o and 1
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = alphabet[b[j]&31]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the stored form of a recovery code, ignoring case, spaces and dashes.
+func HashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	return crypto.HashToken(normalized)
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// Test vectors of RFC 6238, appendix B, for SHA1, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, TOTPCode(secret, TOTPStep(time.Unix(tt.unix, 0))), tt.unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	now := time.Now()
+	step := TOTPStep(now)
+
+	got, ok := VerifyTOTP(secret, TOTPCode(secret, step), now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	// One period of clock drift either way is fine, two are not
+	_, ok = VerifyTOTP(secret, TOTPCode(secret, step-1), now, 0)
+	assert.True(t, ok)
+	_, ok = VerifyTOTP(secret, TOTPCode(secret, step+1), now, 0)
+	assert.True(t, ok)
+	_, ok = VerifyTOTP(secret, TOTPCode(secret, step-2), now, 0)
+	assert.False(t, ok)
+
+	// A used step cannot be replayed
+	_, ok = VerifyTOTP(secret, TOTPCode(secret, step), now, step)
+	assert.False(t, ok)
+
+	_, ok = VerifyTOTP(secret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestSealTOTPSecret(t *testing.T) {
+	t.Setenv("BASTION_JWT_SECRET", "test-secret")
+	userID := uuid.New()
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+
+	sealed, err := SealTOTPSecret(userID, secret)
+	require.NoError(t, err)
+	opened, err := OpenTOTPSecret(userID, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, secret, opened)
+
+	// A sealed secret is bound to its user
+	_, err = OpenTOTPSecret(uuid.New(), sealed)
+	assert.Error(t, err)
+
+	// and a bare nonce||ciphertext, which binds it to no one, is refused
+	kek, err := serverKEK("bastion/totp-secrets/v1")
+	require.NoError(t, err)
+	legacy, err := crypto.Encrypt(kek, secret)
+	require.NoError(t, err)
+	_, err = OpenTOTPSecret(userID, hex.EncodeToString(legacy))
+	assert.Error(t, err)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Bastion", "alice@example.com", []byte("12345678901234567890"))
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Bastion:alice@example.com?"), uri)
+	assert.Contains(t, uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	assert.Contains(t, uri, "issuer=Bastion")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodeCount)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, `^[a-z0-9]{5}-[a-z0-9]{5}$`, code)
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+
+	// Codes are matched regardless of how they are typed back
+	assert.Equal(t, HashRecoveryCode("k3x9d-7mqpa"), HashRecoveryCode(" K3X9D 7MQPA "))
+	assert.NotEqual(t, HashRecoveryCode("k3x9d-7mqpa"), HashRecoveryCode("k3x9d-7mqpb"))
+}
@@ -8,10 +8,11 @@ import (
 )
 
 type Profile struct {
-	Name     string `yaml:"name"`
-	URL      string `yaml:"url"`
-	Token    string `yaml:"token,omitempty"`
-	IsActive bool   `yaml:"is_active"`
+	Name         string `yaml:"name"`
+	URL          string `yaml:"url"`
+	Token        string `yaml:"token,omitempty"`
+	RefreshToken string `yaml:"refresh_token,omitempty"`
+	IsActive     bool   `yaml:"is_active"`
 }
 
 type Config struct {
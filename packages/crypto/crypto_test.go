@@ -104,3 +104,47 @@ func TestHexEncoding(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, data, decoded)
 }
+
+func TestEncryptDecryptWithAAD(t *testing.T) {
+	key, _ := GenerateRandomKey()
+	aad := []byte("project|API_KEY|1")
+
+	payload, err := EncryptWithAAD(key, []byte("hello"), aad)
+	require.NoError(t, err)
+	assert.True(t, IsEnvelope(payload))
+	assert.Equal(t, EnvelopeV1, payload[2])
+	assert.Equal(t, AlgAES256GCM, payload[3])
+
+	plaintext, err := DecryptWithAAD(key, payload, aad)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), plaintext)
+
+	// Swapped context must fail
+	_, err = DecryptWithAAD(key, payload, []byte("project|OTHER_KEY|1"))
+	assert.Error(t, err)
+
+	// Different key is detected through the key ID
+	otherKey, _ := GenerateRandomKey()
+	_, err = OpenEnvelope(otherKey, payload, aad)
+	assert.ErrorIs(t, err, ErrKeyMismatch)
+
+	// Tampering with the header is detected
+	tampered := append([]byte{}, payload...)
+	tampered[2] = 9
+	_, err = OpenEnvelope(key, tampered, aad)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestDecryptWithAAD_Legacy(t *testing.T) {
+	key, _ := GenerateRandomKey()
+
+	legacy, err := Encrypt(key, []byte("old-secret"))
+	require.NoError(t, err)
+
+	_, err = OpenEnvelope(key, legacy, nil)
+	assert.ErrorIs(t, err, ErrNotEnvelope)
+
+	plaintext, err := DecryptWithAAD(key, legacy, []byte("ignored"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old-secret"), plaintext)
+}
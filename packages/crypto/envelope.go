@@ -0,0 +1,125 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// Envelope layout (all fields are authenticated):
+//
+//	magic "BV" (2) | format version (1) | algorithm ID (1) | key ID (8) | nonce (12) | ciphertext + tag
+//
+// The header up to the key ID is prepended to the caller's additional data, so a payload
+// cannot be re-labelled with a different version, algorithm or key.
+const (
+	EnvelopeV1   byte = 1
+	AlgAES256GCM byte = 1
+
+	keyIDLen          = 8
+	envelopeNonceLen  = 12
+	envelopePrefixLen = 2 + 1 + 1 + keyIDLen
+	envelopeHeaderLen = envelopePrefixLen + envelopeNonceLen
+)
+
+var envelopeMagic = []byte("BV")
+
+var (
+	ErrNotEnvelope          = errors.New("payload is not a versioned envelope")
+	ErrUnsupportedVersion   = errors.New("unsupported envelope version")
+	ErrUnsupportedAlgorithm = errors.New("unsupported envelope algorithm")
+	ErrKeyMismatch          = errors.New("payload was encrypted with a different key")
+)
+
+// KeyID returns a short, non-secret identifier for a key, used to detect which key sealed an envelope.
+func KeyID(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("bastion/key-id/v1"), key...))
+	return sum[:keyIDLen]
+}
+
+// IsEnvelope reports whether the payload carries a versioned envelope header.
+func IsEnvelope(payload []byte) bool {
+	return len(payload) > envelopeHeaderLen && bytes.Equal(payload[:2], envelopeMagic)
+}
+
+// EncryptWithAAD seals plaintext in a versioned AES-256-GCM envelope bound to the additional data.
+func EncryptWithAAD(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, envelopeHeaderLen)
+	header = append(header, envelopeMagic...)
+	header = append(header, EnvelopeV1, AlgAES256GCM)
+	header = append(header, KeyID(key)...)
+
+	nonce := make([]byte, envelopeNonceLen)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	additional := append(append([]byte{}, header...), aad...)
+	header = append(header, nonce...)
+
+	return gcm.Seal(header, nonce, plaintext, additional), nil
+}
+
+// OpenEnvelope decrypts a versioned envelope. It fails with ErrNotEnvelope for legacy payloads.
+func OpenEnvelope(key, payload, aad []byte) ([]byte, error) {
+	if !IsEnvelope(payload) {
+		return nil, ErrNotEnvelope
+	}
+
+	switch {
+	case payload[2] != EnvelopeV1:
+		return nil, ErrUnsupportedVersion
+	case payload[3] != AlgAES256GCM:
+		return nil, ErrUnsupportedAlgorithm
+	case !bytes.Equal(payload[4:envelopePrefixLen], KeyID(key)):
+		return nil, ErrKeyMismatch
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	additional := append(append([]byte{}, payload[:envelopePrefixLen]...), aad...)
+	nonce := payload[envelopePrefixLen:envelopeHeaderLen]
+
+	return gcm.Open(nil, nonce, payload[envelopeHeaderLen:], additional)
+}
+
+// DecryptWithAAD decrypts a versioned envelope bound to the additional data.
+// Legacy nonce||ciphertext payloads produced by Encrypt are still accepted, without AAD, so nothing binds
+// them to aad: use OpenEnvelope for data that was never stored in the legacy format.
+func DecryptWithAAD(key, payload, aad []byte) ([]byte, error) {
+	plaintext, err := OpenEnvelope(key, payload, aad)
+	if err == nil {
+		return plaintext, nil
+	}
+
+	// A legacy payload may start with the magic bytes by chance, so always try the legacy format
+	if legacy, legacyErr := Decrypt(key, payload); legacyErr == nil {
+		return legacy, nil
+	}
+	if errors.Is(err, ErrNotEnvelope) {
+		return nil, errors.New("decryption failed")
+	}
+	return nil, err
+}
@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Environment variables overriding the Argon2id parameters used for new hashes and KEKs.
+const (
+	EnvKDFTime    = "BASTION_KDF_TIME"
+	EnvKDFMemory  = "BASTION_KDF_MEMORY" // KiB
+	EnvKDFThreads = "BASTION_KDF_THREADS"
+)
+
+// KDFParams are the Argon2id cost parameters stored alongside every salt.
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+var (
+	// LegacyKDFParams are the parameters implied by a bare hex salt, as stored before parameters were encoded.
+	LegacyKDFParams = KDFParams{Time: timeParams, Memory: memParams, Threads: threads}
+
+	// DefaultKDFParams are used for new hashes unless overridden by the environment (RFC 9106, second recommendation).
+	DefaultKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+)
+
+var ErrInvalidEncodedSalt = errors.New("invalid encoded salt")
+
+// Validate checks that the parameters are accepted by Argon2id.
+func (p KDFParams) Validate() error {
+	if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("invalid argon2id parameters: m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads)
+	}
+	return nil
+}
+
+// CurrentKDFParams returns the parameters to use for new hashes: the defaults, overridden by the environment.
+// Invalid overrides are ignored.
+func CurrentKDFParams() KDFParams {
+	p := DefaultKDFParams
+	if v, err := strconv.ParseUint(os.Getenv(EnvKDFTime), 10, 32); err == nil {
+		p.Time = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv(EnvKDFMemory), 10, 32); err == nil {
+		p.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv(EnvKDFThreads), 10, 8); err == nil {
+		p.Threads = uint8(v)
+	}
+	if p.Validate() != nil {
+		return DefaultKDFParams
+	}
+	return p
+}
+
+// DeriveKeyWithParams generates a 32-byte key from a password and salt using Argon2id with explicit parameters.
+func DeriveKeyWithParams(password, salt []byte, p KDFParams) []byte {
+	return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, keyLen)
+}
+
+// EncodeSalt encodes a salt and its parameters as a PHC-style string: $argon2id$v=19$m=...,t=...,p=...$<salt>.
+func EncodeSalt(salt []byte, p KDFParams) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, base64.RawStdEncoding.EncodeToString(salt))
+}
+
+// DecodeSalt parses a salt produced by EncodeSalt. Bare hex salts are accepted with LegacyKDFParams.
+func DecodeSalt(encoded string) ([]byte, KDFParams, error) {
+	if !strings.HasPrefix(encoded, "$") {
+		salt, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, KDFParams{}, ErrInvalidEncodedSalt
+		}
+		return salt, LegacyKDFParams, nil
+	}
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "argon2id" {
+		return nil, KDFParams{}, ErrInvalidEncodedSalt
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, KDFParams{}, ErrInvalidEncodedSalt
+	}
+
+	var p KDFParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return nil, KDFParams{}, ErrInvalidEncodedSalt
+	}
+	if err := p.Validate(); err != nil {
+		return nil, KDFParams{}, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, KDFParams{}, ErrInvalidEncodedSalt
+	}
+
+	return salt, p, nil
+}
+
+// NewEncodedSalt generates a random salt encoded with the current parameters.
+func NewEncodedSalt() (string, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return "", err
+	}
+	return EncodeSalt(salt, CurrentKDFParams()), nil
+}
+
+// DeriveKeyFromEncodedSalt derives a key using the salt and parameters of an encoded salt.
+func DeriveKeyFromEncodedSalt(password []byte, encodedSalt string) ([]byte, error) {
+	salt, p, err := DecodeSalt(encodedSalt)
+	if err != nil {
+		return nil, err
+	}
+	return DeriveKeyWithParams(password, salt, p), nil
+}
+
+// HashPassword hashes a password with a fresh salt and the current parameters.
+// It returns the hex-encoded hash and the encoded salt.
+func HashPassword(password string) (string, string, error) {
+	encodedSalt, err := NewEncodedSalt()
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := DeriveKeyFromEncodedSalt([]byte(password), encodedSalt)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(hash), encodedSalt, nil
+}
+
+// VerifyPassword checks a password against a hex-encoded hash and its encoded salt in constant time.
+// needsRehash reports whether the hash was computed with parameters other than the current ones.
+func VerifyPassword(password, hashHex, encodedSalt string) (ok bool, needsRehash bool) {
+	salt, p, err := DecodeSalt(encodedSalt)
+	if err != nil {
+		return false, false
+	}
+	storedHash, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return false, false
+	}
+
+	computedHash := DeriveKeyWithParams([]byte(password), salt, p)
+	if subtle.ConstantTimeCompare(computedHash, storedHash) != 1 {
+		return false, false
+	}
+	return true, p != CurrentKDFParams()
+}
+
+// KDFOutdated reports whether an encoded salt uses parameters other than the current ones.
+func KDFOutdated(encodedSalt string) bool {
+	_, p, err := DecodeSalt(encodedSalt)
+	return err == nil && p != CurrentKDFParams()
+}
+
+// DeriveSubkey derives a 32-byte key from a high-entropy secret with HKDF-SHA256. Keys derived with
+// different info strings are independent.
+func DeriveSubkey(secret []byte, info string) ([]byte, error) {
+	return hkdf.Key(sha256.New, secret, nil, info, keyLen)
+}
@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecodeSalt(t *testing.T) {
+	salt, _ := GenerateSalt()
+	p := KDFParams{Time: 2, Memory: 32 * 1024, Threads: 2}
+
+	encoded := EncodeSalt(salt, p)
+	assert.Contains(t, encoded, "$argon2id$v=19$m=32768,t=2,p=2$")
+
+	decodedSalt, decodedParams, err := DecodeSalt(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, salt, decodedSalt)
+	assert.Equal(t, p, decodedParams)
+}
+
+func TestDecodeSalt_Legacy(t *testing.T) {
+	salt, _ := GenerateSalt()
+
+	decodedSalt, p, err := DecodeSalt(hex.EncodeToString(salt))
+	require.NoError(t, err)
+	assert.Equal(t, salt, decodedSalt)
+	assert.Equal(t, LegacyKDFParams, p)
+
+	// Legacy salts keep deriving the same key as DeriveKey
+	key, err := DeriveKeyFromEncodedSalt([]byte("pw"), hex.EncodeToString(salt))
+	require.NoError(t, err)
+	assert.Equal(t, DeriveKey([]byte("pw"), salt), key)
+}
+
+func TestDecodeSalt_Invalid(t *testing.T) {
+	for _, encoded := range []string{
+		"not-hex",
+		"$argon2i$v=19$m=65536,t=1,p=4$c2FsdA",
+		"$argon2id$v=16$m=65536,t=1,p=4$c2FsdA",
+		"$argon2id$v=19$m=1,t=1,p=4$c2FsdA",
+		"$argon2id$v=19$m=65536,t=1,p=4",
+	} {
+		_, _, err := DecodeSalt(encoded)
+		assert.Error(t, err, encoded)
+	}
+}
+
+func TestCurrentKDFParams(t *testing.T) {
+	assert.Equal(t, DefaultKDFParams, CurrentKDFParams())
+
+	os.Setenv(EnvKDFTime, "5")
+	os.Setenv(EnvKDFMemory, "131072")
+	defer os.Unsetenv(EnvKDFTime)
+	defer os.Unsetenv(EnvKDFMemory)
+	assert.Equal(t, KDFParams{Time: 5, Memory: 128 * 1024, Threads: 4}, CurrentKDFParams())
+
+	// Invalid overrides fall back to the defaults
+	os.Setenv(EnvKDFMemory, "1")
+	assert.Equal(t, DefaultKDFParams, CurrentKDFParams())
+}
+
+func TestHashVerifyPassword(t *testing.T) {
+	hash, salt, err := HashPassword("correct horse")
+	require.NoError(t, err)
+
+	ok, rehash := VerifyPassword("correct horse", hash, salt)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _ = VerifyPassword("wrong", hash, salt)
+	assert.False(t, ok)
+
+	// Hashes made with legacy parameters verify but need a rehash
+	legacySalt, _ := GenerateSalt()
+	legacyHash := hex.EncodeToString(DeriveKey([]byte("correct horse"), legacySalt))
+	ok, rehash = VerifyPassword("correct horse", legacyHash, hex.EncodeToString(legacySalt))
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestDeriveSubkey(t *testing.T) {
+	secret := []byte("server secret")
+
+	a, err := DeriveSubkey(secret, "bastion/a/v1")
+	require.NoError(t, err)
+	assert.Len(t, a, 32)
+
+	again, err := DeriveSubkey(secret, "bastion/a/v1")
+	require.NoError(t, err)
+	assert.Equal(t, a, again)
+
+	b, err := DeriveSubkey(secret, "bastion/b/v1")
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+}
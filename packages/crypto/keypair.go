@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// Sealed box layout, used to wrap a key to a recipient's X25519 public key:
+//
+//	magic "BS" (2) | format version (1) | ephemeral public key (32) | envelope
+//
+// The envelope key is derived with HKDF-SHA256 from the X25519 shared secret. The sealed header
+// and the recipient's public key are prepended to the caller's additional data.
+const (
+	SealedV1 byte = 1
+
+	X25519KeyLen = 32
+
+	sealedHeaderLen = 2 + 1 + X25519KeyLen
+	sealInfo        = "bastion/sealed-box/v1"
+)
+
+var sealedMagic = []byte("BS")
+
+var (
+	ErrNotSealed         = errors.New("payload is not a sealed box")
+	ErrInvalidPublicKey  = errors.New("invalid X25519 public key")
+	ErrInvalidPrivateKey = errors.New("invalid X25519 private key")
+)
+
+// GenerateKeyPair generates a new X25519 keypair and returns the raw public and private keys.
+func GenerateKeyPair() (publicKey, privateKey []byte, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv.PublicKey().Bytes(), priv.Bytes(), nil
+}
+
+// PublicKeyFromPrivate returns the X25519 public key matching a raw private key.
+func PublicKeyFromPrivate(privateKey []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	return priv.PublicKey().Bytes(), nil
+}
+
+// IsSealed reports whether the payload carries a sealed box header.
+func IsSealed(payload []byte) bool {
+	return len(payload) > sealedHeaderLen && bytes.Equal(payload[:2], sealedMagic)
+}
+
+// SealToPublicKey encrypts plaintext so that only the holder of the recipient's private key can open it.
+func SealToPublicKey(recipientPublicKey, plaintext, aad []byte) ([]byte, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(recipientPublicKey)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, sealedHeaderLen)
+	header = append(header, sealedMagic...)
+	header = append(header, SealedV1)
+	header = append(header, ephemeral.PublicKey().Bytes()...)
+
+	key, err := sealKey(shared, header, recipientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := EncryptWithAAD(key, plaintext, sealAAD(header, recipientPublicKey, aad))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, envelope...), nil
+}
+
+// OpenSealed decrypts a sealed box with the recipient's private key.
+func OpenSealed(privateKey, sealed, aad []byte) ([]byte, error) {
+	if !IsSealed(sealed) {
+		return nil, ErrNotSealed
+	}
+	if sealed[2] != SealedV1 {
+		return nil, ErrUnsupportedVersion
+	}
+
+	priv, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[3:sealedHeaderLen])
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+
+	shared, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	recipientPublicKey := priv.PublicKey().Bytes()
+	header := sealed[:sealedHeaderLen]
+
+	key, err := sealKey(shared, header, recipientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenEnvelope(key, sealed[sealedHeaderLen:], sealAAD(header, recipientPublicKey, aad))
+}
+
+// sealKey derives the envelope key from the shared secret, bound to both public keys.
+func sealKey(shared, header, recipientPublicKey []byte) ([]byte, error) {
+	salt := append(append([]byte{}, header...), recipientPublicKey...)
+	return hkdf.Key(sha256.New, shared, salt, sealInfo, keyLen)
+}
+
+func sealAAD(header, recipientPublicKey, aad []byte) []byte {
+	out := make([]byte, 0, len(header)+len(recipientPublicKey)+len(aad))
+	out = append(out, header...)
+	out = append(out, recipientPublicKey...)
+	return append(out, aad...)
+}
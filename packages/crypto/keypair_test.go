@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	pub, priv, err := GenerateKeyPair()
+	require.NoError(t, err)
+	assert.Len(t, pub, X25519KeyLen)
+	assert.Len(t, priv, X25519KeyLen)
+
+	derived, err := PublicKeyFromPrivate(priv)
+	require.NoError(t, err)
+	assert.Equal(t, pub, derived)
+}
+
+func TestSealOpen(t *testing.T) {
+	pub, priv, _ := GenerateKeyPair()
+	dataKey, _ := GenerateRandomKey()
+	aad := []byte("bastion/grant/v1|project")
+
+	sealed, err := SealToPublicKey(pub, dataKey, aad)
+	require.NoError(t, err)
+	assert.True(t, IsSealed(sealed))
+
+	opened, err := OpenSealed(priv, sealed, aad)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, opened)
+
+	// Wrong additional data
+	_, err = OpenSealed(priv, sealed, []byte("bastion/grant/v1|other"))
+	assert.Error(t, err)
+
+	// Wrong recipient
+	_, otherPriv, _ := GenerateKeyPair()
+	_, err = OpenSealed(otherPriv, sealed, aad)
+	assert.Error(t, err)
+
+	// Tampered ephemeral key
+	tampered := append([]byte{}, sealed...)
+	tampered[5] ^= 0xff
+	_, err = OpenSealed(priv, tampered, aad)
+	assert.Error(t, err)
+}
+
+func TestSealToPublicKey_Invalid(t *testing.T) {
+	_, err := SealToPublicKey([]byte("short"), []byte("data"), nil)
+	assert.ErrorIs(t, err, ErrInvalidPublicKey)
+
+	_, priv, _ := GenerateKeyPair()
+	_, err = OpenSealed(priv, []byte("not a sealed box at all, but long enough to look like one"), nil)
+	assert.ErrorIs(t, err, ErrNotSealed)
+}
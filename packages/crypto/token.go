@@ -0,0 +1,26 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const tokenLen = 32
+
+// GenerateToken returns a random, URL-safe bearer token with a readable prefix (e.g. "bst_inv_").
+func GenerateToken(prefix string) (string, error) {
+	b := make([]byte, tokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 of a token. Only this hash is stored, so a
+// database leak does not reveal usable tokens; tokens carry enough entropy not to need a salt.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,20 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateToken(t *testing.T) {
+	a, err := GenerateToken("bst_test_")
+	require.NoError(t, err)
+	b, _ := GenerateToken("bst_test_")
+
+	assert.True(t, len(a) > len("bst_test_")+40)
+	assert.Contains(t, a, "bst_test_")
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, HashToken(a), HashToken(a))
+	assert.NotEqual(t, HashToken(a), HashToken(b))
+}
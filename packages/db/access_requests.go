@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAccessRequestNotFound is returned when an access request does not exist or was already decided.
+var ErrAccessRequestNotFound = errors.New("access request not found or already decided")
+
+const accessRequestColumns = `
+	r.id, r.project_id, p.name, r.user_id, u.username, r.role, r.reason, r.duration_hours, r.status,
+	r.decided_by, r.decided_at, r.decision_note, r.grant_expires_at, r.created_at
+`
+
+const accessRequestJoins = `
+	FROM access_requests r
+	JOIN projects p ON p.id = r.project_id
+	JOIN users u ON u.id = r.user_id
+`
+
+func scanAccessRequest(row pgx.Row) (*models.AccessRequest, error) {
+	ar := &models.AccessRequest{}
+	err := row.Scan(
+		&ar.ID,
+		&ar.ProjectID,
+		&ar.ProjectName,
+		&ar.UserID,
+		&ar.Username,
+		&ar.Role,
+		&ar.Reason,
+		&ar.DurationHours,
+		&ar.Status,
+		&ar.DecidedBy,
+		&ar.DecidedAt,
+		&ar.DecisionNote,
+		&ar.GrantExpiresAt,
+		&ar.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+// CreateAccessRequest files a pending request for a short-lived grant.
+func (db *DB) CreateAccessRequest(ctx context.Context, projectID, userID uuid.UUID, role, reason string, durationHours int) (*models.AccessRequest, error) {
+	var id uuid.UUID
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO access_requests (project_id, user_id, role, reason, duration_hours)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, projectID, userID, role, reason, durationHours).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create access request: %w", err)
+	}
+	return db.GetAccessRequest(ctx, id)
+}
+
+// GetAccessRequest returns an access request by ID.
+func (db *DB) GetAccessRequest(ctx context.Context, id uuid.UUID) (*models.AccessRequest, error) {
+	ar, err := scanAccessRequest(db.Pool.QueryRow(ctx, `SELECT `+accessRequestColumns+accessRequestJoins+` WHERE r.id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAccessRequestNotFound
+	}
+	return ar, err
+}
+
+// ListAccessRequests returns access requests, newest first. A non-nil userID restricts the list to that
+// requester, and a non-empty status to requests in that status.
+func (db *DB) ListAccessRequests(ctx context.Context, userID *uuid.UUID, status string) ([]models.AccessRequest, error) {
+	query := `SELECT ` + accessRequestColumns + accessRequestJoins + `
+		WHERE ($1::uuid IS NULL OR r.user_id = $1) AND ($2 = '' OR r.status = $2)
+		ORDER BY r.created_at DESC`
+
+	rows, err := db.Pool.Query(ctx, query, userID, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access requests: %w", err)
+	}
+	defer rows.Close()
+
+	var requests []models.AccessRequest
+	for rows.Next() {
+		ar, err := scanAccessRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan access request: %w", err)
+		}
+		requests = append(requests, *ar)
+	}
+
+	return requests, nil
+}
+
+// ApproveAccessRequest approves a pending request and grants the requested role until now plus the requested
+// duration, with a data key sealed to the requester's public key. A standing grant the requester already
+// holds is left untouched.
+func (db *DB) ApproveAccessRequest(ctx context.Context, id uuid.UUID, wrappedKey string, decidedBy uuid.UUID, note string) (*models.AccessRequest, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID, projectID uuid.UUID
+	var role string
+	err = tx.QueryRow(ctx, `
+		UPDATE access_requests
+		SET status = 'approved', decided_by = $2, decided_at = NOW(), decision_note = $3,
+			grant_expires_at = NOW() + make_interval(hours => duration_hours)
+		WHERE id = $1 AND status = 'pending'
+		RETURNING user_id, project_id, role
+	`, id, decidedBy, note).Scan(&userID, &projectID, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAccessRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve access request: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_project_access (user_id, project_id, wrapped_data_key, role, granted_by, granted_at, expires_at)
+		SELECT user_id, project_id, $2, role, decided_by, NOW(), grant_expires_at FROM access_requests WHERE id = $1
+		ON CONFLICT (user_id, project_id) DO UPDATE
+		SET wrapped_data_key = EXCLUDED.wrapped_data_key, role = EXCLUDED.role,
+			granted_by = EXCLUDED.granted_by, granted_at = EXCLUDED.granted_at, expires_at = EXCLUDED.expires_at
+		WHERE user_project_access.expires_at IS NOT NULL
+	`, id, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to grant project access: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return db.GetAccessRequest(ctx, id)
+}
+
+// DenyAccessRequest denies a pending request.
+func (db *DB) DenyAccessRequest(ctx context.Context, id uuid.UUID, decidedBy uuid.UUID, note string) (*models.AccessRequest, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE access_requests
+		SET status = 'denied', decided_by = $2, decided_at = NOW(), decision_note = $3
+		WHERE id = $1 AND status = 'pending'
+	`, id, decidedBy, note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deny access request: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAccessRequestNotFound
+	}
+	return db.GetAccessRequest(ctx, id)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/dcdavidev/bastion/packages/auth"
 	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/google/uuid"
 )
@@ -13,8 +14,8 @@ import (
 // LogEvent records a sensitive action in the audit_logs table.
 func (db *DB) LogEvent(ctx context.Context, action, targetType string, targetID uuid.UUID, metadata map[string]interface{}) error {
 	query := `
-		INSERT INTO audit_logs (action, target_type, target_id, metadata)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO audit_logs (action, target_type, target_id, metadata, actor_type, actor_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
 	`
 
 	metaJSON, err := json.Marshal(metadata)
@@ -22,7 +23,14 @@ func (db *DB) LogEvent(ctx context.Context, action, targetType string, targetID
 		metaJSON = []byte("{}")
 	}
 
-	_, err = db.Pool.Exec(ctx, query, action, targetType, targetID, metaJSON)
+	// Record who acted, so service account tokens are told apart from users
+	var actorID *uuid.UUID
+	actorType, id := auth.ActorFromContext(ctx)
+	if actorType != "" {
+		actorID = &id
+	}
+
+	_, err = db.Pool.Exec(ctx, query, action, targetType, targetID, metaJSON, actorType, actorID)
 	if err != nil {
 		return fmt.Errorf("failed to log audit event: %w", err)
 	}
@@ -33,6 +41,7 @@ func (db *DB) LogEvent(ctx context.Context, action, targetType string, targetID
 type AuditFilter struct {
 	Action     string
 	TargetType string
+	ActorType  string
 	FromDate   *time.Time
 	ToDate     *time.Time
 	Limit      int
@@ -41,7 +50,7 @@ type AuditFilter struct {
 // GetAuditLogs returns filtered audit events.
 func (db *DB) GetAuditLogs(ctx context.Context, filter AuditFilter) ([]models.AuditLog, error) {
 	query := `
-		SELECT id, action, target_type, target_id, metadata, created_at
+		SELECT id, action, target_type, target_id, COALESCE(actor_type, ''), actor_id, metadata, created_at
 		FROM audit_logs
 		WHERE 1=1
 	`
@@ -60,6 +69,12 @@ func (db *DB) GetAuditLogs(ctx context.Context, filter AuditFilter) ([]models.Au
 		argIdx++
 	}
 
+	if filter.ActorType != "" {
+		query += fmt.Sprintf(" AND actor_type = $%d", argIdx)
+		args = append(args, filter.ActorType)
+		argIdx++
+	}
+
 	if filter.FromDate != nil {
 		query += fmt.Sprintf(" AND created_at >= $%d", argIdx)
 		args = append(args, *filter.FromDate)
@@ -85,7 +100,7 @@ func (db *DB) GetAuditLogs(ctx context.Context, filter AuditFilter) ([]models.Au
 	for rows.Next() {
 		var l models.AuditLog
 		var metaRaw []byte
-		if err := rows.Scan(&l.ID, &l.Action, &l.TargetType, &l.TargetID, &metaRaw, &l.CreatedAt); err != nil {
+		if err := rows.Scan(&l.ID, &l.Action, &l.TargetType, &l.TargetID, &l.ActorType, &l.ActorID, &metaRaw, &l.CreatedAt); err != nil {
 			return nil, err
 		}
 		json.Unmarshal(metaRaw, &l.Metadata)
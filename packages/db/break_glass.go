@@ -0,0 +1,308 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrNoBreakGlassEscrow is returned when the break-glass escrow has not been set up.
+	ErrNoBreakGlassEscrow = errors.New("break-glass escrow is not set up")
+	// ErrBreakGlassEscrowExists is returned when setting up the escrow a second time.
+	ErrBreakGlassEscrowExists = errors.New("break-glass escrow already exists")
+	// ErrNotBreakGlassResponder is returned when a user does not hold the escrow key.
+	ErrNotBreakGlassResponder = errors.New("user is not a break-glass responder")
+	// ErrBreakGlassNotArmed is returned when a project has no data key sealed to the escrow.
+	ErrBreakGlassNotArmed = errors.New("project is not armed for break-glass access")
+	// ErrBreakGlassSessionNotFound is returned when a session does not exist or was already acknowledged.
+	ErrBreakGlassSessionNotFound = errors.New("break-glass session not found or already acknowledged")
+	// ErrSelfAcknowledgment is returned when the responder who broke the glass tries to acknowledge the session.
+	ErrSelfAcknowledgment = errors.New("a break-glass session must be acknowledged by someone other than its responder")
+)
+
+// CreateBreakGlassEscrow stores the escrow public key with its creator as the first responder, since only
+// responders can hand out the escrow private key.
+func (db *DB) CreateBreakGlassEscrow(ctx context.Context, publicKey string, createdBy uuid.UUID, wrappedEscrowKey string) (*models.BreakGlassEscrow, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	escrow := &models.BreakGlassEscrow{}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO break_glass_escrow (public_key, created_by) VALUES ($1, $2)
+		RETURNING public_key, created_by, created_at
+	`, publicKey, createdBy).Scan(&escrow.PublicKey, &escrow.CreatedBy, &escrow.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrBreakGlassEscrowExists
+		}
+		return nil, fmt.Errorf("failed to create break-glass escrow: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO break_glass_responders (user_id, wrapped_escrow_key, added_by) VALUES ($1, $2, $1)
+	`, createdBy, wrappedEscrowKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add escrow creator: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return escrow, nil
+}
+
+// GetBreakGlassEscrow returns the escrow public key.
+func (db *DB) GetBreakGlassEscrow(ctx context.Context) (*models.BreakGlassEscrow, error) {
+	escrow := &models.BreakGlassEscrow{}
+	err := db.Pool.QueryRow(ctx, `SELECT public_key, created_by, created_at FROM break_glass_escrow`).
+		Scan(&escrow.PublicKey, &escrow.CreatedBy, &escrow.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoBreakGlassEscrow
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get break-glass escrow: %w", err)
+	}
+	return escrow, nil
+}
+
+// ListBreakGlassResponders returns the users holding the escrow key, without their wrapped keys.
+func (db *DB) ListBreakGlassResponders(ctx context.Context) ([]models.BreakGlassResponder, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT r.user_id, u.username, COALESCE(u.email, ''), r.added_by, r.added_at
+		FROM break_glass_responders r
+		JOIN users u ON u.id = r.user_id
+		ORDER BY u.username
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list break-glass responders: %w", err)
+	}
+	defer rows.Close()
+
+	var responders []models.BreakGlassResponder
+	for rows.Next() {
+		var r models.BreakGlassResponder
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Email, &r.AddedBy, &r.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan break-glass responder: %w", err)
+		}
+		responders = append(responders, r)
+	}
+
+	return responders, nil
+}
+
+// GetBreakGlassResponder returns a responder with the escrow key sealed to them.
+func (db *DB) GetBreakGlassResponder(ctx context.Context, userID uuid.UUID) (*models.BreakGlassResponder, error) {
+	r := &models.BreakGlassResponder{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT r.user_id, u.username, COALESCE(u.email, ''), r.wrapped_escrow_key, r.added_by, r.added_at
+		FROM break_glass_responders r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.user_id = $1
+	`, userID).Scan(&r.UserID, &r.Username, &r.Email, &r.WrappedEscrowKey, &r.AddedBy, &r.AddedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotBreakGlassResponder
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get break-glass responder: %w", err)
+	}
+	return r, nil
+}
+
+// AddBreakGlassResponder makes a user a responder, or replaces their wrapped escrow key.
+func (db *DB) AddBreakGlassResponder(ctx context.Context, userID uuid.UUID, wrappedEscrowKey string, addedBy uuid.UUID) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO break_glass_responders (user_id, wrapped_escrow_key, added_by, added_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET wrapped_escrow_key = EXCLUDED.wrapped_escrow_key, added_by = EXCLUDED.added_by, added_at = EXCLUDED.added_at
+	`, userID, wrappedEscrowKey, addedBy)
+	if err != nil {
+		return fmt.Errorf("failed to add break-glass responder: %w", err)
+	}
+	return nil
+}
+
+// RemoveBreakGlassResponder removes a user's copy of the escrow key.
+func (db *DB) RemoveBreakGlassResponder(ctx context.Context, userID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM break_glass_responders WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove break-glass responder: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotBreakGlassResponder
+	}
+	return nil
+}
+
+// ArmBreakGlass stores a project's data key sealed to the escrow public key, replacing any previous one.
+func (db *DB) ArmBreakGlass(ctx context.Context, projectID uuid.UUID, wrappedKey string, armedBy uuid.UUID) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO break_glass_keys (project_id, wrapped_data_key, armed_by, armed_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (project_id) DO UPDATE
+		SET wrapped_data_key = EXCLUDED.wrapped_data_key, armed_by = EXCLUDED.armed_by, armed_at = EXCLUDED.armed_at
+	`, projectID, wrappedKey, armedBy)
+	if err != nil {
+		return fmt.Errorf("failed to arm break-glass access: %w", err)
+	}
+	return nil
+}
+
+// DisarmBreakGlass deletes a project's escrowed data key.
+func (db *DB) DisarmBreakGlass(ctx context.Context, projectID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM break_glass_keys WHERE project_id = $1`, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to disarm break-glass access: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrBreakGlassNotArmed
+	}
+	return nil
+}
+
+// ListArmedProjects returns the IDs of the projects armed for break-glass access.
+func (db *DB) ListArmedProjects(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT project_id FROM break_glass_keys`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list armed projects: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan armed project: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// StartBreakGlassSession opens a session for a responder on an armed project.
+func (db *DB) StartBreakGlassSession(ctx context.Context, projectID, userID uuid.UUID, justification string, expiresAt time.Time) (*models.BreakGlassSession, error) {
+	var armed bool
+	if err := db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM break_glass_keys WHERE project_id = $1)`, projectID).Scan(&armed); err != nil {
+		return nil, fmt.Errorf("failed to check break-glass key: %w", err)
+	}
+	if !armed {
+		return nil, ErrBreakGlassNotArmed
+	}
+
+	var id uuid.UUID
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO break_glass_sessions (project_id, user_id, justification, expires_at)
+		SELECT $1, user_id, $3, $4 FROM break_glass_responders WHERE user_id = $2
+		RETURNING id
+	`, projectID, userID, justification, expiresAt).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotBreakGlassResponder
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start break-glass session: %w", err)
+	}
+	return db.getBreakGlassSession(ctx, id)
+}
+
+const breakGlassSessionColumns = `
+	s.id, s.project_id, p.name, s.user_id, u.username, s.justification, s.expires_at,
+	s.acknowledged_by, s.acknowledged_at, s.acknowledgment_note, s.created_at
+	FROM break_glass_sessions s
+	JOIN projects p ON p.id = s.project_id
+	JOIN users u ON u.id = s.user_id
+`
+
+func scanBreakGlassSession(row pgx.Row) (*models.BreakGlassSession, error) {
+	s := &models.BreakGlassSession{}
+	err := row.Scan(&s.ID, &s.ProjectID, &s.ProjectName, &s.UserID, &s.Username, &s.Justification, &s.ExpiresAt,
+		&s.AcknowledgedBy, &s.AcknowledgedAt, &s.AcknowledgmentNote, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (db *DB) getBreakGlassSession(ctx context.Context, id uuid.UUID) (*models.BreakGlassSession, error) {
+	s, err := scanBreakGlassSession(db.Pool.QueryRow(ctx, `SELECT `+breakGlassSessionColumns+` WHERE s.id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrBreakGlassSessionNotFound
+	}
+	return s, err
+}
+
+// ListBreakGlassSessions returns break-glass sessions, newest first, optionally only those not yet acknowledged.
+func (db *DB) ListBreakGlassSessions(ctx context.Context, unacknowledgedOnly bool) ([]models.BreakGlassSession, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT `+breakGlassSessionColumns+`
+		WHERE NOT $1 OR s.acknowledged_at IS NULL
+		ORDER BY s.created_at DESC
+	`, unacknowledgedOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list break-glass sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.BreakGlassSession
+	for rows.Next() {
+		s, err := scanBreakGlassSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan break-glass session: %w", err)
+		}
+		sessions = append(sessions, *s)
+	}
+
+	return sessions, nil
+}
+
+// AcknowledgeBreakGlassSession records an admin's review of a break-glass session. The responder who
+// opened it cannot review it, and ErrSelfAcknowledgment is returned.
+func (db *DB) AcknowledgeBreakGlassSession(ctx context.Context, id, acknowledgedBy uuid.UUID, note string) (*models.BreakGlassSession, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE break_glass_sessions SET acknowledged_by = $2, acknowledged_at = NOW(), acknowledgment_note = $3
+		WHERE id = $1 AND acknowledged_at IS NULL AND user_id <> $2
+	`, id, acknowledgedBy, note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acknowledge break-glass session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		session, err := db.getBreakGlassSession(ctx, id)
+		if err == nil && session.AcknowledgedAt == nil && session.UserID == acknowledgedBy {
+			return nil, ErrSelfAcknowledgment
+		}
+		return nil, ErrBreakGlassSessionNotFound
+	}
+	return db.getBreakGlassSession(ctx, id)
+}
+
+// GetBreakGlassProjectKey returns the keys a responder needs to open a project during an active session:
+// the escrow key sealed to them and the data key sealed to the escrow, in the shape of a group grant.
+func (db *DB) GetBreakGlassProjectKey(ctx context.Context, projectID, userID uuid.UUID) (*models.GroupProjectKey, error) {
+	key := &models.GroupProjectKey{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT e.public_key, r.wrapped_escrow_key, k.wrapped_data_key
+		FROM break_glass_sessions s
+		JOIN break_glass_keys k ON k.project_id = s.project_id
+		JOIN break_glass_responders r ON r.user_id = s.user_id
+		CROSS JOIN break_glass_escrow e
+		WHERE s.project_id = $1 AND s.user_id = $2 AND s.expires_at > NOW()
+		LIMIT 1
+	`, projectID, userID).Scan(&key.GroupPublicKey, &key.WrappedGroupKey, &key.WrappedDataKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAccessNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrChangeSetNotFound is returned when a change set does not exist or was already decided.
+	ErrChangeSetNotFound = errors.New("change set not found or already decided")
+	// ErrSelfApproval is returned when the author of a change set tries to approve it.
+	ErrSelfApproval = errors.New("a change set must be approved by someone other than its author")
+)
+
+// SetProjectProtected turns two-person approval for a project's writes on or off.
+func (db *DB) SetProjectProtected(ctx context.Context, projectID uuid.UUID, protected bool) error {
+	tag, err := db.Pool.Exec(ctx, `UPDATE projects SET protected = $2, updated_at = NOW() WHERE id = $1`, projectID, protected)
+	if err != nil {
+		return fmt.Errorf("failed to update project: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("project not found")
+	}
+	return nil
+}
+
+// CreateChangeSet stages secret writes to a project for approval.
+func (db *DB) CreateChangeSet(ctx context.Context, projectID uuid.UUID, description string, secrets []models.ChangeSetSecret, createdBy uuid.UUID) (*models.ChangeSet, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	cs := &models.ChangeSet{ProjectID: projectID, Description: description, CreatedBy: createdBy, Secrets: secrets}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO change_sets (project_id, description, created_by)
+		VALUES ($1, $2, $3)
+		RETURNING id, status, created_at
+	`, projectID, description, createdBy).Scan(&cs.ID, &cs.Status, &cs.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create change set: %w", err)
+	}
+
+	for _, s := range secrets {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO change_set_secrets (change_set_id, key, value, version) VALUES ($1, $2, $3, $4)
+		`, cs.ID, s.Key, s.Value, s.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stage secret: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// GetChangeSet returns a change set together with its staged secrets.
+func (db *DB) GetChangeSet(ctx context.Context, id uuid.UUID) (*models.ChangeSet, error) {
+	cs := &models.ChangeSet{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id, project_id, description, status, created_by, decided_by, decided_at, decision_note, created_at
+		FROM change_sets WHERE id = $1
+	`, id).Scan(&cs.ID, &cs.ProjectID, &cs.Description, &cs.Status, &cs.CreatedBy, &cs.DecidedBy, &cs.DecidedAt, &cs.DecisionNote, &cs.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrChangeSetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get change set: %w", err)
+	}
+
+	rows, err := db.Pool.Query(ctx, `SELECT key, value, version FROM change_set_secrets WHERE change_set_id = $1 ORDER BY key`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged secrets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.ChangeSetSecret
+		if err := rows.Scan(&s.Key, &s.Value, &s.Version); err != nil {
+			return nil, fmt.Errorf("failed to scan staged secret: %w", err)
+		}
+		cs.Secrets = append(cs.Secrets, s)
+	}
+
+	return cs, nil
+}
+
+// ListChangeSets returns the change sets of a project, newest first, without their staged secrets.
+// A non-empty status restricts the list to change sets in that status.
+func (db *DB) ListChangeSets(ctx context.Context, projectID uuid.UUID, status string) ([]models.ChangeSet, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, project_id, description, status, created_by, decided_by, decided_at, decision_note, created_at
+		FROM change_sets
+		WHERE project_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+	`, projectID, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list change sets: %w", err)
+	}
+	defer rows.Close()
+
+	var changeSets []models.ChangeSet
+	for rows.Next() {
+		var cs models.ChangeSet
+		if err := rows.Scan(&cs.ID, &cs.ProjectID, &cs.Description, &cs.Status, &cs.CreatedBy, &cs.DecidedBy, &cs.DecidedAt, &cs.DecisionNote, &cs.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan change set: %w", err)
+		}
+		changeSets = append(changeSets, cs)
+	}
+
+	return changeSets, nil
+}
+
+// ApproveChangeSet promotes a pending change set into the project's secrets in a single transaction.
+// The approver must not be its author. If one of the staged versions was written in the meantime,
+// nothing is promoted and ErrSecretVersionExists is returned.
+func (db *DB) ApproveChangeSet(ctx context.Context, id, decidedBy uuid.UUID, note string) ([]models.Secret, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var projectID, createdBy uuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT project_id, created_by FROM change_sets WHERE id = $1 AND status = 'pending' FOR UPDATE
+	`, id).Scan(&projectID, &createdBy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrChangeSetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get change set: %w", err)
+	}
+	if createdBy == decidedBy {
+		return nil, ErrSelfApproval
+	}
+
+	rows, err := tx.Query(ctx, `
+		INSERT INTO secrets (project_id, key, value, version)
+		SELECT $2, key, value, version FROM change_set_secrets WHERE change_set_id = $1
+		RETURNING id, project_id, key, value, version, created_at, updated_at
+	`, id, projectID)
+	if err != nil {
+		return nil, promoteError(err)
+	}
+	var secrets []models.Secret
+	for rows.Next() {
+		var s models.Secret
+		if err := rows.Scan(&s.ID, &s.ProjectID, &s.Key, &s.Value, &s.Version, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		secrets = append(secrets, s)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, promoteError(err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE change_sets SET status = 'approved', decided_by = $2, decided_at = NOW(), decision_note = $3 WHERE id = $1
+	`, id, decidedBy, note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve change set: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func promoteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrSecretVersionExists
+	}
+	return fmt.Errorf("failed to promote change set: %w", err)
+}
+
+// RejectChangeSet rejects a pending change set. Its author may reject, i.e. withdraw, it.
+func (db *DB) RejectChangeSet(ctx context.Context, id, decidedBy uuid.UUID, note string) error {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE change_sets SET status = 'rejected', decided_by = $2, decided_at = NOW(), decision_note = $3
+		WHERE id = $1 AND status = 'pending'
+	`, id, decidedBy, note)
+	if err != nil {
+		return fmt.Errorf("failed to reject change set: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrChangeSetNotFound
+	}
+	return nil
+}
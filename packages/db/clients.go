@@ -81,3 +81,60 @@ func (db *DB) DeleteClient(ctx context.Context, id uuid.UUID) error {
 	}
 	return nil
 }
+
+// ListClientUsers returns the portal accounts restricted to a client.
+func (db *DB) ListClientUsers(ctx context.Context, clientID uuid.UUID) ([]models.User, error) {
+	query := `SELECT id, username, email, role, client_id, created_at, updated_at FROM users WHERE client_id = $1 ORDER BY username`
+
+	rows, err := db.Pool.Query(ctx, query, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list client users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &u.ClientID, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+// SetUserClient restricts a collaborator to the projects of a client, or lifts the restriction when
+// clientID is nil. Direct grants on other clients' projects are revoked.
+func (db *DB) SetUserClient(ctx context.Context, userID uuid.UUID, clientID *uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var role string
+	err = tx.QueryRow(ctx, `SELECT role FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&role)
+	if err != nil {
+		return err
+	}
+	if clientID != nil && role != "COLLABORATOR" {
+		return ErrClientScopedRole
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET client_id = $1, updated_at = NOW() WHERE id = $2`, clientID, userID); err != nil {
+		return fmt.Errorf("failed to set user client: %w", err)
+	}
+
+	if clientID != nil {
+		_, err = tx.Exec(ctx, `
+			DELETE FROM user_project_access
+			WHERE user_id = $1 AND project_id NOT IN (SELECT id FROM projects WHERE client_id = $2)
+		`, userID, *clientID)
+		if err != nil {
+			return fmt.Errorf("failed to revoke grants outside the client: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
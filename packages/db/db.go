@@ -35,35 +35,176 @@ type Database interface {
 	GetUserByUsername(ctx context.Context, username string) (*models.User, string, string, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, string, string, error)
 	GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error)
-	GrantProjectAccess(ctx context.Context, userID, projectID uuid.UUID, wrappedKey string) error
+	SetUserKeyPair(ctx context.Context, userID uuid.UUID, keys *models.UserKeyPair) error
+	GetUserKeyPair(ctx context.Context, userID uuid.UUID) (*models.UserKeyPair, error)
+	DeleteUserKeyPair(ctx context.Context, userID uuid.UUID) error
+	SetUserRole(ctx context.Context, userID uuid.UUID, role string) error
+	GrantProjectAccess(ctx context.Context, userID, projectID uuid.UUID, wrappedKey, role string, grantedBy uuid.UUID, expiresAt *time.Time) error
+	DeleteExpiredGrants(ctx context.Context) ([]models.ProjectAccess, error)
+	GetProjectRole(ctx context.Context, userID, projectID uuid.UUID) (string, error)
+	RevokeProjectAccess(ctx context.Context, userID, projectID uuid.UUID) error
+	GetProjectAccess(ctx context.Context, projectID uuid.UUID) ([]models.ProjectAccess, error)
+
+	// Sessions
+	CreateSession(ctx context.Context, userID uuid.UUID, refreshTokenHash, userAgent, ip string, expiresAt time.Time) (*models.Session, error)
+	RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string) (*models.Session, error)
+	SessionActive(ctx context.Context, id uuid.UUID) (bool, error)
+	ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID uuid.UUID, reason string) error
+	RevokeUserSessions(ctx context.Context, userID uuid.UUID, reason string) (int64, error)
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+
+	// Two-factor authentication
+	GetUserTOTP(ctx context.Context, userID uuid.UUID) (*models.UserTOTP, error)
+	SetPendingTOTP(ctx context.Context, userID uuid.UUID, sealedSecret string) error
+	EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID uuid.UUID) error
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (int, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	GetMFAStatus(ctx context.Context, userID uuid.UUID) (*models.MFAStatus, error)
+	CreateMFAChallenge(ctx context.Context, userID uuid.UUID, tokenHash, purpose string, expiresAt time.Time) (*models.MFAChallenge, error)
+	GetMFAChallenge(ctx context.Context, tokenHash string) (*models.MFAChallenge, error)
+	FailMFAChallenge(ctx context.Context, id uuid.UUID) (int, error)
+	ConsumeMFAChallenge(ctx context.Context, id uuid.UUID) error
+	DeleteExpiredMFAChallenges(ctx context.Context) (int64, error)
+	ListMFARequiredRoles(ctx context.Context) ([]string, error)
+	SetMFARequiredRoles(ctx context.Context, roles []string, setBy uuid.UUID) error
+	RoleRequiresMFA(ctx context.Context, role string) (bool, error)
+
+	// Login throttling
+	GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, key string, userID *uuid.UUID, window time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ClearLoginThrottle(ctx context.Context, key string) (bool, error)
+	ListLoginLockouts(ctx context.Context) ([]models.LoginLockout, error)
+	DeleteStaleLoginThrottles(ctx context.Context, window time.Duration) (int64, error)
+
+	// Single sign-on
+	CreateOIDCLogin(ctx context.Context, stateHash string, login *models.OIDCLogin) error
+	TakeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error)
+	CompleteOIDCLogin(ctx context.Context, id, userID uuid.UUID, loginCodeHash string, expiresAt time.Time) error
+	RedeemOIDCLoginCode(ctx context.Context, loginCodeHash string) (*models.OIDCLogin, error)
+	DeleteExpiredOIDCLogins(ctx context.Context) (int64, error)
+	GetUserByOIDCIdentity(ctx context.Context, issuer, subject string) (*models.User, error)
+	LinkUserOIDCIdentity(ctx context.Context, userID uuid.UUID, issuer, subject string) error
+	CreateOIDCUser(ctx context.Context, username, email, role, issuer, subject string) (*models.User, error)
+
+	// Token signing keys
+	ListSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	RotateSigningKey(ctx context.Context, key *models.SigningKey, retention time.Duration) error
+	DeleteExpiredSigningKeys(ctx context.Context) (int64, error)
+
+	// Service accounts and API tokens
+	CreateServiceAccount(ctx context.Context, name, description string, createdBy uuid.UUID) (*models.ServiceAccount, error)
+	ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error)
+	GetServiceAccount(ctx context.Context, id uuid.UUID) (*models.ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, id uuid.UUID) error
+	SetServiceAccountPublicKey(ctx context.Context, id uuid.UUID, publicKey string) (int64, error)
+	GrantServiceAccountProjectAccess(ctx context.Context, serviceAccountID, projectID uuid.UUID, wrappedKey string, grantedBy uuid.UUID) error
+	RevokeServiceAccountProjectAccess(ctx context.Context, serviceAccountID, projectID uuid.UUID) error
+	GetProjectServiceAccountAccess(ctx context.Context, projectID uuid.UUID) ([]models.ServiceAccountProjectAccess, error)
+	GetServiceAccountProjectKey(ctx context.Context, projectID, serviceAccountID uuid.UUID) (string, error)
+	CreateAPIToken(ctx context.Context, params CreateAPITokenParams) (*models.APIToken, error)
+	ListAPITokens(ctx context.Context, serviceAccountID uuid.UUID) ([]models.APIToken, error)
+	RevokeAPIToken(ctx context.Context, serviceAccountID, tokenID uuid.UUID) error
+	AuthenticateAPIToken(ctx context.Context, tokenHash string) (*models.APIToken, error)
+
+	// Protected projects and change sets
+	SetProjectProtected(ctx context.Context, projectID uuid.UUID, protected bool) error
+	CreateChangeSet(ctx context.Context, projectID uuid.UUID, description string, secrets []models.ChangeSetSecret, createdBy uuid.UUID) (*models.ChangeSet, error)
+	GetChangeSet(ctx context.Context, id uuid.UUID) (*models.ChangeSet, error)
+	ListChangeSets(ctx context.Context, projectID uuid.UUID, status string) ([]models.ChangeSet, error)
+	ApproveChangeSet(ctx context.Context, id, decidedBy uuid.UUID, note string) ([]models.Secret, error)
+	RejectChangeSet(ctx context.Context, id, decidedBy uuid.UUID, note string) error
+
+	// Break-glass access
+	CreateBreakGlassEscrow(ctx context.Context, publicKey string, createdBy uuid.UUID, wrappedEscrowKey string) (*models.BreakGlassEscrow, error)
+	GetBreakGlassEscrow(ctx context.Context) (*models.BreakGlassEscrow, error)
+	ListBreakGlassResponders(ctx context.Context) ([]models.BreakGlassResponder, error)
+	GetBreakGlassResponder(ctx context.Context, userID uuid.UUID) (*models.BreakGlassResponder, error)
+	AddBreakGlassResponder(ctx context.Context, userID uuid.UUID, wrappedEscrowKey string, addedBy uuid.UUID) error
+	RemoveBreakGlassResponder(ctx context.Context, userID uuid.UUID) error
+	ArmBreakGlass(ctx context.Context, projectID uuid.UUID, wrappedKey string, armedBy uuid.UUID) error
+	DisarmBreakGlass(ctx context.Context, projectID uuid.UUID) error
+	ListArmedProjects(ctx context.Context) ([]uuid.UUID, error)
+	StartBreakGlassSession(ctx context.Context, projectID, userID uuid.UUID, justification string, expiresAt time.Time) (*models.BreakGlassSession, error)
+	ListBreakGlassSessions(ctx context.Context, unacknowledgedOnly bool) ([]models.BreakGlassSession, error)
+	AcknowledgeBreakGlassSession(ctx context.Context, id, acknowledgedBy uuid.UUID, note string) (*models.BreakGlassSession, error)
+	GetBreakGlassProjectKey(ctx context.Context, projectID, userID uuid.UUID) (*models.GroupProjectKey, error)
+
+	// Access requests
+	CreateAccessRequest(ctx context.Context, projectID, userID uuid.UUID, role, reason string, durationHours int) (*models.AccessRequest, error)
+	GetAccessRequest(ctx context.Context, id uuid.UUID) (*models.AccessRequest, error)
+	ListAccessRequests(ctx context.Context, userID *uuid.UUID, status string) ([]models.AccessRequest, error)
+	ApproveAccessRequest(ctx context.Context, id uuid.UUID, wrappedKey string, decidedBy uuid.UUID, note string) (*models.AccessRequest, error)
+	DenyAccessRequest(ctx context.Context, id uuid.UUID, decidedBy uuid.UUID, note string) (*models.AccessRequest, error)
+
+	// Groups
+	CreateGroup(ctx context.Context, name, publicKey string, createdBy uuid.UUID, wrappedGroupKey string) (*models.Group, error)
+	ListGroups(ctx context.Context) ([]models.Group, error)
+	ListGroupsForUser(ctx context.Context, userID uuid.UUID) ([]models.Group, error)
+	GetGroup(ctx context.Context, id uuid.UUID) (*models.Group, error)
+	DeleteGroup(ctx context.Context, id uuid.UUID) error
+	ListGroupMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error)
+	GetGroupMember(ctx context.Context, groupID, userID uuid.UUID) (*models.GroupMember, error)
+	AddGroupMember(ctx context.Context, groupID, userID uuid.UUID, wrappedGroupKey string, addedBy uuid.UUID) error
+	RemoveGroupMember(ctx context.Context, groupID, userID uuid.UUID) error
+	GrantGroupProjectAccess(ctx context.Context, groupID, projectID uuid.UUID, wrappedKey, role string, grantedBy uuid.UUID) error
+	RevokeGroupProjectAccess(ctx context.Context, groupID, projectID uuid.UUID) error
+	GetProjectGroupAccess(ctx context.Context, projectID uuid.UUID) ([]models.GroupProjectAccess, error)
+	GetGroupProjectKeyForUser(ctx context.Context, projectID, userID uuid.UUID) (*models.GroupProjectKey, error)
+
+	// Invitations
+	CreateInvitation(ctx context.Context, email string, projectID *uuid.UUID, projectRole string, clientID *uuid.UUID, createdBy uuid.UUID, tokenHash string, expiresAt time.Time) (*models.Invitation, error)
+	GetInvitation(ctx context.Context, id uuid.UUID) (*models.Invitation, error)
+	GetPendingInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error)
+	ListInvitations(ctx context.Context) ([]models.Invitation, error)
+	RevokeInvitation(ctx context.Context, id uuid.UUID) error
+	AcceptInvitation(ctx context.Context, params AcceptInvitationParams) (*models.Invitation, *models.User, error)
+	CompleteInvitationGrant(ctx context.Context, id uuid.UUID, wrappedKey string, grantedBy uuid.UUID) (*models.Invitation, error)
 
 	// WebAuthn
 	AddWebAuthnCredential(ctx context.Context, userID uuid.UUID, cred *models.WebAuthnCredential) error
 	GetWebAuthnCredentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
 	UpdateWebAuthnCredential(ctx context.Context, cred *models.WebAuthnCredential) error
+	RenameWebAuthnCredential(ctx context.Context, userID, publicID uuid.UUID, name string) error
+	DeleteWebAuthnCredential(ctx context.Context, userID, publicID uuid.UUID, keepOne bool) (*models.WebAuthnCredential, error)
+	CreateWebAuthnCeremony(ctx context.Context, c *models.WebAuthnCeremony) error
+	TakeWebAuthnCeremony(ctx context.Context, id, kind string) (*models.WebAuthnCeremony, error)
+	DeleteExpiredWebAuthnCeremonies(ctx context.Context) (int64, error)
 
 	// Vault
 	GetVaultConfig(ctx context.Context) (*VaultConfig, error)
 	InitializeVault(ctx context.Context, wrappedMK, salt string) error
 	UpdateVaultConfig(ctx context.Context, wrappedMK, salt string) error
+	DisallowLegacySecrets(ctx context.Context) error
+	RotateMasterKey(ctx context.Context, rotation MasterKeyRotation) error
 
 	// Clients
 	CreateClient(ctx context.Context, name string) (*models.Client, error)
 	GetClients(ctx context.Context) ([]models.Client, error)
 	GetClientByID(ctx context.Context, id uuid.UUID) (*models.Client, error)
 	DeleteClient(ctx context.Context, id uuid.UUID) error
+	ListClientUsers(ctx context.Context, clientID uuid.UUID) ([]models.User, error)
+	SetUserClient(ctx context.Context, userID uuid.UUID, clientID *uuid.UUID) error
 
 	// Projects
 	CreateProject(ctx context.Context, clientID uuid.UUID, name string, wrappedKey string) (*models.Project, error)
 	GetProjectsByClient(ctx context.Context, clientID uuid.UUID) ([]models.Project, error)
+	GetGrantedProjectsByClient(ctx context.Context, userID, clientID uuid.UUID) ([]models.Project, error)
+	GetAllProjects(ctx context.Context) ([]models.Project, error)
 	GetProjectByID(ctx context.Context, id uuid.UUID) (*models.Project, error)
 	DeleteProject(ctx context.Context, id uuid.UUID) error
 	GetProjectKeyForUser(ctx context.Context, projectID, userID uuid.UUID, isAdmin bool) (string, error)
+	RotateProjectKey(ctx context.Context, projectID uuid.UUID, rotation ProjectKeyRotation) ([]uuid.UUID, error)
 
 	// Secrets
-	CreateSecret(ctx context.Context, projectID uuid.UUID, key string, value string) (*models.Secret, error)
+	CreateSecret(ctx context.Context, projectID uuid.UUID, key string, value string, version int) (*models.Secret, error)
 	GetSecretsByProject(ctx context.Context, projectID uuid.UUID) ([]models.Secret, error)
 	GetSecretHistory(ctx context.Context, projectID uuid.UUID, key string) ([]models.Secret, error)
+	GetAllSecretVersions(ctx context.Context, projectID uuid.UUID) ([]models.Secret, error)
+	UpdateSecretValues(ctx context.Context, projectID uuid.UUID, values map[uuid.UUID]string) error
 
 	// Audit
 	LogEvent(ctx context.Context, action, targetType string, targetID uuid.UUID, metadata map[string]interface{}) error
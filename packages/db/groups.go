@@ -0,0 +1,290 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrGroupNotFound is returned when a group does not exist.
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupExists is returned when creating a group with a name that is already taken.
+	ErrGroupExists = errors.New("a group with this name already exists")
+	// ErrNotGroupMember is returned when a user is not a member of a group.
+	ErrNotGroupMember = errors.New("user is not a member of this group")
+)
+
+// CreateGroup stores a new group with its creator as the first member, since only members can
+// hand out the group private key.
+func (db *DB) CreateGroup(ctx context.Context, name, publicKey string, createdBy uuid.UUID, wrappedGroupKey string) (*models.Group, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	group := &models.Group{MemberCount: 1}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO groups (name, public_key, created_by)
+		VALUES ($1, $2, $3)
+		RETURNING id, name, public_key, created_by, created_at
+	`, name, publicKey, createdBy).Scan(&group.ID, &group.Name, &group.PublicKey, &group.CreatedBy, &group.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrGroupExists
+		}
+		return nil, fmt.Errorf("failed to create group: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO group_members (group_id, user_id, wrapped_group_key, added_by)
+		VALUES ($1, $2, $3, $2)
+	`, group.ID, createdBy, wrappedGroupKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add group creator: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+const groupColumns = `
+	g.id, g.name, g.public_key, g.created_by,
+	(SELECT COUNT(*) FROM group_members m WHERE m.group_id = g.id),
+	g.created_at
+`
+
+// ListGroups returns all groups with their member counts.
+func (db *DB) ListGroups(ctx context.Context) ([]models.Group, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT `+groupColumns+` FROM groups g ORDER BY g.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var g models.Group
+		if err := rows.Scan(&g.ID, &g.Name, &g.PublicKey, &g.CreatedBy, &g.MemberCount, &g.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+// ListGroupsForUser returns the groups a user is a member of.
+func (db *DB) ListGroupsForUser(ctx context.Context, userID uuid.UUID) ([]models.Group, error) {
+	query := `SELECT ` + groupColumns + ` FROM groups g
+		WHERE EXISTS (SELECT 1 FROM group_members m WHERE m.group_id = g.id AND m.user_id = $1)
+		ORDER BY g.name`
+
+	rows, err := db.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var g models.Group
+		if err := rows.Scan(&g.ID, &g.Name, &g.PublicKey, &g.CreatedBy, &g.MemberCount, &g.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+// GetGroup returns a group by ID.
+func (db *DB) GetGroup(ctx context.Context, id uuid.UUID) (*models.Group, error) {
+	g := &models.Group{}
+	err := db.Pool.QueryRow(ctx, `SELECT `+groupColumns+` FROM groups g WHERE g.id = $1`, id).
+		Scan(&g.ID, &g.Name, &g.PublicKey, &g.CreatedBy, &g.MemberCount, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// DeleteGroup removes a group together with its memberships and project grants.
+func (db *DB) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM groups WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// ListGroupMembers returns the members of a group.
+func (db *DB) ListGroupMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error) {
+	query := `
+		SELECT m.group_id, m.user_id, u.username, u.email, m.wrapped_group_key, m.added_by, m.added_at
+		FROM group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1
+		ORDER BY u.username
+	`
+
+	rows, err := db.Pool.Query(ctx, query, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.GroupMember
+	for rows.Next() {
+		var m models.GroupMember
+		if err := rows.Scan(&m.GroupID, &m.UserID, &m.Username, &m.Email, &m.WrappedGroupKey, &m.AddedBy, &m.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+// GetGroupMember returns a single membership, including the group key sealed to that member.
+func (db *DB) GetGroupMember(ctx context.Context, groupID, userID uuid.UUID) (*models.GroupMember, error) {
+	query := `
+		SELECT m.group_id, m.user_id, u.username, u.email, m.wrapped_group_key, m.added_by, m.added_at
+		FROM group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1 AND m.user_id = $2
+	`
+
+	m := &models.GroupMember{}
+	err := db.Pool.QueryRow(ctx, query, groupID, userID).
+		Scan(&m.GroupID, &m.UserID, &m.Username, &m.Email, &m.WrappedGroupKey, &m.AddedBy, &m.AddedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotGroupMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddGroupMember adds a user to a group with the group private key sealed to their public key.
+// Adding an existing member replaces their sealed key.
+func (db *DB) AddGroupMember(ctx context.Context, groupID, userID uuid.UUID, wrappedGroupKey string, addedBy uuid.UUID) error {
+	query := `
+		INSERT INTO group_members (group_id, user_id, wrapped_group_key, added_by, added_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (group_id, user_id) DO UPDATE
+		SET wrapped_group_key = EXCLUDED.wrapped_group_key, added_by = EXCLUDED.added_by, added_at = EXCLUDED.added_at
+	`
+	_, err := db.Pool.Exec(ctx, query, groupID, userID, wrappedGroupKey, addedBy)
+	if err != nil {
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+	return nil
+}
+
+// RemoveGroupMember removes a user from a group. Project grants of the group are left untouched.
+func (db *DB) RemoveGroupMember(ctx context.Context, groupID, userID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2`, groupID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove group member: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotGroupMember
+	}
+	return nil
+}
+
+// GrantGroupProjectAccess links a group to a project with the data key sealed to the group's public key.
+func (db *DB) GrantGroupProjectAccess(ctx context.Context, groupID, projectID uuid.UUID, wrappedKey, role string, grantedBy uuid.UUID) error {
+	query := `
+		INSERT INTO group_project_access (group_id, project_id, wrapped_data_key, role, granted_by, granted_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (group_id, project_id) DO UPDATE
+		SET wrapped_data_key = EXCLUDED.wrapped_data_key, role = EXCLUDED.role,
+			granted_by = EXCLUDED.granted_by, granted_at = EXCLUDED.granted_at
+	`
+	_, err := db.Pool.Exec(ctx, query, groupID, projectID, wrappedKey, role, grantedBy)
+	return err
+}
+
+// RevokeGroupProjectAccess removes a group's grant on a project.
+func (db *DB) RevokeGroupProjectAccess(ctx context.Context, groupID, projectID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM group_project_access WHERE group_id = $1 AND project_id = $2`, groupID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke group access: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAccessNotFound
+	}
+	return nil
+}
+
+// GetProjectGroupAccess returns the groups granted access to a project.
+func (db *DB) GetProjectGroupAccess(ctx context.Context, projectID uuid.UUID) ([]models.GroupProjectAccess, error) {
+	query := `
+		SELECT a.group_id, g.name, g.public_key, a.project_id, a.wrapped_data_key, a.role, a.granted_by, a.granted_at
+		FROM group_project_access a
+		JOIN groups g ON g.id = a.group_id
+		WHERE a.project_id = $1
+		ORDER BY g.name
+	`
+
+	rows, err := db.Pool.Query(ctx, query, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group access: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.GroupProjectAccess
+	for rows.Next() {
+		var a models.GroupProjectAccess
+		if err := rows.Scan(&a.GroupID, &a.GroupName, &a.GroupPublicKey, &a.ProjectID, &a.WrappedDataKey, &a.Role, &a.GrantedBy, &a.GrantedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group access: %w", err)
+		}
+		entries = append(entries, a)
+	}
+
+	return entries, nil
+}
+
+// GetGroupProjectKeyForUser returns the keys a user needs to open a project through one of their groups.
+func (db *DB) GetGroupProjectKeyForUser(ctx context.Context, projectID, userID uuid.UUID) (*models.GroupProjectKey, error) {
+	query := `
+		SELECT g.id, g.public_key, m.wrapped_group_key, a.wrapped_data_key
+		FROM group_project_access a
+		JOIN groups g ON g.id = a.group_id
+		JOIN group_members m ON m.group_id = a.group_id
+		JOIN users u ON u.id = m.user_id
+		JOIN projects p ON p.id = a.project_id
+		WHERE a.project_id = $1 AND m.user_id = $2 AND (u.client_id IS NULL OR u.client_id = p.client_id)
+		ORDER BY g.name
+		LIMIT 1
+	`
+
+	key := &models.GroupProjectKey{}
+	err := db.Pool.QueryRow(ctx, query, projectID, userID).
+		Scan(&key.GroupID, &key.GroupPublicKey, &key.WrappedGroupKey, &key.WrappedDataKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAccessNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrInvitationInvalid is returned when a token does not match a pending, unexpired invitation.
+	ErrInvitationInvalid = errors.New("invitation is invalid, expired or already used")
+	// ErrInvitationNotFound is returned when an invitation does not exist or is not in the expected state.
+	ErrInvitationNotFound = errors.New("invitation not found")
+	// ErrUserExists is returned when registering a username or email that is already taken.
+	ErrUserExists = errors.New("a user with this username or email already exists")
+)
+
+// AcceptInvitationParams carries the account chosen by the invitee. The keypair is generated client-side.
+type AcceptInvitationParams struct {
+	TokenHash    string
+	Email        string
+	Username     string
+	PasswordHash string
+	Salt         string
+	KeyPair      *models.UserKeyPair
+}
+
+const invitationColumns = `
+	id, email, project_id, project_role, client_id, created_by,
+	CASE
+		WHEN revoked_at IS NOT NULL THEN 'revoked'
+		WHEN granted_at IS NOT NULL THEN 'completed'
+		WHEN accepted_at IS NOT NULL THEN 'accepted'
+		WHEN expires_at <= NOW() THEN 'expired'
+		ELSE 'pending'
+	END,
+	expires_at, accepted_at, accepted_user_id, granted_at, revoked_at, created_at
+`
+
+func scanInvitation(row pgx.Row) (*models.Invitation, error) {
+	inv := &models.Invitation{}
+	err := row.Scan(
+		&inv.ID,
+		&inv.Email,
+		&inv.ProjectID,
+		&inv.ProjectRole,
+		&inv.ClientID,
+		&inv.CreatedBy,
+		&inv.Status,
+		&inv.ExpiresAt,
+		&inv.AcceptedAt,
+		&inv.AcceptedUserID,
+		&inv.GrantedAt,
+		&inv.RevokedAt,
+		&inv.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// CreateInvitation stores a new invitation. Only the hash of its token is persisted. A non-nil clientID
+// makes the invitee a portal account of that client.
+func (db *DB) CreateInvitation(ctx context.Context, email string, projectID *uuid.UUID, projectRole string, clientID *uuid.UUID, createdBy uuid.UUID, tokenHash string, expiresAt time.Time) (*models.Invitation, error) {
+	query := `
+		INSERT INTO invitations (email, token_hash, project_id, project_role, client_id, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + invitationColumns
+
+	inv, err := scanInvitation(db.Pool.QueryRow(ctx, query, strings.ToLower(email), tokenHash, projectID, projectRole, clientID, createdBy, expiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return inv, nil
+}
+
+// GetInvitation returns an invitation by ID.
+func (db *DB) GetInvitation(ctx context.Context, id uuid.UUID) (*models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE id = $1`
+
+	inv, err := scanInvitation(db.Pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvitationNotFound
+	}
+	return inv, err
+}
+
+// GetPendingInvitation returns the pending, unexpired invitation of a token hash, or ErrInvitationInvalid.
+func (db *DB) GetPendingInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()`
+
+	inv, err := scanInvitation(db.Pool.QueryRow(ctx, query, tokenHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvitationInvalid
+	}
+	return inv, err
+}
+
+// ListInvitations returns all invitations, newest first.
+func (db *DB) ListInvitations(ctx context.Context) ([]models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations ORDER BY created_at DESC`
+
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	defer rows.Close()
+
+	var invitations []models.Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invitation: %w", err)
+		}
+		invitations = append(invitations, *inv)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation revokes an invitation that has not been redeemed yet.
+func (db *DB) RevokeInvitation(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE invitations SET revoked_at = NOW() WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL`
+
+	tag, err := db.Pool.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptInvitation redeems a pending invitation: it creates the invitee's account with their keypair and
+// marks the invitation as used, in a single transaction. The email must match the invited address.
+func (db *DB) AcceptInvitation(ctx context.Context, params AcceptInvitationParams) (*models.Invitation, *models.User, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT id, email, client_id FROM invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`
+	var invitationID uuid.UUID
+	var email string
+	var clientID *uuid.UUID
+	if err := tx.QueryRow(ctx, query, params.TokenHash).Scan(&invitationID, &email, &clientID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrInvitationInvalid
+		}
+		return nil, nil, err
+	}
+	if !strings.EqualFold(email, params.Email) {
+		return nil, nil, ErrInvitationInvalid
+	}
+
+	user := &models.User{}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (username, email, password_hash, salt, role, public_key, encrypted_private_key, private_key_salt, client_id)
+		VALUES ($1, $2, $3, $4, 'COLLABORATOR', $5, $6, $7, $8)
+		RETURNING id, username, email, role, client_id, created_at, updated_at
+	`, params.Username, email, params.PasswordHash, params.Salt,
+		params.KeyPair.PublicKey, params.KeyPair.EncryptedPrivateKey, params.KeyPair.PrivateKeySalt, clientID,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.ClientID, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, nil, ErrUserExists
+		}
+		return nil, nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	inv, err := scanInvitation(tx.QueryRow(ctx, `
+		UPDATE invitations SET accepted_at = NOW(), accepted_user_id = $1
+		WHERE id = $2
+		RETURNING `+invitationColumns, user.ID, invitationID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to mark invitation as accepted: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, err
+	}
+	return inv, user, nil
+}
+
+// CompleteInvitationGrant grants the invitation's project to the invitee with a data key sealed to their
+// public key, and marks the invitation as completed.
+func (db *DB) CompleteInvitationGrant(ctx context.Context, id uuid.UUID, wrappedKey string, grantedBy uuid.UUID) (*models.Invitation, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT accepted_user_id, project_id, project_role FROM invitations
+		WHERE id = $1 AND accepted_user_id IS NOT NULL AND project_id IS NOT NULL
+			AND granted_at IS NULL AND revoked_at IS NULL
+		FOR UPDATE
+	`
+	var userID, projectID uuid.UUID
+	var role string
+	if err := tx.QueryRow(ctx, query, id).Scan(&userID, &projectID, &role); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_project_access (user_id, project_id, wrapped_data_key, role, granted_by, granted_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (user_id, project_id) DO UPDATE
+		SET wrapped_data_key = EXCLUDED.wrapped_data_key, role = EXCLUDED.role,
+			granted_by = EXCLUDED.granted_by, granted_at = EXCLUDED.granted_at, expires_at = NULL
+	`, userID, projectID, wrappedKey, role, grantedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to grant project access: %w", err)
+	}
+
+	inv, err := scanInvitation(tx.QueryRow(ctx, `UPDATE invitations SET granted_at = NOW() WHERE id = $1 RETURNING `+invitationColumns, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrTOTPEnabled is returned when enrolling a user whose TOTP is already enabled.
+	ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPNotPending is returned when confirming an enrollment that was never started or already confirmed.
+	ErrTOTPNotPending = errors.New("no two-factor enrollment in progress")
+	// ErrTOTPCodeUsed is returned when a code's time step is not newer than the last one used.
+	ErrTOTPCodeUsed = errors.New("code already used")
+	// ErrRecoveryCodeInvalid is returned for an unknown or already used recovery code.
+	ErrRecoveryCodeInvalid = errors.New("invalid or used recovery code")
+	// ErrMFAChallengeNotFound is returned when a login challenge is unknown, used or expired.
+	ErrMFAChallengeNotFound = errors.New("login challenge not found, used or expired")
+)
+
+// GetUserTOTP returns a user's TOTP enrollment, with an empty secret if they never enrolled.
+func (db *DB) GetUserTOTP(ctx context.Context, userID uuid.UUID) (*models.UserTOTP, error) {
+	query := `SELECT COALESCE(totp_secret, ''), totp_enabled_at, totp_last_step FROM users WHERE id = $1`
+	t := &models.UserTOTP{}
+	if err := db.Pool.QueryRow(ctx, query, userID).Scan(&t.Secret, &t.EnabledAt, &t.LastStep); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetPendingTOTP starts an enrollment with a sealed secret, replacing any unconfirmed one.
+func (db *DB) SetPendingTOTP(ctx context.Context, userID uuid.UUID, sealedSecret string) error {
+	query := `UPDATE users SET totp_secret = $2, totp_last_step = 0, updated_at = NOW() WHERE id = $1 AND totp_enabled_at IS NULL`
+	tag, err := db.Pool.Exec(ctx, query, userID, sealedSecret)
+	if err != nil {
+		return fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPEnabled
+	}
+	return nil
+}
+
+// EnableTOTP confirms a pending enrollment with the time step of its first code, and replaces the user's
+// recovery codes.
+func (db *DB) EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+		WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPNotPending
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DisableTOTP removes a user's TOTP secret and recovery codes.
+func (db *DB) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+		WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep records the time step of an accepted code. It fails with ErrTOTPCodeUsed if the step is not
+// newer than the last one, e.g. when the same code is sent twice at once.
+func (db *DB) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	tag, err := db.Pool.Exec(ctx, `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`, userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTOTPCodeUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode marks a recovery code as used and returns how many the user has left.
+func (db *DB) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (int, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, ErrRecoveryCodeInvalid
+	}
+
+	var left int
+	err = db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&left)
+	return left, err
+}
+
+// ReplaceRecoveryCodes replaces all of a user's recovery codes, used or not.
+func (db *DB) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return fmt.Errorf("failed to store recovery codes: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetMFAStatus returns a user's second factor, and whether their role requires one.
+func (db *DB) GetMFAStatus(ctx context.Context, userID uuid.UUID) (*models.MFAStatus, error) {
+	query := `
+		SELECT u.totp_enabled_at,
+			(SELECT COUNT(*) FROM user_recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL),
+			EXISTS(SELECT 1 FROM mfa_required_roles r WHERE r.role = u.role)
+		FROM users u
+		WHERE u.id = $1
+	`
+	s := &models.MFAStatus{}
+	if err := db.Pool.QueryRow(ctx, query, userID).Scan(&s.EnabledAt, &s.RecoveryCodesLeft, &s.Required); err != nil {
+		return nil, err
+	}
+	s.TOTPEnabled = s.EnabledAt != nil
+	return s, nil
+}
+
+// CreateMFAChallenge records a password login waiting for a second factor, found again by its token's hash.
+func (db *DB) CreateMFAChallenge(ctx context.Context, userID uuid.UUID, tokenHash, purpose string, expiresAt time.Time) (*models.MFAChallenge, error) {
+	query := `
+		INSERT INTO mfa_challenges (token_hash, user_id, purpose, expires_at) VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, purpose, attempts, expires_at
+	`
+	c := &models.MFAChallenge{}
+	err := db.Pool.QueryRow(ctx, query, tokenHash, userID, purpose, expiresAt).Scan(&c.ID, &c.UserID, &c.Purpose, &c.Attempts, &c.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return c, nil
+}
+
+// GetMFAChallenge returns the unexpired challenge of a token.
+func (db *DB) GetMFAChallenge(ctx context.Context, tokenHash string) (*models.MFAChallenge, error) {
+	query := `
+		SELECT id, user_id, purpose, attempts, expires_at FROM mfa_challenges
+		WHERE token_hash = $1 AND expires_at > NOW()
+	`
+	c := &models.MFAChallenge{}
+	err := db.Pool.QueryRow(ctx, query, tokenHash).Scan(&c.ID, &c.UserID, &c.Purpose, &c.Attempts, &c.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMFAChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// FailMFAChallenge counts a wrong code against a challenge and returns its failed attempts so far.
+func (db *DB) FailMFAChallenge(ctx context.Context, id uuid.UUID) (int, error) {
+	var attempts int
+	err := db.Pool.QueryRow(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts`, id).Scan(&attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrMFAChallengeNotFound
+	}
+	return attempts, err
+}
+
+// ConsumeMFAChallenge deletes a challenge. It fails with ErrMFAChallengeNotFound if it is already gone, so
+// one challenge completes one login.
+func (db *DB) ConsumeMFAChallenge(ctx context.Context, id uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMFAChallengeNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredMFAChallenges removes abandoned login challenges.
+func (db *DB) DeleteExpiredMFAChallenges(ctx context.Context) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListMFARequiredRoles returns the global roles whose users must use a second factor.
+func (db *DB) ListMFARequiredRoles(ctx context.Context) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT role FROM mfa_required_roles ORDER BY role`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// SetMFARequiredRoles replaces the roles whose users must use a second factor.
+func (db *DB) SetMFARequiredRoles(ctx context.Context, roles []string, setBy uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_required_roles`); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if _, err := tx.Exec(ctx, `INSERT INTO mfa_required_roles (role, set_by) VALUES ($1, $2) ON CONFLICT DO NOTHING`, role, setBy); err != nil {
+			return fmt.Errorf("failed to store policy: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// RoleRequiresMFA reports whether users of a global role must use a second factor.
+func (db *DB) RoleRequiresMFA(ctx context.Context, role string) (bool, error) {
+	var required bool
+	err := db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM mfa_required_roles WHERE role = $1)`, role).Scan(&required)
+	return required, err
+}
@@ -0,0 +1,4 @@
+-- Per-user X25519 keypairs. The private key is encrypted client-side under the user's password.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS encrypted_private_key TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS private_key_salt TEXT;
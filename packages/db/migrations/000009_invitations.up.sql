@@ -0,0 +1,16 @@
+-- Single-use, expiring invitations. Only a SHA-256 hash of the token is stored.
+CREATE TABLE IF NOT EXISTS invitations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE, -- Project to grant once accepted (optional)
+    created_by UUID NOT NULL,                                   -- May be the reserved environment admin ID
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    accepted_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    granted_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_invitations_email ON invitations(email);
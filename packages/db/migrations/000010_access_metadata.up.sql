@@ -0,0 +1,3 @@
+-- Record who granted project access and when.
+ALTER TABLE user_project_access ADD COLUMN IF NOT EXISTS granted_by UUID; -- May be the reserved environment admin ID
+ALTER TABLE user_project_access ADD COLUMN IF NOT EXISTS granted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
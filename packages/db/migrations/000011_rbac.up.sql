@@ -0,0 +1,7 @@
+-- Project roles attached to grants: 'viewer', 'editor' or 'project-admin'.
+-- Existing grants keep read and write access to their projects.
+ALTER TABLE user_project_access ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'editor';
+ALTER TABLE invitations ADD COLUMN IF NOT EXISTS project_role TEXT NOT NULL DEFAULT 'editor';
+
+-- users.role may now also be 'AUDITOR'
+COMMENT ON COLUMN users.role IS 'ADMIN, COLLABORATOR or AUDITOR';
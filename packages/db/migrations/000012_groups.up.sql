@@ -0,0 +1,33 @@
+-- Groups have their own X25519 keypair. The group private key is sealed to each member's public key,
+-- and project data keys are sealed once to the group's public key, so membership changes need no
+-- per-project re-wrapping.
+CREATE TABLE IF NOT EXISTS groups (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name TEXT NOT NULL UNIQUE,
+    public_key TEXT NOT NULL,
+    created_by UUID NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    wrapped_group_key TEXT NOT NULL, -- Group private key sealed to the member's public key
+    added_by UUID,
+    added_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_group_members_user ON group_members(user_id);
+
+CREATE TABLE IF NOT EXISTS group_project_access (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    wrapped_data_key TEXT NOT NULL, -- Project data key sealed to the group's public key
+    role TEXT NOT NULL DEFAULT 'editor',
+    granted_by UUID,
+    granted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, project_id)
+);
+
+CREATE INDEX idx_group_project_access_project ON group_project_access(project_id);
@@ -0,0 +1,7 @@
+-- Client portal accounts: users restricted to the projects of a single client.
+-- Deleting the client deletes its portal accounts rather than widening their access.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS client_id UUID REFERENCES clients(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_users_client ON users(client_id);
+
+-- Invitations may create a portal account for a client.
+ALTER TABLE invitations ADD COLUMN IF NOT EXISTS client_id UUID REFERENCES clients(id) ON DELETE CASCADE;
@@ -0,0 +1,4 @@
+-- Time-bound grants: access stops at expires_at and the reaper deletes the wrapped key afterwards.
+-- NULL means the grant never expires.
+ALTER TABLE user_project_access ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_user_project_access_expires ON user_project_access(expires_at) WHERE expires_at IS NOT NULL;
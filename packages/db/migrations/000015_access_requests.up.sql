@@ -0,0 +1,18 @@
+-- Just-in-time access: a collaborator asks for a short-lived grant, an admin approves or denies it.
+CREATE TABLE IF NOT EXISTS access_requests (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'editor',
+    reason TEXT NOT NULL,
+    duration_hours INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'approved' or 'denied'
+    decided_by UUID, -- May be the reserved environment admin ID
+    decided_at TIMESTAMP WITH TIME ZONE,
+    decision_note TEXT NOT NULL DEFAULT '',
+    grant_expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_requests_status ON access_requests(status);
+CREATE INDEX IF NOT EXISTS idx_access_requests_user ON access_requests(user_id);
@@ -0,0 +1,25 @@
+-- Two-person approval: writes to protected projects are staged as change sets until another user approves them.
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS protected BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS change_sets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    description TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'approved' or 'rejected'
+    created_by UUID NOT NULL, -- May be the reserved environment admin ID
+    decided_by UUID,
+    decided_at TIMESTAMP WITH TIME ZONE,
+    decision_note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Ciphertexts are bound to their project, key and version, so the version is fixed when the change is staged
+CREATE TABLE IF NOT EXISTS change_set_secrets (
+    change_set_id UUID NOT NULL REFERENCES change_sets(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    PRIMARY KEY (change_set_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_change_sets_project_status ON change_sets(project_id, status);
@@ -0,0 +1,39 @@
+-- Break-glass emergency access. A single escrow keypair's private key is sealed to each on-call responder,
+-- and the data keys of armed projects are sealed to the escrow public key ahead of time, so a responder
+-- can open a project they have no grant on without anyone else being online.
+CREATE TABLE IF NOT EXISTS break_glass_escrow (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), -- There is only one escrow
+    public_key TEXT NOT NULL,
+    created_by UUID NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS break_glass_responders (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    wrapped_escrow_key TEXT NOT NULL, -- Escrow private key sealed to the responder's public key
+    added_by UUID,
+    added_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS break_glass_keys (
+    project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    wrapped_data_key TEXT NOT NULL, -- Project data key sealed to the escrow public key
+    armed_by UUID,
+    armed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Each use of the escrow, with the responder's justification and the admin's acknowledgment
+CREATE TABLE IF NOT EXISTS break_glass_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    justification TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    acknowledged_by UUID, -- May be the reserved environment admin ID
+    acknowledged_at TIMESTAMP WITH TIME ZONE,
+    acknowledgment_note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_break_glass_sessions_active ON break_glass_sessions(user_id, project_id, expires_at);
+CREATE INDEX IF NOT EXISTS idx_break_glass_sessions_unacknowledged ON break_glass_sessions(created_at) WHERE acknowledged_at IS NULL;
@@ -0,0 +1,37 @@
+-- Service accounts are non-human principals, e.g. CI pipelines, that authenticate with API tokens.
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_by UUID NOT NULL, -- May be the reserved environment admin ID
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- API tokens of service accounts. Only hashes are stored; the token is shown once on creation.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    service_account_id UUID NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    permission TEXT NOT NULL DEFAULT 'read', -- 'read' or 'read-write'
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}', -- CIDRs the token may be used from; empty allows any address
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_by UUID NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (service_account_id, name)
+);
+
+-- The projects a token is scoped to.
+CREATE TABLE IF NOT EXISTS api_token_projects (
+    token_id UUID NOT NULL REFERENCES api_tokens(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    PRIMARY KEY (token_id, project_id)
+);
+
+-- Who performed an audited action: a 'user' or a 'service_account'. Empty for system events.
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_type TEXT;
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_id UUID;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor ON audit_logs (actor_type, actor_id);
@@ -0,0 +1,13 @@
+-- Service accounts get their own X25519 keypair. The private key never reaches the server; it is handed
+-- to the machine, e.g. as the BASTION_SERVICE_KEY secret of a CI pipeline.
+ALTER TABLE service_accounts ADD COLUMN IF NOT EXISTS public_key TEXT;
+
+-- Project data keys sealed to a service account's public key.
+CREATE TABLE IF NOT EXISTS service_account_project_access (
+    service_account_id UUID NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    wrapped_data_key TEXT NOT NULL,
+    granted_by UUID,
+    granted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (service_account_id, project_id)
+);
@@ -0,0 +1,12 @@
+-- Asymmetric keys signing access tokens. The newest unretired key signs; retired keys keep verifying
+-- tokens until they expire, so rotations don't sign anyone out. Private keys are encrypted under
+-- BASTION_JWT_SECRET.
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL, -- 'EdDSA' or 'ES256'
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
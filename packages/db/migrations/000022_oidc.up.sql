@@ -0,0 +1,21 @@
+-- Identity of a user at the OpenID Connect provider, linked on their first single sign-on login.
+-- Accounts provisioned through single sign-on have an empty password hash and cannot log in with a password.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_issuer TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_identity ON users(oidc_issuer, oidc_subject);
+
+-- Single sign-on logins in progress. The state sent to the provider finds the login on the callback (its
+-- hash is cleared once used), then a one-time login code, redeemable only with the PKCE verifier of the
+-- client that started the login, hands over the session.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    state_hash TEXT UNIQUE,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL, -- PKCE verifier towards the provider
+    return_to TEXT NOT NULL,
+    code_challenge TEXT NOT NULL, -- PKCE challenge of the client that started the login
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    login_code_hash TEXT UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,35 @@
+-- TOTP second factor for password logins. The secret is encrypted under a key derived from
+-- BASTION_JWT_SECRET, since the server must read it to check codes. It is pending until the user confirms
+-- a first code; totp_last_step is the last time step used, so a code cannot be replayed.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Single-use recovery codes, for a lost authenticator. Only hashes are stored.
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+-- Password logins waiting for their second factor, or for the user to enroll one if their role requires it.
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash TEXT NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL, -- 'verify' or 'enroll'
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user ON mfa_challenges(user_id);
+
+-- Global roles whose users must use a second factor for password logins.
+CREATE TABLE IF NOT EXISTS mfa_required_roles (
+    role TEXT PRIMARY KEY,
+    set_by UUID NOT NULL,
+    set_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
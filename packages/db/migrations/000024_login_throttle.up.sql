@@ -0,0 +1,11 @@
+-- Failed logins, counted per account, or per identifier when it matches no account so that unknown
+-- identifiers lock out like real ones. The environment admin is counted under the key 'admin'.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key TEXT PRIMARY KEY, -- 'user:<id>', 'admin' or 'identifier:<sha256>'
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttles_locked ON login_throttles(locked_until) WHERE locked_until IS NOT NULL;
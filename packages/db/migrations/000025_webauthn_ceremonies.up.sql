@@ -0,0 +1,12 @@
+-- State of passkey registrations and logins between their begin and finish requests, so that any server
+-- replica can finish a ceremony another one began. Each ceremony has a random ID handed to the client.
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL, -- 'registration' or 'login'
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_ceremonies_expires ON webauthn_ceremonies(expires_at);
@@ -0,0 +1,7 @@
+-- Passkeys get an ID users can refer to, a name, and the time of their last login
+ALTER TABLE webauthn_credentials
+    ADD COLUMN IF NOT EXISTS public_id UUID NOT NULL DEFAULT gen_random_uuid(),
+    ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT 'Passkey',
+    ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webauthn_credentials_public_id ON webauthn_credentials(public_id);
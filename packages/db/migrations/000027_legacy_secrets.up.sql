@@ -0,0 +1,3 @@
+-- Whether secrets may still use the pre-envelope nonce||ciphertext format. Existing vaults may have some
+-- until 'bastion db upgrade-secrets' has run; vaults created from now on never do.
+ALTER TABLE vault_config ADD COLUMN IF NOT EXISTS legacy_secrets BOOLEAN NOT NULL DEFAULT TRUE;
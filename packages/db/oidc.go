@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrOIDCLoginNotFound is returned when a login state or login code is unknown, already used or expired.
+	ErrOIDCLoginNotFound = errors.New("single sign-on login not found, used or expired")
+	// ErrIdentityLinked is returned when linking a single sign-on identity to a user already linked to another.
+	ErrIdentityLinked = errors.New("user is already linked to another single sign-on identity")
+)
+
+const oidcLoginColumns = `id, nonce, code_verifier, return_to, code_challenge, user_id, expires_at`
+
+func scanOIDCLogin(row pgx.Row) (*models.OIDCLogin, error) {
+	l := &models.OIDCLogin{}
+	err := row.Scan(&l.ID, &l.Nonce, &l.CodeVerifier, &l.ReturnTo, &l.CodeChallenge, &l.UserID, &l.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOIDCLoginNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// CreateOIDCLogin records a login sent to the provider, found again on the callback by the hash of its state.
+func (db *DB) CreateOIDCLogin(ctx context.Context, stateHash string, login *models.OIDCLogin) error {
+	query := `
+		INSERT INTO oidc_logins (state_hash, nonce, code_verifier, return_to, code_challenge, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	err := db.Pool.QueryRow(ctx, query, stateHash, login.Nonce, login.CodeVerifier, login.ReturnTo, login.CodeChallenge, login.ExpiresAt).Scan(&login.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create login: %w", err)
+	}
+	return nil
+}
+
+// TakeOIDCLogin returns the pending login of a state and clears the state, so a callback cannot be replayed.
+func (db *DB) TakeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error) {
+	return scanOIDCLogin(db.Pool.QueryRow(ctx, `
+		UPDATE oidc_logins SET state_hash = NULL
+		WHERE state_hash = $1 AND user_id IS NULL AND expires_at > NOW()
+		RETURNING `+oidcLoginColumns, stateHash))
+}
+
+// CompleteOIDCLogin records the user the provider authenticated and the hash of the login code that hands
+// the session to the client, valid until expiresAt.
+func (db *DB) CompleteOIDCLogin(ctx context.Context, id, userID uuid.UUID, loginCodeHash string, expiresAt time.Time) error {
+	query := `UPDATE oidc_logins SET user_id = $2, login_code_hash = $3, expires_at = $4 WHERE id = $1 AND user_id IS NULL`
+	tag, err := db.Pool.Exec(ctx, query, id, userID, loginCodeHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to complete login: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOIDCLoginNotFound
+	}
+	return nil
+}
+
+// RedeemOIDCLoginCode deletes the completed login of a login code and returns it. A code works once.
+func (db *DB) RedeemOIDCLoginCode(ctx context.Context, loginCodeHash string) (*models.OIDCLogin, error) {
+	return scanOIDCLogin(db.Pool.QueryRow(ctx, `
+		DELETE FROM oidc_logins
+		WHERE login_code_hash = $1 AND user_id IS NOT NULL AND expires_at > NOW()
+		RETURNING `+oidcLoginColumns, loginCodeHash))
+}
+
+// DeleteExpiredOIDCLogins removes logins that were abandoned or never redeemed.
+func (db *DB) DeleteExpiredOIDCLogins(ctx context.Context) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM oidc_logins WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetUserByOIDCIdentity returns the user linked to an identity at the provider. It returns pgx.ErrNoRows if
+// none is.
+func (db *DB) GetUserByOIDCIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	query := `SELECT id, username, email, role, client_id, created_at, updated_at FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2`
+	user := &models.User{SSO: true}
+	err := db.Pool.QueryRow(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Role,
+		&user.ClientID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// LinkUserOIDCIdentity links an existing user to an identity at the provider. A user is linked once; it
+// returns ErrIdentityLinked if the user or the identity is already linked.
+func (db *DB) LinkUserOIDCIdentity(ctx context.Context, userID uuid.UUID, issuer, subject string) error {
+	query := `UPDATE users SET oidc_issuer = $2, oidc_subject = $3, updated_at = NOW() WHERE id = $1 AND oidc_subject IS NULL`
+	tag, err := db.Pool.Exec(ctx, query, userID, issuer, subject)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrIdentityLinked
+		}
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+// CreateOIDCUser provisions a user for an identity at the provider. The account has no password, so it
+// can only log in through single sign-on.
+func (db *DB) CreateOIDCUser(ctx context.Context, username, email, role, issuer, subject string) (*models.User, error) {
+	query := `
+		INSERT INTO users (username, email, password_hash, salt, role, oidc_issuer, oidc_subject)
+		VALUES ($1, $2, '', '', $3, $4, $5)
+		RETURNING id, username, email, role, client_id, created_at, updated_at
+	`
+
+	user := &models.User{SSO: true}
+	err := db.Pool.QueryRow(ctx, query, username, email, role, issuer, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Role,
+		&user.ClientID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrUserExists
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return user, nil
+}
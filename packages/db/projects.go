@@ -2,12 +2,28 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/google/uuid"
 )
 
+// ErrSecretSetChanged is returned when a project key rotation does not cover exactly the secrets being re-encrypted.
+var ErrSecretSetChanged = errors.New("the secrets of this project changed during rotation, please retry")
+
+// ProjectKeyRotation carries the client-side re-encrypted material for a project data key rotation.
+type ProjectKeyRotation struct {
+	OldWrappedDataKey string               // The wrapped data key the rotation was made from, which must still be current
+	WrappedDataKey    string               // New data key wrapped with the Master Key
+	Secrets           map[uuid.UUID]string // Secret version ID -> value re-encrypted with the new data key
+	AccessKeys        map[uuid.UUID]string // User ID -> new data key wrapped for that user
+	GroupKeys         map[uuid.UUID]string // Group ID -> new data key sealed to that group
+	ServiceKeys       map[uuid.UUID]string // Service account ID -> new data key sealed to that service account
+	BreakGlassKey     string               // New data key sealed to the break-glass escrow, required if the project is armed
+	DeleteHistory     bool                 // Only latest versions were re-encrypted; older versions are permanently deleted
+}
+
 // GetProjectKeyForUser returns the wrapped data key for a specific user and project.
 func (db *DB) GetProjectKeyForUser(ctx context.Context, projectID, userID uuid.UUID, isAdmin bool) (string, error) {
 	if isAdmin {
@@ -17,7 +33,14 @@ func (db *DB) GetProjectKeyForUser(ctx context.Context, projectID, userID uuid.U
 		return key, err
 	}
 
-	query := `SELECT wrapped_data_key FROM user_project_access WHERE project_id = $1 AND user_id = $2`
+	query := `
+		SELECT a.wrapped_data_key
+		FROM user_project_access a
+		JOIN users u ON u.id = a.user_id
+		JOIN projects p ON p.id = a.project_id
+		WHERE a.project_id = $1 AND a.user_id = $2 AND (u.client_id IS NULL OR u.client_id = p.client_id)
+			AND (a.expires_at IS NULL OR a.expires_at > NOW())
+	`
 	var key string
 	err := db.Pool.QueryRow(ctx, query, projectID, userID).Scan(&key)
 	return key, err
@@ -28,7 +51,7 @@ func (db *DB) CreateProject(ctx context.Context, clientID uuid.UUID, name string
 	query := `
 		INSERT INTO projects (client_id, name, wrapped_data_key)
 		VALUES ($1, $2, $3)
-		RETURNING id, client_id, name, wrapped_data_key, created_at, updated_at
+		RETURNING id, client_id, name, wrapped_data_key, protected, created_at, updated_at
 	`
 
 	project := &models.Project{}
@@ -37,6 +60,7 @@ func (db *DB) CreateProject(ctx context.Context, clientID uuid.UUID, name string
 		&project.ClientID,
 		&project.Name,
 		&project.WrappedDataKey,
+		&project.Protected,
 		&project.CreatedAt,
 		&project.UpdatedAt,
 	)
@@ -51,7 +75,7 @@ func (db *DB) CreateProject(ctx context.Context, clientID uuid.UUID, name string
 // GetProjectsByClient returns all projects belonging to a specific client.
 func (db *DB) GetProjectsByClient(ctx context.Context, clientID uuid.UUID) ([]models.Project, error) {
 	query := `
-		SELECT id, client_id, name, wrapped_data_key, created_at, updated_at 
+		SELECT id, client_id, name, wrapped_data_key, protected, created_at, updated_at 
 		FROM projects 
 		WHERE client_id = $1 
 		ORDER BY name ASC
@@ -66,7 +90,73 @@ func (db *DB) GetProjectsByClient(ctx context.Context, clientID uuid.UUID) ([]mo
 	var projects []models.Project
 	for rows.Next() {
 		var p models.Project
-		if err := rows.Scan(&p.ID, &p.ClientID, &p.Name, &p.WrappedDataKey, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.ClientID, &p.Name, &p.WrappedDataKey, &p.Protected, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, nil
+}
+
+// GetGrantedProjectsByClient returns the projects of a client on which a user holds a grant, directly, through
+// a group or through an active break-glass session.
+func (db *DB) GetGrantedProjectsByClient(ctx context.Context, userID, clientID uuid.UUID) ([]models.Project, error) {
+	query := `
+		SELECT p.id, p.client_id, p.name, p.wrapped_data_key, p.protected, p.created_at, p.updated_at
+		FROM projects p
+		WHERE p.client_id = $2 AND (
+			EXISTS (
+				SELECT 1 FROM user_project_access a
+				WHERE a.user_id = $1 AND a.project_id = p.id AND (a.expires_at IS NULL OR a.expires_at > NOW())
+			) OR EXISTS (
+				SELECT 1 FROM group_project_access a
+				JOIN group_members m ON m.group_id = a.group_id
+				WHERE m.user_id = $1 AND a.project_id = p.id
+			) OR EXISTS (
+				SELECT 1 FROM break_glass_sessions s
+				WHERE s.user_id = $1 AND s.project_id = p.id AND s.expires_at > NOW()
+			)
+		)
+		ORDER BY p.name ASC
+	`
+
+	rows, err := db.Pool.Query(ctx, query, userID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	defer rows.Close()
+
+	var projects []models.Project
+	for rows.Next() {
+		var p models.Project
+		if err := rows.Scan(&p.ID, &p.ClientID, &p.Name, &p.WrappedDataKey, &p.Protected, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, nil
+}
+
+// GetAllProjects returns every project in the vault, across all clients.
+func (db *DB) GetAllProjects(ctx context.Context) ([]models.Project, error) {
+	query := `
+		SELECT id, client_id, name, wrapped_data_key, protected, created_at, updated_at
+		FROM projects
+		ORDER BY client_id, name ASC
+	`
+
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	defer rows.Close()
+
+	var projects []models.Project
+	for rows.Next() {
+		var p models.Project
+		if err := rows.Scan(&p.ID, &p.ClientID, &p.Name, &p.WrappedDataKey, &p.Protected, &p.CreatedAt, &p.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan project: %w", err)
 		}
 		projects = append(projects, p)
@@ -77,7 +167,7 @@ func (db *DB) GetProjectsByClient(ctx context.Context, clientID uuid.UUID) ([]mo
 
 // GetProjectByID returns a single project by its ID.
 func (db *DB) GetProjectByID(ctx context.Context, id uuid.UUID) (*models.Project, error) {
-	query := `SELECT id, client_id, name, wrapped_data_key, created_at, updated_at FROM projects WHERE id = $1`
+	query := `SELECT id, client_id, name, wrapped_data_key, protected, created_at, updated_at FROM projects WHERE id = $1`
 
 	project := &models.Project{}
 	err := db.Pool.QueryRow(ctx, query, id).Scan(
@@ -85,6 +175,7 @@ func (db *DB) GetProjectByID(ctx context.Context, id uuid.UUID) (*models.Project
 		&project.ClientID,
 		&project.Name,
 		&project.WrappedDataKey,
+		&project.Protected,
 		&project.CreatedAt,
 		&project.UpdatedAt,
 	)
@@ -105,3 +196,189 @@ func (db *DB) DeleteProject(ctx context.Context, id uuid.UUID) error {
 	}
 	return nil
 }
+
+// RotateProjectKey atomically replaces a project's data key, its secret ciphertexts and the per-user and per-group
+// wrapped keys. Grants of users missing from AccessKeys are revoked; their IDs are returned. Every group grant must
+// be present in GroupKeys, and BreakGlassKey is required if the project is armed. Pending change sets are rejected, since their ciphertexts use the retired key.
+// If the project's wrapped data key is no longer OldWrappedDataKey, because the project or the Master Key was
+// rotated in the meantime, ErrVaultKeysChanged is returned and nothing changes.
+func (db *DB) RotateProjectKey(ctx context.Context, projectID uuid.UUID, rotation ProjectKeyRotation) ([]uuid.UUID, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Master Key rotations lock this row first too, so the two cannot interleave
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM vault_config FOR SHARE`); err != nil {
+		return nil, fmt.Errorf("failed to lock vault configuration: %w", err)
+	}
+
+	// Locking the project row also blocks new secret versions (foreign key check) until we commit
+	var current string
+	if err := tx.QueryRow(ctx, `SELECT wrapped_data_key FROM projects WHERE id = $1 FOR UPDATE`, projectID).Scan(&current); err != nil {
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+	if current != rotation.OldWrappedDataKey {
+		return nil, ErrVaultKeysChanged
+	}
+
+	secretsQuery := `SELECT id FROM secrets WHERE project_id = $1`
+	if rotation.DeleteHistory {
+		secretsQuery = `SELECT DISTINCT ON (key) id FROM secrets WHERE project_id = $1 ORDER BY key, version DESC`
+	}
+	rows, err := tx.Query(ctx, secretsQuery, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	var secretIDs []uuid.UUID
+	for rows.Next() {
+		var sid uuid.UUID
+		if err := rows.Scan(&sid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		secretIDs = append(secretIDs, sid)
+	}
+	rows.Close()
+
+	if len(secretIDs) != len(rotation.Secrets) {
+		return nil, ErrSecretSetChanged
+	}
+	for _, sid := range secretIDs {
+		if _, ok := rotation.Secrets[sid]; !ok {
+			return nil, ErrSecretSetChanged
+		}
+	}
+
+	if rotation.DeleteHistory {
+		// Asked for explicitly: older versions are still encrypted with the retired key and would become unreadable
+		if _, err := tx.Exec(ctx, `DELETE FROM secrets WHERE project_id = $1 AND NOT (id = ANY($2))`, projectID, secretIDs); err != nil {
+			return nil, fmt.Errorf("failed to prune secret history: %w", err)
+		}
+	}
+
+	for sid, value := range rotation.Secrets {
+		if _, err := tx.Exec(ctx, `UPDATE secrets SET value = $1 WHERE id = $2 AND project_id = $3`, value, sid, projectID); err != nil {
+			return nil, fmt.Errorf("failed to update secret: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE projects SET wrapped_data_key = $1 WHERE id = $2`, rotation.WrappedDataKey, projectID); err != nil {
+		return nil, fmt.Errorf("failed to update project key: %w", err)
+	}
+
+	// Staged ciphertexts are encrypted with the retired key and could never be promoted
+	if _, err := tx.Exec(ctx, `
+		UPDATE change_sets SET status = 'rejected', decided_at = NOW(), decision_note = 'superseded by a project key rotation'
+		WHERE project_id = $1 AND status = 'pending'
+	`, projectID); err != nil {
+		return nil, fmt.Errorf("failed to reject pending change sets: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, `SELECT user_id FROM user_project_access WHERE project_id = $1 FOR UPDATE`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project access: %w", err)
+	}
+	var granted []uuid.UUID
+	for rows.Next() {
+		var uid uuid.UUID
+		if err := rows.Scan(&uid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan project access: %w", err)
+		}
+		granted = append(granted, uid)
+	}
+	rows.Close()
+
+	var revoked []uuid.UUID
+	for _, uid := range granted {
+		key, ok := rotation.AccessKeys[uid]
+		if !ok {
+			if _, err := tx.Exec(ctx, `DELETE FROM user_project_access WHERE user_id = $1 AND project_id = $2`, uid, projectID); err != nil {
+				return nil, fmt.Errorf("failed to revoke project access: %w", err)
+			}
+			revoked = append(revoked, uid)
+			continue
+		}
+		if _, err := tx.Exec(ctx, `UPDATE user_project_access SET wrapped_data_key = $1 WHERE user_id = $2 AND project_id = $3`, key, uid, projectID); err != nil {
+			return nil, fmt.Errorf("failed to update project access: %w", err)
+		}
+	}
+	if len(granted)-len(revoked) != len(rotation.AccessKeys) {
+		// Keys were supplied for users that have no grant on this project
+		return nil, ErrSecretSetChanged
+	}
+
+	// Groups always have a public key, so every group grant must be re-sealed
+	rows, err = tx.Query(ctx, `SELECT group_id FROM group_project_access WHERE project_id = $1 FOR UPDATE`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group access: %w", err)
+	}
+	var groups []uuid.UUID
+	for rows.Next() {
+		var gid uuid.UUID
+		if err := rows.Scan(&gid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan group access: %w", err)
+		}
+		groups = append(groups, gid)
+	}
+	rows.Close()
+
+	if len(groups) != len(rotation.GroupKeys) {
+		return nil, ErrSecretSetChanged
+	}
+	for _, gid := range groups {
+		key, ok := rotation.GroupKeys[gid]
+		if !ok {
+			return nil, ErrSecretSetChanged
+		}
+		if _, err := tx.Exec(ctx, `UPDATE group_project_access SET wrapped_data_key = $1 WHERE group_id = $2 AND project_id = $3`, key, gid, projectID); err != nil {
+			return nil, fmt.Errorf("failed to update group access: %w", err)
+		}
+	}
+
+	// Like groups, service accounts always have a public key, and a stale key would break their pipelines
+	rows, err = tx.Query(ctx, `SELECT service_account_id FROM service_account_project_access WHERE project_id = $1 FOR UPDATE`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service account access: %w", err)
+	}
+	var accounts []uuid.UUID
+	for rows.Next() {
+		var said uuid.UUID
+		if err := rows.Scan(&said); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan service account access: %w", err)
+		}
+		accounts = append(accounts, said)
+	}
+	rows.Close()
+
+	if len(accounts) != len(rotation.ServiceKeys) {
+		return nil, ErrSecretSetChanged
+	}
+	for _, said := range accounts {
+		key, ok := rotation.ServiceKeys[said]
+		if !ok {
+			return nil, ErrSecretSetChanged
+		}
+		if _, err := tx.Exec(ctx, `UPDATE service_account_project_access SET wrapped_data_key = $1 WHERE service_account_id = $2 AND project_id = $3`, key, said, projectID); err != nil {
+			return nil, fmt.Errorf("failed to update service account access: %w", err)
+		}
+	}
+
+	// An escrowed copy of the retired key would make break-glass sessions fail when they matter most
+	tag, err := tx.Exec(ctx, `UPDATE break_glass_keys SET wrapped_data_key = $1 WHERE project_id = $2`, rotation.BreakGlassKey, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update break-glass key: %w", err)
+	}
+	if tag.RowsAffected() > 0 && rotation.BreakGlassKey == "" {
+		return nil, ErrSecretSetChanged
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit rotation: %w", err)
+	}
+	return revoked, nil
+}
@@ -2,22 +2,31 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-// CreateSecret inserts a new encrypted secret for a project.
-func (db *DB) CreateSecret(ctx context.Context, projectID uuid.UUID, key string, value string) (*models.Secret, error) {
+// ErrSecretVersionExists is returned when a secret version has already been written.
+var ErrSecretVersionExists = errors.New("this secret version already exists")
+
+// CreateSecret inserts a new encrypted secret version for a project.
+// A version of 0 lets the database pick the next version for the key; clients that bind the
+// ciphertext to its version pass it explicitly so a concurrent write surfaces as a conflict.
+func (db *DB) CreateSecret(ctx context.Context, projectID uuid.UUID, key string, value string, version int) (*models.Secret, error) {
 	query := `
-		INSERT INTO secrets (project_id, key, value)
-		VALUES ($1, $2, $3)
+		INSERT INTO secrets (project_id, key, value, version)
+		VALUES ($1, $2, $3, COALESCE(NULLIF($4::int, 0), (
+			SELECT COALESCE(MAX(version), 0) + 1 FROM secrets WHERE project_id = $1 AND key = $2
+		)))
 		RETURNING id, project_id, key, value, version, created_at, updated_at
 	`
 
 	secret := &models.Secret{}
-	err := db.Pool.QueryRow(ctx, query, projectID, key, value).Scan(
+	err := db.Pool.QueryRow(ctx, query, projectID, key, value, version).Scan(
 		&secret.ID,
 		&secret.ProjectID,
 		&secret.Key,
@@ -27,6 +36,10 @@ func (db *DB) CreateSecret(ctx context.Context, projectID uuid.UUID, key string,
 		&secret.UpdatedAt,
 	)
 
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrSecretVersionExists
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create secret: %w", err)
 	}
@@ -88,3 +101,51 @@ func (db *DB) GetSecretHistory(ctx context.Context, projectID uuid.UUID, key str
 
 	return history, nil
 }
+
+// GetAllSecretVersions returns every version of every secret in a project.
+func (db *DB) GetAllSecretVersions(ctx context.Context, projectID uuid.UUID) ([]models.Secret, error) {
+	query := `
+		SELECT id, project_id, key, value, version, created_at, updated_at
+		FROM secrets
+		WHERE project_id = $1
+		ORDER BY key, version DESC
+	`
+
+	rows, err := db.Pool.Query(ctx, query, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secret versions: %w", err)
+	}
+	defer rows.Close()
+
+	var secrets []models.Secret
+	for rows.Next() {
+		var s models.Secret
+		if err := rows.Scan(&s.ID, &s.ProjectID, &s.Key, &s.Value, &s.Version, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		secrets = append(secrets, s)
+	}
+
+	return secrets, nil
+}
+
+// UpdateSecretValues replaces the ciphertexts of existing secret versions in a single transaction.
+func (db *DB) UpdateSecretValues(ctx context.Context, projectID uuid.UUID, values map[uuid.UUID]string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for id, value := range values {
+		tag, err := tx.Exec(ctx, `UPDATE secrets SET value = $1 WHERE id = $2 AND project_id = $3`, value, id, projectID)
+		if err != nil {
+			return fmt.Errorf("failed to update secret: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrSecretSetChanged
+		}
+	}
+
+	return tx.Commit(ctx)
+}
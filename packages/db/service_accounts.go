@@ -0,0 +1,347 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrServiceAccountExists is returned when a service account name is already taken.
+	ErrServiceAccountExists = errors.New("a service account with this name already exists")
+	// ErrServiceAccountNotFound is returned when a service account does not exist.
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	// ErrAPITokenExists is returned when a service account already has a token with that name.
+	ErrAPITokenExists = errors.New("the service account already has a token with this name")
+	// ErrAPITokenNotFound is returned when a token does not exist, or is revoked or expired.
+	ErrAPITokenNotFound = errors.New("api token not found, revoked or expired")
+)
+
+// CreateAPITokenParams describes a new API token. Only the hash of the token is stored.
+type CreateAPITokenParams struct {
+	ServiceAccountID uuid.UUID
+	Name             string
+	TokenHash        string
+	Permission       string
+	ProjectIDs       []uuid.UUID
+	AllowedIPs       []string
+	ExpiresAt        *time.Time
+	CreatedBy        uuid.UUID
+}
+
+const apiTokenColumns = `
+	t.id, t.service_account_id, sa.name, t.name, t.permission,
+	COALESCE((SELECT array_agg(tp.project_id::text) FROM api_token_projects tp WHERE tp.token_id = t.id), '{}'),
+	t.allowed_ips, t.expires_at, t.last_used_at, t.revoked_at, t.created_by, t.created_at
+`
+
+func scanAPIToken(row pgx.Row) (*models.APIToken, error) {
+	t := &models.APIToken{}
+	var projectIDs []string
+	err := row.Scan(
+		&t.ID,
+		&t.ServiceAccountID,
+		&t.ServiceAccountName,
+		&t.Name,
+		&t.Permission,
+		&projectIDs,
+		&t.AllowedIPs,
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+		&t.RevokedAt,
+		&t.CreatedBy,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.ProjectIDs = make([]uuid.UUID, 0, len(projectIDs))
+	for _, raw := range projectIDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		t.ProjectIDs = append(t.ProjectIDs, id)
+	}
+	return t, nil
+}
+
+// CreateServiceAccount creates a service account without tokens.
+func (db *DB) CreateServiceAccount(ctx context.Context, name, description string, createdBy uuid.UUID) (*models.ServiceAccount, error) {
+	sa := &models.ServiceAccount{}
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO service_accounts (name, description, created_by) VALUES ($1, $2, $3)
+		RETURNING id, name, description, COALESCE(public_key, ''), created_by, created_at
+	`, name, description, createdBy).Scan(&sa.ID, &sa.Name, &sa.Description, &sa.PublicKey, &sa.CreatedBy, &sa.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrServiceAccountExists
+		}
+		return nil, fmt.Errorf("failed to create service account: %w", err)
+	}
+	return sa, nil
+}
+
+// ListServiceAccounts returns all service accounts with their number of active tokens.
+func (db *DB) ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT sa.id, sa.name, sa.description, COALESCE(sa.public_key, ''), sa.created_by, sa.created_at,
+			(SELECT COUNT(*) FROM api_tokens t
+			 WHERE t.service_account_id = sa.id AND t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > NOW()))
+		FROM service_accounts sa
+		ORDER BY sa.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []models.ServiceAccount
+	for rows.Next() {
+		var sa models.ServiceAccount
+		if err := rows.Scan(&sa.ID, &sa.Name, &sa.Description, &sa.PublicKey, &sa.CreatedBy, &sa.CreatedAt, &sa.TokenCount); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, sa)
+	}
+	return accounts, rows.Err()
+}
+
+// GetServiceAccount returns a service account by ID.
+func (db *DB) GetServiceAccount(ctx context.Context, id uuid.UUID) (*models.ServiceAccount, error) {
+	sa := &models.ServiceAccount{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id, name, description, COALESCE(public_key, ''), created_by, created_at FROM service_accounts WHERE id = $1
+	`, id).Scan(&sa.ID, &sa.Name, &sa.Description, &sa.PublicKey, &sa.CreatedBy, &sa.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// SetServiceAccountPublicKey sets the public key of a service account. Project keys sealed to a previous
+// key can no longer be opened by the new private key, so they are removed in the same transaction.
+func (db *DB) SetServiceAccountPublicKey(ctx context.Context, id uuid.UUID, publicKey string) (int64, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE service_accounts SET public_key = $1 WHERE id = $2`, publicKey, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set public key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, ErrServiceAccountNotFound
+	}
+
+	tag, err = tx.Exec(ctx, `DELETE FROM service_account_project_access WHERE service_account_id = $1`, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove stale project keys: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GrantServiceAccountProjectAccess stores a project data key sealed to a service account. Granting again
+// replaces the wrapped key.
+func (db *DB) GrantServiceAccountProjectAccess(ctx context.Context, serviceAccountID, projectID uuid.UUID, wrappedKey string, grantedBy uuid.UUID) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO service_account_project_access (service_account_id, project_id, wrapped_data_key, granted_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (service_account_id, project_id)
+		DO UPDATE SET wrapped_data_key = EXCLUDED.wrapped_data_key, granted_by = EXCLUDED.granted_by, granted_at = NOW()
+	`, serviceAccountID, projectID, wrappedKey, grantedBy)
+	if err != nil {
+		return fmt.Errorf("failed to grant service account access: %w", err)
+	}
+	return nil
+}
+
+// RevokeServiceAccountProjectAccess removes the project data key sealed to a service account.
+func (db *DB) RevokeServiceAccountProjectAccess(ctx context.Context, serviceAccountID, projectID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `
+		DELETE FROM service_account_project_access WHERE service_account_id = $1 AND project_id = $2
+	`, serviceAccountID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke service account access: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAccessNotFound
+	}
+	return nil
+}
+
+// GetProjectServiceAccountAccess returns the service accounts holding a project's data key.
+func (db *DB) GetProjectServiceAccountAccess(ctx context.Context, projectID uuid.UUID) ([]models.ServiceAccountProjectAccess, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT a.service_account_id, sa.name, sa.public_key, a.project_id, a.wrapped_data_key, a.granted_by, a.granted_at
+		FROM service_account_project_access a
+		JOIN service_accounts sa ON sa.id = a.service_account_id
+		WHERE a.project_id = $1
+		ORDER BY sa.name
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service account access: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.ServiceAccountProjectAccess
+	for rows.Next() {
+		var a models.ServiceAccountProjectAccess
+		if err := rows.Scan(&a.ServiceAccountID, &a.ServiceAccountName, &a.PublicKey, &a.ProjectID, &a.WrappedDataKey, &a.GrantedBy, &a.GrantedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan service account access: %w", err)
+		}
+		entries = append(entries, a)
+	}
+	return entries, rows.Err()
+}
+
+// GetServiceAccountProjectKey returns the project data key sealed to a service account.
+func (db *DB) GetServiceAccountProjectKey(ctx context.Context, projectID, serviceAccountID uuid.UUID) (string, error) {
+	var key string
+	err := db.Pool.QueryRow(ctx, `
+		SELECT wrapped_data_key FROM service_account_project_access WHERE project_id = $1 AND service_account_id = $2
+	`, projectID, serviceAccountID).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrAccessNotFound
+	}
+	return key, err
+}
+
+// DeleteServiceAccount removes a service account and all its tokens.
+func (db *DB) DeleteServiceAccount(ctx context.Context, id uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM service_accounts WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+// CreateAPIToken stores a new token of a service account with its project scope.
+func (db *DB) CreateAPIToken(ctx context.Context, params CreateAPITokenParams) (*models.APIToken, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	allowedIPs := params.AllowedIPs
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO api_tokens (service_account_id, name, token_hash, permission, allowed_ips, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, params.ServiceAccountID, params.Name, params.TokenHash, params.Permission, allowedIPs, params.ExpiresAt, params.CreatedBy).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case "23505":
+				return nil, ErrAPITokenExists
+			case "23503":
+				return nil, ErrServiceAccountNotFound
+			}
+		}
+		return nil, fmt.Errorf("failed to create api token: %w", err)
+	}
+
+	for _, projectID := range params.ProjectIDs {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO api_token_projects (token_id, project_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+		`, id, projectID)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				return nil, fmt.Errorf("project %s not found", projectID)
+			}
+			return nil, err
+		}
+	}
+
+	token, err := scanAPIToken(tx.QueryRow(ctx, `
+		SELECT `+apiTokenColumns+` FROM api_tokens t JOIN service_accounts sa ON sa.id = t.service_account_id
+		WHERE t.id = $1
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListAPITokens returns the tokens of a service account, including revoked and expired ones.
+func (db *DB) ListAPITokens(ctx context.Context, serviceAccountID uuid.UUID) ([]models.APIToken, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+apiTokenColumns+` FROM api_tokens t JOIN service_accounts sa ON sa.id = t.service_account_id
+		WHERE t.service_account_id = $1
+		ORDER BY t.created_at DESC
+	`, serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes a token of a service account. It stays listed for the record.
+func (db *DB) RevokeAPIToken(ctx context.Context, serviceAccountID, tokenID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE api_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND service_account_id = $2 AND revoked_at IS NULL
+	`, tokenID, serviceAccountID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIToken returns the valid token with the given hash and records its use.
+func (db *DB) AuthenticateAPIToken(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	token, err := scanAPIToken(db.Pool.QueryRow(ctx, `
+		UPDATE api_tokens t SET last_used_at = NOW()
+		FROM service_accounts sa
+		WHERE sa.id = t.service_account_id AND t.token_hash = $1
+			AND t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > NOW())
+		RETURNING `+apiTokenColumns, tokenHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAPITokenNotFound
+	}
+	return token, err
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session or refresh token does not exist, or its session was
+	// revoked or has expired.
+	ErrSessionNotFound = errors.New("session not found, revoked or expired")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again. Its
+	// session has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+const sessionColumns = `id, user_id, user_agent, ip, expires_at, revoked_at, revoked_reason, last_used_at, created_at`
+
+func scanSession(row pgx.Row) (*models.Session, error) {
+	s := &models.Session{}
+	err := row.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.UserAgent,
+		&s.IP,
+		&s.ExpiresAt,
+		&s.RevokedAt,
+		&s.RevokedReason,
+		&s.LastUsedAt,
+		&s.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// CreateSession starts a session lasting until expiresAt with its first refresh token.
+func (db *DB) CreateSession(ctx context.Context, userID uuid.UUID, refreshTokenHash, userAgent, ip string, expiresAt time.Time) (*models.Session, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	session, err := scanSession(tx.QueryRow(ctx, `
+		INSERT INTO sessions (user_id, user_agent, ip, expires_at) VALUES ($1, $2, $3, $4)
+		RETURNING `+sessionColumns, userID, userAgent, ip, expiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO refresh_tokens (token_hash, session_id) VALUES ($1, $2)`, refreshTokenHash, session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// RotateRefreshToken marks a refresh token as used and replaces it with newTokenHash. If the token was
+// already used, its session is revoked and returned along with ErrRefreshTokenReused, so the caller can
+// tell whose session it was.
+func (db *DB) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash string) (*models.Session, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID uuid.UUID
+	var usedAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT session_id, used_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE
+	`, tokenHash).Scan(&sessionID, &usedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := scanSession(tx.QueryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = $1 FOR UPDATE`, sessionID))
+	if err != nil {
+		return nil, err
+	}
+
+	if usedAt != nil {
+		// Either the legitimate client or an attacker holds a copy of the token; end the session for both
+		if session.RevokedAt == nil {
+			if _, err := tx.Exec(ctx, `
+				UPDATE sessions SET revoked_at = NOW(), revoked_reason = 'refresh token reused' WHERE id = $1
+			`, sessionID); err != nil {
+				return nil, err
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return session, ErrRefreshTokenReused
+	}
+	if session.RevokedAt != nil || !session.ExpiresAt.After(time.Now()) {
+		return nil, ErrSessionNotFound
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1`, tokenHash); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO refresh_tokens (token_hash, session_id) VALUES ($1, $2)`, newTokenHash, sessionID); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET last_used_at = NOW() WHERE id = $1`, sessionID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// SessionActive reports whether a session exists and has neither been revoked nor expired.
+func (db *DB) SessionActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	var active bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW())
+	`, id).Scan(&active)
+	return active, err
+}
+
+// ListSessions returns a user's active sessions, most recently used first.
+func (db *DB) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of a user's active sessions.
+func (db *DB) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID, reason string) error {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID, reason)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes all active sessions of a user and returns how many there were.
+func (db *DB) RevokeUserSessions(ctx context.Context, userID uuid.UUID, reason string) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = $2
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	`, userID, reason)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteExpiredSessions removes expired sessions with their refresh tokens and returns how many there were.
+func (db *DB) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+)
+
+// ListSigningKeys returns the signing keys that still verify tokens, the current one first.
+func (db *DB) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT kid, algorithm, public_key, private_key, created_at, retired_at, expires_at
+		FROM signing_keys
+		WHERE expires_at IS NULL OR expires_at > NOW()
+		ORDER BY retired_at IS NULL DESC, created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var k models.SigningKey
+		if err := rows.Scan(&k.KID, &k.Algorithm, &k.PublicKey, &k.PrivateKey, &k.CreatedAt, &k.RetiredAt, &k.ExpiresAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKey makes key the current signing key. The previous one is retired and keeps verifying
+// tokens for retention.
+func (db *DB) RotateSigningKey(ctx context.Context, key *models.SigningKey, retention time.Duration) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE signing_keys SET retired_at = NOW(), expires_at = NOW() + $1 * INTERVAL '1 second'
+		WHERE retired_at IS NULL
+	`, int64(retention.Seconds())); err != nil {
+		return fmt.Errorf("failed to retire signing key: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO signing_keys (kid, algorithm, public_key, private_key, created_at) VALUES ($1, $2, $3, $4, $5)
+	`, key.KID, key.Algorithm, key.PublicKey, key.PrivateKey, key.CreatedAt); err != nil {
+		return fmt.Errorf("failed to store signing key: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteExpiredSigningKeys removes retired signing keys that no longer verify tokens.
+func (db *DB) DeleteExpiredSigningKeys(ctx context.Context) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM signing_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
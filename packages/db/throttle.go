@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetLoginThrottle returns the failed logins counted under a key, with no failures if there are none.
+func (db *DB) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	query := `SELECT key, user_id, failures, last_failure_at, locked_until FROM login_throttles WHERE key = $1`
+	t := &models.LoginThrottle{}
+	err := db.Pool.QueryRow(ctx, query, key).Scan(&t.Key, &t.UserID, &t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &models.LoginThrottle{Key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RecordLoginFailure counts a failed login under a key and returns the failures in a row so far. Failures
+// are forgotten once none happened for window.
+func (db *DB) RecordLoginFailure(ctx context.Context, key string, userID *uuid.UUID, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_throttles (key, user_id, failures, last_failure_at) VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.last_failure_at < NOW() - $3 * INTERVAL '1 second' THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failures
+	`
+	var failures int
+	if err := db.Pool.QueryRow(ctx, query, key, userID, window.Seconds()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+// LockLogin refuses logins under a key until the given time. The failure count starts over, so the lockout
+// replaces the backoff that led to it.
+func (db *DB) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := db.Pool.Exec(ctx, `UPDATE login_throttles SET failures = 0, locked_until = $2 WHERE key = $1`, key, until)
+	if err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+	return nil
+}
+
+// ClearLoginThrottle forgets the failures and lockout under a key, after a successful login or an admin
+// unlock. It reports whether there was anything to forget.
+func (db *DB) ClearLoginThrottle(ctx context.Context, key string) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM login_throttles WHERE key = $1`, key)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListLoginLockouts returns the accounts currently locked, including the environment admin. Identifiers
+// matching no account lock out too, but are not listed.
+func (db *DB) ListLoginLockouts(ctx context.Context) ([]models.LoginLockout, error) {
+	query := `
+		SELECT t.user_id, COALESCE(u.username, 'admin'), t.locked_until
+		FROM login_throttles t
+		LEFT JOIN users u ON u.id = t.user_id
+		WHERE t.locked_until > NOW() AND (t.user_id IS NOT NULL OR t.key = 'admin')
+		ORDER BY t.locked_until DESC
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []models.LoginLockout{}
+	for rows.Next() {
+		var l models.LoginLockout
+		if err := rows.Scan(&l.UserID, &l.Username, &l.LockedUntil); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, rows.Err()
+}
+
+// DeleteStaleLoginThrottles removes counters with no failure for window and no active lockout.
+func (db *DB) DeleteStaleLoginThrottles(ctx context.Context, window time.Duration) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		DELETE FROM login_throttles
+		WHERE last_failure_at < NOW() - $1 * INTERVAL '1 second' AND (locked_until IS NULL OR locked_until <= NOW())`,
+		window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -2,10 +2,13 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dcdavidev/bastion/packages/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 // HasAdmin checks if there is at least one admin user in the database.
@@ -21,7 +24,7 @@ func (db *DB) CreateUser(ctx context.Context, username, email, hash, salt, role
 	query := `
 		INSERT INTO users (username, email, password_hash, salt, role)
 		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, username, email, role, created_at, updated_at
+		RETURNING id, username, email, role, client_id, created_at, updated_at
 	`
 
 	user := &models.User{}
@@ -30,6 +33,7 @@ func (db *DB) CreateUser(ctx context.Context, username, email, hash, salt, role
 		&user.Username,
 		&user.Email,
 		&user.Role,
+		&user.ClientID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -48,20 +52,220 @@ func (db *DB) UpdateUserPassword(ctx context.Context, userID uuid.UUID, hash, sa
 	return err
 }
 
-// GrantProjectAccess links a user to a project with a specific wrapped data key.
-func (db *DB) GrantProjectAccess(ctx context.Context, userID, projectID uuid.UUID, wrappedKey string) error {
+// ErrKeyPairExists is returned when replacing a user's keypair with a different public key,
+// which would make every existing grant to that user unreadable.
+var ErrKeyPairExists = errors.New("user already has a different keypair")
+
+// SetUserKeyPair stores a user's keypair. Once set, only the encrypted private key and its salt
+// may change (e.g. after a password change); the public key is immutable.
+func (db *DB) SetUserKeyPair(ctx context.Context, userID uuid.UUID, keys *models.UserKeyPair) error {
+	query := `
+		UPDATE users
+		SET public_key = $1, encrypted_private_key = $2, private_key_salt = $3, updated_at = NOW()
+		WHERE id = $4 AND (public_key IS NULL OR public_key = $1)
+	`
+	tag, err := db.Pool.Exec(ctx, query, keys.PublicKey, keys.EncryptedPrivateKey, keys.PrivateKeySalt, userID)
+	if err != nil {
+		return fmt.Errorf("failed to store keypair: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		if err := db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return pgx.ErrNoRows
+		}
+		return ErrKeyPairExists
+	}
+	return nil
+}
+
+// GetUserKeyPair returns a user's keypair. It returns pgx.ErrNoRows if the user has none.
+func (db *DB) GetUserKeyPair(ctx context.Context, userID uuid.UUID) (*models.UserKeyPair, error) {
 	query := `
-		INSERT INTO user_project_access (user_id, project_id, wrapped_data_key)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (user_id, project_id) DO UPDATE SET wrapped_data_key = EXCLUDED.wrapped_data_key
+		SELECT public_key, encrypted_private_key, private_key_salt
+		FROM users
+		WHERE id = $1 AND public_key IS NOT NULL
 	`
-	_, err := db.Pool.Exec(ctx, query, userID, projectID, wrappedKey)
+	keys := &models.UserKeyPair{}
+	err := db.Pool.QueryRow(ctx, query, userID).Scan(&keys.PublicKey, &keys.EncryptedPrivateKey, &keys.PrivateKeySalt)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteUserKeyPair removes a user's keypair together with every grant and group key sealed to it, which
+// can no longer be opened. It is used when a password is reset without the old one, so the private key is lost.
+func (db *DB) DeleteUserKeyPair(ctx context.Context, userID uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_project_access WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to revoke grants: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM group_members WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to remove group memberships: %w", err)
+	}
+
+	query := `
+		UPDATE users
+		SET public_key = NULL, encrypted_private_key = NULL, private_key_salt = NULL, updated_at = NOW()
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete keypair: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ErrAccessNotFound is returned when a user has no grant on a project.
+var ErrAccessNotFound = errors.New("user has no access to this project")
+
+// GrantProjectAccess links a user to a project with a specific wrapped data key and project role.
+// A nil expiresAt grants access until it is revoked.
+func (db *DB) GrantProjectAccess(ctx context.Context, userID, projectID uuid.UUID, wrappedKey, role string, grantedBy uuid.UUID, expiresAt *time.Time) error {
+	query := `
+		INSERT INTO user_project_access (user_id, project_id, wrapped_data_key, role, granted_by, granted_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), $6)
+		ON CONFLICT (user_id, project_id) DO UPDATE
+		SET wrapped_data_key = EXCLUDED.wrapped_data_key, role = EXCLUDED.role,
+			granted_by = EXCLUDED.granted_by, granted_at = EXCLUDED.granted_at, expires_at = EXCLUDED.expires_at
+	`
+	_, err := db.Pool.Exec(ctx, query, userID, projectID, wrappedKey, role, grantedBy, expiresAt)
 	return err
 }
 
+// GetProjectRole returns the highest role a user holds on a project, through a direct grant, a group or an
+// active break-glass session, which only allows reading.
+// Expired grants are ignored, and grants of client portal accounts only count on projects of their own client.
+func (db *DB) GetProjectRole(ctx context.Context, userID, projectID uuid.UUID) (string, error) {
+	query := `
+		SELECT role FROM (
+			SELECT role FROM user_project_access
+			WHERE user_id = $1 AND project_id = $2 AND (expires_at IS NULL OR expires_at > NOW())
+			UNION ALL
+			SELECT a.role FROM group_project_access a
+			JOIN group_members m ON m.group_id = a.group_id
+			WHERE m.user_id = $1 AND a.project_id = $2
+			UNION ALL
+			SELECT 'viewer' FROM break_glass_sessions
+			WHERE user_id = $1 AND project_id = $2 AND expires_at > NOW()
+		) roles
+		WHERE EXISTS (
+			SELECT 1 FROM users u JOIN projects p ON p.id = $2
+			WHERE u.id = $1 AND (u.client_id IS NULL OR u.client_id = p.client_id)
+		)
+		ORDER BY CASE role WHEN 'project-admin' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END DESC
+		LIMIT 1
+	`
+	var role string
+	err := db.Pool.QueryRow(ctx, query, userID, projectID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrAccessNotFound
+	}
+	return role, err
+}
+
+// ErrClientScopedRole is returned when giving a client portal account a global role other than COLLABORATOR.
+var ErrClientScopedRole = errors.New("client portal accounts can only be collaborators")
+
+// SetUserRole changes a user's global role.
+func (db *DB) SetUserRole(ctx context.Context, userID uuid.UUID, role string) error {
+	query := `UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2 AND (client_id IS NULL OR $1 = 'COLLABORATOR')`
+	tag, err := db.Pool.Exec(ctx, query, role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set user role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		if err := db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrClientScopedRole
+		}
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeProjectAccess removes a user's grant on a project.
+func (db *DB) RevokeProjectAccess(ctx context.Context, userID, projectID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM user_project_access WHERE user_id = $1 AND project_id = $2`, userID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke project access: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAccessNotFound
+	}
+	return nil
+}
+
+// GetProjectAccess returns the per-user wrapped data keys granted for a project, with the grantees' public keys.
+func (db *DB) GetProjectAccess(ctx context.Context, projectID uuid.UUID) ([]models.ProjectAccess, error) {
+	query := `
+		SELECT a.user_id, a.project_id, a.wrapped_data_key, a.role, u.username, u.email, COALESCE(u.public_key, ''),
+			a.granted_by, a.granted_at, a.expires_at
+		FROM user_project_access a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.project_id = $1
+		ORDER BY u.username
+	`
+
+	rows, err := db.Pool.Query(ctx, query, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project access: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.ProjectAccess
+	for rows.Next() {
+		var a models.ProjectAccess
+		if err := rows.Scan(&a.UserID, &a.ProjectID, &a.WrappedDataKey, &a.Role, &a.Username, &a.Email, &a.PublicKey, &a.GrantedBy, &a.GrantedAt, &a.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan project access: %w", err)
+		}
+		entries = append(entries, a)
+	}
+
+	return entries, nil
+}
+
+// DeleteExpiredGrants removes the grants, and so the wrapped data keys, whose expiry has passed.
+// It returns the deleted grants so they can be audited.
+func (db *DB) DeleteExpiredGrants(ctx context.Context) ([]models.ProjectAccess, error) {
+	query := `
+		DELETE FROM user_project_access
+		WHERE expires_at IS NOT NULL AND expires_at <= NOW()
+		RETURNING user_id, project_id, role, granted_by, granted_at, expires_at
+	`
+
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []models.ProjectAccess
+	for rows.Next() {
+		var a models.ProjectAccess
+		if err := rows.Scan(&a.UserID, &a.ProjectID, &a.Role, &a.GrantedBy, &a.GrantedAt, &a.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan expired grant: %w", err)
+		}
+		grants = append(grants, a)
+	}
+
+	return grants, rows.Err()
+}
+
 // GetUserByUsername retrieves a user for authentication.
 func (db *DB) GetUserByUsername(ctx context.Context, username string) (*models.User, string, string, error) {
-	query := `SELECT id, username, email, password_hash, salt, role, created_at, updated_at FROM users WHERE username = $1`
+	query := `SELECT id, username, email, password_hash, salt, role, client_id, created_at, updated_at FROM users WHERE username = $1`
 
 	user := &models.User{}
 	var hash, salt string
@@ -72,6 +276,7 @@ func (db *DB) GetUserByUsername(ctx context.Context, username string) (*models.U
 		&hash,
 		&salt,
 		&user.Role,
+		&user.ClientID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -85,7 +290,7 @@ func (db *DB) GetUserByUsername(ctx context.Context, username string) (*models.U
 
 // GetUserByEmail retrieves a user by email for authentication.
 func (db *DB) GetUserByEmail(ctx context.Context, email string) (*models.User, string, string, error) {
-	query := `SELECT id, username, email, password_hash, salt, role, created_at, updated_at FROM users WHERE email = $1`
+	query := `SELECT id, username, email, password_hash, salt, role, client_id, created_at, updated_at FROM users WHERE email = $1`
 
 	user := &models.User{}
 	var hash, salt string
@@ -96,6 +301,7 @@ func (db *DB) GetUserByEmail(ctx context.Context, email string) (*models.User, s
 		&hash,
 		&salt,
 		&user.Role,
+		&user.ClientID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -118,13 +324,15 @@ func (db *DB) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, erro
 		}, nil
 	}
 
-	query := `SELECT id, username, email, role, created_at, updated_at FROM users WHERE id = $1`
+	query := `SELECT id, username, email, role, client_id, oidc_subject IS NOT NULL, created_at, updated_at FROM users WHERE id = $1`
 	user := &models.User{}
 	err := db.Pool.QueryRow(ctx, query, id).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.Role,
+		&user.ClientID,
+		&user.SSO,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -134,30 +342,38 @@ func (db *DB) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, erro
 	return user, nil
 }
 
+// ErrPasskeyNotFound is returned when a user has no passkey with the given ID.
+var ErrPasskeyNotFound = errors.New("passkey not found")
+
+// ErrLastPasskey is returned when removing a passkey would leave its user without one.
+var ErrLastPasskey = errors.New("this is the only passkey of the account")
+
 // AddWebAuthnCredential saves a new WebAuthn credential for a user.
 func (db *DB) AddWebAuthnCredential(ctx context.Context, userID uuid.UUID, cred *models.WebAuthnCredential) error {
 	query := `
-		INSERT INTO webauthn_credentials (id, user_id, public_key, attestation_type, transport, sign_count, clone_warning)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO webauthn_credentials (id, user_id, name, public_key, attestation_type, transport, sign_count, clone_warning)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING public_id, created_at, updated_at
 	`
-	_, err := db.Pool.Exec(ctx, query,
+	return db.Pool.QueryRow(ctx, query,
 		cred.ID,
 		userID,
+		cred.Name,
 		cred.PublicKey,
 		cred.AttestationType,
 		cred.Transport,
 		cred.SignCount,
 		cred.CloneWarning,
-	)
-	return err
+	).Scan(&cred.PublicID, &cred.CreatedAt, &cred.UpdatedAt)
 }
 
-// GetWebAuthnCredentials retrieves all WebAuthn credentials for a user.
+// GetWebAuthnCredentials retrieves all WebAuthn credentials for a user, oldest first.
 func (db *DB) GetWebAuthnCredentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
 	query := `
-		SELECT id, public_key, attestation_type, transport, sign_count, clone_warning, created_at, updated_at
+		SELECT public_id, id, name, public_key, attestation_type, transport, sign_count, clone_warning, last_used_at, created_at, updated_at
 		FROM webauthn_credentials
 		WHERE user_id = $1
+		ORDER BY created_at
 	`
 	rows, err := db.Pool.Query(ctx, query, userID)
 	if err != nil {
@@ -169,12 +385,15 @@ func (db *DB) GetWebAuthnCredentials(ctx context.Context, userID uuid.UUID) ([]m
 	for rows.Next() {
 		var cred models.WebAuthnCredential
 		err := rows.Scan(
+			&cred.PublicID,
 			&cred.ID,
+			&cred.Name,
 			&cred.PublicKey,
 			&cred.AttestationType,
 			&cred.Transport,
 			&cred.SignCount,
 			&cred.CloneWarning,
+			&cred.LastUsedAt,
 			&cred.CreatedAt,
 			&cred.UpdatedAt,
 		)
@@ -186,13 +405,73 @@ func (db *DB) GetWebAuthnCredentials(ctx context.Context, userID uuid.UUID) ([]m
 	return creds, nil
 }
 
-// UpdateWebAuthnCredential updates the sign count and other fields of a WebAuthn credential.
+// UpdateWebAuthnCredential records a login with a WebAuthn credential: its new sign count, whether it may
+// have been cloned, and the time it was used.
 func (db *DB) UpdateWebAuthnCredential(ctx context.Context, cred *models.WebAuthnCredential) error {
 	query := `
 		UPDATE webauthn_credentials
-		SET sign_count = $1, clone_warning = $2, updated_at = NOW()
+		SET sign_count = $1, clone_warning = $2, last_used_at = NOW(), updated_at = NOW()
 		WHERE id = $3
 	`
 	_, err := db.Pool.Exec(ctx, query, cred.SignCount, cred.CloneWarning, cred.ID)
 	return err
 }
+
+// RenameWebAuthnCredential renames one of a user's passkeys.
+func (db *DB) RenameWebAuthnCredential(ctx context.Context, userID, publicID uuid.UUID, name string) error {
+	query := `UPDATE webauthn_credentials SET name = $3, updated_at = NOW() WHERE user_id = $1 AND public_id = $2`
+	tag, err := db.Pool.Exec(ctx, query, userID, publicID, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential removes one of a user's passkeys and returns it. With keepOne, it fails with
+// ErrLastPasskey instead of removing the user's only passkey; the user's passkeys are locked meanwhile, so
+// concurrent removals cannot both pass the check.
+func (db *DB) DeleteWebAuthnCredential(ctx context.Context, userID, publicID uuid.UUID, keepOne bool) (*models.WebAuthnCredential, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT public_id FROM webauthn_credentials WHERE user_id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return nil, err
+	}
+	count, found := 0, false
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		count++
+		found = found || id == publicID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrPasskeyNotFound
+	}
+	if keepOne && count == 1 {
+		return nil, ErrLastPasskey
+	}
+
+	cred := &models.WebAuthnCredential{}
+	err = tx.QueryRow(ctx, `
+		DELETE FROM webauthn_credentials WHERE user_id = $1 AND public_id = $2
+		RETURNING public_id, id, name, created_at
+	`, userID, publicID).Scan(&cred.PublicID, &cred.ID, &cred.Name, &cred.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return cred, tx.Commit(ctx)
+}
@@ -2,35 +2,132 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
 )
 
+// ErrProjectSetChanged is returned when a key rotation does not cover exactly the current set of projects.
+var ErrProjectSetChanged = errors.New("the set of projects changed during rotation, please retry")
+
+// ErrVaultKeysChanged is returned when a key rotation was prepared from a Master Key or data key that has
+// since been replaced, e.g. by another rotation.
+var ErrVaultKeysChanged = errors.New("the vault's keys changed during rotation, please retry")
+
+// MasterKeyRotation carries the client-side re-wrapped keys of a Master Key rotation, with the wrapped keys
+// they were made from. Those must still be current, or the rotation would overwrite newer keys.
+type MasterKeyRotation struct {
+	OldWrappedMasterKey string
+	OldMasterKeySalt    string
+	WrappedMasterKey    string
+	MasterKeySalt       string
+	OldProjectKeys      map[uuid.UUID]string // Project ID -> data key wrapped with the old Master Key
+	ProjectKeys         map[uuid.UUID]string // Project ID -> data key re-wrapped with the new Master Key
+}
+
 // VaultConfig represents the global vault settings
 type VaultConfig struct {
 	WrappedMasterKey string `json:"wrapped_master_key"`
 	MasterKeySalt    string `json:"master_key_salt"`
+	LegacySecrets    bool   `json:"legacy_secrets"` // Secrets may still use the legacy format, until upgrade-secrets runs
 }
 
 // GetVaultConfig retrieves the global vault configuration.
 func (db *DB) GetVaultConfig(ctx context.Context) (*VaultConfig, error) {
-	query := `SELECT wrapped_master_key, master_key_salt FROM vault_config LIMIT 1`
+	query := `SELECT wrapped_master_key, master_key_salt, legacy_secrets FROM vault_config LIMIT 1`
 	config := &VaultConfig{}
-	err := db.Pool.QueryRow(ctx, query).Scan(&config.WrappedMasterKey, &config.MasterKeySalt)
+	err := db.Pool.QueryRow(ctx, query).Scan(&config.WrappedMasterKey, &config.MasterKeySalt, &config.LegacySecrets)
 	if err != nil {
 		return nil, err
 	}
 	return config, nil
 }
 
-// InitializeVault sets up the master key for the first time.
+// InitializeVault sets up the master key for the first time. A new vault has no legacy secrets.
 func (db *DB) InitializeVault(ctx context.Context, wrappedMK, salt string) error {
-	query := `INSERT INTO vault_config (wrapped_master_key, master_key_salt) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	query := `INSERT INTO vault_config (wrapped_master_key, master_key_salt, legacy_secrets) VALUES ($1, $2, FALSE) ON CONFLICT DO NOTHING`
 	_, err := db.Pool.Exec(ctx, query, wrappedMK, salt)
 	return err
 }
 
+// DisallowLegacySecrets records that every secret has been upgraded to an AAD-bound envelope, so clients
+// refuse legacy payloads from now on.
+func (db *DB) DisallowLegacySecrets(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `UPDATE vault_config SET legacy_secrets = FALSE, updated_at = NOW()`)
+	return err
+}
+
 // UpdateVaultConfig updates the global vault configuration.
 func (db *DB) UpdateVaultConfig(ctx context.Context, wrappedMK, salt string) error {
 	query := `UPDATE vault_config SET wrapped_master_key = $1, master_key_salt = $2, updated_at = NOW()`
 	_, err := db.Pool.Exec(ctx, query, wrappedMK, salt)
 	return err
 }
+
+// RotateMasterKey atomically replaces the wrapped master key and every project's wrapped data key.
+// ProjectKeys must contain a re-wrapped data key for every existing project. If the Master Key or a
+// project's wrapped data key is no longer the one the rotation was made from, ErrVaultKeysChanged is
+// returned and nothing changes.
+func (db *DB) RotateMasterKey(ctx context.Context, rotation MasterKeyRotation) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The vault_config row is locked first, like project key rotations do, so the two wait for each other
+	tag, err := tx.Exec(ctx, `
+		UPDATE vault_config SET wrapped_master_key = $1, master_key_salt = $2, updated_at = NOW()
+		WHERE wrapped_master_key = $3 AND master_key_salt = $4
+	`, rotation.WrappedMasterKey, rotation.MasterKeySalt, rotation.OldWrappedMasterKey, rotation.OldMasterKeySalt)
+	if err != nil {
+		return fmt.Errorf("failed to update vault configuration: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrVaultKeysChanged
+	}
+
+	// Row locks would not stop new projects from being inserted, with a data key wrapped by the old Master Key.
+	// This lock mode conflicts with the INSERTs and UPDATEs of other transactions, not with reads, and
+	// projects created before it is granted are seen by the SELECT below.
+	if _, err := tx.Exec(ctx, `LOCK TABLE projects IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock projects: %w", err)
+	}
+	rows, err := tx.Query(ctx, `SELECT id FROM projects`)
+	if err != nil {
+		return fmt.Errorf("failed to list projects: %w", err)
+	}
+	var existing []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan project: %w", err)
+		}
+		existing = append(existing, id)
+	}
+	rows.Close()
+
+	if len(existing) != len(rotation.ProjectKeys) {
+		return ErrProjectSetChanged
+	}
+	for _, id := range existing {
+		if _, ok := rotation.ProjectKeys[id]; !ok {
+			return ErrProjectSetChanged
+		}
+	}
+
+	for id, key := range rotation.ProjectKeys {
+		// A data key rotated since the client read it would be replaced by the retired one
+		tag, err := tx.Exec(ctx, `UPDATE projects SET wrapped_data_key = $1 WHERE id = $2 AND wrapped_data_key = $3`, key, id, rotation.OldProjectKeys[id])
+		if err != nil {
+			return fmt.Errorf("failed to update project key: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrVaultKeysChanged
+		}
+	}
+
+	return tx.Commit(ctx)
+}
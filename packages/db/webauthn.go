@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrCeremonyNotFound is returned when a passkey ceremony is unknown, already finished or expired.
+var ErrCeremonyNotFound = errors.New("passkey ceremony not found, finished or expired")
+
+// CreateWebAuthnCeremony stores a passkey ceremony until it is finished or expires.
+func (db *DB) CreateWebAuthnCeremony(ctx context.Context, c *models.WebAuthnCeremony) error {
+	query := `
+		INSERT INTO webauthn_ceremonies (id, kind, user_id, session_data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	if _, err := db.Pool.Exec(ctx, query, c.ID, c.Kind, c.UserID, c.SessionData, c.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to store passkey ceremony: %w", err)
+	}
+	return nil
+}
+
+// TakeWebAuthnCeremony deletes and returns an unexpired ceremony of the given kind, so each one can be
+// finished once.
+func (db *DB) TakeWebAuthnCeremony(ctx context.Context, id, kind string) (*models.WebAuthnCeremony, error) {
+	query := `
+		DELETE FROM webauthn_ceremonies
+		WHERE id = $1 AND kind = $2 AND expires_at > NOW()
+		RETURNING id, kind, user_id, session_data, expires_at
+	`
+	c := &models.WebAuthnCeremony{}
+	err := db.Pool.QueryRow(ctx, query, id, kind).Scan(&c.ID, &c.Kind, &c.UserID, &c.SessionData, &c.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCeremonyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DeleteExpiredWebAuthnCeremonies removes abandoned passkey ceremonies.
+func (db *DB) DeleteExpiredWebAuthnCeremonies(ctx context.Context) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM webauthn_ceremonies WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
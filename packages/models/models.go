@@ -25,6 +25,7 @@ type Project struct {
 	ClientID       uuid.UUID `json:"client_id"`
 	Name           string    `json:"name"`
 	WrappedDataKey string    `json:"wrapped_data_key,omitempty"`
+	Protected      bool      `json:"protected"` // Writes need a second user's approval
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
@@ -42,14 +43,252 @@ type Secret struct {
 
 // User represents an administrator or a collaborator.
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email,omitempty"`
-	PasswordHash string    `json:"-"`
-	Salt         string    `json:"-"`
-	Role         string    `json:"role"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           uuid.UUID  `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email,omitempty"`
+	PasswordHash string     `json:"-"`
+	Salt         string     `json:"-"`
+	Role         string     `json:"role"`
+	ClientID     *uuid.UUID `json:"client_id,omitempty"` // Set for client portal accounts, restricted to that client's projects
+	SSO          bool       `json:"sso,omitempty"`       // Linked to an identity at the single sign-on provider
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// UserKeyPair is a user's X25519 keypair. The private key is encrypted client-side under a key
+// derived from the user's password, so the server only ever stores ciphertext.
+type UserKeyPair struct {
+	PublicKey           string `json:"public_key"`            // Hex-encoded X25519 public key
+	EncryptedPrivateKey string `json:"encrypted_private_key"` // Hex-encoded envelope
+	PrivateKeySalt      string `json:"private_key_salt"`      // Encoded Argon2id salt for the private key KEK
+}
+
+// ProjectAccess links a user to a project through a wrapped copy of its data key.
+type ProjectAccess struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	ProjectID      uuid.UUID  `json:"project_id"`
+	WrappedDataKey string     `json:"wrapped_data_key"`
+	Role           string     `json:"role"` // Project role: viewer, editor or project-admin
+	Username       string     `json:"username"`
+	Email          string     `json:"email"`
+	PublicKey      string     `json:"public_key,omitempty"` // Empty if the user has no keypair
+	GrantedBy      *uuid.UUID `json:"granted_by,omitempty"`
+	GrantedAt      *time.Time `json:"granted_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"` // Nil for grants that never expire
+}
+
+// Group is a set of users granted projects together. It has its own keypair so project keys are
+// sealed once per group rather than once per member.
+type Group struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"` // Hex-encoded X25519 public key
+	CreatedBy   uuid.UUID `json:"created_by"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GroupMember is a user holding a copy of the group private key sealed to their public key.
+type GroupMember struct {
+	GroupID         uuid.UUID  `json:"group_id"`
+	UserID          uuid.UUID  `json:"user_id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	WrappedGroupKey string     `json:"wrapped_group_key"`
+	AddedBy         *uuid.UUID `json:"added_by,omitempty"`
+	AddedAt         *time.Time `json:"added_at,omitempty"`
+}
+
+// GroupProjectAccess links a group to a project through a copy of its data key sealed to the group.
+type GroupProjectAccess struct {
+	GroupID        uuid.UUID  `json:"group_id"`
+	GroupName      string     `json:"group_name"`
+	GroupPublicKey string     `json:"group_public_key"`
+	ProjectID      uuid.UUID  `json:"project_id"`
+	WrappedDataKey string     `json:"wrapped_data_key"`
+	Role           string     `json:"role"`
+	GrantedBy      *uuid.UUID `json:"granted_by,omitempty"`
+	GrantedAt      *time.Time `json:"granted_at,omitempty"`
+}
+
+// GroupProjectKey is what a member needs to open a project granted to one of their groups.
+type GroupProjectKey struct {
+	GroupID         uuid.UUID `json:"group_id"`
+	GroupPublicKey  string    `json:"group_public_key"`
+	WrappedGroupKey string    `json:"wrapped_group_key"` // Group private key sealed to the member
+	WrappedDataKey  string    `json:"wrapped_data_key"`  // Project data key sealed to the group
+}
+
+// Invitation statuses.
+const (
+	InvitationPending   = "pending"   // Waiting to be redeemed
+	InvitationAccepted  = "accepted"  // Redeemed; the project grant is not completed yet
+	InvitationCompleted = "completed" // Redeemed and the project data key was sealed to the invitee
+	InvitationExpired   = "expired"
+	InvitationRevoked   = "revoked"
+)
+
+// Invitation is a single-use, expiring invite bound to an email address.
+type Invitation struct {
+	ID             uuid.UUID  `json:"id"`
+	Email          string     `json:"email"`
+	ProjectID      *uuid.UUID `json:"project_id,omitempty"`
+	ProjectRole    string     `json:"project_role,omitempty"` // Role of the grant once completed
+	ClientID       *uuid.UUID `json:"client_id,omitempty"`    // Creates a client portal account when set
+	CreatedBy      uuid.UUID  `json:"created_by"`
+	Status         string     `json:"status"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	AcceptedUserID *uuid.UUID `json:"accepted_user_id,omitempty"`
+	GrantedAt      *time.Time `json:"granted_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// AccessRequest is a collaborator's request for a short-lived grant on a project.
+type AccessRequest struct {
+	ID             uuid.UUID  `json:"id"`
+	ProjectID      uuid.UUID  `json:"project_id"`
+	ProjectName    string     `json:"project_name"`
+	UserID         uuid.UUID  `json:"user_id"`
+	Username       string     `json:"username"`
+	Role           string     `json:"role"`
+	Reason         string     `json:"reason"`
+	DurationHours  int        `json:"duration_hours"`
+	Status         string     `json:"status"`
+	DecidedBy      *uuid.UUID `json:"decided_by,omitempty"`
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+	DecisionNote   string     `json:"decision_note,omitempty"`
+	GrantExpiresAt *time.Time `json:"grant_expires_at,omitempty"` // Set once approved
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Access request statuses.
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved" // A grant expiring at GrantExpiresAt was created
+	AccessRequestDenied   = "denied"
+)
+
+// ChangeSet is a staged set of secret writes to a protected project, promoted into secrets once
+// a user other than its author approves it.
+type ChangeSet struct {
+	ID           uuid.UUID         `json:"id"`
+	ProjectID    uuid.UUID         `json:"project_id"`
+	Description  string            `json:"description"`
+	Status       string            `json:"status"`
+	CreatedBy    uuid.UUID         `json:"created_by"`
+	DecidedBy    *uuid.UUID        `json:"decided_by,omitempty"`
+	DecidedAt    *time.Time        `json:"decided_at,omitempty"`
+	DecisionNote string            `json:"decision_note,omitempty"`
+	Secrets      []ChangeSetSecret `json:"secrets,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// ChangeSetSecret is one staged secret version of a change set.
+type ChangeSetSecret struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"` // Encrypted, bound to the project, key and version
+	Version int    `json:"version"`
+}
+
+// Change set statuses.
+const (
+	ChangeSetPending  = "pending"
+	ChangeSetApproved = "approved" // Its secrets were written to the project
+	ChangeSetRejected = "rejected"
+)
+
+// BreakGlassEscrow is the keypair the data keys of armed projects are sealed to for emergencies.
+type BreakGlassEscrow struct {
+	PublicKey string    `json:"public_key"` // Hex-encoded X25519 public key
+	CreatedBy uuid.UUID `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BreakGlassResponder is an on-call user holding the escrow private key sealed to their public key.
+type BreakGlassResponder struct {
+	UserID           uuid.UUID  `json:"user_id"`
+	Username         string     `json:"username"`
+	Email            string     `json:"email"`
+	WrappedEscrowKey string     `json:"wrapped_escrow_key,omitempty"`
+	AddedBy          *uuid.UUID `json:"added_by,omitempty"`
+	AddedAt          *time.Time `json:"added_at,omitempty"`
+}
+
+// BreakGlassSession is one emergency use of the escrow on a project. It grants read access until
+// ExpiresAt and stays unacknowledged until an admin reviews it.
+type BreakGlassSession struct {
+	ID                 uuid.UUID  `json:"id"`
+	ProjectID          uuid.UUID  `json:"project_id"`
+	ProjectName        string     `json:"project_name"`
+	UserID             uuid.UUID  `json:"user_id"`
+	Username           string     `json:"username"`
+	Justification      string     `json:"justification"`
+	ExpiresAt          time.Time  `json:"expires_at"`
+	AcknowledgedBy     *uuid.UUID `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt     *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgmentNote string     `json:"acknowledgment_note,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// Session is a login. Its access tokens carry its ID as jti, and its refresh token rotates on every use.
+type Session struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        uuid.UUID  `json:"user_id"`
+	UserAgent     string     `json:"user_agent,omitempty"`
+	IP            string     `json:"ip,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Current       bool       `json:"current,omitempty"` // Set by the API for the session of the request
+}
+
+// ServiceAccount is a non-human principal, such as a CI pipeline, that authenticates with API tokens.
+type ServiceAccount struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	PublicKey   string    `json:"public_key,omitempty"` // Hex-encoded X25519 public key, empty until a keypair is set
+	CreatedBy   uuid.UUID `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	TokenCount  int       `json:"token_count"` // Active tokens
+}
+
+// ServiceAccountProjectAccess links a service account to a project through a copy of its data key
+// sealed to the service account.
+type ServiceAccountProjectAccess struct {
+	ServiceAccountID   uuid.UUID  `json:"service_account_id"`
+	ServiceAccountName string     `json:"service_account_name"`
+	PublicKey          string     `json:"public_key"`
+	ProjectID          uuid.UUID  `json:"project_id"`
+	WrappedDataKey     string     `json:"wrapped_data_key"`
+	GrantedBy          *uuid.UUID `json:"granted_by,omitempty"`
+	GrantedAt          *time.Time `json:"granted_at,omitempty"`
+}
+
+// API token permissions.
+const (
+	APITokenRead      = "read"       // Read secrets
+	APITokenReadWrite = "read-write" // Read and write secrets
+)
+
+// APIToken is a named credential of a service account, scoped to some projects.
+type APIToken struct {
+	ID                 uuid.UUID   `json:"id"`
+	ServiceAccountID   uuid.UUID   `json:"service_account_id"`
+	ServiceAccountName string      `json:"service_account_name"`
+	Name               string      `json:"name"`
+	Permission         string      `json:"permission"`
+	ProjectIDs         []uuid.UUID `json:"project_ids"`
+	AllowedIPs         []string    `json:"allowed_ips,omitempty"` // CIDRs; empty allows any address
+	ExpiresAt          *time.Time  `json:"expires_at,omitempty"`
+	LastUsedAt         *time.Time  `json:"last_used_at,omitempty"`
+	RevokedAt          *time.Time  `json:"revoked_at,omitempty"`
+	CreatedBy          uuid.UUID   `json:"created_by"`
+	CreatedAt          time.Time   `json:"created_at"`
 }
 
 // AuditLog tracks sensitive operations in the vault.
@@ -58,18 +297,100 @@ type AuditLog struct {
 	Action     string                 `json:"action"`
 	TargetType string                 `json:"target_type"`
 	TargetID   uuid.UUID              `json:"target_id,omitempty"`
+	ActorType  string                 `json:"actor_type,omitempty"` // "user" or "service_account"; empty for system events
+	ActorID    *uuid.UUID             `json:"actor_id,omitempty"`
 	Metadata   map[string]interface{} `json:"metadata,omitempty"`
 	CreatedAt  time.Time              `json:"created_at"`
 }
 
-// WebAuthnCredential represents a stored passkey.
+// WebAuthnCredential represents a stored passkey. ID is the authenticator's credential ID; users refer to
+// a passkey by its PublicID.
 type WebAuthnCredential struct {
-	ID              []byte    `json:"id"`
-	PublicKey       []byte    `json:"public_key"`
-	AttestationType string    `json:"attestation_type"`
-	Transport       []string  `json:"transport"`
-	SignCount       uint32    `json:"sign_count"`
-	CloneWarning    bool      `json:"clone_warning"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	PublicID        uuid.UUID  `json:"id"`
+	ID              []byte     `json:"credential_id"`
+	Name            string     `json:"name"`
+	PublicKey       []byte     `json:"public_key"`
+	AttestationType string     `json:"attestation_type"`
+	Transport       []string   `json:"transport"`
+	SignCount       uint32     `json:"sign_count"`
+	CloneWarning    bool       `json:"clone_warning"` // The authenticator's counter went backwards: it may have been cloned
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// WebAuthnCeremony is a passkey registration or login between its begin and finish requests. SessionData
+// is the WebAuthn library's session, as JSON.
+type WebAuthnCeremony struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	SessionData []byte     `json:"-"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+// SigningKey is a key that signs access tokens. Only the newest unretired key signs; retired keys keep
+// verifying tokens until they expire.
+type SigningKey struct {
+	KID        string     `json:"kid"`
+	Algorithm  string     `json:"algorithm"`  // EdDSA or ES256
+	PublicKey  string     `json:"public_key"` // Base64-encoded PKIX DER
+	PrivateKey string     `json:"-"`          // PKCS#8 DER encrypted under BASTION_JWT_SECRET, hex-encoded
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at,omitempty"` // When it stopped signing
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // When it stops verifying
+}
+
+// OIDCLogin is a single sign-on login in progress. UserID is set once the provider has authenticated the
+// user, and the client that started the login can then redeem its login code.
+type OIDCLogin struct {
+	ID            uuid.UUID  `json:"id"`
+	Nonce         string     `json:"-"`
+	CodeVerifier  string     `json:"-"` // PKCE verifier towards the provider
+	ReturnTo      string     `json:"return_to"`
+	CodeChallenge string     `json:"-"` // PKCE challenge of the client that started the login
+	UserID        *uuid.UUID `json:"user_id,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+}
+
+// UserTOTP is a user's TOTP enrollment. Secret is sealed under a key derived from BASTION_JWT_SECRET and
+// empty if the user never enrolled; EnabledAt is nil while the enrollment awaits its first code.
+type UserTOTP struct {
+	Secret    string     `json:"-"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+	LastStep  int64      `json:"-"` // Last time step used, rejected afterwards
+}
+
+// MFAStatus describes a user's second factor.
+type MFAStatus struct {
+	TOTPEnabled       bool       `json:"totp_enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	Required          bool       `json:"required"` // The user's role requires a second factor
+}
+
+// LoginThrottle counts the failed logins of an account, or of an identifier that matches no account.
+type LoginThrottle struct {
+	Key           string     `json:"key"`
+	UserID        *uuid.UUID `json:"user_id,omitempty"`
+	Failures      int        `json:"failures"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// LoginLockout is an account locked after too many failed logins. UserID is nil for the environment admin.
+type LoginLockout struct {
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	Username    string     `json:"username"`
+	LockedUntil time.Time  `json:"locked_until"`
+}
+
+// MFAChallenge is a password login waiting for its second factor (purpose "verify"), or for the user to
+// enroll one because their role requires it ("enroll").
+type MFAChallenge struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+)
+
+// ErrGroupKeyMismatch is returned when an unwrapped group private key does not belong to the group's public key.
+var ErrGroupKeyMismatch = errors.New("group private key does not match the group's public key")
+
+// GroupKeyAAD binds a group private key sealed to a member to the group's public key.
+func GroupKeyAAD(groupPublicKeyHex string) []byte {
+	return []byte("bastion/group-key/v1|" + groupPublicKeyHex)
+}
+
+// NewGroupKeyPair generates a group's X25519 keypair. Project data keys are sealed to the group's public key
+// with WrapDataKeyForUser, exactly like a user grant, and the private key is sealed to every member.
+func NewGroupKeyPair() (string, []byte, error) {
+	publicKey, privateKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(publicKey), privateKey, nil
+}
+
+// WrapGroupKeyForMember seals a group private key to a member's public key.
+func WrapGroupKeyForMember(memberPublicKeyHex, groupPublicKeyHex string, groupPrivateKey []byte) (string, error) {
+	memberPublicKey, err := hex.DecodeString(memberPublicKeyHex)
+	if err != nil {
+		return "", crypto.ErrInvalidPublicKey
+	}
+
+	sealed, err := crypto.SealToPublicKey(memberPublicKey, groupPrivateKey, GroupKeyAAD(groupPublicKeyHex))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sealed), nil
+}
+
+// UnwrapGroupKey opens a group private key sealed to a member and checks that it matches the group's public key.
+func UnwrapGroupKey(memberPrivateKey []byte, groupPublicKeyHex, wrappedHex string) ([]byte, error) {
+	sealed, err := hex.DecodeString(wrappedHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex encoding: %w", err)
+	}
+
+	groupPrivateKey, err := crypto.OpenSealed(memberPrivateKey, sealed, GroupKeyAAD(groupPublicKeyHex))
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := crypto.PublicKeyFromPrivate(groupPrivateKey)
+	if err != nil || hex.EncodeToString(publicKey) != groupPublicKeyHex {
+		return nil, ErrGroupKeyMismatch
+	}
+	return groupPrivateKey, nil
+}
@@ -0,0 +1,49 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupGrant(t *testing.T) {
+	member, memberPrivateKey, _ := NewUserKeyPair("member-password")
+	groupPublicKey, groupPrivateKey, err := NewGroupKeyPair()
+	require.NoError(t, err)
+
+	wrappedGroupKey, err := WrapGroupKeyForMember(member.PublicKey, groupPublicKey, groupPrivateKey)
+	require.NoError(t, err)
+
+	// The project key is sealed once to the group and opened through the member's copy of the group key
+	dataKey, _ := crypto.GenerateRandomKey()
+	projectID := uuid.New()
+	wrappedDataKey, err := WrapDataKeyForUser(groupPublicKey, projectID, dataKey)
+	require.NoError(t, err)
+
+	unwrappedGroupKey, err := UnwrapGroupKey(memberPrivateKey, groupPublicKey, wrappedGroupKey)
+	require.NoError(t, err)
+	unwrapped, err := UnwrapDataKeyForUser(unwrappedGroupKey, projectID, wrappedDataKey)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+}
+
+func TestUnwrapGroupKey_Mismatch(t *testing.T) {
+	member, memberPrivateKey, _ := NewUserKeyPair("member-password")
+	groupPublicKey, groupPrivateKey, _ := NewGroupKeyPair()
+	otherPublicKey, _, _ := NewGroupKeyPair()
+
+	wrapped, _ := WrapGroupKeyForMember(member.PublicKey, groupPublicKey, groupPrivateKey)
+
+	// The group key is bound to its public key and cannot be presented as another group's
+	_, err := UnwrapGroupKey(memberPrivateKey, otherPublicKey, wrapped)
+	assert.Error(t, err)
+
+	// A sealed key that opens but belongs to another keypair is rejected
+	_, otherPrivateKey, _ := NewGroupKeyPair()
+	forged, _ := WrapGroupKeyForMember(member.PublicKey, groupPublicKey, otherPrivateKey)
+	_, err = UnwrapGroupKey(memberPrivateKey, groupPublicKey, forged)
+	assert.ErrorIs(t, err, ErrGroupKeyMismatch)
+}
@@ -0,0 +1,139 @@
+package vault
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+)
+
+// ErrNoKeyPair is returned when a user has not generated a keypair yet.
+var ErrNoKeyPair = errors.New("user has no keypair, run 'bastion create keypair' first")
+
+// PrivateKeyAAD binds an encrypted private key to its public key, so it cannot be swapped for another user's.
+func PrivateKeyAAD(publicKeyHex string) []byte {
+	return []byte("bastion/private-key/v1|" + publicKeyHex)
+}
+
+// GrantAAD binds a data key sealed to a user's public key to its project.
+func GrantAAD(projectID uuid.UUID) []byte {
+	return []byte("bastion/grant/v1|" + projectID.String())
+}
+
+// NewUserKeyPair generates an X25519 keypair and encrypts the private key under a key derived from the password.
+// The raw private key is returned as well so the caller can use it right away.
+func NewUserKeyPair(password string) (*models.UserKeyPair, []byte, error) {
+	publicKey, privateKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := encryptPrivateKey(hex.EncodeToString(publicKey), privateKey, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keys, privateKey, nil
+}
+
+// UnlockPrivateKey decrypts a user's private key with their password.
+func UnlockPrivateKey(keys *models.UserKeyPair, password string) ([]byte, error) {
+	if keys == nil || keys.PublicKey == "" {
+		return nil, ErrNoKeyPair
+	}
+
+	kek, err := crypto.DeriveKeyFromEncodedSalt([]byte(password), keys.PrivateKeySalt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key salt: %w", err)
+	}
+
+	payload, err := hex.DecodeString(keys.EncryptedPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted private key: %w", err)
+	}
+
+	privateKey, err := crypto.OpenEnvelope(kek, payload, PrivateKeyAAD(keys.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock private key: %w", err)
+	}
+
+	return privateKey, nil
+}
+
+// RewrapPrivateKey re-encrypts a user's private key under a new password, keeping the same keypair
+// so existing grants remain valid.
+func RewrapPrivateKey(keys *models.UserKeyPair, oldPassword, newPassword string) (*models.UserKeyPair, error) {
+	privateKey, err := UnlockPrivateKey(keys, oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	return encryptPrivateKey(keys.PublicKey, privateKey, newPassword)
+}
+
+// WrapDataKeyForUser seals a project data key to a user's public key.
+func WrapDataKeyForUser(publicKeyHex string, projectID uuid.UUID, dataKey []byte) (string, error) {
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	if err != nil {
+		return "", crypto.ErrInvalidPublicKey
+	}
+
+	sealed, err := crypto.SealToPublicKey(publicKey, dataKey, GrantAAD(projectID))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sealed), nil
+}
+
+// UnwrapDataKeyForUser opens a project data key sealed to the user's public key.
+func UnwrapDataKeyForUser(privateKey []byte, projectID uuid.UUID, wrappedHex string) ([]byte, error) {
+	sealed, err := hex.DecodeString(wrappedHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex encoding: %w", err)
+	}
+	return crypto.OpenSealed(privateKey, sealed, GrantAAD(projectID))
+}
+
+// ValidateKeyPair checks that a keypair submitted by a client is well-formed.
+func ValidateKeyPair(keys *models.UserKeyPair) error {
+	publicKey, err := hex.DecodeString(keys.PublicKey)
+	if err != nil || len(publicKey) != crypto.X25519KeyLen {
+		return crypto.ErrInvalidPublicKey
+	}
+
+	payload, err := hex.DecodeString(keys.EncryptedPrivateKey)
+	if err != nil || !crypto.IsEnvelope(payload) {
+		return errors.New("encrypted_private_key must be a hex-encoded envelope")
+	}
+
+	if _, _, err := crypto.DecodeSalt(keys.PrivateKeySalt); err != nil {
+		return fmt.Errorf("invalid private_key_salt: %w", err)
+	}
+
+	return nil
+}
+
+func encryptPrivateKey(publicKeyHex string, privateKey []byte, password string) (*models.UserKeyPair, error) {
+	salt, err := crypto.NewEncodedSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := crypto.DeriveKeyFromEncodedSalt([]byte(password), salt)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := crypto.EncryptWithAAD(kek, privateKey, PrivateKeyAAD(publicKeyHex))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.UserKeyPair{
+		PublicKey:           publicKeyHex,
+		EncryptedPrivateKey: hex.EncodeToString(payload),
+		PrivateKeySalt:      salt,
+	}, nil
+}
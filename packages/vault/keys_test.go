@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserKeyPair(t *testing.T) {
+	keys, privateKey, err := NewUserKeyPair("user-password")
+	require.NoError(t, err)
+	require.NoError(t, ValidateKeyPair(keys))
+
+	unlocked, err := UnlockPrivateKey(keys, "user-password")
+	require.NoError(t, err)
+	assert.Equal(t, privateKey, unlocked)
+
+	_, err = UnlockPrivateKey(keys, "wrong-password")
+	assert.Error(t, err)
+
+	// The private key cannot be re-labelled with another public key
+	other, _, _ := NewUserKeyPair("user-password")
+	swapped := *keys
+	swapped.PublicKey = other.PublicKey
+	_, err = UnlockPrivateKey(&swapped, "user-password")
+	assert.Error(t, err)
+
+	_, err = UnlockPrivateKey(nil, "user-password")
+	assert.ErrorIs(t, err, ErrNoKeyPair)
+}
+
+func TestRewrapPrivateKey(t *testing.T) {
+	keys, privateKey, _ := NewUserKeyPair("old-password")
+
+	rewrapped, err := RewrapPrivateKey(keys, "old-password", "new-password")
+	require.NoError(t, err)
+	assert.Equal(t, keys.PublicKey, rewrapped.PublicKey)
+
+	unlocked, err := UnlockPrivateKey(rewrapped, "new-password")
+	require.NoError(t, err)
+	assert.Equal(t, privateKey, unlocked)
+
+	_, err = RewrapPrivateKey(keys, "wrong-password", "new-password")
+	assert.Error(t, err)
+}
+
+func TestWrapDataKeyForUser(t *testing.T) {
+	keys, privateKey, _ := NewUserKeyPair("user-password")
+	dataKey, _ := crypto.GenerateRandomKey()
+	projectID := uuid.New()
+
+	wrapped, err := WrapDataKeyForUser(keys.PublicKey, projectID, dataKey)
+	require.NoError(t, err)
+	require.NoError(t, checkWrappedKeyFormat(wrapped))
+
+	unwrapped, err := UnwrapDataKeyForUser(privateKey, projectID, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	// A grant for one project cannot be replayed for another
+	_, err = UnwrapDataKeyForUser(privateKey, uuid.New(), wrapped)
+	assert.Error(t, err)
+
+	_, err = WrapDataKeyForUser("not-hex", projectID, dataKey)
+	assert.ErrorIs(t, err, crypto.ErrInvalidPublicKey)
+}
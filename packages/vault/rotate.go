@@ -0,0 +1,49 @@
+package vault
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+)
+
+// RewrapProjectKeys unwraps every project data key with the old master key and wraps it again with the new one.
+// It fails on the first project whose key cannot be unwrapped, so a rotation never silently drops a project.
+func RewrapProjectKeys(oldMasterKey, newMasterKey []byte, projects []models.Project) (map[uuid.UUID]string, error) {
+	keys := make(map[uuid.UUID]string, len(projects))
+	for _, project := range projects {
+		dataKey, err := UnwrapHexKey(oldMasterKey, project.WrappedDataKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key of project '%s' (%s): %w", project.Name, project.ID, err)
+		}
+
+		wrapped, err := crypto.WrapKey(newMasterKey, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[project.ID] = hex.EncodeToString(wrapped)
+	}
+	return keys, nil
+}
+
+// ReencryptSecrets decrypts every secret with the old data key and seals it again with the new one.
+// It returns the new hex-encoded values keyed by secret version ID; legacy values are upgraded to envelopes
+// if allowLegacy, and refused otherwise.
+func ReencryptSecrets(oldDataKey, newDataKey []byte, secrets []models.Secret, allowLegacy bool) (map[uuid.UUID]string, error) {
+	values := make(map[uuid.UUID]string, len(secrets))
+	for _, secret := range secrets {
+		plaintext, _, err := DecryptSecret(oldDataKey, secret, allowLegacy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret '%s' v%d: %w", secret.Key, secret.Version, err)
+		}
+
+		value, err := EncryptSecret(newDataKey, secret.ProjectID, secret.Key, secret.Version, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		values[secret.ID] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,78 @@
+package vault
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrapProjectKeys(t *testing.T) {
+	oldMK, _ := crypto.GenerateRandomKey()
+	newMK, _ := crypto.GenerateRandomKey()
+	dataKey, _ := crypto.GenerateRandomKey()
+	wrapped, _ := crypto.WrapKey(oldMK, dataKey)
+
+	project := models.Project{ID: uuid.New(), Name: "api", WrappedDataKey: hex.EncodeToString(wrapped)}
+
+	keys, err := RewrapProjectKeys(oldMK, newMK, []models.Project{project})
+	require.NoError(t, err)
+	require.Contains(t, keys, project.ID)
+
+	unwrapped, err := UnwrapHexKey(newMK, keys[project.ID])
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	// The old master key must no longer open the re-wrapped key
+	_, err = UnwrapHexKey(oldMK, keys[project.ID])
+	assert.Error(t, err)
+}
+
+func TestRewrapProjectKeys_BrokenProject(t *testing.T) {
+	oldMK, _ := crypto.GenerateRandomKey()
+	newMK, _ := crypto.GenerateRandomKey()
+
+	_, err := RewrapProjectKeys(oldMK, newMK, []models.Project{{ID: uuid.New(), WrappedDataKey: "deadbeef"}})
+	assert.Error(t, err)
+}
+
+func TestWrapMasterKey(t *testing.T) {
+	masterKey, _ := crypto.GenerateRandomKey()
+
+	wrappedHex, saltHex, err := WrapMasterKey(masterKey, "admin-pass")
+	require.NoError(t, err)
+
+	unwrapped, err := UnwrapMasterKey(&db.VaultConfig{WrappedMasterKey: wrappedHex, MasterKeySalt: saltHex}, "admin-pass")
+	require.NoError(t, err)
+	assert.Equal(t, masterKey, unwrapped)
+}
+
+func TestReencryptSecrets(t *testing.T) {
+	oldDK, _ := crypto.GenerateRandomKey()
+	newDK, _ := crypto.GenerateRandomKey()
+	ciphertext, _ := crypto.Encrypt(oldDK, []byte("s3cr3t"))
+
+	secret := models.Secret{ID: uuid.New(), ProjectID: uuid.New(), Key: "TOKEN", Version: 3, Value: hex.EncodeToString(ciphertext)}
+
+	values, err := ReencryptSecrets(oldDK, newDK, []models.Secret{secret}, true)
+	require.NoError(t, err)
+
+	rotated := secret
+	rotated.Value = values[secret.ID]
+	plaintext, legacy, err := DecryptSecret(newDK, rotated, false)
+	require.NoError(t, err)
+	assert.False(t, legacy)
+	assert.Equal(t, []byte("s3cr3t"), plaintext)
+
+	_, err = ReencryptSecrets(newDK, oldDK, []models.Secret{secret}, true)
+	assert.Error(t, err)
+
+	// Once the vault is upgraded, a rotation does not launder a legacy payload into an envelope
+	_, err = ReencryptSecrets(oldDK, newDK, []models.Secret{secret}, false)
+	assert.ErrorIs(t, err, ErrLegacySecret)
+}
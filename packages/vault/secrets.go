@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+)
+
+// SecretAAD returns the additional data binding a secret ciphertext to its project, key name and version.
+// The project ID has a fixed length and the version is last, so the encoding is unambiguous.
+func SecretAAD(projectID uuid.UUID, key string, version int) []byte {
+	return []byte(fmt.Sprintf("bastion/secret/v1|%s|%s|%d", projectID, key, version))
+}
+
+// EncryptSecret seals a secret value in a versioned envelope bound to its project, key and version.
+func EncryptSecret(dataKey []byte, projectID uuid.UUID, key string, version int, plaintext []byte) (string, error) {
+	payload, err := crypto.EncryptWithAAD(dataKey, plaintext, SecretAAD(projectID, key, version))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(payload), nil
+}
+
+// ErrLegacySecret is returned for a secret in the legacy format once the vault no longer allows it. A
+// legacy payload is not bound to its project, key and version, so it could have been moved from another.
+var ErrLegacySecret = errors.New("secret uses the legacy unbound format, which this vault no longer accepts")
+
+// DecryptSecret decrypts a stored secret version. With allowLegacy, the pre-envelope nonce||ciphertext
+// format is accepted too, and legacy is true when the value uses it and should be upgraded. Pass the
+// vault's LegacySecrets flag: it is cleared once 'bastion db upgrade-secrets' has run.
+func DecryptSecret(dataKey []byte, secret models.Secret, allowLegacy bool) (plaintext []byte, legacy bool, err error) {
+	payload, err := hex.DecodeString(secret.Value)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid hex encoding: %w", err)
+	}
+
+	plaintext, err = crypto.OpenEnvelope(dataKey, payload, SecretAAD(secret.ProjectID, secret.Key, secret.Version))
+	if err == nil {
+		return plaintext, false, nil
+	}
+
+	if old, legacyErr := crypto.Decrypt(dataKey, payload); legacyErr == nil {
+		if !allowLegacy {
+			return nil, true, ErrLegacySecret
+		}
+		return old, true, nil
+	}
+	return nil, false, fmt.Errorf("decryption failed: %w", err)
+}
+
+// UpgradeSecrets re-encrypts legacy secret versions into AAD-bound envelopes.
+// Versions already in the envelope format are skipped.
+func UpgradeSecrets(dataKey []byte, secrets []models.Secret) (map[uuid.UUID]string, error) {
+	values := map[uuid.UUID]string{}
+	for _, secret := range secrets {
+		plaintext, legacy, err := DecryptSecret(dataKey, secret, true)
+		if err != nil {
+			return nil, fmt.Errorf("secret '%s' v%d: %w", secret.Key, secret.Version, err)
+		}
+		if !legacy {
+			continue
+		}
+
+		value, err := EncryptSecret(dataKey, secret.ProjectID, secret.Key, secret.Version, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		values[secret.ID] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,76 @@
+package vault
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecryptSecret(t *testing.T) {
+	dataKey, _ := crypto.GenerateRandomKey()
+	projectID := uuid.New()
+
+	value, err := EncryptSecret(dataKey, projectID, "DB_URL", 2, []byte("postgres://"))
+	require.NoError(t, err)
+
+	secret := models.Secret{ProjectID: projectID, Key: "DB_URL", Version: 2, Value: value}
+	plaintext, legacy, err := DecryptSecret(dataKey, secret, false)
+	require.NoError(t, err)
+	assert.False(t, legacy)
+	assert.Equal(t, []byte("postgres://"), plaintext)
+
+	// Moving the ciphertext to another key, version or project must fail
+	for _, swapped := range []models.Secret{
+		{ProjectID: projectID, Key: "API_KEY", Version: 2, Value: value},
+		{ProjectID: projectID, Key: "DB_URL", Version: 1, Value: value},
+		{ProjectID: uuid.New(), Key: "DB_URL", Version: 2, Value: value},
+	} {
+		_, _, err := DecryptSecret(dataKey, swapped, false)
+		assert.Error(t, err)
+	}
+}
+
+func TestUpgradeSecrets(t *testing.T) {
+	dataKey, _ := crypto.GenerateRandomKey()
+	projectID := uuid.New()
+
+	legacyCiphertext, _ := crypto.Encrypt(dataKey, []byte("old"))
+	legacy := models.Secret{ID: uuid.New(), ProjectID: projectID, Key: "OLD", Version: 1, Value: hex.EncodeToString(legacyCiphertext)}
+
+	current, _ := EncryptSecret(dataKey, projectID, "NEW", 1, []byte("new"))
+	upgraded := models.Secret{ID: uuid.New(), ProjectID: projectID, Key: "NEW", Version: 1, Value: current}
+
+	values, err := UpgradeSecrets(dataKey, []models.Secret{legacy, upgraded})
+	require.NoError(t, err)
+	require.Len(t, values, 1)
+
+	legacy.Value = values[legacy.ID]
+	plaintext, isLegacy, err := DecryptSecret(dataKey, legacy, false)
+	require.NoError(t, err)
+	assert.False(t, isLegacy)
+	assert.Equal(t, []byte("old"), plaintext)
+}
+
+func TestDecryptSecret_LegacyRefusedAfterUpgrade(t *testing.T) {
+	dataKey, _ := crypto.GenerateRandomKey()
+	projectID := uuid.New()
+
+	// A legacy value of one secret, copied over another key and version by someone who can write ciphertexts
+	ciphertext, _ := crypto.Encrypt(dataKey, []byte("prod-password"))
+	moved := models.Secret{ProjectID: projectID, Key: "PUBLIC_URL", Version: 7, Value: hex.EncodeToString(ciphertext)}
+
+	// Before the upgrade, legacy values carry no binding and are accepted
+	_, legacy, err := DecryptSecret(dataKey, moved, true)
+	require.NoError(t, err)
+	assert.True(t, legacy)
+
+	// Afterwards they are refused
+	plaintext, _, err := DecryptSecret(dataKey, moved, false)
+	assert.ErrorIs(t, err, ErrLegacySecret)
+	assert.Nil(t, plaintext)
+}
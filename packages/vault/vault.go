@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+)
+
+// UnwrapMasterKey derives the admin KEK from the password and unwraps the master key stored in the vault configuration.
+func UnwrapMasterKey(config *db.VaultConfig, password string) ([]byte, error) {
+	kek, err := crypto.DeriveKeyFromEncodedSalt([]byte(password), config.MasterKeySalt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key salt: %w", err)
+	}
+
+	wrappedMK, err := hex.DecodeString(config.WrappedMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped master key: %w", err)
+	}
+
+	masterKey, err := crypto.UnwrapKey(kek, wrappedMK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap master key: %w", err)
+	}
+
+	return masterKey, nil
+}
+
+// UnwrapHexKey decodes a hex-encoded wrapped key and unwraps it with the given wrapper key.
+func UnwrapHexKey(wrapperKey []byte, wrappedHex string) ([]byte, error) {
+	wrapped, err := hex.DecodeString(wrappedHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex encoding: %w", err)
+	}
+	return crypto.UnwrapKey(wrapperKey, wrapped)
+}
+
+// WrapMasterKey derives a fresh admin KEK from the password, with the current Argon2id parameters,
+// and wraps the master key with it. It returns the hex-encoded wrapped key and the encoded salt,
+// ready to be stored in vault_config.
+func WrapMasterKey(masterKey []byte, password string) (string, string, error) {
+	salt, err := crypto.NewEncodedSalt()
+	if err != nil {
+		return "", "", err
+	}
+
+	kek, err := crypto.DeriveKeyFromEncodedSalt([]byte(password), salt)
+	if err != nil {
+		return "", "", err
+	}
+
+	wrappedMK, err := crypto.WrapKey(kek, masterKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(wrappedMK), salt, nil
+}
@@ -0,0 +1,124 @@
+package vault
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/dcdavidev/bastion/packages/crypto"
+	"github.com/dcdavidev/bastion/packages/db"
+	"github.com/dcdavidev/bastion/packages/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDatabase implements the subset of db.Database used by the verifier.
+type fakeDatabase struct {
+	db.Database
+	config   *db.VaultConfig
+	projects []models.Project
+	secrets  map[uuid.UUID][]models.Secret
+	access   map[uuid.UUID][]models.ProjectAccess
+}
+
+func (f *fakeDatabase) GetVaultConfig(ctx context.Context) (*db.VaultConfig, error) {
+	return f.config, nil
+}
+func (f *fakeDatabase) GetAllProjects(ctx context.Context) ([]models.Project, error) {
+	return f.projects, nil
+}
+func (f *fakeDatabase) GetAllSecretVersions(ctx context.Context, p uuid.UUID) ([]models.Secret, error) {
+	return f.secrets[p], nil
+}
+func (f *fakeDatabase) GetProjectAccess(ctx context.Context, p uuid.UUID) ([]models.ProjectAccess, error) {
+	return f.access[p], nil
+}
+
+func newFakeVault(t *testing.T, password string) (*fakeDatabase, []byte) {
+	salt, err := crypto.GenerateSalt()
+	require.NoError(t, err)
+	masterKey, err := crypto.GenerateRandomKey()
+	require.NoError(t, err)
+	wrappedMK, err := crypto.WrapKey(crypto.DeriveKey([]byte(password), salt), masterKey)
+	require.NoError(t, err)
+
+	return &fakeDatabase{
+		config: &db.VaultConfig{
+			WrappedMasterKey: hex.EncodeToString(wrappedMK),
+			MasterKeySalt:    hex.EncodeToString(salt),
+			LegacySecrets:    true, // A vault from before envelopes, not upgraded yet
+		},
+		secrets: map[uuid.UUID][]models.Secret{},
+		access:  map[uuid.UUID][]models.ProjectAccess{},
+	}, masterKey
+}
+
+func addProject(t *testing.T, f *fakeDatabase, masterKey []byte) (uuid.UUID, []byte) {
+	dataKey, err := crypto.GenerateRandomKey()
+	require.NoError(t, err)
+	wrappedDK, err := crypto.WrapKey(masterKey, dataKey)
+	require.NoError(t, err)
+
+	id := uuid.New()
+	f.projects = append(f.projects, models.Project{ID: id, WrappedDataKey: hex.EncodeToString(wrappedDK)})
+	return id, dataKey
+}
+
+func TestVerify_Healthy(t *testing.T) {
+	f, masterKey := newFakeVault(t, "admin-pass")
+	projectID, dataKey := addProject(t, f, masterKey)
+
+	ciphertext, err := crypto.Encrypt(dataKey, []byte("value"))
+	require.NoError(t, err)
+	f.secrets[projectID] = []models.Secret{{ID: uuid.New(), Key: "API_KEY", Version: 1, Value: hex.EncodeToString(ciphertext)}}
+
+	userKey, _ := crypto.GenerateRandomKey()
+	wrappedForUser, _ := crypto.WrapKey(userKey, dataKey)
+	f.access[projectID] = []models.ProjectAccess{{UserID: uuid.New(), ProjectID: projectID, WrappedDataKey: hex.EncodeToString(wrappedForUser)}}
+
+	report, err := Verify(context.Background(), f, "admin-pass")
+	require.NoError(t, err)
+
+	assert.True(t, report.OK)
+	assert.Equal(t, 1, report.ProjectsChecked)
+	assert.Equal(t, 1, report.SecretsChecked)
+	assert.Equal(t, 1, report.AccessKeysChecked)
+	assert.Empty(t, report.Issues)
+}
+
+func TestVerify_WrongPassword(t *testing.T) {
+	f, _ := newFakeVault(t, "admin-pass")
+
+	_, err := Verify(context.Background(), f, "wrong-pass")
+	assert.Error(t, err)
+}
+
+func TestVerify_ReportsBrokenItems(t *testing.T) {
+	f, masterKey := newFakeVault(t, "admin-pass")
+	projectID, _ := addProject(t, f, masterKey)
+
+	otherKey, _ := crypto.GenerateRandomKey()
+	ciphertext, _ := crypto.Encrypt(otherKey, []byte("value"))
+	f.secrets[projectID] = []models.Secret{{ID: uuid.New(), Key: "API_KEY", Version: 2, Value: hex.EncodeToString(ciphertext)}}
+	f.access[projectID] = []models.ProjectAccess{{UserID: uuid.New(), ProjectID: projectID, WrappedDataKey: "not-hex"}}
+
+	brokenID := uuid.New()
+	f.projects = append(f.projects, models.Project{ID: brokenID, WrappedDataKey: "deadbeef"})
+	f.secrets[brokenID] = []models.Secret{{ID: uuid.New(), Key: "DB_URL", Version: 1, Value: "00"}}
+
+	report, err := Verify(context.Background(), f, "admin-pass")
+	require.NoError(t, err)
+
+	assert.False(t, report.OK)
+	assert.Equal(t, 2, report.ProjectsChecked)
+	require.Len(t, report.Issues, 4)
+
+	kinds := map[string]int{}
+	for _, issue := range report.Issues {
+		kinds[issue.Kind]++
+	}
+	assert.Equal(t, 1, kinds[IssueProjectKey])
+	assert.Equal(t, 2, kinds[IssueSecret])
+	assert.Equal(t, 1, kinds[IssueAccessKey])
+}